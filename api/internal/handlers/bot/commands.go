@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"log"
+	"strings"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// CommandsStore manages the user's custom bot commands.
+type CommandsStore interface {
+	CustomCommands(userID string) (cmds []store.CustomCommand, err error)
+	SetCustomCommand(userID string, cmd store.CustomCommand) (err error)
+	DeleteCustomCommand(userID, trigger string) (err error)
+}
+
+// CommandsListHandler responds with the user's custom bot commands.
+type CommandsListHandler struct {
+	store CommandsStore
+}
+
+// NewCommandsListHandler returns a new CommandsListHandler.
+func NewCommandsListHandler(store CommandsStore) *CommandsListHandler {
+	return &CommandsListHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *CommandsListHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	cmds, err := h.store.CustomCommands(userID)
+	if err != nil {
+		log.Printf("unable to get custom commands: %s", err)
+		return
+	}
+
+	payload := make([]map[string]interface{}, 0, len(cmds))
+	for _, cmd := range cmds {
+		payload = append(payload, map[string]interface{}{
+			"trigger":  cmd.Trigger,
+			"response": cmd.Response,
+			"count":    cmd.Count,
+		})
+	}
+	resp.Payload = payload
+	resp.Error = nil
+}
+
+// SetCommandHandler creates or updates a custom bot command.
+type SetCommandHandler struct {
+	store CommandsStore
+}
+
+// NewSetCommandHandler returns a new SetCommandHandler.
+func NewSetCommandHandler(store CommandsStore) *SetCommandHandler {
+	return &SetCommandHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *SetCommandHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, cmd := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	err := h.store.SetCustomCommand(userID, cmd)
+	if err != nil {
+		if err == store.ErrInvalidCustomCommand {
+			resp.Error = handlers.InvalidPayload
+			return
+		}
+		log.Printf("unable to set custom command: %s", err)
+		return
+	}
+	resp.Error = nil
+}
+
+// validatePayload returns true if the payload is valid. The trigger must be
+// a single word.
+func (h *SetCommandHandler) validatePayload(p interface{}) (bool, store.CustomCommand) {
+	payload, ok := p.(map[string]interface{})
+	if !ok {
+		return false, store.CustomCommand{}
+	}
+	trigger, ok := payload["trigger"].(string)
+	if !ok || trigger == "" || strings.ContainsAny(trigger, " \t\r\n") {
+		return false, store.CustomCommand{}
+	}
+	response, ok := payload["response"].(string)
+	if !ok || strings.TrimSpace(response) == "" {
+		return false, store.CustomCommand{}
+	}
+
+	return true, store.CustomCommand{
+		Trigger:  trigger,
+		Response: response,
+	}
+}
+
+// DeleteCommandHandler removes a custom bot command.
+type DeleteCommandHandler struct {
+	store CommandsStore
+}
+
+// NewDeleteCommandHandler returns a new DeleteCommandHandler.
+func NewDeleteCommandHandler(store CommandsStore) *DeleteCommandHandler {
+	return &DeleteCommandHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *DeleteCommandHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	trigger, ok := e.Payload.(string)
+	if !ok || trigger == "" {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	err := h.store.DeleteCustomCommand(userID, trigger)
+	if err != nil {
+		if err == store.ErrUnknownCustomCommand {
+			resp.Error = handlers.UnknownBotCommand
+			return
+		}
+		log.Printf("unable to delete custom command: %s", err)
+		return
+	}
+	resp.Error = nil
+}
@@ -0,0 +1,183 @@
+package bot_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/bot"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestListingCommands(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyCommandsStore{
+		cmds: []store.CustomCommand{
+			{
+				Trigger:  "!hug",
+				Response: "{user} hugs {args}",
+				Count:    3,
+			},
+		},
+	}
+	handler := bot.NewCommandsListHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-commands-list",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-commands-list",
+		RequestID: "test-request-id",
+		Payload: []map[string]interface{}{
+			{
+				"trigger":  "!hug",
+				"response": "{user} hugs {args}",
+				"count":    3,
+			},
+		},
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestListingCommandsWhenStoreFails(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := bot.NewCommandsListHandler(&SpyCommandsStore{
+		listErr: errors.New("test-error"),
+	})
+	event := handlers.Event{
+		Cmd:       "bot-commands-list",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-commands-list",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingACommand(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyCommandsStore{}
+	handler := bot.NewSetCommandHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-command-set",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"trigger":  "!hug",
+			"response": "{user} hugs {args}",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-command-set",
+		RequestID: "test-request-id",
+	}
+	expect(spyStore.setCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.setCalledWithCmd).To.Equal(store.CustomCommand{
+		Trigger:  "!hug",
+		Response: "{user} hugs {args}",
+	})
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingACommandWithInvalidPayloads(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"empty payload":       nil,
+		"missing trigger":     map[string]interface{}{"response": "test-response"},
+		"multi word trigger":  map[string]interface{}{"trigger": "a b", "response": "test-response"},
+		"missing response":    map[string]interface{}{"trigger": "!test"},
+		"blank response":      map[string]interface{}{"trigger": "!test", "response": " "},
+		"non string response": map[string]interface{}{"trigger": "!test", "response": 1.0},
+	}
+
+	for _, payload := range cases {
+		spySession := &SpySession{}
+		spyStore := &SpyCommandsStore{}
+		handler := bot.NewSetCommandHandler(spyStore)
+		event := handlers.Event{
+			Cmd:       "bot-command-set",
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+
+		handler.HandleEvent(event, spySession)
+
+		expected := handlers.Event{
+			Cmd:       "bot-command-set",
+			RequestID: "test-request-id",
+			Error:     handlers.InvalidPayload,
+		}
+		expect(spySession.sendCalledWith).To.Equal(expected)
+		expect(spyStore.setCalledWithUserID).To.Equal("")
+	}
+}
+
+func TestDeletingACommand(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyCommandsStore{}
+	handler := bot.NewDeleteCommandHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-command-delete",
+		RequestID: "test-request-id",
+		Payload:   "!hug",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-command-delete",
+		RequestID: "test-request-id",
+	}
+	expect(spyStore.deleteCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.deleteCalledWithTrigger).To.Equal("!hug")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestDeletingAnUnknownCommand(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := bot.NewDeleteCommandHandler(&SpyCommandsStore{
+		deleteErr: store.ErrUnknownCustomCommand,
+	})
+	event := handlers.Event{
+		Cmd:       "bot-command-delete",
+		RequestID: "test-request-id",
+		Payload:   "!hug",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-command-delete",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownBotCommand,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"log"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// ModerationStore gets and sets the user's moderation rules.
+type ModerationStore interface {
+	ModerationRules(userID string) (rules store.ModerationRules, err error)
+	SetModerationRules(userID string, rules store.ModerationRules) (err error)
+}
+
+// ModerationRulesHandler responds with the user's moderation rules.
+type ModerationRulesHandler struct {
+	store ModerationStore
+}
+
+// NewModerationRulesHandler returns a new ModerationRulesHandler.
+func NewModerationRulesHandler(store ModerationStore) *ModerationRulesHandler {
+	return &ModerationRulesHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *ModerationRulesHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	rules, err := h.store.ModerationRules(userID)
+	if err != nil {
+		log.Printf("unable to get moderation rules: %s", err)
+		return
+	}
+
+	resp.Payload = map[string]interface{}{
+		"enabled":          rules.Enabled,
+		"banned_phrases":   nonNil(rules.BannedPhrases),
+		"banned_patterns":  nonNil(rules.BannedPatterns),
+		"block_links":      rules.BlockLinks,
+		"allowed_domains":  nonNil(rules.AllowedDomains),
+		"max_caps_percent": rules.MaxCapsPercent,
+		"min_caps_length":  rules.MinCapsLength,
+		"max_emotes":       rules.MaxEmotes,
+		"timeout_duration": int64(rules.TimeoutDuration / time.Second),
+		"ban_after":        rules.BanAfter,
+	}
+	resp.Error = nil
+}
+
+// nonNil returns an empty slice in place of nil so that it is encoded as an
+// empty array.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// SetModerationRulesHandler sets the user's moderation rules.
+type SetModerationRulesHandler struct {
+	store ModerationStore
+}
+
+// NewSetModerationRulesHandler returns a new SetModerationRulesHandler.
+func NewSetModerationRulesHandler(store ModerationStore) *SetModerationRulesHandler {
+	return &SetModerationRulesHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *SetModerationRulesHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, rules := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	err := h.store.SetModerationRules(userID, rules)
+	if err != nil {
+		if err == store.ErrInvalidModerationRules {
+			resp.Error = handlers.InvalidPayload
+			return
+		}
+		log.Printf("unable to set moderation rules: %s", err)
+		return
+	}
+	resp.Error = nil
+}
+
+// validatePayload returns true if the payload is valid. The timeout duration
+// is in seconds.
+func (h *SetModerationRulesHandler) validatePayload(p interface{}) (bool, store.ModerationRules) {
+	payload, ok := p.(map[string]interface{})
+	if !ok {
+		return false, store.ModerationRules{}
+	}
+
+	var rules store.ModerationRules
+	rules.Enabled, ok = payload["enabled"].(bool)
+	if !ok {
+		return false, store.ModerationRules{}
+	}
+	rules.BlockLinks, ok = payload["block_links"].(bool)
+	if !ok {
+		return false, store.ModerationRules{}
+	}
+
+	lists := map[string]*[]string{
+		"banned_phrases":  &rules.BannedPhrases,
+		"banned_patterns": &rules.BannedPatterns,
+		"allowed_domains": &rules.AllowedDomains,
+	}
+	for key, dst := range lists {
+		*dst, ok = stringList(payload[key])
+		if !ok {
+			return false, store.ModerationRules{}
+		}
+	}
+
+	ints := map[string]*int{
+		"max_caps_percent": &rules.MaxCapsPercent,
+		"min_caps_length":  &rules.MinCapsLength,
+		"max_emotes":       &rules.MaxEmotes,
+		"ban_after":        &rules.BanAfter,
+	}
+	for key, dst := range ints {
+		*dst, ok = nonNegativeInt(payload[key])
+		if !ok {
+			return false, store.ModerationRules{}
+		}
+	}
+
+	timeout, ok := nonNegativeInt(payload["timeout_duration"])
+	if !ok {
+		return false, store.ModerationRules{}
+	}
+	rules.TimeoutDuration = time.Duration(timeout) * time.Second
+
+	return true, rules
+}
+
+// stringList returns the strings in a JSON array. It returns false if any
+// of the elements are not strings.
+func stringList(v interface{}) ([]string, bool) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		list = append(list, s)
+	}
+	return list, true
+}
+
+// nonNegativeInt returns the integer value of a JSON number. It returns
+// false if the number is negative or has a fractional part.
+func nonNegativeInt(v interface{}) (int, bool) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != float64(int(f)) {
+		return 0, false
+	}
+	return int(f), true
+}
@@ -0,0 +1,158 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/bot"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestGettingModerationRules(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyModerationStore{
+		rules: store.ModerationRules{
+			Enabled:         true,
+			BannedPhrases:   []string{"bad phrase"},
+			BlockLinks:      true,
+			MaxCapsPercent:  70,
+			MinCapsLength:   10,
+			MaxEmotes:       5,
+			TimeoutDuration: 10 * time.Minute,
+			BanAfter:        3,
+		},
+	}
+	handler := bot.NewModerationRulesHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-moderation-rules",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-moderation-rules",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"enabled":          true,
+			"banned_phrases":   []string{"bad phrase"},
+			"banned_patterns":  []string{},
+			"block_links":      true,
+			"allowed_domains":  []string{},
+			"max_caps_percent": 70,
+			"min_caps_length":  10,
+			"max_emotes":       5,
+			"timeout_duration": int64(600),
+			"ban_after":        3,
+		},
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingModerationRules(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyModerationStore{}
+	handler := bot.NewSetModerationRulesHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-set-moderation-rules",
+		RequestID: "test-request-id",
+		Payload:   validModerationPayload(),
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyStore.setCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.setCalledWithRules).To.Equal(store.ModerationRules{
+		Enabled:         true,
+		BannedPhrases:   []string{"bad phrase"},
+		BannedPatterns:  []string{`b[a4]d`},
+		BlockLinks:      true,
+		AllowedDomains:  []string{"twitch.tv"},
+		MaxCapsPercent:  70,
+		MinCapsLength:   10,
+		MaxEmotes:       5,
+		TimeoutDuration: 10 * time.Minute,
+		BanAfter:        3,
+	})
+	expected := handlers.Event{
+		Cmd:       "bot-set-moderation-rules",
+		RequestID: "test-request-id",
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingModerationRulesWithInvalidPayload(t *testing.T) {
+	cases := map[string]func(map[string]interface{}){
+		"missing enabled":   func(p map[string]interface{}) { delete(p, "enabled") },
+		"phrases not list":  func(p map[string]interface{}) { p["banned_phrases"] = "bad" },
+		"non string domain": func(p map[string]interface{}) { p["allowed_domains"] = []interface{}{1.0} },
+		"negative emotes":   func(p map[string]interface{}) { p["max_emotes"] = -1.0 },
+		"fractional caps":   func(p map[string]interface{}) { p["max_caps_percent"] = 50.5 },
+		"missing timeout":   func(p map[string]interface{}) { delete(p, "timeout_duration") },
+	}
+
+	for name, modify := range cases {
+		t.Run(name, func(t *testing.T) {
+			expect := expect.New(t)
+
+			spySession := &SpySession{}
+			spyStore := &SpyModerationStore{}
+			handler := bot.NewSetModerationRulesHandler(spyStore)
+			payload := validModerationPayload()
+			modify(payload)
+			event := handlers.Event{
+				Cmd:       "bot-set-moderation-rules",
+				RequestID: "test-request-id",
+				Payload:   payload,
+			}
+
+			handler.HandleEvent(event, spySession)
+
+			expect(spyStore.setCalledWithUserID).To.Equal("")
+			expect(spySession.sendCalledWith.Error).To.Equal(handlers.InvalidPayload)
+		})
+	}
+}
+
+func TestSettingModerationRulesRejectedByStore(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := bot.NewSetModerationRulesHandler(&SpyModerationStore{
+		setErr: store.ErrInvalidModerationRules,
+	})
+	event := handlers.Event{
+		Cmd:       "bot-set-moderation-rules",
+		RequestID: "test-request-id",
+		Payload:   validModerationPayload(),
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spySession.sendCalledWith.Error).To.Equal(handlers.InvalidPayload)
+}
+
+func validModerationPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":          true,
+		"banned_phrases":   []interface{}{"bad phrase"},
+		"banned_patterns":  []interface{}{`b[a4]d`},
+		"block_links":      true,
+		"allowed_domains":  []interface{}{"twitch.tv"},
+		"max_caps_percent": 70.0,
+		"min_caps_length":  10.0,
+		"max_emotes":       5.0,
+		"timeout_duration": 600.0,
+		"ban_after":        3.0,
+	}
+}
@@ -0,0 +1,99 @@
+package bot_test
+
+import (
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+type SpySession struct {
+	handlers.Session
+	sendCalledWith handlers.Event
+	userID         string
+	authenticated  bool
+}
+
+func (s *SpySession) Send(e handlers.Event) error {
+	s.sendCalledWith = e
+	return nil
+}
+
+func (s *SpySession) Authenticated() (userID string, authenticated bool) {
+	return s.userID, s.authenticated
+}
+
+type SpyCommandsStore struct {
+	cmds    []store.CustomCommand
+	listErr error
+
+	setCalledWithUserID string
+	setCalledWithCmd    store.CustomCommand
+	setErr              error
+
+	deleteCalledWithUserID  string
+	deleteCalledWithTrigger string
+	deleteErr               error
+}
+
+func (s *SpyCommandsStore) CustomCommands(userID string) (cmds []store.CustomCommand, err error) {
+	return s.cmds, s.listErr
+}
+
+func (s *SpyCommandsStore) SetCustomCommand(userID string, cmd store.CustomCommand) (err error) {
+	s.setCalledWithUserID = userID
+	s.setCalledWithCmd = cmd
+	return s.setErr
+}
+
+func (s *SpyCommandsStore) DeleteCustomCommand(userID, trigger string) (err error) {
+	s.deleteCalledWithUserID = userID
+	s.deleteCalledWithTrigger = trigger
+	return s.deleteErr
+}
+
+type SpyModerationStore struct {
+	rules  store.ModerationRules
+	getErr error
+
+	setCalledWithUserID string
+	setCalledWithRules  store.ModerationRules
+	setErr              error
+}
+
+func (s *SpyModerationStore) ModerationRules(userID string) (rules store.ModerationRules, err error) {
+	return s.rules, s.getErr
+}
+
+func (s *SpyModerationStore) SetModerationRules(userID string, rules store.ModerationRules) (err error) {
+	s.setCalledWithUserID = userID
+	s.setCalledWithRules = rules
+	return s.setErr
+}
+
+type SpyTimersStore struct {
+	timers  []store.Timer
+	listErr error
+
+	setCalledWithUserID string
+	setCalledWithTimer  store.Timer
+	setErr              error
+
+	deleteCalledWithUserID string
+	deleteCalledWithName   string
+	deleteErr              error
+}
+
+func (s *SpyTimersStore) Timers(userID string) (timers []store.Timer, err error) {
+	return s.timers, s.listErr
+}
+
+func (s *SpyTimersStore) SetTimer(userID string, timer store.Timer) (err error) {
+	s.setCalledWithUserID = userID
+	s.setCalledWithTimer = timer
+	return s.setErr
+}
+
+func (s *SpyTimersStore) DeleteTimer(userID, name string) (err error) {
+	s.deleteCalledWithUserID = userID
+	s.deleteCalledWithName = name
+	return s.deleteErr
+}
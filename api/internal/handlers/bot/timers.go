@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// TimersStore manages the user's bot timers.
+type TimersStore interface {
+	Timers(userID string) (timers []store.Timer, err error)
+	SetTimer(userID string, timer store.Timer) (err error)
+	DeleteTimer(userID, name string) (err error)
+}
+
+// TimersListHandler responds with the user's bot timers.
+type TimersListHandler struct {
+	store TimersStore
+}
+
+// NewTimersListHandler returns a new TimersListHandler.
+func NewTimersListHandler(store TimersStore) *TimersListHandler {
+	return &TimersListHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *TimersListHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	timers, err := h.store.Timers(userID)
+	if err != nil {
+		log.Printf("unable to get timers: %s", err)
+		return
+	}
+
+	payload := make([]map[string]interface{}, 0, len(timers))
+	for _, timer := range timers {
+		payload = append(payload, map[string]interface{}{
+			"name":      timer.Name,
+			"message":   timer.Message,
+			"interval":  int64(timer.Interval / time.Second),
+			"min_lines": timer.MinLines,
+		})
+	}
+	resp.Payload = payload
+	resp.Error = nil
+}
+
+// SetTimerHandler creates or updates a bot timer.
+type SetTimerHandler struct {
+	store TimersStore
+}
+
+// NewSetTimerHandler returns a new SetTimerHandler.
+func NewSetTimerHandler(store TimersStore) *SetTimerHandler {
+	return &SetTimerHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *SetTimerHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, timer := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	err := h.store.SetTimer(userID, timer)
+	if err != nil {
+		if err == store.ErrInvalidTimer {
+			resp.Error = handlers.InvalidPayload
+			return
+		}
+		log.Printf("unable to set timer: %s", err)
+		return
+	}
+	resp.Error = nil
+}
+
+// validatePayload returns true if the payload is valid. The interval is in
+// seconds.
+func (h *SetTimerHandler) validatePayload(p interface{}) (bool, store.Timer) {
+	payload, ok := p.(map[string]interface{})
+	if !ok {
+		return false, store.Timer{}
+	}
+	name, ok := payload["name"].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return false, store.Timer{}
+	}
+	message, ok := payload["message"].(string)
+	if !ok || strings.TrimSpace(message) == "" {
+		return false, store.Timer{}
+	}
+	interval, ok := nonNegativeInt(payload["interval"])
+	if !ok {
+		return false, store.Timer{}
+	}
+	minLines, ok := nonNegativeInt(payload["min_lines"])
+	if !ok {
+		return false, store.Timer{}
+	}
+
+	return true, store.Timer{
+		Name:     name,
+		Message:  message,
+		Interval: time.Duration(interval) * time.Second,
+		MinLines: minLines,
+	}
+}
+
+// DeleteTimerHandler removes a bot timer.
+type DeleteTimerHandler struct {
+	store TimersStore
+}
+
+// NewDeleteTimerHandler returns a new DeleteTimerHandler.
+func NewDeleteTimerHandler(store TimersStore) *DeleteTimerHandler {
+	return &DeleteTimerHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *DeleteTimerHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	name, ok := e.Payload.(string)
+	if !ok || name == "" {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	err := h.store.DeleteTimer(userID, name)
+	if err != nil {
+		if err == store.ErrUnknownTimer {
+			resp.Error = handlers.UnknownBotTimer
+			return
+		}
+		log.Printf("unable to delete timer: %s", err)
+		return
+	}
+	resp.Error = nil
+}
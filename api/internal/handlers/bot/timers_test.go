@@ -0,0 +1,170 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/bot"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestListingTimers(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyTimersStore{
+		timers: []store.Timer{
+			{
+				Name:     "socials",
+				Message:  "follow me on twitter",
+				Interval: 15 * time.Minute,
+				MinLines: 10,
+			},
+		},
+	}
+	handler := bot.NewTimersListHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-timers-list",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-timers-list",
+		RequestID: "test-request-id",
+		Payload: []map[string]interface{}{
+			{
+				"name":      "socials",
+				"message":   "follow me on twitter",
+				"interval":  int64(900),
+				"min_lines": 10,
+			},
+		},
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingATimer(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyTimersStore{}
+	handler := bot.NewSetTimerHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-timer-set",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"name":      "socials",
+			"message":   "follow me on twitter",
+			"interval":  900.0,
+			"min_lines": 10.0,
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-timer-set",
+		RequestID: "test-request-id",
+	}
+	expect(spyStore.setCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.setCalledWithTimer).To.Equal(store.Timer{
+		Name:     "socials",
+		Message:  "follow me on twitter",
+		Interval: 15 * time.Minute,
+		MinLines: 10,
+	})
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingATimerWithInvalidPayloads(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"empty payload":       nil,
+		"missing name":        map[string]interface{}{"message": "m", "interval": 60.0, "min_lines": 0.0},
+		"blank message":       map[string]interface{}{"name": "n", "message": " ", "interval": 60.0, "min_lines": 0.0},
+		"missing interval":    map[string]interface{}{"name": "n", "message": "m", "min_lines": 0.0},
+		"negative min lines":  map[string]interface{}{"name": "n", "message": "m", "interval": 60.0, "min_lines": -1.0},
+		"fractional interval": map[string]interface{}{"name": "n", "message": "m", "interval": 60.5, "min_lines": 0.0},
+	}
+
+	for _, payload := range cases {
+		spySession := &SpySession{}
+		spyStore := &SpyTimersStore{}
+		handler := bot.NewSetTimerHandler(spyStore)
+		event := handlers.Event{
+			Cmd:       "bot-timer-set",
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+
+		handler.HandleEvent(event, spySession)
+
+		expected := handlers.Event{
+			Cmd:       "bot-timer-set",
+			RequestID: "test-request-id",
+			Error:     handlers.InvalidPayload,
+		}
+		expect(spySession.sendCalledWith).To.Equal(expected)
+		expect(spyStore.setCalledWithUserID).To.Equal("")
+	}
+}
+
+func TestSettingATimerRejectedByStore(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := bot.NewSetTimerHandler(&SpyTimersStore{
+		setErr: store.ErrInvalidTimer,
+	})
+	event := handlers.Event{
+		Cmd:       "bot-timer-set",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"name":      "socials",
+			"message":   "follow me on twitter",
+			"interval":  1.0,
+			"min_lines": 0.0,
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spySession.sendCalledWith.Error).To.Equal(handlers.InvalidPayload)
+}
+
+func TestDeletingAnUnknownTimer(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyTimersStore{
+		deleteErr: store.ErrUnknownTimer,
+	}
+	handler := bot.NewDeleteTimerHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "bot-timer-delete",
+		RequestID: "test-request-id",
+		Payload:   "socials",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "bot-timer-delete",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownBotTimer,
+	}
+	expect(spyStore.deleteCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.deleteCalledWithName).To.Equal("socials")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -0,0 +1,55 @@
+package discord
+
+import (
+	"log"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// ChannelLister lists the text channels in a Discord guild.
+type ChannelLister interface {
+	DiscordChannels(userID, guildID string) (channels []stream.DiscordChannel, err error)
+}
+
+// ChannelsHandler lists the text channels in the user's linked guild.
+type ChannelsHandler struct {
+	creds    CredentialsProvider
+	channels ChannelLister
+}
+
+// NewChannelsHandler returns a new ChannelsHandler.
+func NewChannelsHandler(
+	creds CredentialsProvider,
+	channels ChannelLister,
+) *ChannelsHandler {
+	return &ChannelsHandler{
+		creds:    creds,
+		channels: channels,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *ChannelsHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	creds, err := h.creds.DiscordCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get discord credentials for user: %s: %s", userID, err)
+		return
+	}
+
+	channels, err := h.channels.DiscordChannels(userID, creds.GuildID)
+	if err != nil {
+		log.Printf("unable to list discord channels for user: %s: %s", userID, err)
+		resp.Error = handlers.DiscordUnavailable
+		return
+	}
+	if channels == nil {
+		channels = []stream.DiscordChannel{}
+	}
+	resp.Payload = channels
+	resp.Error = nil
+}
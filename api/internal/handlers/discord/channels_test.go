@@ -0,0 +1,74 @@
+package discord_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/discord"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestDiscordChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.DiscordCredentials{
+			Authenticated: true,
+			GuildID:       "test-guild-id",
+		},
+	}
+	channels := []stream.DiscordChannel{
+		{ID: "test-channel-id", Name: "general"},
+	}
+	spyStreamManager := &SpyStreamManager{
+		channels: channels,
+	}
+	handler := discord.NewChannelsHandler(spyCredsProvider, spyStreamManager)
+	event := handlers.Event{
+		Cmd:       "discord-channels",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-channels",
+		RequestID: "test-request-id",
+		Payload:   channels,
+	}
+	expect(spyStreamManager.channelsCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStreamManager.channelsCalledWithGuildID).To.Equal("test-guild-id")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestDiscordChannelsWhenDiscordIsUnavailable(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStreamManager := &SpyStreamManager{
+		err: errors.New("test-error"),
+	}
+	handler := discord.NewChannelsHandler(&SpyCredentialsProvider{}, spyStreamManager)
+	event := handlers.Event{
+		Cmd:       "discord-channels",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-channels",
+		RequestID: "test-request-id",
+		Error:     handlers.DiscordUnavailable,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -0,0 +1,46 @@
+package discord
+
+import (
+	"log"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// CredentialsProvider provides Discord credentials.
+type CredentialsProvider interface {
+	DiscordCredentials(userID string) (creds store.DiscordCredentials, err error)
+}
+
+// UserDetailsHandler provides information on the Discord guild the user has
+// linked.
+type UserDetailsHandler struct {
+	creds CredentialsProvider
+}
+
+// NewUserDetailsHandler returns a new UserDetailsHandler.
+func NewUserDetailsHandler(creds CredentialsProvider) *UserDetailsHandler {
+	return &UserDetailsHandler{
+		creds: creds,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *UserDetailsHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	creds, err := h.creds.DiscordCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get discord credentials for user: %s: %s", userID, err)
+		return
+	}
+
+	resp.Payload = map[string]interface{}{
+		"authenticated": creds.Authenticated,
+		"guild_id":      creds.GuildID,
+		"owner_id":      creds.OwnerID,
+	}
+	resp.Error = nil
+}
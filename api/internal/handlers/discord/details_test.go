@@ -0,0 +1,97 @@
+package discord_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/discord"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestDiscordUserDetails(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.DiscordCredentials{
+			Authenticated: true,
+			OwnerID:       "test-owner-id",
+			GuildID:       "test-guild-id",
+		},
+	}
+	handler := discord.NewUserDetailsHandler(spyCredsProvider)
+	event := handlers.Event{
+		Cmd:       "discord-user-details",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-user-details",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"authenticated": true,
+			"guild_id":      "test-guild-id",
+			"owner_id":      "test-owner-id",
+		},
+	}
+	expect(spyCredsProvider.calledWith).To.Equal("test-user-id")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestDiscordUserDetailsWhenNotLinked(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	handler := discord.NewUserDetailsHandler(&SpyCredentialsProvider{})
+	event := handlers.Event{
+		Cmd:       "discord-user-details",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-user-details",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"authenticated": false,
+			"guild_id":      "",
+			"owner_id":      "",
+		},
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestErrorWhenGettingDiscordCredentials(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		err: errors.New("test-error"),
+	}
+	handler := discord.NewUserDetailsHandler(spyCredsProvider)
+	event := handlers.Event{
+		Cmd:       "discord-user-details",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-user-details",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -0,0 +1,141 @@
+package discord
+
+import (
+	"log"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// StreamManager is used to list Discord channels and members and send
+// messages to them.
+type StreamManager interface {
+	ChannelLister
+	IsDiscordGuildMember(userID, guildID, memberID string) (member bool, err error)
+	Send(msg stream.TXMessage)
+}
+
+// SendMessageHandler accepts messages to send to a channel in the user's
+// linked guild or directly to a member of it. The bot is shared by every
+// user so it only sends to channels and members of the user's own guild.
+type SendMessageHandler struct {
+	creds         CredentialsProvider
+	streamManager StreamManager
+}
+
+// NewSendMessageHandler returns a new SendMessageHandler.
+func NewSendMessageHandler(
+	creds CredentialsProvider,
+	streamManager StreamManager,
+) *SendMessageHandler {
+	return &SendMessageHandler{
+		creds:         creds,
+		streamManager: streamManager,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *SendMessageHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, payload := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	creds, err := h.creds.DiscordCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get discord credentials for user: %s: %s", userID, err)
+		return
+	}
+	switch payload.sendType {
+	case stream.Channel:
+		channels, err := h.streamManager.DiscordChannels(userID, creds.GuildID)
+		if err != nil {
+			log.Printf("unable to list discord channels for user: %s: %s", userID, err)
+			resp.Error = handlers.DiscordUnavailable
+			return
+		}
+		if !hasChannel(channels, payload.to) {
+			resp.Error = handlers.UnknownDiscordChannel
+			return
+		}
+	case stream.Private:
+		if !creds.Authenticated {
+			resp.Error = handlers.UnknownDiscordUser
+			return
+		}
+		member, err := h.streamManager.IsDiscordGuildMember(userID, creds.GuildID, payload.to)
+		if err != nil {
+			log.Printf("unable to check discord guild member for user: %s: %s", userID, err)
+			resp.Error = handlers.DiscordUnavailable
+			return
+		}
+		if !member {
+			resp.Error = handlers.UnknownDiscordUser
+			return
+		}
+	}
+
+	h.streamManager.Send(stream.TXMessage{
+		Type: stream.Discord,
+		Discord: &stream.TXDiscord{
+			UserID:  userID,
+			Type:    payload.sendType,
+			To:      payload.to,
+			Message: payload.message,
+		},
+	})
+	resp.Error = nil
+}
+
+// sendMessagePayload represents the payload that should be sent when
+// sending a message.
+type sendMessagePayload struct {
+	sendType stream.TXDiscordType
+	to       string
+	message  string
+}
+
+// validatePayload returns true if the payload is valid.
+func (h *SendMessageHandler) validatePayload(p interface{}) (bool, sendMessagePayload) {
+	data, ok := p.(map[string]interface{})
+	if !ok {
+		return false, sendMessagePayload{}
+	}
+	var sendType stream.TXDiscordType
+	switch data["type"] {
+	case "channel":
+		sendType = stream.Channel
+	case "private":
+		sendType = stream.Private
+	default:
+		return false, sendMessagePayload{}
+	}
+	to, ok := data["to"].(string)
+	if !ok || to == "" {
+		return false, sendMessagePayload{}
+	}
+	message, ok := data["message"].(string)
+	if !ok || message == "" {
+		return false, sendMessagePayload{}
+	}
+	return true, sendMessagePayload{
+		sendType: sendType,
+		to:       to,
+		message:  message,
+	}
+}
+
+// hasChannel returns true if the channel ID is in the list of channels.
+func hasChannel(channels []stream.DiscordChannel, channelID string) bool {
+	for _, ch := range channels {
+		if ch.ID == channelID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package discord_test
+
+import (
+	"testing"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/discord"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestSendingToADiscordChannel(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.DiscordCredentials{
+			Authenticated: true,
+			GuildID:       "test-guild-id",
+		},
+	}
+	spyStreamManager := &SpyStreamManager{
+		channels: []stream.DiscordChannel{
+			{ID: "test-channel-id", Name: "general"},
+		},
+	}
+	handler := discord.NewSendMessageHandler(spyCredsProvider, spyStreamManager)
+	event := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"type":    "channel",
+			"to":      "test-channel-id",
+			"message": "test-message",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+	expect(spyStreamManager.channelsCalledWithGuildID).To.Equal("test-guild-id")
+	expect(spyStreamManager.sendCalledWith).To.Equal([]stream.TXMessage{
+		{
+			Type: stream.Discord,
+			Discord: &stream.TXDiscord{
+				UserID:  "test-user-id",
+				Type:    stream.Channel,
+				To:      "test-channel-id",
+				Message: "test-message",
+			},
+		},
+	})
+}
+
+func TestSendingToAChannelOutsideTheLinkedGuild(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStreamManager := &SpyStreamManager{
+		channels: []stream.DiscordChannel{
+			{ID: "test-channel-id", Name: "general"},
+		},
+	}
+	handler := discord.NewSendMessageHandler(&SpyCredentialsProvider{}, spyStreamManager)
+	event := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"type":    "channel",
+			"to":      "other-channel-id",
+			"message": "test-message",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownDiscordChannel,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+	expect(len(spyStreamManager.sendCalledWith)).To.Equal(0)
+}
+
+func TestSendingADiscordDirectMessage(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.DiscordCredentials{
+			Authenticated: true,
+			GuildID:       "test-guild-id",
+		},
+	}
+	spyStreamManager := &SpyStreamManager{
+		member: true,
+	}
+	handler := discord.NewSendMessageHandler(spyCredsProvider, spyStreamManager)
+	event := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"type":    "private",
+			"to":      "test-discord-user-id",
+			"message": "test-message",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+	expect(spyStreamManager.memberCalledWithGuildID).To.Equal("test-guild-id")
+	expect(spyStreamManager.memberCalledWithMemberID).To.Equal("test-discord-user-id")
+	expect(spyStreamManager.sendCalledWith).To.Equal([]stream.TXMessage{
+		{
+			Type: stream.Discord,
+			Discord: &stream.TXDiscord{
+				UserID:  "test-user-id",
+				Type:    stream.Private,
+				To:      "test-discord-user-id",
+				Message: "test-message",
+			},
+		},
+	})
+}
+
+func TestSendingADirectMessageOutsideTheLinkedGuild(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.DiscordCredentials{
+			Authenticated: true,
+			GuildID:       "test-guild-id",
+		},
+	}
+	spyStreamManager := &SpyStreamManager{
+		member: false,
+	}
+	handler := discord.NewSendMessageHandler(spyCredsProvider, spyStreamManager)
+	event := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"type":    "private",
+			"to":      "other-discord-user-id",
+			"message": "test-message",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-send-message",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownDiscordUser,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+	expect(len(spyStreamManager.sendCalledWith)).To.Equal(0)
+}
+
+func TestSendDiscordMessageInvalidPayloads(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"empty payload": nil,
+		"invalid type": map[string]interface{}{
+			"type":    "invalid",
+			"to":      "test-channel-id",
+			"message": "test-message",
+		},
+		"missing to": map[string]interface{}{
+			"type":    "channel",
+			"message": "test-message",
+		},
+		"empty message": map[string]interface{}{
+			"type":    "channel",
+			"to":      "test-channel-id",
+			"message": "",
+		},
+	}
+
+	for _, payload := range cases {
+		spySession := &SpySession{}
+		handler := discord.NewSendMessageHandler(nil, nil)
+		event := handlers.Event{
+			Cmd:       "discord-send-message",
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+
+		handler.HandleEvent(event, spySession)
+
+		expected := handlers.Event{
+			Cmd:       "discord-send-message",
+			RequestID: "test-request-id",
+			Error:     handlers.InvalidPayload,
+		}
+		expect(spySession.sendCalledWith).To.Equal(expected)
+	}
+}
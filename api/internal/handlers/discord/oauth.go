@@ -0,0 +1,134 @@
+package discord
+
+import (
+	"log"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/discord/oauth"
+)
+
+// NonceStore stores Discord oauth nonces.
+type NonceStore interface {
+	DiscordOauthNonce(userID string) (nonce string, err error)
+	StoreDiscordOauthNonce(userID, nonce string) (err error)
+}
+
+// NonceGenerator generates a random nonce to be used in the oauth flow.
+type NonceGenerator func() string
+
+// OauthCallbackRegistrar registers callbacks that are invoked when the oauth
+// flow for a given nonce is complete.
+type OauthCallbackRegistrar interface {
+	RegisterCompletionCallback(nonce string, f func())
+}
+
+// BotConnector connects the Discord bot for the user once they have linked
+// their guild.
+type BotConnector interface {
+	StartDiscord(userID string)
+}
+
+// OauthStartHandler responds with a URL to start the Discord oauth flow that
+// adds the bot to the user's guild.
+type OauthStartHandler struct {
+	nonceGen         NonceGenerator
+	nonceStore       NonceStore
+	oauthClientID    string
+	oauthRedirectURL string
+	oauthCallbacks   OauthCallbackRegistrar
+	bots             BotConnector
+}
+
+// NewOauthStartHandler returns a new OauthStartHandler.
+func NewOauthStartHandler(
+	nonceGen NonceGenerator,
+	nonceStore NonceStore,
+	oauthClientID string,
+	oauthRedirectURL string,
+	oauthCallbacks OauthCallbackRegistrar,
+	bots BotConnector,
+) *OauthStartHandler {
+	return &OauthStartHandler{
+		nonceGen:         nonceGen,
+		nonceStore:       nonceStore,
+		oauthClientID:    oauthClientID,
+		oauthRedirectURL: oauthRedirectURL,
+		oauthCallbacks:   oauthCallbacks,
+		bots:             bots,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *OauthStartHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+
+	nonce, err := h.nonceStore.DiscordOauthNonce(userID)
+	if err == nil {
+		resp.Payload = oauth.URL(h.oauthClientID, h.oauthRedirectURL, nonce)
+		resp.Error = nil
+		return
+	}
+
+	nonce = h.nonceGen()
+	err = h.nonceStore.StoreDiscordOauthNonce(userID, nonce)
+	if err != nil {
+		log.Printf("got an err trying to store discord oauth nonce: %s", err)
+		return
+	}
+
+	h.oauthCallbacks.RegisterCompletionCallback(nonce, func() {
+		h.bots.StartDiscord(userID)
+
+		resp := handlers.Event{
+			Cmd: "discord-oauth-complete",
+		}
+		err := s.Send(resp)
+		if err != nil {
+			log.Printf("unable to tx: %s", err)
+		}
+	})
+	resp.Payload = oauth.URL(h.oauthClientID, h.oauthRedirectURL, nonce)
+	resp.Error = nil
+}
+
+// AuthClearer unlinks the Discord guild associated with the user.
+type AuthClearer interface {
+	DiscordClearAuth(userID string) (err error)
+}
+
+// BotDisconnector disconnects the Discord bot for the user.
+type BotDisconnector interface {
+	StopDiscord(userID string)
+}
+
+// ClearAuthHandler unlinks the user's Discord guild and disconnects their
+// Discord bot.
+type ClearAuthHandler struct {
+	authClearer AuthClearer
+	bots        BotDisconnector
+}
+
+// NewClearAuthHandler returns a new ClearAuthHandler.
+func NewClearAuthHandler(ac AuthClearer, bots BotDisconnector) *ClearAuthHandler {
+	return &ClearAuthHandler{
+		authClearer: ac,
+		bots:        bots,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *ClearAuthHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	err := h.authClearer.DiscordClearAuth(userID)
+	if err != nil {
+		return
+	}
+	h.bots.StopDiscord(userID)
+	resp.Error = nil
+}
@@ -0,0 +1,213 @@
+package discord_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/discord"
+)
+
+const testOauthURL = "https://discordapp.com/api/oauth2/authorize?client_id=test-oauth-client-id&permissions=76864&redirect_uri=test-redirect-url&response_type=code&scope=bot&state="
+
+func TestOauthStart(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyNonceGen := func() string {
+		return "test-nonce"
+	}
+	spyNonceStore := &SpyNonceStore{
+		err: errors.New("test-error"),
+	}
+	spyCallbackRegistrar := &SpyOauthCallbackRegistrar{}
+	handler := discord.NewOauthStartHandler(
+		spyNonceGen,
+		spyNonceStore,
+		"test-oauth-client-id",
+		"test-redirect-url",
+		spyCallbackRegistrar,
+		&SpyBotRunner{},
+	)
+	event := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+		Payload:   testOauthURL + "test-nonce",
+	}
+	expect(spyNonceStore.storeCalledWithUserID).To.Equal("test-user-id")
+	expect(spyNonceStore.storeCalledWithNonce).To.Equal("test-nonce")
+	expect(spyCallbackRegistrar.calledWithNonce).To.Equal("test-nonce")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestDiscordNonceAlreadyExists(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyNonceStore := &SpyNonceStore{
+		nonce: "existing-nonce",
+	}
+	handler := discord.NewOauthStartHandler(
+		nil,
+		spyNonceStore,
+		"test-oauth-client-id",
+		"test-redirect-url",
+		&SpyOauthCallbackRegistrar{},
+		&SpyBotRunner{},
+	)
+	event := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+		Payload:   testOauthURL + "existing-nonce",
+	}
+	expect(spyNonceStore.storeCalledWithNonce).To.Equal("")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestErrorWhenStoringDiscordNonce(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyNonceGen := func() string {
+		return "test-nonce"
+	}
+	spyNonceStore := &SpyNonceStore{
+		err:      errors.New("test-error"),
+		storeErr: errors.New("test-error"),
+	}
+	handler := discord.NewOauthStartHandler(
+		spyNonceGen,
+		spyNonceStore,
+		"test-oauth-client-id",
+		"test-redirect-url",
+		&SpyOauthCallbackRegistrar{},
+		&SpyBotRunner{},
+	)
+	event := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestOauthCompletionStartsDiscord(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyNonceGen := func() string {
+		return "test-nonce"
+	}
+	spyNonceStore := &SpyNonceStore{
+		err: errors.New("test-error"),
+	}
+	spyCallbackRegistrar := &SpyOauthCallbackRegistrar{}
+	spyBotRunner := &SpyBotRunner{}
+	handler := discord.NewOauthStartHandler(
+		spyNonceGen,
+		spyNonceStore,
+		"test-oauth-client-id",
+		"test-redirect-url",
+		spyCallbackRegistrar,
+		spyBotRunner,
+	)
+	event := handlers.Event{
+		Cmd:       "discord-oauth-start",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyBotRunner.startCalledWith).To.Equal("")
+
+	spyCallbackRegistrar.callback()
+
+	expected := handlers.Event{
+		Cmd: "discord-oauth-complete",
+	}
+	expect(spyBotRunner.startCalledWith).To.Equal("test-user-id")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestDiscordClearAuth(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyAuthClearer := &SpyAuthClearer{}
+	spyBotRunner := &SpyBotRunner{}
+	handler := discord.NewClearAuthHandler(spyAuthClearer, spyBotRunner)
+	event := handlers.Event{
+		Cmd:       "discord-clear-auth",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-clear-auth",
+		RequestID: "test-request-id",
+	}
+	expect(spyAuthClearer.calledWith).To.Equal("test-user-id")
+	expect(spyBotRunner.stopCalledWith).To.Equal("test-user-id")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestWhenUnableToClearDiscordAuth(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyAuthClearer := &SpyAuthClearer{
+		err: errors.New("test-error"),
+	}
+	spyBotRunner := &SpyBotRunner{}
+	handler := discord.NewClearAuthHandler(spyAuthClearer, spyBotRunner)
+	event := handlers.Event{
+		Cmd:       "discord-clear-auth",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "discord-clear-auth",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spyBotRunner.stopCalledWith).To.Equal("")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -0,0 +1,122 @@
+package discord_test
+
+import (
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+type SpySession struct {
+	handlers.Session
+	sendCalledWith handlers.Event
+	userID         string
+	authenticated  bool
+}
+
+func (s *SpySession) Send(e handlers.Event) error {
+	s.sendCalledWith = e
+	return nil
+}
+
+func (s *SpySession) Authenticated() (userID string, authenticated bool) {
+	return s.userID, s.authenticated
+}
+
+type SpyNonceStore struct {
+	nonce string
+	err   error
+
+	storeCalledWithUserID string
+	storeCalledWithNonce  string
+	storeErr              error
+}
+
+func (s *SpyNonceStore) DiscordOauthNonce(userID string) (nonce string, err error) {
+	return s.nonce, s.err
+}
+
+func (s *SpyNonceStore) StoreDiscordOauthNonce(userID, nonce string) (err error) {
+	s.storeCalledWithUserID = userID
+	s.storeCalledWithNonce = nonce
+	return s.storeErr
+}
+
+type SpyOauthCallbackRegistrar struct {
+	calledWithNonce string
+	callback        func()
+}
+
+func (s *SpyOauthCallbackRegistrar) RegisterCompletionCallback(nonce string, f func()) {
+	s.calledWithNonce = nonce
+	s.callback = f
+}
+
+type SpyBotRunner struct {
+	startCalledWith string
+	stopCalledWith  string
+}
+
+func (s *SpyBotRunner) StartDiscord(userID string) {
+	s.startCalledWith = userID
+}
+
+func (s *SpyBotRunner) StopDiscord(userID string) {
+	s.stopCalledWith = userID
+}
+
+type SpyAuthClearer struct {
+	calledWith string
+	err        error
+}
+
+func (s *SpyAuthClearer) DiscordClearAuth(userID string) (err error) {
+	s.calledWith = userID
+	return s.err
+}
+
+type SpyCredentialsProvider struct {
+	creds      store.DiscordCredentials
+	err        error
+	calledWith string
+}
+
+func (s *SpyCredentialsProvider) DiscordCredentials(userID string) (creds store.DiscordCredentials, err error) {
+	s.calledWith = userID
+	return s.creds, s.err
+}
+
+type SpyStreamManager struct {
+	channels []stream.DiscordChannel
+	err      error
+	member   bool
+
+	channelsCalledWithUserID  string
+	channelsCalledWithGuildID string
+	memberCalledWithGuildID   string
+	memberCalledWithMemberID  string
+	sendCalledWith            []stream.TXMessage
+}
+
+func (s *SpyStreamManager) DiscordChannels(userID, guildID string) (channels []stream.DiscordChannel, err error) {
+	s.channelsCalledWithUserID = userID
+	s.channelsCalledWithGuildID = guildID
+	return s.channels, s.err
+}
+
+func (s *SpyStreamManager) IsDiscordGuildMember(userID, guildID, memberID string) (member bool, err error) {
+	s.memberCalledWithGuildID = guildID
+	s.memberCalledWithMemberID = memberID
+	return s.member, s.err
+}
+
+func (s *SpyStreamManager) Send(msg stream.TXMessage) {
+	s.sendCalledWith = append(s.sendCalledWith, msg)
+}
+
+type SpyHandler struct {
+	called bool
+}
+
+func (s *SpyHandler) HandleEvent(handlers.Event, handlers.Session) {
+	s.called = true
+}
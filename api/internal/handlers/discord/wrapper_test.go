@@ -0,0 +1,83 @@
+package discord_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/discord"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestLinkedUser(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.DiscordCredentials{
+			Authenticated: true,
+		},
+	}
+	spyHandler := &SpyHandler{}
+	wrapped := discord.AuthenticateWrapper(spyCredsProvider, spyHandler)
+	event := handlers.Event{
+		Cmd:       "test-command",
+		RequestID: "test-request-id",
+	}
+
+	wrapped.HandleEvent(event, spySession)
+
+	expect(spyCredsProvider.calledWith).To.Equal("test-user-id")
+	expect(spyHandler.called).To.Be.True()
+}
+
+func TestUnlinkedUser(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	spyHandler := &SpyHandler{}
+	wrapped := discord.AuthenticateWrapper(&SpyCredentialsProvider{}, spyHandler)
+	event := handlers.Event{
+		Cmd:       "test-command",
+		RequestID: "test-request-id",
+	}
+
+	wrapped.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "test-command",
+		RequestID: "test-request-id",
+		Error:     handlers.DiscordAuthenticationError,
+	}
+	expect(spyHandler.called).To.Be.False()
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestLinkedUserError(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	spyCredsProvider := &SpyCredentialsProvider{
+		err: errors.New("test-error"),
+	}
+	spyHandler := &SpyHandler{}
+	wrapped := discord.AuthenticateWrapper(spyCredsProvider, spyHandler)
+	event := handlers.Event{
+		Cmd:       "test-command",
+		RequestID: "test-request-id",
+	}
+
+	wrapped.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "test-command",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spyHandler.called).To.Be.False()
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -61,4 +61,46 @@ var (
 		Code: 7,
 		Text: "unable to gather emoji from bttv api",
 	}
+	// UnknownBotCommand occurs when the user attempts to modify a custom
+	// bot command that does not exist.
+	UnknownBotCommand = &Error{
+		Code: 8,
+		Text: "bot command does not exist",
+	}
+	// UnknownBotTimer occurs when the user attempts to modify a bot timer
+	// that does not exist.
+	UnknownBotTimer = &Error{
+		Code: 9,
+		Text: "bot timer does not exist",
+	}
+	// DiscordAuthenticationError occurs when the user has not linked a
+	// Discord guild but is trying to access an endpoint that requires one.
+	DiscordAuthenticationError = &Error{
+		Code: 10,
+		Text: "discord guild has not been linked",
+	}
+	// DiscordUnavailable occurs when the Discord bot is not connected for
+	// the user or Discord's API is down.
+	DiscordUnavailable = &Error{
+		Code: 11,
+		Text: "unable to reach discord",
+	}
+	// UnknownDiscordChannel occurs when the user attempts to send a message
+	// to a channel that is not a text channel in their linked guild.
+	UnknownDiscordChannel = &Error{
+		Code: 12,
+		Text: "discord channel does not exist in linked guild",
+	}
+	// TwitchNotConnected occurs when the user attempts to change the
+	// channels of a Twitch connection that has not been established.
+	TwitchNotConnected = &Error{
+		Code: 13,
+		Text: "twitch is not connected",
+	}
+	// UnknownDiscordUser occurs when the user attempts to send a direct
+	// message to a Discord user that is not a member of their linked guild.
+	UnknownDiscordUser = &Error{
+		Code: 14,
+		Text: "discord user is not a member of linked guild",
+	}
 )
@@ -0,0 +1,200 @@
+package twitch
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+var channelPattern = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+// ChannelManager joins, parts and lists the Twitch channels of a user.
+type ChannelManager interface {
+	Connector
+	PartTwitchChannel(user, channel string) (err error)
+	TwitchChannels(user string) (channels []string)
+}
+
+// ChannelsHandler responds with the channels the streamer and bot have
+// joined.
+type ChannelsHandler struct {
+	creds    CredentialsProvider
+	channels ChannelManager
+}
+
+// NewChannelsHandler returns a new ChannelsHandler.
+func NewChannelsHandler(creds CredentialsProvider, channels ChannelManager) *ChannelsHandler {
+	return &ChannelsHandler{
+		creds:    creds,
+		channels: channels,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *ChannelsHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	creds, err := h.creds.TwitchCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get creds: %s", err)
+		return
+	}
+
+	resp.Payload = map[string]interface{}{
+		"streamer": channelList(h.channels.TwitchChannels(creds.StreamerUsername)),
+		"bot":      channelList(h.channels.TwitchChannels(creds.BotUsername)),
+	}
+	resp.Error = nil
+}
+
+// JoinChannelHandler joins a Twitch channel as the streamer or bot so that
+// messages from it are received.
+type JoinChannelHandler struct {
+	creds    CredentialsProvider
+	channels ChannelManager
+}
+
+// NewJoinChannelHandler returns a new JoinChannelHandler.
+func NewJoinChannelHandler(creds CredentialsProvider, channels ChannelManager) *JoinChannelHandler {
+	return &JoinChannelHandler{
+		creds:    creds,
+		channels: channels,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *JoinChannelHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, payload := validateChannelPayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	creds, err := h.creds.TwitchCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get creds: %s", err)
+		return
+	}
+
+	username, password := twitchUser(creds, payload.userType)
+	h.channels.ConnectTwitch(username, "oauth:"+password, payload.channel)
+
+	resp.Payload = map[string]interface{}{
+		"channels": channelList(h.channels.TwitchChannels(username)),
+	}
+	resp.Error = nil
+}
+
+// PartChannelHandler leaves a Twitch channel the streamer or bot has joined.
+type PartChannelHandler struct {
+	creds    CredentialsProvider
+	channels ChannelManager
+}
+
+// NewPartChannelHandler returns a new PartChannelHandler.
+func NewPartChannelHandler(creds CredentialsProvider, channels ChannelManager) *PartChannelHandler {
+	return &PartChannelHandler{
+		creds:    creds,
+		channels: channels,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *PartChannelHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, payload := validateChannelPayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	creds, err := h.creds.TwitchCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get creds: %s", err)
+		return
+	}
+
+	username, _ := twitchUser(creds, payload.userType)
+	err = h.channels.PartTwitchChannel(username, payload.channel)
+	if err != nil {
+		log.Printf("unable to part channel: %s", err)
+		resp.Error = handlers.TwitchNotConnected
+		return
+	}
+
+	resp.Payload = map[string]interface{}{
+		"channels": channelList(h.channels.TwitchChannels(username)),
+	}
+	resp.Error = nil
+}
+
+// channelPayload represents the payload that should be sent when joining or
+// parting a channel.
+type channelPayload struct {
+	userType string
+	channel  string
+}
+
+// validateChannelPayload returns true if the payload is valid.
+func validateChannelPayload(p interface{}) (bool, channelPayload) {
+	data, ok := p.(map[string]interface{})
+	if !ok {
+		return false, channelPayload{}
+	}
+	userType, ok := data["user_type"].(string)
+	if !ok || (userType != "streamer" && userType != "bot") {
+		return false, channelPayload{}
+	}
+	name, ok := data["channel"].(string)
+	if !ok {
+		return false, channelPayload{}
+	}
+	channel, ok := normalizeChannel(name)
+	if !ok {
+		return false, channelPayload{}
+	}
+	return true, channelPayload{
+		userType: userType,
+		channel:  channel,
+	}
+}
+
+// normalizeChannel converts the name of a channel into the form used by
+// Twitch IRC. The leading # is optional and case is ignored. It returns false
+// if the name is not a valid Twitch login.
+func normalizeChannel(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+	if !channelPattern.MatchString(name) {
+		return "", false
+	}
+	return "#" + name, true
+}
+
+// twitchUser returns the username and password of the streamer or bot.
+func twitchUser(creds store.TwitchCredentials, userType string) (string, string) {
+	if userType == "bot" {
+		return creds.BotUsername, creds.BotPassword
+	}
+	return creds.StreamerUsername, creds.StreamerPassword
+}
+
+// channelList ensures an empty list is sent rather than null when no
+// channels have been joined.
+func channelList(channels []string) []string {
+	if channels == nil {
+		return []string{}
+	}
+	return channels
+}
@@ -0,0 +1,213 @@
+package twitch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/twitch"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+var channelCreds = store.TwitchCredentials{
+	StreamerAuthenticated: true,
+	StreamerUsername:      "test-streamer-username",
+	StreamerPassword:      "test-streamer-password",
+
+	BotAuthenticated: true,
+	BotUsername:      "test-bot-username",
+	BotPassword:      "test-bot-password",
+}
+
+func TestListingChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: channelCreds,
+	}
+	spyChannelManager := &SpyChannelManager{
+		channels: map[string][]string{
+			"test-streamer-username": {"#test-streamer-username", "#test-friend"},
+		},
+	}
+	handler := twitch.NewChannelsHandler(spyCredsProvider, spyChannelManager)
+	event := handlers.Event{
+		Cmd:       "twitch-channels",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyCredsProvider.calledWith).To.Equal("test-user-id")
+	expect(spySession.sendCalledWith).To.Equal(handlers.Event{
+		Cmd:       "twitch-channels",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"streamer": []string{"#test-streamer-username", "#test-friend"},
+			"bot":      []string{},
+		},
+	})
+}
+
+func TestJoiningChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyChannelManager := &SpyChannelManager{
+		channels: map[string][]string{
+			"test-bot-username": {"#test-streamer-username", "#test-friend"},
+		},
+	}
+	handler := twitch.NewJoinChannelHandler(
+		&SpyCredentialsProvider{
+			creds: channelCreds,
+		},
+		spyChannelManager,
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-join-channel",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"user_type": "bot",
+			"channel":   "Test-Friend",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyChannelManager.connectCalls).To.Equal([]connectArgs{
+		{
+			user:    "test-bot-username",
+			pass:    "oauth:test-bot-password",
+			channel: "#test-friend",
+		},
+	})
+	expect(spySession.sendCalledWith).To.Equal(handlers.Event{
+		Cmd:       "twitch-join-channel",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"channels": []string{"#test-streamer-username", "#test-friend"},
+		},
+	})
+}
+
+func TestPartingChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyChannelManager := &SpyChannelManager{
+		channels: map[string][]string{
+			"test-streamer-username": {"#test-streamer-username"},
+		},
+	}
+	handler := twitch.NewPartChannelHandler(
+		&SpyCredentialsProvider{
+			creds: channelCreds,
+		},
+		spyChannelManager,
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-part-channel",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"user_type": "streamer",
+			"channel":   "#test-friend",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyChannelManager.partCalledWithUser).To.Equal("test-streamer-username")
+	expect(spyChannelManager.partCalledWithChannel).To.Equal("#test-friend")
+	expect(spySession.sendCalledWith).To.Equal(handlers.Event{
+		Cmd:       "twitch-part-channel",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"channels": []string{"#test-streamer-username"},
+		},
+	})
+}
+
+func TestPartingChannelsWhenNotConnected(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := twitch.NewPartChannelHandler(
+		&SpyCredentialsProvider{
+			creds: channelCreds,
+		},
+		&SpyChannelManager{
+			partErr: errors.New("test-error"),
+		},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-part-channel",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"user_type": "streamer",
+			"channel":   "test-friend",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spySession.sendCalledWith).To.Equal(handlers.Event{
+		Cmd:       "twitch-part-channel",
+		RequestID: "test-request-id",
+		Error:     handlers.TwitchNotConnected,
+	})
+}
+
+func TestJoiningAndPartingInvalidChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"nil payload": nil,
+		"invalid user_type": map[string]interface{}{
+			"user_type": "something-odd",
+			"channel":   "test-friend",
+		},
+		"missing channel": map[string]interface{}{
+			"user_type": "bot",
+		},
+		"invalid channel": map[string]interface{}{
+			"user_type": "bot",
+			"channel":   "not a channel",
+		},
+	}
+
+	for _, cmd := range []string{"twitch-join-channel", "twitch-part-channel"} {
+		for _, payload := range cases {
+			spySession := &SpySession{}
+			var handler handlers.EventHandler = twitch.NewJoinChannelHandler(
+				&SpyCredentialsProvider{},
+				&SpyChannelManager{},
+			)
+			if cmd == "twitch-part-channel" {
+				handler = twitch.NewPartChannelHandler(
+					&SpyCredentialsProvider{},
+					&SpyChannelManager{},
+				)
+			}
+			handler.HandleEvent(handlers.Event{
+				Cmd:       cmd,
+				RequestID: "test-request-id",
+				Payload:   payload,
+			}, spySession)
+
+			expect(spySession.sendCalledWith).To.Equal(handlers.Event{
+				Cmd:       cmd,
+				RequestID: "test-request-id",
+				Error:     handlers.InvalidPayload,
+			})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package twitch
+
+import (
+	"log"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 500
+)
+
+// MessagesFetcher fetches pages of older messages for the user.
+type MessagesFetcher interface {
+	FetchMessages(userID, cursor string, limit int) (msgs []stream.RXMessage, next string, err error)
+}
+
+// ChatHistoryStore fetches pages of messages and twitch credentials for the
+// user.
+type ChatHistoryStore interface {
+	CredentialsProvider
+	MessagesFetcher
+}
+
+// ChatHistoryHandler responds with a page of older chat messages so the
+// client can lazily load scrollback history.
+type ChatHistoryHandler struct {
+	store ChatHistoryStore
+}
+
+// NewChatHistoryHandler returns a new ChatHistoryHandler.
+func NewChatHistoryHandler(store ChatHistoryStore) *ChatHistoryHandler {
+	return &ChatHistoryHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *ChatHistoryHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, payload := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	creds, err := h.store.TwitchCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get creds: %s", err)
+		return
+	}
+
+	msgs, next, err := h.store.FetchMessages(userID, payload.cursor, payload.limit)
+	if err == store.ErrInvalidCursor {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+	if err != nil {
+		log.Printf("unable to fetch messages: %s", err)
+		return
+	}
+
+	messages := make([]Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Type != stream.Twitch {
+			continue
+		}
+		if msg.Twitch.OwnerID == creds.BotTwitchUserID &&
+			!UserMessage(&msg, creds.StreamerUsername) {
+			continue
+		}
+		messages = append(messages, Message{
+			Type:   msg.Type,
+			Twitch: newTMessage(msg.Twitch),
+		})
+	}
+	// cursor is empty when there are no older messages
+	resp.Payload = map[string]interface{}{
+		"messages": messages,
+		"cursor":   next,
+	}
+	resp.Error = nil
+}
+
+// chatHistoryPayload represents the payload that should be sent when
+// requesting chat history.
+type chatHistoryPayload struct {
+	cursor string
+	limit  int
+}
+
+// validatePayload returns true if the payload is valid. Both the cursor and
+// limit are optional.
+func (h *ChatHistoryHandler) validatePayload(p interface{}) (bool, chatHistoryPayload) {
+	payload := chatHistoryPayload{
+		limit: defaultHistoryLimit,
+	}
+	if p == nil {
+		return true, payload
+	}
+	data, ok := p.(map[string]interface{})
+	if !ok {
+		return false, chatHistoryPayload{}
+	}
+	if c, ok := data["cursor"]; ok {
+		cursor, ok := c.(string)
+		if !ok {
+			return false, chatHistoryPayload{}
+		}
+		payload.cursor = cursor
+	}
+	if l, ok := data["limit"]; ok {
+		limit, ok := l.(float64)
+		if !ok || limit < 1 || limit > maxHistoryLimit || limit != float64(int(limit)) {
+			return false, chatHistoryPayload{}
+		}
+		payload.limit = int(limit)
+	}
+	return true, payload
+}
@@ -0,0 +1,172 @@
+package twitch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/fluffle/goirc/client"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/twitch"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestFetchingChatHistory(t *testing.T) {
+	expect := expect.New(t)
+
+	now := time.Now()
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyChatHistoryStore{
+		creds: store.TwitchCredentials{
+			StreamerUsername:     "test-streamer",
+			StreamerTwitchUserID: 12345,
+			BotTwitchUserID:      54321,
+		},
+		next: "test-next-cursor",
+		msgs: []stream.RXMessage{
+			{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 12345,
+					Line: &client.Line{
+						Cmd:  "PRIVMSG",
+						Nick: "test-nick",
+						Args: []string{
+							"#test-streamer",
+							"test-body",
+						},
+						Time: now,
+					},
+				},
+			},
+			{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 54321,
+					Line: &client.Line{
+						Cmd:  "PRIVMSG",
+						Nick: "test-nick",
+						Args: []string{
+							"#test-streamer",
+							"test-duplicate-body",
+						},
+						Time: now,
+					},
+				},
+			},
+		},
+	}
+	handler := twitch.NewChatHistoryHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "chat-history",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"cursor": "test-cursor",
+			"limit":  float64(50),
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyStore.fetchCalledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.fetchCalledWithCursor).To.Equal("test-cursor")
+	expect(spyStore.fetchCalledWithLimit).To.Equal(50)
+
+	expected := handlers.Event{
+		Cmd:       "chat-history",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"messages": []twitch.Message{
+				{
+					Type: stream.Twitch,
+					Twitch: &twitch.TMessage{
+						Cmd:     "PRIVMSG",
+						Nick:    "test-nick",
+						Target:  "#test-streamer",
+						Channel: "#test-streamer",
+						Body:    "test-body",
+						Time:    now,
+					},
+				},
+			},
+			"cursor": "test-next-cursor",
+		},
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestFetchingChatHistoryDefaultsTheLimit(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyChatHistoryStore{}
+	handler := twitch.NewChatHistoryHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "chat-history",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, &SpySession{})
+
+	expect(spyStore.fetchCalledWithCursor).To.Equal("")
+	expect(spyStore.fetchCalledWithLimit).To.Equal(100)
+}
+
+func TestFetchingChatHistoryWithInvalidPayload(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"non-map":        "test-cursor",
+		"non-string":     map[string]interface{}{"cursor": 12345},
+		"non-number":     map[string]interface{}{"limit": "10"},
+		"zero limit":     map[string]interface{}{"limit": float64(0)},
+		"large limit":    map[string]interface{}{"limit": float64(501)},
+		"fraction limit": map[string]interface{}{"limit": 1.5},
+	}
+
+	for _, payload := range cases {
+		spySession := &SpySession{}
+		handler := twitch.NewChatHistoryHandler(&SpyChatHistoryStore{})
+		event := handlers.Event{
+			Cmd:       "chat-history",
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+
+		handler.HandleEvent(event, spySession)
+
+		expected := handlers.Event{
+			Cmd:       "chat-history",
+			RequestID: "test-request-id",
+			Error:     handlers.InvalidPayload,
+		}
+		expect(spySession.sendCalledWith).To.Equal(expected)
+	}
+}
+
+func TestFetchingChatHistoryWithUnknownCursor(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := twitch.NewChatHistoryHandler(&SpyChatHistoryStore{
+		fetchErr: store.ErrInvalidCursor,
+	})
+	event := handlers.Event{
+		Cmd:       "chat-history",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"cursor": "bad-cursor",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "chat-history",
+		RequestID: "test-request-id",
+		Error:     handlers.InvalidPayload,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
@@ -7,9 +7,10 @@ import (
 	"time"
 
 	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/stream"
 
-	"github.com/pebbe/zmq4"
+	"github.com/bwmarrin/discordgo"
 )
 
 // Message is the structure that is written out to the websocket connection.
@@ -21,57 +22,225 @@ type Message struct {
 
 // TMessage represents a Twitch message.
 type TMessage struct {
-	Cmd    string            `json:"cmd"`
-	Nick   string            `json:"nick"`
-	Target string            `json:"target"`
-	Body   string            `json:"body"`
-	Time   time.Time         `json:"time"`
-	Tags   map[string]string `json:"tags"`
+	Cmd    string `json:"cmd"`
+	Nick   string `json:"nick"`
+	Target string `json:"target"`
+	// Channel is the channel the message was sent to. It is empty for
+	// messages that were not sent to a channel such as whispers.
+	Channel string            `json:"channel"`
+	Body    string            `json:"body"`
+	Time    time.Time         `json:"time"`
+	Tags    map[string]string `json:"tags"`
+	// ParsedTags has the tags converted into their typed representation.
+	ParsedTags stream.TwitchTags `json:"parsed_tags"`
+	// Event is set for USERNOTICE, CLEARCHAT, CLEARMSG, ROOMSTATE and
+	// HOSTTARGET messages.
+	Event *stream.TwitchEvent `json:"event"`
 }
 
-// DiscordMessage represents a Discord message.
-type DiscordMessage struct{}
+// newTMessage converts a message received from Twitch into a TMessage.
+func newTMessage(rx *stream.RXTwitch) *TMessage {
+	tm := &TMessage{
+		Cmd:        rx.Line.Cmd,
+		Nick:       rx.Line.Nick,
+		Channel:    twitchChannel(rx),
+		Time:       rx.Line.Time,
+		Tags:       rx.Line.Tags,
+		ParsedTags: stream.ParseTwitchTags(rx.Line.Tags),
+	}
+	if len(rx.Line.Args) > 0 {
+		tm.Target = rx.Line.Args[0]
+	}
+	if len(rx.Line.Args) > 1 {
+		tm.Body = rx.Line.Args[1]
+	}
+	if e, ok := stream.ParseTwitchEvent(rx.Line); ok {
+		tm.Event = e
+	}
+	return tm
+}
+
+// twitchChannel returns the channel the message was sent to. Messages that
+// were stored before they were tagged with their channel have it taken from
+// the IRC line.
+func twitchChannel(rx *stream.RXTwitch) string {
+	if rx.Channel != "" {
+		return rx.Channel
+	}
+	return stream.LineChannel(rx.Line)
+}
+
+// eventCmd returns the websocket command used to send the message. Twitch
+// events such as subs, raids and bans are sent as chat-event while
+// everything else is sent as chat-message.
+func eventCmd(m Message) string {
+	if m.Twitch != nil && m.Twitch.Event != nil {
+		return "chat-event"
+	}
+	return "chat-message"
+}
+
+// Discord events that are written out to the websocket connection.
+const (
+	DiscordMessageCreate  = "message-create"
+	DiscordMessageUpdate  = "message-update"
+	DiscordMessageDelete  = "message-delete"
+	DiscordReactionAdd    = "reaction-add"
+	DiscordReactionRemove = "reaction-remove"
+	DiscordMemberAdd      = "member-add"
+)
+
+// DiscordMessage represents a Discord message or an event that occurred in
+// the guild. Event indicates what happened. For edits the content is the new
+// content of the message, deletes only identify the message that was deleted
+// and reactions identify the message that was reacted to along with who
+// reacted with which emoji.
+type DiscordMessage struct {
+	Event       string              `json:"event"`
+	ID          string              `json:"id"`
+	AuthorID    string              `json:"author_id"`
+	Author      string              `json:"author"`
+	AuthorBot   bool                `json:"author_bot"`
+	ChannelID   string              `json:"channel_id"`
+	Channel     string              `json:"channel"`
+	GuildID     string              `json:"guild_id"`
+	Content     string              `json:"content"`
+	Emoji       string              `json:"emoji"`
+	Attachments []DiscordAttachment `json:"attachments"`
+	Time        time.Time           `json:"time"`
+}
+
+// DiscordAttachment represents a file attached to a Discord message.
+type DiscordAttachment struct {
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Size     int    `json:"size"`
+}
+
+// newDiscordMessage converts an event received from Discord into a
+// DiscordMessage. It returns nil if the event is not one that is written out
+// to the websocket connection.
+func newDiscordMessage(rx *stream.RXDiscord) *DiscordMessage {
+	if rx == nil {
+		return nil
+	}
+	dm := &DiscordMessage{
+		Channel:     rx.ChannelName,
+		GuildID:     rx.GuildID,
+		Attachments: []DiscordAttachment{},
+		Time:        rx.Time,
+	}
+
+	switch {
+	case rx.MessageCreate != nil && rx.MessageCreate.Message != nil:
+		dm.Event = DiscordMessageCreate
+		setDiscordMessage(dm, rx.MessageCreate.Message)
+		t, err := rx.MessageCreate.Timestamp.Parse()
+		if err == nil {
+			dm.Time = t
+		}
+	case rx.MessageUpdate != nil && rx.MessageUpdate.Message != nil:
+		dm.Event = DiscordMessageUpdate
+		setDiscordMessage(dm, rx.MessageUpdate.Message)
+		t, err := rx.MessageUpdate.EditedTimestamp.Parse()
+		if err == nil {
+			dm.Time = t
+		}
+	case rx.MessageDelete != nil && rx.MessageDelete.Message != nil:
+		dm.Event = DiscordMessageDelete
+		dm.ID = rx.MessageDelete.ID
+		dm.ChannelID = rx.MessageDelete.ChannelID
+	case rx.MessageReactionAdd != nil && rx.MessageReactionAdd.MessageReaction != nil:
+		dm.Event = DiscordReactionAdd
+		setDiscordReaction(dm, rx.MessageReactionAdd.MessageReaction)
+	case rx.MessageReactionRemove != nil && rx.MessageReactionRemove.MessageReaction != nil:
+		dm.Event = DiscordReactionRemove
+		setDiscordReaction(dm, rx.MessageReactionRemove.MessageReaction)
+	case rx.GuildMemberAdd != nil && rx.GuildMemberAdd.Member != nil:
+		dm.Event = DiscordMemberAdd
+		if u := rx.GuildMemberAdd.User; u != nil {
+			dm.AuthorID = u.ID
+			dm.Author = u.Username
+			dm.AuthorBot = u.Bot
+		}
+	default:
+		return nil
+	}
+	return dm
+}
+
+// setDiscordMessage copies the details of a created or edited message.
+func setDiscordMessage(dm *DiscordMessage, m *discordgo.Message) {
+	dm.ID = m.ID
+	dm.ChannelID = m.ChannelID
+	dm.Content = m.Content
+	if m.Author != nil {
+		dm.AuthorID = m.Author.ID
+		dm.Author = m.Author.Username
+		dm.AuthorBot = m.Author.Bot
+	}
+	for _, a := range m.Attachments {
+		dm.Attachments = append(dm.Attachments, DiscordAttachment{
+			Filename: a.Filename,
+			URL:      a.URL,
+			Size:     a.Size,
+		})
+	}
+}
+
+// setDiscordReaction copies the details of a reaction. The reacting user is
+// reported as the author.
+func setDiscordReaction(dm *DiscordMessage, r *discordgo.MessageReaction) {
+	dm.ID = r.MessageID
+	dm.ChannelID = r.ChannelID
+	dm.AuthorID = r.UserID
+	dm.Emoji = r.Emoji.Name
+}
 
 type messageWriter struct {
 	streamerUsername string
-	streamerSub      *zmq4.Socket
-	botSub           *zmq4.Socket
+	streamerSub      bus.Subscription
+	botSub           bus.Subscription
+	botChannels      map[string]bool
+	discordSub       bus.Subscription
+	discordGuildID   string
+	channels         map[string]bool
 	s                handlers.Session
 	requestID        string
 }
 
+// newMessageWriter returns a messageWriter subscribed to the streamer and bot
+// topics. All messages the bot receives in botChannels are written while
+// only the streamer's messages are written from its other channels. If
+// discordTopic is not empty it is also subscribed to messages from the
+// Discord guild with the given ID. If channels is not empty only Twitch
+// messages from those channels are written.
 func newMessageWriter(
 	streamerUsername string,
-	streamerTopic string,
-	botTopic string,
+	streamerTopics []string,
+	botTopics []string,
+	botChannels map[string]bool,
+	discordTopic string,
+	discordGuildID string,
+	channels map[string]bool,
+	b bus.Bus,
 	subEndpoints []string,
 	s handlers.Session,
 	requestID string,
 ) (*messageWriter, error) {
-	streamerSub, err := zmq4.NewSocket(zmq4.SUB)
-	if err != nil {
-		return nil, err
-	}
-	err = streamerSub.SetSubscribe(streamerTopic)
+	streamerSub, err := b.Subscribe(subEndpoints, streamerTopics)
 	if err != nil {
 		return nil, err
 	}
 
-	botSub, err := zmq4.NewSocket(zmq4.SUB)
-	if err != nil {
-		return nil, err
-	}
-	err = botSub.SetSubscribe(botTopic)
+	botSub, err := b.Subscribe(subEndpoints, botTopics)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, endpoint := range subEndpoints {
-		err = streamerSub.Connect(endpoint)
-		if err != nil {
-			return nil, err
-		}
-		err = botSub.Connect(endpoint)
+	var discordSub bus.Subscription
+	if discordTopic != "" {
+		discordSub, err = b.Subscribe(subEndpoints, []string{discordTopic})
 		if err != nil {
 			return nil, err
 		}
@@ -81,6 +250,10 @@ func newMessageWriter(
 		streamerUsername: streamerUsername,
 		streamerSub:      streamerSub,
 		botSub:           botSub,
+		botChannels:      botChannels,
+		discordSub:       discordSub,
+		discordGuildID:   discordGuildID,
+		channels:         channels,
 		s:                s,
 		requestID:        requestID,
 	}, nil
@@ -89,12 +262,16 @@ func newMessageWriter(
 // StartStreamer reads messages off of the streamer sub socket and writes them
 // to the session's ws conn.
 func (mw *messageWriter) StartStreamer() {
+	defer closeSub(mw.streamerSub)
 	for {
 		ms, err := readMessage(mw.streamerSub)
 		if err != nil {
 			log.Printf("got err reading from streamer socket: %s", err)
 			continue
 		}
+		if !ChannelMessage(ms, mw.channels) {
+			continue
+		}
 		err = mw.WriteMessage(ms)
 		if err != nil {
 			log.Printf("got error when writing to ws conn, aborting: %s", err)
@@ -106,13 +283,42 @@ func (mw *messageWriter) StartStreamer() {
 // StartBot reads messages off of the bot sub socket and writes them to the
 // session's ws conn.
 func (mw *messageWriter) StartBot() {
+	defer closeSub(mw.botSub)
 	for {
 		ms, err := readMessage(mw.botSub)
 		if err != nil {
 			log.Printf("got err reading from streamer socket: %s", err)
 			continue
 		}
-		if !UserMessage(ms, mw.streamerUsername) {
+		if !BotMessage(ms, mw.streamerUsername, mw.botChannels) && !StateMessage(ms) {
+			continue
+		}
+		if !ChannelMessage(ms, mw.channels) {
+			continue
+		}
+		err = mw.WriteMessage(ms)
+		if err != nil {
+			log.Printf("got error when writing to ws conn, aborting: %s", err)
+			return
+		}
+	}
+}
+
+// StartDiscord reads messages off of the discord sub socket and writes the
+// ones from the user's guild to the session's ws conn. It returns
+// immediately if the writer is not subscribed to Discord.
+func (mw *messageWriter) StartDiscord() {
+	if mw.discordSub == nil {
+		return
+	}
+	defer closeSub(mw.discordSub)
+	for {
+		ms, err := readMessage(mw.discordSub)
+		if err != nil {
+			log.Printf("got err reading from discord socket: %s", err)
+			continue
+		}
+		if !GuildMessage(ms, mw.discordGuildID) {
 			continue
 		}
 		err = mw.WriteMessage(ms)
@@ -123,17 +329,23 @@ func (mw *messageWriter) StartBot() {
 	}
 }
 
-func readMessage(sub *zmq4.Socket) (*stream.RXMessage, error) {
-	rb, err := sub.RecvMessageBytes(0)
+// closeSub closes the subscription once the writer has stopped so messages
+// are no longer received for it.
+func closeSub(sub bus.Subscription) {
+	err := sub.Close()
 	if err != nil {
-		return nil, fmt.Errorf("messages not read, got err: %s", err)
+		log.Printf("got err closing subscription: %s", err)
 	}
-	if len(rb) < 2 {
-		return nil, fmt.Errorf("received message bytes had invalid length: %#v", rb)
+}
+
+func readMessage(sub bus.Subscription) (*stream.RXMessage, error) {
+	_, msg, err := sub.Receive(bus.Forever)
+	if err != nil {
+		return nil, fmt.Errorf("messages not read, got err: %s", err)
 	}
 
 	var ms stream.RXMessage
-	err = json.Unmarshal(rb[1], &ms)
+	err = json.Unmarshal(msg, &ms)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal, got err: %s", err)
 	}
@@ -143,35 +355,76 @@ func readMessage(sub *zmq4.Socket) (*stream.RXMessage, error) {
 // UserMessage returns true if the message was sent from the user, otherwise it
 // returns false.
 func UserMessage(ms *stream.RXMessage, username string) bool {
-	if ms.Type != stream.Twitch {
+	if ms.Type != stream.Twitch || ms.Twitch == nil || ms.Twitch.Line == nil {
 		return false
 	}
 	return ms.Twitch.Line.Nick == username
 }
 
+// BotMessage returns true if the message received by the bot should be
+// written out. These are messages sent to one of the bot's channels and
+// messages sent by the streamer, otherwise it returns false.
+func BotMessage(ms *stream.RXMessage, streamerUsername string, botChannels map[string]bool) bool {
+	if ms.Type != stream.Twitch || ms.Twitch == nil || ms.Twitch.Line == nil {
+		return false
+	}
+	return botChannels[twitchChannel(ms.Twitch)] || UserMessage(ms, streamerUsername)
+}
+
+// ChannelMessage returns true if the Twitch message was sent to one of the
+// channels. Messages that were not sent to a channel, such as whispers and
+// changes to the state of the connection, are always included as are all
+// messages when no channels are given.
+func ChannelMessage(ms *stream.RXMessage, channels map[string]bool) bool {
+	if len(channels) == 0 || ms.Type != stream.Twitch || ms.Twitch == nil || ms.Twitch.Line == nil {
+		return true
+	}
+	channel := twitchChannel(ms.Twitch)
+	return channel == "" || channels[channel]
+}
+
+// StateMessage returns true if the message reports a change in the state of
+// a connection to twitch.
+func StateMessage(ms *stream.RXMessage) bool {
+	return ms.Type == stream.Twitch && ms.Twitch != nil && ms.Twitch.State != nil
+}
+
+// GuildMessage returns true if the message was received in the Discord
+// guild, otherwise it returns false. Messages without a guild are assumed to
+// be from the guild.
+func GuildMessage(ms *stream.RXMessage, guildID string) bool {
+	if ms.Type != stream.Discord || ms.Discord == nil {
+		return false
+	}
+	return ms.Discord.GuildID == "" || ms.Discord.GuildID == guildID
+}
+
 func (mw *messageWriter) WriteMessage(ms *stream.RXMessage) error {
+	if StateMessage(ms) {
+		return mw.s.Send(handlers.Event{
+			Cmd:       "twitch-connection-state",
+			RequestID: mw.requestID,
+			Payload:   ms.Twitch.State,
+		})
+	}
+
 	p := Message{
 		Type: ms.Type,
 	}
 	switch ms.Type {
 	case stream.Twitch:
-		p.Twitch = &TMessage{
-			Cmd:    ms.Twitch.Line.Cmd,
-			Nick:   ms.Twitch.Line.Nick,
-			Target: ms.Twitch.Line.Args[0],
-			Body:   ms.Twitch.Line.Args[1],
-			Time:   ms.Twitch.Line.Time,
-			Tags:   ms.Twitch.Line.Tags,
-		}
+		p.Twitch = newTMessage(ms.Twitch)
 	case stream.Discord:
-		// TODO: add support for discord messages
-		return nil
+		p.Discord = newDiscordMessage(ms.Discord)
+		if p.Discord == nil {
+			return nil
+		}
 	default:
 		log.Println("got unknown message type while reading from sub sock")
 		return nil
 	}
 	e := handlers.Event{
-		Cmd:       "chat-message",
+		Cmd:       eventCmd(p),
 		RequestID: mw.requestID,
 		Payload:   p,
 	}
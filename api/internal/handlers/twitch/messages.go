@@ -2,8 +2,12 @@ package twitch
 
 import (
 	"log"
+	"sort"
+	"time"
 
 	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/store"
 	"github.com/jasonkeene/anubot-server/stream"
 )
 
@@ -12,11 +16,19 @@ type RecentMessagesFetcher interface {
 	FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error)
 }
 
-// StreamMessagesStore fetches recent messages and twitch credentials for the
-// user.
+// DiscordMessagesFetcher fetches the user's linked Discord guild and its
+// recent messages.
+type DiscordMessagesFetcher interface {
+	DiscordCredentials(userID string) (creds store.DiscordCredentials, err error)
+	FetchRecentDiscordMessages(userID string) (msgs []stream.RXMessage, err error)
+}
+
+// StreamMessagesStore fetches recent messages and twitch and discord
+// credentials for the user.
 type StreamMessagesStore interface {
 	CredentialsProvider
 	RecentMessagesFetcher
+	DiscordMessagesFetcher
 }
 
 // Connector ensures a connection is made to twitch for the user.
@@ -24,28 +36,53 @@ type Connector interface {
 	ConnectTwitch(user, pass, channel string)
 }
 
+// StreamConnector ensures a connection is made to twitch for the user and
+// lists the channels they have joined.
+type StreamConnector interface {
+	Connector
+	TwitchChannels(user string) (channels []string)
+}
+
 // StreamMessagesHandler writes chat messages to websocket connection.
 type StreamMessagesHandler struct {
 	store        StreamMessagesStore
-	connector    Connector
+	connector    StreamConnector
+	bus          bus.Bus
 	subEndpoints []string
 }
 
-// NewStreamMessagesHandler returns a new StreamMessagesHandler.
+// NewStreamMessagesHandler returns a new StreamMessagesHandler that
+// subscribes to new messages with the bus.
 func NewStreamMessagesHandler(
 	store StreamMessagesStore,
-	connector Connector,
+	connector StreamConnector,
+	b bus.Bus,
 	subEndpoints []string,
 ) *StreamMessagesHandler {
 	return &StreamMessagesHandler{
 		store:        store,
 		connector:    connector,
+		bus:          b,
 		subEndpoints: subEndpoints,
 	}
 }
 
-// HandleEvent responds to a websocket event.
+// HandleEvent responds to a websocket event. The payload may contain a list
+// of channels to only stream Twitch messages from those channels.
 func (h *StreamMessagesHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	channels, ok := channelFilter(e.Payload)
+	if !ok {
+		err := s.Send(handlers.Event{
+			Cmd:       e.Cmd,
+			RequestID: e.RequestID,
+			Error:     handlers.InvalidPayload,
+		})
+		if err != nil {
+			log.Printf("unable to tx: %s", err)
+		}
+		return
+	}
+
 	userID, _ := s.Authenticated()
 	creds, err := h.store.TwitchCredentials(userID)
 	if err != nil {
@@ -53,35 +90,22 @@ func (h *StreamMessagesHandler) HandleEvent(e handlers.Event, s handlers.Session
 		return
 	}
 
-	recent, err := h.store.FetchRecentMessages(userID)
-	if err == nil {
-		for _, msg := range recent {
-			if msg.Type != stream.Twitch {
-				continue
-			}
-			if msg.Twitch.OwnerID == creds.BotTwitchUserID &&
-				!UserMessage(&msg, creds.StreamerUsername) {
-				continue
-			}
+	discordCreds, err := h.store.DiscordCredentials(userID)
+	if err != nil {
+		log.Printf("unable to get discord creds: %s", err)
+		discordCreds = store.DiscordCredentials{}
+	}
 
-			err = s.Send(handlers.Event{
-				Cmd:       "chat-message",
-				RequestID: e.RequestID,
-				Payload: Message{
-					Type: msg.Type,
-					Twitch: &TMessage{
-						Cmd:    msg.Twitch.Line.Cmd,
-						Nick:   msg.Twitch.Line.Nick,
-						Target: msg.Twitch.Line.Args[0],
-						Body:   msg.Twitch.Line.Args[1],
-						Time:   msg.Twitch.Line.Time,
-						Tags:   msg.Twitch.Line.Tags,
-					},
-				},
-			})
-			if err != nil {
-				log.Printf("unable to tx: %s", err)
-			}
+	botChannels := h.botChannels(creds)
+
+	for _, msg := range h.recentMessages(userID, creds, discordCreds, botChannels, channels) {
+		err = s.Send(handlers.Event{
+			Cmd:       eventCmd(msg),
+			RequestID: e.RequestID,
+			Payload:   msg,
+		})
+		if err != nil {
+			log.Printf("unable to tx: %s", err)
 		}
 	}
 
@@ -96,10 +120,29 @@ func (h *StreamMessagesHandler) HandleEvent(e handlers.Event, s handlers.Session
 		"#"+creds.StreamerUsername,
 	)
 
+	botTopics := []string{
+		stream.TwitchChannelTopic(creds.BotTwitchUserID, "#"+creds.StreamerUsername),
+		stream.TwitchStateTopic(creds.BotUsername),
+	}
+	for _, channel := range sortedChannels(botChannels) {
+		botTopics = append(botTopics, stream.TwitchChannelTopic(creds.BotTwitchUserID, channel))
+	}
+	var discordTopic string
+	if discordCreds.Authenticated {
+		discordTopic = "discord:" + discordCreds.OwnerID
+	}
 	mw, err := newMessageWriter(
 		creds.StreamerUsername,
-		"twitch:"+creds.StreamerUsername,
-		"twitch:"+creds.BotUsername,
+		[]string{
+			stream.TwitchOwnerTopic(creds.StreamerTwitchUserID),
+			stream.TwitchStateTopic(creds.StreamerUsername),
+		},
+		botTopics,
+		botChannels,
+		discordTopic,
+		discordCreds.GuildID,
+		channels,
+		h.bus,
 		h.subEndpoints,
 		s,
 		e.RequestID,
@@ -110,6 +153,142 @@ func (h *StreamMessagesHandler) HandleEvent(e handlers.Event, s handlers.Session
 	}
 	go mw.StartStreamer()
 	go mw.StartBot()
+	go mw.StartDiscord()
+}
+
+// channelFilter returns the channels the client asked to stream messages
+// from. It returns nil if all channels should be streamed and false if the
+// payload is not valid.
+func channelFilter(p interface{}) (map[string]bool, bool) {
+	if p == nil {
+		return nil, true
+	}
+	data, ok := p.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	raw, ok := data["channels"]
+	if !ok {
+		return nil, true
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	channels := make(map[string]bool, len(list))
+	for _, c := range list {
+		name, ok := c.(string)
+		if !ok {
+			return nil, false
+		}
+		channel, ok := normalizeChannel(name)
+		if !ok {
+			return nil, false
+		}
+		channels[channel] = true
+	}
+	return channels, true
+}
+
+// botChannels returns the channels the bot has joined that the streamer has
+// not. Messages from the streamer's channels are received by the streamer
+// so the bot's copies of them are not needed.
+func (h *StreamMessagesHandler) botChannels(creds store.TwitchCredentials) map[string]bool {
+	streamerChannels := map[string]bool{
+		"#" + creds.StreamerUsername: true,
+	}
+	for _, channel := range h.connector.TwitchChannels(creds.StreamerUsername) {
+		streamerChannels[channel] = true
+	}
+	channels := make(map[string]bool)
+	for _, channel := range h.connector.TwitchChannels(creds.BotUsername) {
+		if !streamerChannels[channel] {
+			channels[channel] = true
+		}
+	}
+	return channels
+}
+
+// sortedChannels returns the channels in order.
+func sortedChannels(channels map[string]bool) []string {
+	sorted := make([]string, 0, len(channels))
+	for channel := range channels {
+		sorted = append(sorted, channel)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// recentMessages returns the recent Twitch and Discord messages for the user
+// in chronological order. Messages received by the bot are only included if
+// they were sent by the streamer or are from one of the bot's channels. If
+// channels is not empty only Twitch messages from those channels are
+// included.
+func (h *StreamMessagesHandler) recentMessages(
+	userID string,
+	creds store.TwitchCredentials,
+	discordCreds store.DiscordCredentials,
+	botChannels map[string]bool,
+	channels map[string]bool,
+) []Message {
+	var msgs []Message
+
+	recent, err := h.store.FetchRecentMessages(userID)
+	if err == nil {
+		for _, msg := range recent {
+			if msg.Type != stream.Twitch {
+				continue
+			}
+			if msg.Twitch.OwnerID == creds.BotTwitchUserID &&
+				!BotMessage(&msg, creds.StreamerUsername, botChannels) {
+				continue
+			}
+			if !ChannelMessage(&msg, channels) {
+				continue
+			}
+			msgs = append(msgs, Message{
+				Type:   msg.Type,
+				Twitch: newTMessage(msg.Twitch),
+			})
+		}
+	}
+
+	if discordCreds.Authenticated {
+		recent, err := h.store.FetchRecentDiscordMessages(userID)
+		if err != nil {
+			log.Printf("unable to get recent discord messages: %s", err)
+		}
+		for _, msg := range recent {
+			if !GuildMessage(&msg, discordCreds.GuildID) {
+				continue
+			}
+			dm := newDiscordMessage(msg.Discord)
+			if dm == nil {
+				continue
+			}
+			msgs = append(msgs, Message{
+				Type:    msg.Type,
+				Discord: dm,
+			})
+		}
+	}
+
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return messageTime(msgs[i]).Before(messageTime(msgs[j]))
+	})
+	return msgs
+}
+
+// messageTime returns the time the message was sent.
+func messageTime(msg Message) time.Time {
+	switch {
+	case msg.Twitch != nil:
+		return msg.Twitch.Time
+	case msg.Discord != nil:
+		return msg.Discord.Time
+	default:
+		return time.Time{}
+	}
 }
 
 // StreamManager is used to connect and send to third party chat.
@@ -153,12 +332,10 @@ func (h *SendMessageHandler) HandleEvent(e handlers.Event, s handlers.Session) {
 		return
 	}
 
-	var username, password string
-	switch payload.userType {
-	case "streamer":
-		username, password = creds.StreamerUsername, creds.StreamerPassword
-	case "bot":
-		username, password = creds.BotUsername, creds.BotPassword
+	username, password := twitchUser(creds, payload.userType)
+	to := payload.channel
+	if to == "" {
+		to = "#" + creds.StreamerUsername
 	}
 	h.streamManager.ConnectTwitch(
 		username,
@@ -169,18 +346,21 @@ func (h *SendMessageHandler) HandleEvent(e handlers.Event, s handlers.Session) {
 		Type: stream.Twitch,
 		Twitch: &stream.TXTwitch{
 			Username: username,
-			To:       "#" + creds.StreamerUsername,
+			To:       to,
 			Message:  payload.message,
+			Priority: true,
 		},
 	})
 	resp.Error = nil
 }
 
 // sendMessagePayload represents the payload that should be sent when
-// sending a message.
+// sending a message. The message is sent to the streamer's channel unless
+// another channel is given.
 type sendMessagePayload struct {
 	userType string
 	message  string
+	channel  string
 }
 
 // validatePayload returns true if the payload is valid.
@@ -200,8 +380,20 @@ func (h *SendMessageHandler) validatePayload(p interface{}) (bool, sendMessagePa
 	if !ok {
 		return false, sendMessagePayload{}
 	}
+	var channel string
+	if raw, ok := data["channel"]; ok {
+		name, ok := raw.(string)
+		if !ok {
+			return false, sendMessagePayload{}
+		}
+		channel, ok = normalizeChannel(name)
+		if !ok {
+			return false, sendMessagePayload{}
+		}
+	}
 	return true, sendMessagePayload{
 		userType: userType,
 		message:  message,
+		channel:  channel,
 	}
 }
@@ -10,12 +10,13 @@ import (
 	"time"
 
 	"github.com/a8m/expect"
+	"github.com/bwmarrin/discordgo"
 	"github.com/fluffle/goirc/client"
 	"github.com/jasonkeene/anubot-server/api/internal/handlers"
 	"github.com/jasonkeene/anubot-server/api/internal/handlers/twitch"
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/store"
 	"github.com/jasonkeene/anubot-server/stream"
-	"github.com/pebbe/zmq4"
 )
 
 func TestRecentMessageStreaming(t *testing.T) {
@@ -94,10 +95,11 @@ func TestRecentMessageStreaming(t *testing.T) {
 			},
 		},
 	}
-	spyConnector := &SpyConnector{}
+	spyConnector := &SpyChannelManager{}
 	handler := twitch.NewStreamMessagesHandler(
 		spyMessagesStore,
 		spyConnector,
+		bus.Default,
 		[]string{},
 	)
 	event := handlers.Event{
@@ -168,7 +170,7 @@ func TestStreamingNewMessages(t *testing.T) {
 		userID: "test-user-id",
 	}
 	endpoint := endpoint()
-	pub := setupPubSocket(endpoint)
+	pub := setupPublisher(endpoint)
 
 	spyMessagesStore := &SpyStreamMessagesStore{
 		creds: store.TwitchCredentials{
@@ -185,7 +187,8 @@ func TestStreamingNewMessages(t *testing.T) {
 	}
 	handler := twitch.NewStreamMessagesHandler(
 		spyMessagesStore,
-		&SpyConnector{},
+		&SpyChannelManager{},
+		bus.Default,
 		[]string{endpoint},
 	)
 	event := handlers.Event{
@@ -245,11 +248,15 @@ func TestStreamingNewMessages(t *testing.T) {
 	botBytesFromStreamer, err := json.Marshal(botMessageFromStreamer)
 	expect(err).To.Be.Nil()
 
-	_, err = pub.SendMessage("twitch:test-streamer-username", streamerBytes)
+	err = pub.Publish("twitch:12345:#test-streamer-username:chat", streamerBytes)
 	expect(err).To.Be.Nil()
-	_, err = pub.SendMessage("twitch:test-bot-username", botBytes)
+	err = pub.Publish("twitch:54321:#test-streamer-username:chat", botBytes)
 	expect(err).To.Be.Nil()
-	_, err = pub.SendMessage("twitch:test-bot-username", botBytesFromStreamer)
+	// lines the bot receives outside of the streamer's channel are not
+	// subscribed to
+	err = pub.Publish("twitch:54321::whisper", botBytesFromStreamer)
+	expect(err).To.Be.Nil()
+	err = pub.Publish("twitch:54321:#test-streamer-username:chat", botBytesFromStreamer)
 	expect(err).To.Be.Nil()
 
 	for {
@@ -289,6 +296,573 @@ func TestStreamingNewMessages(t *testing.T) {
 	expect(spySession.sendCalls()).To.Equal(expected)
 }
 
+func TestStreamingMessagesFromChannelsTheBotJoined(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	endpoint := endpoint()
+	pub := setupPublisher(endpoint)
+
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			StreamerTwitchUserID:  12345,
+
+			BotAuthenticated: true,
+			BotUsername:      "test-bot-username",
+			BotTwitchUserID:  54321,
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{
+			channels: map[string][]string{
+				"test-streamer-username": {"#test-streamer-username", "#test-shared"},
+				"test-bot-username":      {"#test-streamer-username", "#test-friend", "#test-shared"},
+			},
+		},
+		bus.Default,
+		[]string{endpoint},
+	)
+
+	handler.HandleEvent(handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+	}, spySession)
+
+	message := func(channel, body string) []byte {
+		b, err := json.Marshal(stream.RXMessage{
+			Type: stream.Twitch,
+			Twitch: &stream.RXTwitch{
+				OwnerID: 54321,
+				Channel: channel,
+				Line: &client.Line{
+					Cmd:  "PRIVMSG",
+					Nick: "test-nick",
+					Args: []string{channel, body},
+				},
+			},
+		})
+		expect(err).To.Be.Nil()
+		return b
+	}
+
+	// the streamer receives the lines from channels they have joined
+	err := pub.Publish("twitch:54321:#test-shared:chat", message("#test-shared", "message-from-shared-channel"))
+	expect(err).To.Be.Nil()
+	err = pub.Publish("twitch:54321:#test-streamer-username:chat", message("#test-streamer-username", "message-from-streamer-channel"))
+	expect(err).To.Be.Nil()
+	err = pub.Publish("twitch:54321:#test-friend:chat", message("#test-friend", "message-from-friend-channel"))
+	expect(err).To.Be.Nil()
+
+	for {
+		if len(spySession.sendCalls()) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect(spySession.sendCalls()).To.Equal([]handlers.Event{
+		{
+			Cmd:       "chat-message",
+			RequestID: "test-request-id",
+			Payload: twitch.Message{
+				Type: stream.Twitch,
+				Twitch: &twitch.TMessage{
+					Cmd:     "PRIVMSG",
+					Nick:    "test-nick",
+					Target:  "#test-friend",
+					Channel: "#test-friend",
+					Body:    "message-from-friend-channel",
+				},
+			},
+		},
+	})
+}
+
+func TestRecentMessagesCanBeFilteredByChannel(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	now := time.Now()
+	message := func(channel, body string) stream.RXMessage {
+		return stream.RXMessage{
+			Type: stream.Twitch,
+			Twitch: &stream.RXTwitch{
+				OwnerID: 12345,
+				Channel: channel,
+				Line: &client.Line{
+					Cmd:  "PRIVMSG",
+					Nick: "test-nick",
+					Args: []string{channel, body},
+					Time: now,
+				},
+			},
+		}
+	}
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			StreamerTwitchUserID:  12345,
+
+			BotAuthenticated: true,
+			BotUsername:      "test-bot-username",
+			BotTwitchUserID:  54321,
+		},
+		recentMessages: []stream.RXMessage{
+			message("#test-streamer-username", "message-from-streamer-channel"),
+			message("#test-friend", "message-from-friend-channel"),
+			message("#test-other", "message-from-other-channel"),
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{},
+		bus.Default,
+		[]string{},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"channels": []interface{}{"test-streamer-username", "#Test-Friend"},
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	var bodies []string
+	for _, e := range spySession.sendCalls() {
+		tm := e.Payload.(twitch.Message).Twitch
+		expect(tm.Channel).To.Equal(tm.Target)
+		bodies = append(bodies, tm.Body)
+	}
+	expect(bodies).To.Equal([]string{
+		"message-from-streamer-channel",
+		"message-from-friend-channel",
+	})
+}
+
+func TestStreamingMessagesWithAnInvalidChannelFilter(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	spyConnector := &SpyChannelManager{}
+	handler := twitch.NewStreamMessagesHandler(
+		&SpyStreamMessagesStore{},
+		spyConnector,
+		bus.Default,
+		[]string{},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"channels": "test-friend",
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spySession.sendCalledWith).To.Equal(handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+		Error:     handlers.InvalidPayload,
+	})
+	expect(len(spyConnector.connectCalls)).To.Equal(0)
+}
+
+func TestRecentTwitchEventsAreSentAsChatEvents(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	now := time.Now()
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			StreamerTwitchUserID:  12345,
+
+			BotAuthenticated: true,
+			BotUsername:      "test-bot-username",
+			BotTwitchUserID:  54321,
+		},
+		recentMessages: []stream.RXMessage{
+			{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 12345,
+					Line: &client.Line{
+						Cmd:  "CLEARCHAT",
+						Args: []string{"#test-streamer-username", "test-nick"},
+						Time: now,
+						Tags: map[string]string{
+							"ban-duration": "600",
+						},
+					},
+				},
+			},
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{},
+		bus.Default,
+		[]string{},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	sent := spySession.sendCalls()
+	expect(len(sent)).To.Equal(1).Else.FailNow()
+	expect(sent[0].Cmd).To.Equal("chat-event")
+	tm := sent[0].Payload.(twitch.Message).Twitch
+	expect(tm.Cmd).To.Equal("CLEARCHAT")
+	expect(tm.Event).To.Equal(&stream.TwitchEvent{
+		Kind:        stream.TwitchEventTimeout,
+		Channel:     "#test-streamer-username",
+		Time:        now,
+		User:        "test-nick",
+		BanDuration: 600,
+	})
+}
+
+func TestRecentDiscordMessageStreaming(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	now := time.Now()
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			StreamerTwitchUserID:  12345,
+
+			BotAuthenticated: true,
+			BotUsername:      "test-bot-username",
+			BotTwitchUserID:  54321,
+		},
+		recentMessages: []stream.RXMessage{
+			{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 12345,
+					Line: &client.Line{
+						Cmd:  "PRIVMSG",
+						Nick: "test-nick",
+						Args: []string{
+							"test-target",
+							"twitch-message",
+						},
+						Time: now,
+					},
+				},
+			},
+		},
+		discordCreds: store.DiscordCredentials{
+			Authenticated: true,
+			OwnerID:       "test-owner-id",
+			GuildID:       "test-guild-id",
+		},
+		recentDiscordMessages: []stream.RXMessage{
+			discordMessage("test-guild-id", "discord-message", now.Add(-time.Minute)),
+			discordMessage("other-guild-id", "other-guild-message", now.Add(-time.Minute)),
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{},
+		bus.Default,
+		[]string{},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	sent := spySession.sendCalls()
+	expect(len(sent)).To.Equal(2).Else.FailNow()
+	expect(sent[0].Payload).To.Equal(twitch.Message{
+		Type: stream.Discord,
+		Discord: &twitch.DiscordMessage{
+			Event:     twitch.DiscordMessageCreate,
+			ID:        "test-message-id",
+			AuthorID:  "test-author-id",
+			Author:    "test-author",
+			ChannelID: "test-channel-id",
+			Channel:   "general",
+			GuildID:   "test-guild-id",
+			Content:   "discord-message",
+			Attachments: []twitch.DiscordAttachment{
+				{
+					Filename: "test.png",
+					URL:      "https://cdn.example.com/test.png",
+					Size:     1024,
+				},
+			},
+			Time: now.Add(-time.Minute).UTC().Truncate(time.Second),
+		},
+	})
+	expect(sent[1].Payload.(twitch.Message).Twitch.Body).To.Equal("twitch-message")
+}
+
+func TestStreamingNewDiscordMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	endpoint := endpoint()
+	pub := setupPublisher(endpoint)
+
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			BotAuthenticated:      true,
+			BotUsername:           "test-bot-username",
+		},
+		discordCreds: store.DiscordCredentials{
+			Authenticated: true,
+			OwnerID:       "test-owner-id",
+			GuildID:       "test-guild-id",
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{},
+		bus.Default,
+		[]string{endpoint},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	otherBytes, err := json.Marshal(discordMessage("other-guild-id", "other-guild-message", time.Now()))
+	expect(err).To.Be.Nil()
+	guildBytes, err := json.Marshal(discordMessage("test-guild-id", "guild-message", time.Now()))
+	expect(err).To.Be.Nil()
+
+	err = pub.Publish("discord:test-owner-id", otherBytes)
+	expect(err).To.Be.Nil()
+	err = pub.Publish("discord:test-owner-id", guildBytes)
+	expect(err).To.Be.Nil()
+
+	for {
+		if len(spySession.sendCalls()) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	msg := spySession.sendCalls()[0].Payload.(twitch.Message)
+	expect(msg.Type).To.Equal(stream.Discord)
+	expect(msg.Discord.Content).To.Equal("guild-message")
+}
+
+func TestRecentDiscordEventStreaming(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			BotAuthenticated:      true,
+			BotUsername:           "test-bot-username",
+		},
+		discordCreds: store.DiscordCredentials{
+			Authenticated: true,
+			OwnerID:       "test-owner-id",
+			GuildID:       "test-guild-id",
+		},
+		recentDiscordMessages: []stream.RXMessage{
+			discordMessage("test-guild-id", "discord-message", now.Add(-3*time.Minute)),
+			discordEvent(now.Add(-2*time.Minute), &stream.RXDiscord{
+				MessageUpdate: &discordgo.MessageUpdate{
+					Message: &discordgo.Message{
+						ID:              "test-message-id",
+						ChannelID:       "test-channel-id",
+						Content:         "edited-message",
+						Timestamp:       discordgo.Timestamp(now.Add(-3 * time.Minute).Format(time.RFC3339)),
+						EditedTimestamp: discordgo.Timestamp(now.Add(-2 * time.Minute).Format(time.RFC3339)),
+					},
+				},
+			}),
+			discordEvent(now.Add(-time.Minute), &stream.RXDiscord{
+				MessageReactionAdd: &discordgo.MessageReactionAdd{
+					MessageReaction: &discordgo.MessageReaction{
+						UserID:    "test-reactor-id",
+						MessageID: "test-message-id",
+						ChannelID: "test-channel-id",
+						Emoji: discordgo.Emoji{
+							Name: "👍",
+						},
+					},
+				},
+			}),
+			discordEvent(now, &stream.RXDiscord{
+				MessageDelete: &discordgo.MessageDelete{
+					Message: &discordgo.Message{
+						ID:        "test-message-id",
+						ChannelID: "test-channel-id",
+					},
+				},
+			}),
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{},
+		bus.Default,
+		[]string{},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	sent := spySession.sendCalls()
+	expect(len(sent)).To.Equal(4).Else.FailNow()
+	created := sent[0].Payload.(twitch.Message).Discord
+	expect(created.Event).To.Equal(twitch.DiscordMessageCreate)
+	expect(created.Content).To.Equal("discord-message")
+	edited := sent[1].Payload.(twitch.Message).Discord
+	expect(edited.Event).To.Equal(twitch.DiscordMessageUpdate)
+	expect(edited.ID).To.Equal("test-message-id")
+	expect(edited.Content).To.Equal("edited-message")
+	expect(edited.Time).To.Equal(now.Add(-2 * time.Minute))
+	reacted := sent[2].Payload.(twitch.Message).Discord
+	expect(reacted.Event).To.Equal(twitch.DiscordReactionAdd)
+	expect(reacted.ID).To.Equal("test-message-id")
+	expect(reacted.AuthorID).To.Equal("test-reactor-id")
+	expect(reacted.Emoji).To.Equal("👍")
+	deleted := sent[3].Payload.(twitch.Message).Discord
+	expect(deleted.Event).To.Equal(twitch.DiscordMessageDelete)
+	expect(deleted.ID).To.Equal("test-message-id")
+	expect(deleted.Channel).To.Equal("general")
+}
+
+func TestStreamingTwitchConnectionState(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	endpoint := endpoint()
+	pub := setupPublisher(endpoint)
+
+	spyMessagesStore := &SpyStreamMessagesStore{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+			StreamerUsername:      "test-streamer-username",
+			BotAuthenticated:      true,
+			BotUsername:           "test-bot-username",
+		},
+	}
+	handler := twitch.NewStreamMessagesHandler(
+		spyMessagesStore,
+		&SpyChannelManager{},
+		bus.Default,
+		[]string{endpoint},
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-stream-messages",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	state := &stream.TwitchConnState{
+		Username: "test-bot-username",
+		State:    stream.TwitchBackoff,
+		Attempt:  1,
+		Error:    "disconnected from twitch",
+	}
+	stateBytes, err := json.Marshal(stream.RXMessage{
+		Type: stream.Twitch,
+		Twitch: &stream.RXTwitch{
+			State: state,
+		},
+	})
+	expect(err).To.Be.Nil()
+
+	for {
+		err = pub.Publish(stream.TwitchStateTopic("test-bot-username"), stateBytes)
+		expect(err).To.Be.Nil()
+		if len(spySession.sendCalls()) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := spySession.sendCalls()[0]
+	expect(sent.Cmd).To.Equal("twitch-connection-state")
+	expect(sent.Payload).To.Equal(state)
+}
+
+func discordEvent(t time.Time, rx *stream.RXDiscord) stream.RXMessage {
+	rx.OwnerID = "test-owner-id"
+	rx.GuildID = "test-guild-id"
+	rx.ChannelName = "general"
+	rx.Time = t
+	return stream.RXMessage{
+		Type:    stream.Discord,
+		Discord: rx,
+	}
+}
+
+func discordMessage(guildID, content string, t time.Time) stream.RXMessage {
+	return stream.RXMessage{
+		Type: stream.Discord,
+		Discord: &stream.RXDiscord{
+			OwnerID:     "test-owner-id",
+			GuildID:     guildID,
+			ChannelName: "general",
+			MessageCreate: &discordgo.MessageCreate{
+				Message: &discordgo.Message{
+					ID:        "test-message-id",
+					ChannelID: "test-channel-id",
+					Content:   content,
+					Timestamp: discordgo.Timestamp(t.UTC().Format(time.RFC3339)),
+					Author: &discordgo.User{
+						ID:       "test-author-id",
+						Username: "test-author",
+					},
+					Attachments: []*discordgo.MessageAttachment{
+						{
+							Filename: "test.png",
+							URL:      "https://cdn.example.com/test.png",
+							Size:     1024,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func endpoint() string {
 	return "inproc://test-message-streaming-pub-" + randString()
 }
@@ -302,14 +876,10 @@ func randString() string {
 	return fmt.Sprintf("%x", b)
 }
 
-func setupPubSocket(endpoint string) *zmq4.Socket {
-	pub, err := zmq4.NewSocket(zmq4.PUB)
+func setupPublisher(endpoint string) bus.Publisher {
+	pub, err := bus.Default.Publisher([]string{endpoint})
 	if err != nil {
-		log.Panicf("unable to create pub socket %s:", err)
-	}
-	err = pub.Bind(endpoint)
-	if err != nil {
-		log.Panicf("unable to connect pub socket to endpoint: %s", err)
+		log.Panicf("unable to bind publisher to endpoint: %s", err)
 	}
 	return pub
 }
@@ -364,6 +934,7 @@ func TestSendingMessage(t *testing.T) {
 					Username: "test-streamer-username",
 					To:       "#test-streamer-username",
 					Message:  "test-streamer-message",
+					Priority: true,
 				},
 			},
 		},
@@ -385,6 +956,30 @@ func TestSendingMessage(t *testing.T) {
 					Username: "test-bot-username",
 					To:       "#test-streamer-username",
 					Message:  "test-bot-message",
+					Priority: true,
+				},
+			},
+		},
+		"message to another channel": {
+			event: handlers.Event{
+				Cmd:       "twitch-send-message",
+				RequestID: "test-request-id",
+				Payload: map[string]interface{}{
+					"user_type": "bot",
+					"message":   "test-bot-message",
+					"channel":   "Test-Friend",
+				},
+			},
+			user:    "test-bot-username",
+			pass:    "oauth:test-bot-password",
+			channel: "#test-streamer-username",
+			message: stream.TXMessage{
+				Type: stream.Twitch,
+				Twitch: &stream.TXTwitch{
+					Username: "test-bot-username",
+					To:       "#test-friend",
+					Message:  "test-bot-message",
+					Priority: true,
 				},
 			},
 		},
@@ -422,6 +1017,11 @@ func TestSendingInvalidMessages(t *testing.T) {
 			"user_type": "something-odd",
 			"message":   "test-message",
 		},
+		"invalid channel": map[string]interface{}{
+			"user_type": "streamer",
+			"message":   "test-message",
+			"channel":   "not a channel",
+		},
 	}
 
 	for _, payload := range cases {
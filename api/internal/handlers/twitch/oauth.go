@@ -23,6 +23,12 @@ type OauthCallbackRegistrar interface {
 	RegisterCompletionCallback(nonce string, f func())
 }
 
+// BotStarter starts a bot for the user once they have authenticated both
+// their streamer and bot users.
+type BotStarter interface {
+	StartBot(userID string)
+}
+
 // OauthStartHandler responds with a URL to start the Twitch oauth flow.
 // The streamer user is required to be the first to begin the oauth flow,
 // followed by the bot user.
@@ -33,6 +39,7 @@ type OauthStartHandler struct {
 	oauthClientID    string
 	oauthRedirectURL string
 	oauthCallbacks   OauthCallbackRegistrar
+	bots             BotStarter
 }
 
 // NewOauthStartHandler returns a new OauthStartHandler.
@@ -43,6 +50,7 @@ func NewOauthStartHandler(
 	oauthClientID string,
 	oauthRedirectURL string,
 	oauthCallbacks OauthCallbackRegistrar,
+	bots BotStarter,
 ) *OauthStartHandler {
 	return &OauthStartHandler{
 		creds:            creds,
@@ -51,6 +59,7 @@ func NewOauthStartHandler(
 		oauthClientID:    oauthClientID,
 		oauthRedirectURL: oauthRedirectURL,
 		oauthCallbacks:   oauthCallbacks,
+		bots:             bots,
 	}
 }
 
@@ -107,6 +116,8 @@ func (h *OauthStartHandler) HandleEvent(e handlers.Event, s handlers.Session) {
 	)
 
 	h.oauthCallbacks.RegisterCompletionCallback(nonce, func() {
+		h.bots.StartBot(userID)
+
 		resp := handlers.Event{
 			Cmd:     "twitch-oauth-complete",
 			Payload: tu.String(),
@@ -145,15 +156,22 @@ type AuthClearer interface {
 	TwitchClearAuth(userID string) (err error)
 }
 
-// ClearAuthHandler clears all auth data for the user.
+// BotStopper stops the bot for the user.
+type BotStopper interface {
+	StopBot(userID string)
+}
+
+// ClearAuthHandler clears all auth data for the user and stops their bot.
 type ClearAuthHandler struct {
 	authClearer AuthClearer
+	bots        BotStopper
 }
 
 // NewClearAuthHandler returns a new ClearAuthHandler.
-func NewClearAuthHandler(ac AuthClearer) *ClearAuthHandler {
+func NewClearAuthHandler(ac AuthClearer, bots BotStopper) *ClearAuthHandler {
 	return &ClearAuthHandler{
 		authClearer: ac,
+		bots:        bots,
 	}
 }
 
@@ -163,6 +181,7 @@ func (h *ClearAuthHandler) HandleEvent(e handlers.Event, s handlers.Session) {
 	defer send()
 
 	userID, _ := s.Authenticated()
+	h.bots.StopBot(userID)
 	err := h.authClearer.TwitchClearAuth(userID)
 	if err != nil {
 		return
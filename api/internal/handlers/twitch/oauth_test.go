@@ -30,6 +30,7 @@ func TestStreamerOauthStart(t *testing.T) {
 		"test-oauth-client-id",
 		"test-redirect-url",
 		spyCallbackRegistrar,
+		&SpyBotRunner{},
 	)
 	event := handlers.Event{
 		Cmd:       "twitch-oauth-start",
@@ -70,6 +71,7 @@ func TestNonceAlreadyExists(t *testing.T) {
 		"test-oauth-client-id",
 		"test-redirect-url",
 		spyCallbackRegistrar,
+		&SpyBotRunner{},
 	)
 	event := handlers.Event{
 		Cmd:       "twitch-oauth-start",
@@ -108,6 +110,7 @@ func TestErrorWhenStoringNonce(t *testing.T) {
 		"test-oauth-client-id",
 		"test-redirect-url",
 		spyCallbackRegistrar,
+		&SpyBotRunner{},
 	)
 	event := handlers.Event{
 		Cmd:       "twitch-oauth-start",
@@ -153,6 +156,7 @@ func TestBotOauthStart(t *testing.T) {
 		"test-oauth-client-id",
 		"test-redirect-url",
 		spyCallbackRegistrar,
+		&SpyBotRunner{},
 	)
 	event := handlers.Event{
 		Cmd:       "twitch-oauth-start",
@@ -173,6 +177,55 @@ func TestBotOauthStart(t *testing.T) {
 	expect(spySession.sendCalledWith).To.Equal(expected)
 }
 
+func TestOauthCompletionStartsBot(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyNonceGen := func() string {
+		return "test-nonce"
+	}
+	spyNonceStore := &SpyNonceStore{
+		err: errors.New("test-error"),
+	}
+	spyCallbackRegistrar := &SpyOauthCallbackRegistrar{}
+	spyCredsProvider := &SpyCredentialsProvider{
+		creds: store.TwitchCredentials{
+			StreamerAuthenticated: true,
+		},
+	}
+	spyBotRunner := &SpyBotRunner{}
+	handler := twitch.NewOauthStartHandler(
+		spyCredsProvider,
+		spyNonceGen,
+		spyNonceStore,
+		"test-oauth-client-id",
+		"test-redirect-url",
+		spyCallbackRegistrar,
+		spyBotRunner,
+	)
+	event := handlers.Event{
+		Cmd:       "twitch-oauth-start",
+		RequestID: "test-request-id",
+		Payload:   "bot",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expect(spyCallbackRegistrar.calledWithNonce).To.Equal("test-nonce")
+	expect(spyBotRunner.startCalledWith).To.Equal("")
+
+	spyCallbackRegistrar.callback()
+
+	expected := handlers.Event{
+		Cmd:     "twitch-oauth-complete",
+		Payload: "Bot",
+	}
+	expect(spyBotRunner.startCalledWith).To.Equal("test-user-id")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
 func TestBotOauthStartBeforeStreamer(t *testing.T) {
 	expect := expect.New(t)
 
@@ -198,6 +251,7 @@ func TestBotOauthStartBeforeStreamer(t *testing.T) {
 		"test-oauth-client-id",
 		"test-redirect-url",
 		spyCallbackRegistrar,
+		&SpyBotRunner{},
 	)
 	event := handlers.Event{
 		Cmd:       "twitch-oauth-start",
@@ -225,7 +279,7 @@ func TestInvalidPayloads(t *testing.T) {
 
 	for _, payload := range cases {
 		spySession := &SpySession{}
-		handler := twitch.NewOauthStartHandler(nil, nil, nil, "", "", nil)
+		handler := twitch.NewOauthStartHandler(nil, nil, nil, "", "", nil, nil)
 		event := handlers.Event{
 			Cmd:       "twitch-oauth-start",
 			RequestID: "test-request-id",
@@ -266,6 +320,7 @@ func TestErrorWhenGettingTwitchCredentials(t *testing.T) {
 		"test-oauth-client-id",
 		"test-redirect-url",
 		spyCallbackRegistrar,
+		&SpyBotRunner{},
 	)
 	event := handlers.Event{
 		Cmd:       "twitch-oauth-start",
@@ -290,7 +345,8 @@ func TestClearAuth(t *testing.T) {
 		userID: "test-user-id",
 	}
 	spyAuthClearer := &SpyAuthClearer{}
-	handler := twitch.NewClearAuthHandler(spyAuthClearer)
+	spyBotRunner := &SpyBotRunner{}
+	handler := twitch.NewClearAuthHandler(spyAuthClearer, spyBotRunner)
 	event := handlers.Event{
 		Cmd:       "twitch-clear-auth",
 		RequestID: "test-request-id",
@@ -303,6 +359,7 @@ func TestClearAuth(t *testing.T) {
 		RequestID: "test-request-id",
 	}
 	expect(spyAuthClearer.calledWith).To.Equal("test-user-id")
+	expect(spyBotRunner.stopCalledWith).To.Equal("test-user-id")
 	expect(spySession.sendCalledWith).To.Equal(expected)
 }
 
@@ -315,7 +372,7 @@ func TestWhenUnableToClearAuth(t *testing.T) {
 	spyAuthClearer := &SpyAuthClearer{
 		err: errors.New("test-error"),
 	}
-	handler := twitch.NewClearAuthHandler(spyAuthClearer)
+	handler := twitch.NewClearAuthHandler(spyAuthClearer, &SpyBotRunner{})
 	event := handlers.Event{
 		Cmd:       "twitch-clear-auth",
 		RequestID: "test-request-id",
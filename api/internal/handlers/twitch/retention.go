@@ -0,0 +1,100 @@
+package twitch
+
+import (
+	"log"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// RetentionStore gets and sets the user's message retention policy.
+type RetentionStore interface {
+	RetentionPolicy(userID string) (policy store.RetentionPolicy, err error)
+	SetRetentionPolicy(userID string, policy store.RetentionPolicy) (err error)
+}
+
+// RetentionPolicyHandler responds with the user's message retention policy.
+type RetentionPolicyHandler struct {
+	store RetentionStore
+}
+
+// NewRetentionPolicyHandler returns a new RetentionPolicyHandler.
+func NewRetentionPolicyHandler(store RetentionStore) *RetentionPolicyHandler {
+	return &RetentionPolicyHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *RetentionPolicyHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	userID, _ := s.Authenticated()
+	policy, err := h.store.RetentionPolicy(userID)
+	if err != nil {
+		log.Printf("unable to get retention policy: %s", err)
+		return
+	}
+
+	resp.Payload = map[string]interface{}{
+		"max_age":   int64(policy.MaxAge / time.Second),
+		"max_count": policy.MaxCount,
+	}
+	resp.Error = nil
+}
+
+// SetRetentionPolicyHandler sets the user's message retention policy.
+type SetRetentionPolicyHandler struct {
+	store RetentionStore
+}
+
+// NewSetRetentionPolicyHandler returns a new SetRetentionPolicyHandler.
+func NewSetRetentionPolicyHandler(store RetentionStore) *SetRetentionPolicyHandler {
+	return &SetRetentionPolicyHandler{
+		store: store,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *SetRetentionPolicyHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, policy := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	err := h.store.SetRetentionPolicy(userID, policy)
+	if err != nil {
+		log.Printf("unable to set retention policy: %s", err)
+		return
+	}
+	resp.Error = nil
+}
+
+// validatePayload returns true if the payload is valid. The max age is in
+// seconds and zero for either value means there is no limit.
+func (h *SetRetentionPolicyHandler) validatePayload(p interface{}) (bool, store.RetentionPolicy) {
+	payload, ok := p.(map[string]interface{})
+	if !ok {
+		return false, store.RetentionPolicy{}
+	}
+	maxAge, ok := payload["max_age"].(float64)
+	if !ok || maxAge < 0 || maxAge != float64(int64(maxAge)) {
+		return false, store.RetentionPolicy{}
+	}
+	maxCount, ok := payload["max_count"].(float64)
+	if !ok || maxCount < 0 || maxCount != float64(int(maxCount)) {
+		return false, store.RetentionPolicy{}
+	}
+
+	return true, store.RetentionPolicy{
+		MaxAge:   time.Duration(maxAge) * time.Second,
+		MaxCount: int(maxCount),
+	}
+}
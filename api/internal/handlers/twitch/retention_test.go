@@ -0,0 +1,134 @@
+package twitch_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/twitch"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestGettingTheRetentionPolicy(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyRetentionStore{
+		policy: store.RetentionPolicy{
+			MaxAge:   time.Hour,
+			MaxCount: 1000,
+		},
+	}
+	handler := twitch.NewRetentionPolicyHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "chat-retention-policy",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "chat-retention-policy",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"max_age":   int64(3600),
+			"max_count": 1000,
+		},
+	}
+	expect(spyStore.calledWithUserID).To.Equal("test-user-id")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestGettingTheRetentionPolicyWhenStoreFails(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := twitch.NewRetentionPolicyHandler(&SpyRetentionStore{
+		policyErr: errors.New("test-error"),
+	})
+	event := handlers.Event{
+		Cmd:       "chat-retention-policy",
+		RequestID: "test-request-id",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "chat-retention-policy",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingTheRetentionPolicy(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyStore := &SpyRetentionStore{}
+	handler := twitch.NewSetRetentionPolicyHandler(spyStore)
+	event := handlers.Event{
+		Cmd:       "chat-set-retention-policy",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"max_age":   float64(86400),
+			"max_count": float64(0),
+		},
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "chat-set-retention-policy",
+		RequestID: "test-request-id",
+	}
+	expect(spyStore.calledWithUserID).To.Equal("test-user-id")
+	expect(spyStore.setCalledWithPolicy).To.Equal(store.RetentionPolicy{
+		MaxAge: 24 * time.Hour,
+	})
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSettingTheRetentionPolicyWithInvalidPayload(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"nil payload":       nil,
+		"non-map":           "test-policy",
+		"missing max age":   map[string]interface{}{"max_count": float64(1)},
+		"missing max count": map[string]interface{}{"max_age": float64(1)},
+		"negative max age": map[string]interface{}{
+			"max_age":   float64(-1),
+			"max_count": float64(1),
+		},
+		"fractional max count": map[string]interface{}{
+			"max_age":   float64(1),
+			"max_count": 1.5,
+		},
+	}
+
+	for _, payload := range cases {
+		spySession := &SpySession{}
+		handler := twitch.NewSetRetentionPolicyHandler(&SpyRetentionStore{})
+		event := handlers.Event{
+			Cmd:       "chat-set-retention-policy",
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+
+		handler.HandleEvent(event, spySession)
+
+		expected := handlers.Event{
+			Cmd:       "chat-set-retention-policy",
+			RequestID: "test-request-id",
+			Error:     handlers.InvalidPayload,
+		}
+		expect(spySession.sendCalledWith).To.Equal(expected)
+	}
+}
@@ -0,0 +1,80 @@
+package twitch
+
+import (
+	"log"
+	"strings"
+
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// MessageQuerier searches the user's messages.
+type MessageQuerier interface {
+	QueryMessages(userID, search string) (msgs []stream.RXMessage, err error)
+}
+
+// SearchMessagesHandler responds with the user's chat messages that match a
+// search string, best matches first. Messages from the user's linked Discord
+// guild are included alongside their Twitch messages.
+type SearchMessagesHandler struct {
+	querier MessageQuerier
+}
+
+// NewSearchMessagesHandler returns a new SearchMessagesHandler.
+func NewSearchMessagesHandler(querier MessageQuerier) *SearchMessagesHandler {
+	return &SearchMessagesHandler{
+		querier: querier,
+	}
+}
+
+// HandleEvent responds to a websocket event.
+func (h *SearchMessagesHandler) HandleEvent(e handlers.Event, s handlers.Session) {
+	resp, send := handlers.Setup(e, s)
+	defer send()
+
+	ok, search := h.validatePayload(e.Payload)
+	if !ok {
+		resp.Error = handlers.InvalidPayload
+		return
+	}
+
+	userID, _ := s.Authenticated()
+	msgs, err := h.querier.QueryMessages(userID, search)
+	if err != nil {
+		log.Printf("unable to query messages: %s", err)
+		return
+	}
+
+	payload := make([]Message, 0, len(msgs))
+	for _, msg := range msgs {
+		p := Message{
+			Type: msg.Type,
+		}
+		switch msg.Type {
+		case stream.Twitch:
+			p.Twitch = newTMessage(msg.Twitch)
+		case stream.Discord:
+			p.Discord = newDiscordMessage(msg.Discord)
+			if p.Discord == nil {
+				continue
+			}
+		default:
+			continue
+		}
+		payload = append(payload, p)
+	}
+	resp.Payload = payload
+	resp.Error = nil
+}
+
+// validatePayload returns true if the payload is valid.
+func (h *SearchMessagesHandler) validatePayload(p interface{}) (bool, string) {
+	search, ok := p.(string)
+	if !ok {
+		return false, ""
+	}
+	if strings.TrimSpace(search) == "" {
+		return false, ""
+	}
+	return true, search
+}
@@ -0,0 +1,187 @@
+package twitch_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/bwmarrin/discordgo"
+	"github.com/fluffle/goirc/client"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/twitch"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestSearchingMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	now := time.Now()
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyQuerier := &SpyMessageQuerier{
+		msgs: []stream.RXMessage{
+			{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 12345,
+					Line: &client.Line{
+						Cmd:  "PRIVMSG",
+						Nick: "test-nick",
+						Args: []string{
+							"test-target",
+							"test-body",
+						},
+						Time: now,
+						Tags: map[string]string{
+							"test": "tag",
+						},
+					},
+				},
+			},
+		},
+	}
+	handler := twitch.NewSearchMessagesHandler(spyQuerier)
+	event := handlers.Event{
+		Cmd:       "twitch-search-messages",
+		RequestID: "test-request-id",
+		Payload:   "test-search",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "twitch-search-messages",
+		RequestID: "test-request-id",
+		Payload: []twitch.Message{
+			{
+				Type: stream.Twitch,
+				Twitch: &twitch.TMessage{
+					Cmd:    "PRIVMSG",
+					Nick:   "test-nick",
+					Target: "test-target",
+					Body:   "test-body",
+					Time:   now,
+					Tags: map[string]string{
+						"test": "tag",
+					},
+				},
+			},
+		},
+	}
+	expect(spyQuerier.calledWithUserID).To.Equal("test-user-id")
+	expect(spyQuerier.calledWithSearch).To.Equal("test-search")
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSearchingMessagesIncludesDiscordMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	now := time.Now()
+	spySession := &SpySession{
+		userID: "test-user-id",
+	}
+	spyQuerier := &SpyMessageQuerier{
+		msgs: []stream.RXMessage{
+			{
+				Type: stream.Discord,
+				Discord: &stream.RXDiscord{
+					OwnerID:     "test-owner-id",
+					GuildID:     "test-guild-id",
+					ChannelName: "test-channel",
+					Time:        now,
+					MessageCreate: &discordgo.MessageCreate{
+						Message: &discordgo.Message{
+							ID:        "test-message-id",
+							ChannelID: "test-channel-id",
+							Content:   "test-body",
+						},
+					},
+				},
+			},
+		},
+	}
+	handler := twitch.NewSearchMessagesHandler(spyQuerier)
+	event := handlers.Event{
+		Cmd:       "twitch-search-messages",
+		RequestID: "test-request-id",
+		Payload:   "test-search",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "twitch-search-messages",
+		RequestID: "test-request-id",
+		Payload: []twitch.Message{
+			{
+				Type: stream.Discord,
+				Discord: &twitch.DiscordMessage{
+					Event:       twitch.DiscordMessageCreate,
+					ID:          "test-message-id",
+					ChannelID:   "test-channel-id",
+					Channel:     "test-channel",
+					GuildID:     "test-guild-id",
+					Content:     "test-body",
+					Attachments: []twitch.DiscordAttachment{},
+					Time:        now,
+				},
+			},
+		},
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
+
+func TestSearchingMessagesWithInvalidPayload(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := map[string]interface{}{
+		"nil payload":     nil,
+		"non-string":      12345,
+		"blank search":    "   ",
+		"map of anything": map[string]interface{}{},
+	}
+
+	for _, payload := range cases {
+		spySession := &SpySession{}
+		handler := twitch.NewSearchMessagesHandler(&SpyMessageQuerier{})
+		event := handlers.Event{
+			Cmd:       "twitch-search-messages",
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+
+		handler.HandleEvent(event, spySession)
+
+		expected := handlers.Event{
+			Cmd:       "twitch-search-messages",
+			RequestID: "test-request-id",
+			Error:     handlers.InvalidPayload,
+		}
+		expect(spySession.sendCalledWith).To.Equal(expected)
+	}
+}
+
+func TestSearchingMessagesWhenQueryFails(t *testing.T) {
+	expect := expect.New(t)
+
+	spySession := &SpySession{}
+	handler := twitch.NewSearchMessagesHandler(&SpyMessageQuerier{
+		err: errors.New("test-error"),
+	})
+	event := handlers.Event{
+		Cmd:       "twitch-search-messages",
+		RequestID: "test-request-id",
+		Payload:   "test-search",
+	}
+
+	handler.HandleEvent(event, spySession)
+
+	expected := handlers.Event{
+		Cmd:       "twitch-search-messages",
+		RequestID: "test-request-id",
+		Error:     handlers.UnknownError,
+	}
+	expect(spySession.sendCalledWith).To.Equal(expected)
+}
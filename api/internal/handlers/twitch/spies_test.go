@@ -117,9 +117,28 @@ func (s *SpyNonceStore) StoreOauthNonce(userID string, tu store.TwitchUser, nonc
 	return s.storeErr
 }
 
-type SpyOauthCallbackRegistrar struct{}
+type SpyOauthCallbackRegistrar struct {
+	calledWithNonce string
+	callback        func()
+}
+
+func (s *SpyOauthCallbackRegistrar) RegisterCompletionCallback(nonce string, f func()) {
+	s.calledWithNonce = nonce
+	s.callback = f
+}
+
+type SpyBotRunner struct {
+	startCalledWith string
+	stopCalledWith  string
+}
 
-func (s *SpyOauthCallbackRegistrar) RegisterCompletionCallback(nonce string, f func()) {}
+func (s *SpyBotRunner) StartBot(userID string) {
+	s.startCalledWith = userID
+}
+
+func (s *SpyBotRunner) StopBot(userID string) {
+	s.stopCalledWith = userID
+}
 
 type SpyAuthClearer struct {
 	calledWith string
@@ -139,6 +158,9 @@ type SpyStreamMessagesStore struct {
 	credsCalledWith string
 	creds           store.TwitchCredentials
 	credsErr        error
+
+	discordCreds          store.DiscordCredentials
+	recentDiscordMessages []stream.RXMessage
 }
 
 func (s *SpyStreamMessagesStore) FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error) {
@@ -151,6 +173,14 @@ func (s *SpyStreamMessagesStore) TwitchCredentials(userID string) (creds store.T
 	return s.creds, s.credsErr
 }
 
+func (s *SpyStreamMessagesStore) DiscordCredentials(userID string) (creds store.DiscordCredentials, err error) {
+	return s.discordCreds, nil
+}
+
+func (s *SpyStreamMessagesStore) FetchRecentDiscordMessages(userID string) (msgs []stream.RXMessage, err error) {
+	return s.recentDiscordMessages, nil
+}
+
 type connectArgs struct {
 	user    string
 	pass    string
@@ -186,3 +216,79 @@ func (s *SpyStreamManager) ConnectTwitch(user string, pass string, channel strin
 func (s *SpyStreamManager) Send(msg stream.TXMessage) {
 	s.messageSent = msg
 }
+
+type SpyChannelManager struct {
+	SpyConnector
+
+	partCalledWithUser    string
+	partCalledWithChannel string
+	partErr               error
+
+	channels map[string][]string
+}
+
+func (s *SpyChannelManager) PartTwitchChannel(user, channel string) error {
+	s.partCalledWithUser = user
+	s.partCalledWithChannel = channel
+	return s.partErr
+}
+
+func (s *SpyChannelManager) TwitchChannels(user string) []string {
+	return s.channels[user]
+}
+
+type SpyMessageQuerier struct {
+	calledWithUserID string
+	calledWithSearch string
+	msgs             []stream.RXMessage
+	err              error
+}
+
+func (s *SpyMessageQuerier) QueryMessages(userID, search string) (msgs []stream.RXMessage, err error) {
+	s.calledWithUserID = userID
+	s.calledWithSearch = search
+	return s.msgs, s.err
+}
+
+type SpyChatHistoryStore struct {
+	fetchCalledWithUserID string
+	fetchCalledWithCursor string
+	fetchCalledWithLimit  int
+	msgs                  []stream.RXMessage
+	next                  string
+	fetchErr              error
+
+	creds    store.TwitchCredentials
+	credsErr error
+}
+
+func (s *SpyChatHistoryStore) FetchMessages(userID, cursor string, limit int) (msgs []stream.RXMessage, next string, err error) {
+	s.fetchCalledWithUserID = userID
+	s.fetchCalledWithCursor = cursor
+	s.fetchCalledWithLimit = limit
+	return s.msgs, s.next, s.fetchErr
+}
+
+func (s *SpyChatHistoryStore) TwitchCredentials(userID string) (creds store.TwitchCredentials, err error) {
+	return s.creds, s.credsErr
+}
+
+type SpyRetentionStore struct {
+	calledWithUserID string
+	policy           store.RetentionPolicy
+	policyErr        error
+
+	setCalledWithPolicy store.RetentionPolicy
+	setErr              error
+}
+
+func (s *SpyRetentionStore) RetentionPolicy(userID string) (policy store.RetentionPolicy, err error) {
+	s.calledWithUserID = userID
+	return s.policy, s.policyErr
+}
+
+func (s *SpyRetentionStore) SetRetentionPolicy(userID string, policy store.RetentionPolicy) (err error) {
+	s.calledWithUserID = userID
+	s.setCalledWithPolicy = policy
+	return s.setErr
+}
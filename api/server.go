@@ -3,6 +3,7 @@ package api
 import (
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,10 +11,13 @@ import (
 
 	"github.com/jasonkeene/anubot-server/api/internal/handlers"
 	"github.com/jasonkeene/anubot-server/api/internal/handlers/auth"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/bot"
 	"github.com/jasonkeene/anubot-server/api/internal/handlers/bttv"
+	"github.com/jasonkeene/anubot-server/api/internal/handlers/discord"
 	"github.com/jasonkeene/anubot-server/api/internal/handlers/general"
 	"github.com/jasonkeene/anubot-server/api/internal/handlers/twitch"
 	bttvAPI "github.com/jasonkeene/anubot-server/bttv"
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/store"
 	"github.com/jasonkeene/anubot-server/stream"
 	twitchAPI "github.com/jasonkeene/anubot-server/twitch"
@@ -33,12 +37,36 @@ type Store interface {
 	TwitchCredentials(userID string) (creds store.TwitchCredentials, err error)
 	TwitchClearAuth(userID string) (err error)
 
+	DiscordCredentials(userID string) (creds store.DiscordCredentials, err error)
+	DiscordOauthNonce(userID string) (nonce string, err error)
+	StoreDiscordOauthNonce(userID, nonce string) (err error)
+	DiscordClearAuth(userID string) (err error)
+
 	FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error)
+	FetchRecentDiscordMessages(userID string) (msgs []stream.RXMessage, err error)
+	FetchMessages(userID, cursor string, limit int) (msgs []stream.RXMessage, next string, err error)
+	QueryMessages(userID, search string) (msgs []stream.RXMessage, err error)
+
+	RetentionPolicy(userID string) (policy store.RetentionPolicy, err error)
+	SetRetentionPolicy(userID string, policy store.RetentionPolicy) (err error)
+
+	CustomCommands(userID string) (cmds []store.CustomCommand, err error)
+	SetCustomCommand(userID string, cmd store.CustomCommand) (err error)
+	DeleteCustomCommand(userID, trigger string) (err error)
+	ModerationRules(userID string) (rules store.ModerationRules, err error)
+	SetModerationRules(userID string, rules store.ModerationRules) (err error)
+	Timers(userID string) (timers []store.Timer, err error)
+	SetTimer(userID string, timer store.Timer) (err error)
+	DeleteTimer(userID, name string) (err error)
 }
 
 // StreamManager is used to connect and send to third party chat.
 type StreamManager interface {
 	ConnectTwitch(user, pass, channel string)
+	PartTwitchChannel(user, channel string) (err error)
+	TwitchChannels(user string) (channels []string)
+	DiscordChannels(userID, guildID string) (channels []stream.DiscordChannel, err error)
+	IsDiscordGuildMember(userID, guildID, memberID string) (member bool, err error)
 	Send(msg stream.TXMessage)
 }
 
@@ -64,20 +92,51 @@ type OauthCallbackRegistrar interface {
 // NonceGenerator generates a random nonce to be used in the oauth flow.
 type NonceGenerator func() string
 
+// BotRunner starts and stops the bots for users along with their
+// connections to Discord.
+type BotRunner interface {
+	StartBot(userID string)
+	StopBot(userID string)
+	StartDiscord(userID string)
+	StopDiscord(userID string)
+}
+
+// nopBotRunner is used when the server is not configured to run bots.
+type nopBotRunner struct{}
+
+func (nopBotRunner) StartBot(userID string)     {}
+func (nopBotRunner) StopBot(userID string)      {}
+func (nopBotRunner) StartDiscord(userID string) {}
+func (nopBotRunner) StopDiscord(userID string)  {}
+
+// nopOauthCallbacks is used when the server is not configured to link
+// Discord guilds.
+type nopOauthCallbacks struct{}
+
+func (nopOauthCallbacks) RegisterCompletionCallback(nonce string, f func()) {}
+
 // Server responds to websocket events sent from the client.
 type Server struct {
-	streamManager          StreamManager
-	subEndpoints           []string
-	store                  Store
-	twitchClient           TwitchClient
-	twitchOauthClientID    string
-	twitchOauthRedirectURL string
-	bttvClient             BTTVClient
-	pingInterval           time.Duration
-	twitchOauthCallbacks   OauthCallbackRegistrar
-	nonceGen               NonceGenerator
-	handlers               map[string]handlers.EventHandler
-	upgrader               websocket.Upgrader
+	streamManager           StreamManager
+	bus                     bus.Bus
+	subEndpoints            []string
+	store                   Store
+	twitchClient            TwitchClient
+	twitchOauthClientID     string
+	twitchOauthRedirectURL  string
+	bttvClient              BTTVClient
+	pingInterval            time.Duration
+	twitchOauthCallbacks    OauthCallbackRegistrar
+	discordOauthClientID    string
+	discordOauthRedirectURL string
+	discordOauthCallbacks   OauthCallbackRegistrar
+	nonceGen                NonceGenerator
+	botRunner               BotRunner
+	handlers                map[string]handlers.EventHandler
+	upgrader                websocket.Upgrader
+
+	sessionsMu sync.Mutex
+	sessions   map[string]int
 }
 
 // Option is used to configure a Server.
@@ -91,6 +150,14 @@ func WithSubEndpoints(endpoints []string) Option {
 	}
 }
 
+// WithBus allows you to override the default bus that the server subscribes
+// with.
+func WithBus(b bus.Bus) Option {
+	return func(s *Server) {
+		s.bus = b
+	}
+}
+
 // WithPingInterval allows you to configure how fast to send pings.
 func WithPingInterval(pingInterval time.Duration) Option {
 	return func(s *Server) {
@@ -112,6 +179,29 @@ func WithNonceGenerator(ng NonceGenerator) Option {
 	}
 }
 
+// WithDiscordOauth allows users to add the Discord bot to their guild. The
+// callbacks are invoked when a user finishes the Discord oauth flow.
+func WithDiscordOauth(
+	clientID string,
+	redirectURL string,
+	callbacks OauthCallbackRegistrar,
+) Option {
+	return func(s *Server) {
+		s.discordOauthClientID = clientID
+		s.discordOauthRedirectURL = redirectURL
+		s.discordOauthCallbacks = callbacks
+	}
+}
+
+// WithBotRunner allows you to run bots for users. Bots are started when users
+// finish authenticating with Twitch and stopped when they clear their Twitch
+// auth or their last session logs out.
+func WithBotRunner(r BotRunner) Option {
+	return func(s *Server) {
+		s.botRunner = r
+	}
+}
+
 // New creates a new Server.
 func New(
 	streamManager StreamManager,
@@ -124,6 +214,7 @@ func New(
 ) *Server {
 	s := &Server{
 		streamManager:          streamManager,
+		bus:                    bus.Default,
 		subEndpoints:           []string{"inproc://dispatch-pub"},
 		store:                  store,
 		twitchClient:           twitchClient,
@@ -133,11 +224,14 @@ func New(
 		bttvClient:             bttvAPI.New(),
 		pingInterval:           5 * time.Second,
 		nonceGen:               oauth.GenerateNonce,
+		discordOauthCallbacks:  nopOauthCallbacks{},
+		botRunner:              nopBotRunner{},
+		sessions:               make(map[string]int),
 	}
-	s.createHandlers()
 	for _, opt := range opts {
 		opt(s)
 	}
+	s.createHandlers()
 	return s
 }
 
@@ -167,16 +261,35 @@ func (s *Server) createHandlers() {
 				s.twitchOauthClientID,
 				s.twitchOauthRedirectURL,
 				s.twitchOauthCallbacks,
+				s.botRunner,
 			),
 		)
 		s.handlers["twitch-clear-auth"] = auth.AuthenticateWrapper(
-			twitch.NewClearAuthHandler(s.store),
+			twitch.NewClearAuthHandler(s.store, s.botRunner),
+		)
+
+		// discord oauth
+		s.handlers["discord-oauth-start"] = auth.AuthenticateWrapper(
+			discord.NewOauthStartHandler(
+				discord.NonceGenerator(s.nonceGen),
+				s.store,
+				s.discordOauthClientID,
+				s.discordOauthRedirectURL,
+				s.discordOauthCallbacks,
+				s.botRunner,
+			),
+		)
+		s.handlers["discord-clear-auth"] = auth.AuthenticateWrapper(
+			discord.NewClearAuthHandler(s.store, s.botRunner),
 		)
 
 		// user information
 		s.handlers["twitch-user-details"] = auth.AuthenticateWrapper(
 			twitch.NewUserDetailsHandler(s.store, s.twitchClient),
 		)
+		s.handlers["discord-user-details"] = auth.AuthenticateWrapper(
+			discord.NewUserDetailsHandler(s.store),
+		)
 
 		// twitch
 		s.handlers["twitch-games"] = auth.AuthenticateWrapper(
@@ -187,6 +300,44 @@ func (s *Server) createHandlers() {
 		s.handlers["bttv-emoji"] = auth.AuthenticateWrapper(
 			bttv.NewEmojiHandler(s.store, s.bttvClient),
 		)
+
+		// chat history retention
+		s.handlers["chat-retention-policy"] = auth.AuthenticateWrapper(
+			twitch.NewRetentionPolicyHandler(s.store),
+		)
+		s.handlers["chat-set-retention-policy"] = auth.AuthenticateWrapper(
+			twitch.NewSetRetentionPolicyHandler(s.store),
+		)
+
+		// bot custom commands
+		s.handlers["bot-commands-list"] = auth.AuthenticateWrapper(
+			bot.NewCommandsListHandler(s.store),
+		)
+		s.handlers["bot-command-set"] = auth.AuthenticateWrapper(
+			bot.NewSetCommandHandler(s.store),
+		)
+		s.handlers["bot-command-delete"] = auth.AuthenticateWrapper(
+			bot.NewDeleteCommandHandler(s.store),
+		)
+
+		// bot moderation
+		s.handlers["bot-moderation-rules"] = auth.AuthenticateWrapper(
+			bot.NewModerationRulesHandler(s.store),
+		)
+		s.handlers["bot-set-moderation-rules"] = auth.AuthenticateWrapper(
+			bot.NewSetModerationRulesHandler(s.store),
+		)
+
+		// bot timers
+		s.handlers["bot-timers-list"] = auth.AuthenticateWrapper(
+			bot.NewTimersListHandler(s.store),
+		)
+		s.handlers["bot-timer-set"] = auth.AuthenticateWrapper(
+			bot.NewSetTimerHandler(s.store),
+		)
+		s.handlers["bot-timer-delete"] = auth.AuthenticateWrapper(
+			bot.NewDeleteTimerHandler(s.store),
+		)
 	}
 
 	// twitch authenticated
@@ -198,6 +349,7 @@ func (s *Server) createHandlers() {
 				twitch.NewStreamMessagesHandler(
 					s.store,
 					s.streamManager,
+					s.bus,
 					s.subEndpoints,
 				),
 			),
@@ -208,12 +360,59 @@ func (s *Server) createHandlers() {
 				twitch.NewSendMessageHandler(s.store, s.streamManager),
 			),
 		)
+		s.handlers["twitch-channels"] = auth.AuthenticateWrapper(
+			twitch.AuthenticateWrapper(
+				s.store,
+				twitch.NewChannelsHandler(s.store, s.streamManager),
+			),
+		)
+		s.handlers["twitch-join-channel"] = auth.AuthenticateWrapper(
+			twitch.AuthenticateWrapper(
+				s.store,
+				twitch.NewJoinChannelHandler(s.store, s.streamManager),
+			),
+		)
+		s.handlers["twitch-part-channel"] = auth.AuthenticateWrapper(
+			twitch.AuthenticateWrapper(
+				s.store,
+				twitch.NewPartChannelHandler(s.store, s.streamManager),
+			),
+		)
 		s.handlers["twitch-update-chat-description"] = auth.AuthenticateWrapper(
 			twitch.AuthenticateWrapper(
 				s.store,
 				twitch.NewUpdateChatDescriptionHandler(s.store, s.twitchClient),
 			),
 		)
+		s.handlers["twitch-search-messages"] = auth.AuthenticateWrapper(
+			twitch.AuthenticateWrapper(
+				s.store,
+				twitch.NewSearchMessagesHandler(s.store),
+			),
+		)
+		s.handlers["chat-history"] = auth.AuthenticateWrapper(
+			twitch.AuthenticateWrapper(
+				s.store,
+				twitch.NewChatHistoryHandler(s.store),
+			),
+		)
+	}
+
+	// discord linked
+	{
+		// discord chat
+		s.handlers["discord-channels"] = auth.AuthenticateWrapper(
+			discord.AuthenticateWrapper(
+				s.store,
+				discord.NewChannelsHandler(s.store, s.streamManager),
+			),
+		)
+		s.handlers["discord-send-message"] = auth.AuthenticateWrapper(
+			discord.AuthenticateWrapper(
+				s.store,
+				discord.NewSendMessageHandler(s.store, s.streamManager),
+			),
+		)
 	}
 }
 
@@ -233,11 +432,13 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	go s.writePings(ws)
 
 	sess := &session{
-		id: uuid.NewV4().String(),
-		ws: ws,
+		id:      uuid.NewV4().String(),
+		ws:      ws,
+		tracker: s,
 	}
 	log.Printf("serving session: %s", sess.id)
 	defer log.Printf("done serving session: %s", sess.id)
+	defer sess.Close()
 
 	for {
 		e, err := sess.Receive()
@@ -264,6 +465,43 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// login records that a session has authenticated as the user. The user's bot
+// is started if it is not already running.
+func (s *Server) login(userID string) {
+	s.sessionsMu.Lock()
+	s.sessions[userID]++
+	s.sessionsMu.Unlock()
+	go s.botRunner.StartBot(userID)
+}
+
+// logout records that a session for the user has logged out. The user's bot
+// is stopped if this was their last session.
+func (s *Server) logout(userID string) {
+	if s.removeSession(userID) == 0 {
+		s.botRunner.StopBot(userID)
+	}
+}
+
+// disconnect records that a session for the user was closed without logging
+// out. The user's bot keeps running.
+func (s *Server) disconnect(userID string) {
+	s.removeSession(userID)
+}
+
+// removeSession removes a session for the user and returns how many
+// sessions remain.
+func (s *Server) removeSession(userID string) int {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	n := s.sessions[userID] - 1
+	if n <= 0 {
+		delete(s.sessions, userID)
+		return 0
+	}
+	s.sessions[userID] = n
+	return n
+}
+
 func (s *Server) writePings(ws *websocket.Conn) {
 	for {
 		err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
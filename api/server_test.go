@@ -204,11 +204,31 @@ func TestItWiresUpAuthenticatedHandlers(t *testing.T) {
 		"twitch-oauth-start",
 		"twitch-clear-auth",
 		"twitch-user-details",
+		"discord-oauth-start",
+		"discord-clear-auth",
+		"discord-user-details",
 		"twitch-games",
 		"bttv-emoji",
 		"twitch-stream-messages",
 		"twitch-send-message",
+		"twitch-channels",
+		"twitch-join-channel",
+		"twitch-part-channel",
 		"twitch-update-chat-description",
+		"twitch-search-messages",
+		"chat-history",
+		"discord-channels",
+		"discord-send-message",
+		"chat-retention-policy",
+		"chat-set-retention-policy",
+		"bot-commands-list",
+		"bot-command-set",
+		"bot-command-delete",
+		"bot-moderation-rules",
+		"bot-set-moderation-rules",
+		"bot-timers-list",
+		"bot-timer-set",
+		"bot-timer-delete",
 	}
 	for _, method := range cases {
 		event := handlers.Event{
@@ -304,8 +324,13 @@ func TestItWiresUpTwitchAuthenticatedHandlers(t *testing.T) {
 
 	cases := []string{
 		"twitch-send-message",
+		"twitch-channels",
+		"twitch-join-channel",
+		"twitch-part-channel",
 		"twitch-update-chat-description",
 		"twitch-stream-messages",
+		"twitch-search-messages",
+		"chat-history",
 	}
 	for _, method := range cases {
 		event := handlers.Event{
@@ -350,3 +375,142 @@ func TestItWiresUpTwitchAuthenticatedHandlers(t *testing.T) {
 		expect(actual.Error).Not.To.Equal(handlers.TwitchAuthenticationError)
 	}
 }
+
+func TestItWiresUpDiscordLinkedHandlers(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStreamManager := &SpyStreamManager{}
+	spyStore := &SpyStore{}
+	api := api.New(spyStreamManager, spyStore, nil, nil, "", "")
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	url := strings.Replace(server.URL, "http://", "ws://", 1)
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	expect(err).To.Be.Nil()
+	defer func() {
+		_ = c.Close()
+	}()
+
+	spyStore.userID = "test-user-id"
+	spyStore.authenticated = true
+	event := handlers.Event{
+		Cmd:       "authenticate",
+		RequestID: "test-request-id",
+		Payload: map[string]interface{}{
+			"username": "test-username",
+			"password": "test-password",
+		},
+	}
+	bytes, err := json.Marshal(event)
+	expect(err).To.Be.Nil()
+	err = c.WriteMessage(websocket.TextMessage, bytes)
+	expect(err).To.Be.Nil()
+
+	_, _, err = c.ReadMessage()
+	expect(err).To.Be.Nil()
+
+	cases := []string{
+		"discord-channels",
+		"discord-send-message",
+	}
+	for _, method := range cases {
+		event := handlers.Event{
+			Cmd:       method,
+			RequestID: "test-request-id",
+		}
+		bytes, err := json.Marshal(event)
+		expect(err).To.Be.Nil()
+		err = c.WriteMessage(websocket.TextMessage, bytes)
+		expect(err).To.Be.Nil()
+
+		_, resp, err := c.ReadMessage()
+		expect(err).To.Be.Nil()
+		var actual handlers.Event
+		err = json.Unmarshal(resp, &actual)
+		expect(err).To.Be.Nil()
+
+		expect(actual.Error).To.Equal(handlers.DiscordAuthenticationError)
+	}
+
+	spyStore.discordCreds = store.DiscordCredentials{
+		Authenticated: true,
+	}
+
+	for _, method := range cases {
+		event := handlers.Event{
+			Cmd:       method,
+			RequestID: "test-request-id",
+		}
+		bytes, err := json.Marshal(event)
+		expect(err).To.Be.Nil()
+		err = c.WriteMessage(websocket.TextMessage, bytes)
+		expect(err).To.Be.Nil()
+
+		_, resp, err := c.ReadMessage()
+		expect(err).To.Be.Nil()
+		var actual handlers.Event
+		err = json.Unmarshal(resp, &actual)
+		expect(err).To.Be.Nil()
+
+		expect(actual.Error).Not.To.Equal(handlers.DiscordAuthenticationError)
+	}
+}
+
+func TestItStopsBotsWhenTheLastSessionLogsOut(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyStore{
+		userID:        "test-user-id",
+		authenticated: true,
+	}
+	spyBotRunner := NewSpyBotRunner()
+	api := api.New(nil, spyStore, nil, nil, "", "", api.WithBotRunner(spyBotRunner))
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	url := strings.Replace(server.URL, "http://", "ws://", 1)
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		expect(err).To.Be.Nil()
+		defer func() {
+			_ = c.Close()
+		}()
+		conns = append(conns, c)
+	}
+
+	request := func(c *websocket.Conn, cmd string, payload interface{}) {
+		event := handlers.Event{
+			Cmd:       cmd,
+			RequestID: "test-request-id",
+			Payload:   payload,
+		}
+		bytes, err := json.Marshal(event)
+		expect(err).To.Be.Nil()
+		err = c.WriteMessage(websocket.TextMessage, bytes)
+		expect(err).To.Be.Nil()
+		_, _, err = c.ReadMessage()
+		expect(err).To.Be.Nil()
+	}
+
+	for _, c := range conns {
+		request(c, "authenticate", map[string]interface{}{
+			"username": "test-username",
+			"password": "test-password",
+		})
+		select {
+		case userID := <-spyBotRunner.startCalledWith:
+			expect(userID).To.Equal("test-user-id")
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for bot to be started")
+		}
+	}
+
+	request(conns[0], "logout", nil)
+	expect(len(spyBotRunner.stopCalledWith)).To.Equal(0)
+
+	request(conns[1], "logout", nil)
+	expect(len(spyBotRunner.stopCalledWith)).To.Equal(1)
+	expect(<-spyBotRunner.stopCalledWith).To.Equal("test-user-id")
+}
@@ -16,6 +16,15 @@ type session struct {
 	ws            *websocket.Conn
 	authenticated bool
 	userID        string
+	tracker       sessionTracker
+}
+
+// sessionTracker is notified when sessions authenticate, logout or are
+// closed.
+type sessionTracker interface {
+	login(userID string)
+	logout(userID string)
+	disconnect(userID string)
 }
 
 // Send sends an event to the user over the websocket connection.
@@ -43,8 +52,15 @@ func (s *session) Receive() (handlers.Event, error) {
 
 // SetAuthentication sets the authentication for this session.
 func (s *session) SetAuthentication(userID string) {
+	if s.authenticated {
+		if s.userID == userID {
+			return
+		}
+		s.tracker.logout(s.userID)
+	}
 	s.authenticated = true
 	s.userID = userID
+	s.tracker.login(userID)
 }
 
 // Authenticated lets you know what user this session is authenticated as.
@@ -54,6 +70,19 @@ func (s *session) Authenticated() (string, bool) {
 
 // Logout clears the authentication for this session.
 func (s *session) Logout() {
+	if s.authenticated {
+		s.tracker.logout(s.userID)
+	}
+	s.authenticated = false
+	s.userID = ""
+}
+
+// Close releases the authentication for this session without logging out.
+// It is called when the websocket connection has been closed.
+func (s *session) Close() {
+	if s.authenticated {
+		s.tracker.disconnect(s.userID)
+	}
 	s.authenticated = false
 	s.userID = ""
 }
@@ -14,6 +14,22 @@ type SpyStreamManager struct {
 
 func (s *SpyStreamManager) ConnectTwitch(user, pass, channel string) {}
 
+func (s *SpyStreamManager) PartTwitchChannel(user, channel string) error {
+	return nil
+}
+
+func (s *SpyStreamManager) TwitchChannels(user string) []string {
+	return nil
+}
+
+func (s *SpyStreamManager) DiscordChannels(userID, guildID string) ([]stream.DiscordChannel, error) {
+	return nil, nil
+}
+
+func (s *SpyStreamManager) IsDiscordGuildMember(userID, guildID, memberID string) (bool, error) {
+	return false, nil
+}
+
 type SpyStore struct {
 	api.Store
 
@@ -21,7 +37,8 @@ type SpyStore struct {
 	authenticated bool
 	err           error
 
-	creds store.TwitchCredentials
+	creds        store.TwitchCredentials
+	discordCreds store.DiscordCredentials
 }
 
 func (s *SpyStore) AuthenticateUser(username, password string) (userID string, authenticated bool, err error) {
@@ -36,6 +53,30 @@ func (s *SpyStore) TwitchClearAuth(userID string) (err error) {
 	return nil
 }
 
+func (s *SpyStore) RetentionPolicy(userID string) (policy store.RetentionPolicy, err error) {
+	return store.RetentionPolicy{}, nil
+}
+
+func (s *SpyStore) CustomCommands(userID string) (cmds []store.CustomCommand, err error) {
+	return nil, nil
+}
+
+func (s *SpyStore) ModerationRules(userID string) (rules store.ModerationRules, err error) {
+	return store.ModerationRules{}, nil
+}
+
+func (s *SpyStore) Timers(userID string) (timers []store.Timer, err error) {
+	return nil, nil
+}
+
+func (s *SpyStore) DiscordCredentials(userID string) (creds store.DiscordCredentials, err error) {
+	return s.discordCreds, nil
+}
+
+func (s *SpyStore) FetchRecentDiscordMessages(userID string) (msgs []stream.RXMessage, err error) {
+	return nil, nil
+}
+
 func (s *SpyStore) FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error) {
 	return []stream.RXMessage{{
 		Type: stream.Twitch,
@@ -54,3 +95,27 @@ type SpyTwitchClient struct {
 func (s *SpyTwitchClient) Games() (games []twitch.Game) {
 	return nil
 }
+
+type SpyBotRunner struct {
+	startCalledWith chan string
+	stopCalledWith  chan string
+}
+
+func NewSpyBotRunner() *SpyBotRunner {
+	return &SpyBotRunner{
+		startCalledWith: make(chan string, 100),
+		stopCalledWith:  make(chan string, 100),
+	}
+}
+
+func (s *SpyBotRunner) StartBot(userID string) {
+	s.startCalledWith <- userID
+}
+
+func (s *SpyBotRunner) StopBot(userID string) {
+	s.stopCalledWith <- userID
+}
+
+func (s *SpyBotRunner) StartDiscord(userID string) {}
+
+func (s *SpyBotRunner) StopDiscord(userID string) {}
@@ -1,13 +1,14 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/stream"
-
-	"github.com/pebbe/zmq4"
 )
 
 // Sender sends messsgaes to a stream source.
@@ -16,7 +17,8 @@ type Sender interface {
 }
 
 // Feature accepts messages and spawns goroutines to implement the logic of
-// the bot.
+// the bot. Features are started when the bot starts or when they are set on
+// a running bot and are stopped when the bot stops or they are removed.
 type Feature interface {
 	HandleMessage(ms stream.RXMessage)
 	Start()
@@ -27,10 +29,13 @@ type Feature interface {
 type Bot struct {
 	subEndpoints []string
 	topics       []string
-	sub          *zmq4.Socket
+	bus          bus.Bus
+	sub          bus.Subscription
 	featuresMu   sync.Mutex
 	features     map[string]Feature
+	running      bool
 	stop         chan struct{}
+	stopOnce     sync.Once
 	done         chan struct{}
 }
 
@@ -45,12 +50,20 @@ func WithSubEndpoints(endpoints []string) Option {
 	}
 }
 
+// WithBus allows you to override the default bus.
+func WithBus(b bus.Bus) Option {
+	return func(bot *Bot) {
+		bot.bus = b
+	}
+}
+
 // New returns a new Bot that is connected to publishers and accepting messages
 // for specific topics.
 func New(topics []string, opts ...Option) (*Bot, error) {
 	b := &Bot{
 		subEndpoints: []string{"inproc://dispatch-pub"},
 		topics:       topics,
+		bus:          bus.Default,
 		features:     make(map[string]Feature),
 		stop:         make(chan struct{}),
 		done:         make(chan struct{}),
@@ -66,30 +79,28 @@ func New(topics []string, opts ...Option) (*Bot, error) {
 }
 
 func (b *Bot) setupSockets() error {
-	sub, err := zmq4.NewSocket(zmq4.SUB)
+	sub, err := b.bus.Subscribe(b.subEndpoints, b.topics)
 	if err != nil {
 		return err
 	}
-	for _, endpoint := range b.subEndpoints {
-		err = sub.Connect(endpoint)
-		if err != nil {
-			return err
-		}
-	}
-	for _, topic := range b.topics {
-		err = sub.SetSubscribe(topic)
-		if err != nil {
-			return err
-		}
-	}
 	b.sub = sub
 	return nil
 }
 
-// Start reads from sub socket and sends messages to features. It needs to run
-// in its own goroutine.
+// Start starts the features then reads from sub socket and sends messages
+// to them. It needs to run in its own goroutine. The features are stopped
+// before it returns.
 func (b *Bot) Start() {
 	defer close(b.done)
+	defer func() {
+		err := b.sub.Close()
+		if err != nil {
+			log.Printf("got err while closing sub socket: %s", err)
+		}
+	}()
+
+	b.startFeatures()
+	defer b.stopFeatures()
 
 	for {
 		select {
@@ -98,17 +109,18 @@ func (b *Bot) Start() {
 		default:
 		}
 
-		rb, err := b.sub.RecvMessageBytes(0)
+		// waiting for a limited time allows the bot to notice it has been
+		// stopped when no messages are being published
+		_, msg, err := b.sub.Receive(time.Second)
 		if err != nil {
+			if err == bus.ErrTimeout {
+				continue
+			}
 			log.Printf("messages not read, got err: %s", err)
 			continue
 		}
-		if len(rb) < 2 {
-			log.Printf("received message bytes had invalid length: %#v", rb)
-			continue
-		}
 		var ms stream.RXMessage
-		err = json.Unmarshal(rb[1], &ms)
+		err = json.Unmarshal(msg, &ms)
 		if err != nil {
 			log.Printf("could not unmarshal, got err: %s", err)
 			continue
@@ -129,25 +141,74 @@ func (b *Bot) Start() {
 // Stop signals to the goroutine reading messages to stop. It returns a
 // function that can be used to block until reading has finished.
 func (b *Bot) Stop() (wait func()) {
-	close(b.stop)
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
 	return func() {
 		<-b.done
 	}
 }
 
+// Shutdown stops the bot and blocks until its features have stopped and its
+// socket has been closed. If the context is done first its error is
+// returned.
+func (b *Bot) Shutdown(ctx context.Context) error {
+	b.Stop()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFeatures starts all the features that have been set.
+func (b *Bot) startFeatures() {
+	b.featuresMu.Lock()
+	defer b.featuresMu.Unlock()
+	b.running = true
+	for _, f := range b.features {
+		f.Start()
+	}
+}
+
+// stopFeatures stops all the features that have been set.
+func (b *Bot) stopFeatures() {
+	b.featuresMu.Lock()
+	defer b.featuresMu.Unlock()
+	b.running = false
+	for _, f := range b.features {
+		f.Stop()
+	}
+}
+
 // SetFeature sets a feature to accept messages and ticks. This will overwrite
-// features previously set with the same name.
+// features previously set with the same name. If the bot is running the
+// overwritten feature is stopped and the new feature is started.
 func (b *Bot) SetFeature(name string, f Feature) {
 	b.featuresMu.Lock()
 	defer b.featuresMu.Unlock()
+	if b.running {
+		if old, ok := b.features[name]; ok {
+			old.Stop()
+		}
+		f.Start()
+	}
 	b.features[name] = f
 }
 
-// RemoveFeature removes a feature from the bot and returns it.
+// RemoveFeature removes a feature from the bot and returns it. If the bot is
+// running the feature is stopped.
 func (b *Bot) RemoveFeature(name string) Feature {
 	b.featuresMu.Lock()
 	defer b.featuresMu.Unlock()
-	f := b.features[name]
+	f, ok := b.features[name]
+	if !ok {
+		return nil
+	}
 	delete(b.features, name)
+	if b.running {
+		f.Stop()
+	}
 	return f
 }
@@ -9,11 +9,11 @@ import (
 	"time"
 
 	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/stream"
 
 	"github.com/a8m/expect"
 	"github.com/fluffle/goirc/client"
-	"github.com/pebbe/zmq4"
 )
 
 func TestBotDispatchesMessagesToFeatures(t *testing.T) {
@@ -22,11 +22,11 @@ func TestBotDispatchesMessagesToFeatures(t *testing.T) {
 	pubTopic, subTopic := "test-topic", "test-topic"
 
 	f := newMockFeature()
-	pub, endpoints := setupPubSocket(expect)
+	pub, endpoints := setupPublisher(expect)
 	defer func() {
 		err := pub.Close()
 		if err != nil {
-			log.Printf("got err while closing pub socket: %s", err)
+			log.Printf("got err while closing publisher: %s", err)
 		}
 	}()
 	expected, toSend := testMessage(expect, "test-message")
@@ -37,7 +37,7 @@ func TestBotDispatchesMessagesToFeatures(t *testing.T) {
 	go b.Start()
 	defer b.Stop()
 
-	_, err = pub.SendMessage(pubTopic, toSend)
+	err = pub.Publish(pubTopic, toSend)
 	expect(err).To.Be.Nil()
 
 	select {
@@ -56,11 +56,11 @@ func TestBotDoesNotDispatchMessagesIfTopicDoesNotMatch(t *testing.T) {
 	pubTopic, subTopic := "test-a", "test-b"
 
 	f := newMockFeature()
-	pub, endpoints := setupPubSocket(expect)
+	pub, endpoints := setupPublisher(expect)
 	defer func() {
 		err := pub.Close()
 		if err != nil {
-			log.Printf("got err while closing pub socket: %s", err)
+			log.Printf("got err while closing publisher: %s", err)
 		}
 	}()
 	_, badBytes := testMessage(expect, "test-message")
@@ -72,9 +72,9 @@ func TestBotDoesNotDispatchMessagesIfTopicDoesNotMatch(t *testing.T) {
 	go b.Start()
 	defer b.Stop()
 
-	_, err = pub.SendMessage(pubTopic, badBytes)
+	err = pub.Publish(pubTopic, badBytes)
 	expect(err).To.Be.Nil()
-	_, err = pub.SendMessage(subTopic, finalBytes)
+	err = pub.Publish(subTopic, finalBytes)
 	expect(err).To.Be.Nil()
 
 	select {
@@ -87,12 +87,45 @@ func TestBotDoesNotDispatchMessagesIfTopicDoesNotMatch(t *testing.T) {
 	}
 }
 
-func setupPubSocket(expect func(v interface{}) *expect.Expect) (*zmq4.Socket, []string) {
-	endpoint := "inproc://test-pub-" + randString()
-	pub, err := zmq4.NewSocket(zmq4.PUB)
+func TestBotStartsAndStopsFeatures(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	pub, endpoints := setupPublisher(expect)
+	defer func() {
+		err := pub.Close()
+		if err != nil {
+			log.Printf("got err while closing publisher: %s", err)
+		}
+	}()
+
+	b, err := bot.New([]string{"test-topic"}, bot.WithSubEndpoints(endpoints))
+	expect(err).To.Be.Nil()
+	b.SetFeature("test-feature", f)
+	go b.Start()
+
+	select {
+	case <-f.StartCalled:
+	case <-time.After(3 * time.Second):
+		fmt.Println("timed out waiting for bot to start feature")
+		t.Fail()
+	}
+
+	added := newMockFeature()
+	b.SetFeature("added-feature", added)
+	expect(len(added.StartCalled)).To.Equal(1)
+	expect(b.RemoveFeature("added-feature")).To.Equal(added)
+	expect(len(added.StopCalled)).To.Equal(1)
+
+	b.Stop()()
+	expect(len(f.StopCalled)).To.Equal(1)
+}
+
+func setupPublisher(expect func(v interface{}) *expect.Expect) (bus.Publisher, []string) {
+	endpoints := []string{"inproc://test-pub-" + randString()}
+	pub, err := bus.Default.Publisher(endpoints)
 	expect(err).To.Be.Nil()
-	expect(pub.Bind(endpoint)).To.Be.Nil()
-	return pub, []string{endpoint}
+	return pub, endpoints
 }
 
 func randString() string {
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// CustomCommandsStore gets a user's custom commands and records their use.
+type CustomCommandsStore interface {
+	CustomCommands(userID string) (cmds []store.CustomCommand, err error)
+	IncrementCustomCommandCount(userID, trigger string) (count int, err error)
+}
+
+// CustomCommandsFeature replies to the custom commands defined by the
+// streamer. Responses may contain the placeholders {user}, {channel}, {args}
+// and {count}.
+type CustomCommandsFeature struct {
+	userID         string
+	twitchUsername string
+	channel        string
+	store          CustomCommandsStore
+	sender         Sender
+}
+
+// NewCustomCommandsFeature returns a new custom commands feature for the
+// user. Only commands sent to the Twitch channel are replied to and replies
+// are sent as twitchUsername.
+func NewCustomCommandsFeature(
+	userID string,
+	twitchUsername string,
+	channel string,
+	store CustomCommandsStore,
+	sender Sender,
+) *CustomCommandsFeature {
+	return &CustomCommandsFeature{
+		userID:         userID,
+		twitchUsername: twitchUsername,
+		channel:        channel,
+		store:          store,
+		sender:         sender,
+	}
+}
+
+// HandleMessage replies in the channel the message was received from if the
+// message invokes a custom command.
+func (c *CustomCommandsFeature) HandleMessage(in stream.RXMessage) {
+	out := stream.TXMessage{
+		Type: in.Type,
+	}
+	switch in.Type {
+	case stream.Twitch:
+		if in.Twitch.Line.Cmd != "PRIVMSG" {
+			return
+		}
+		if len(in.Twitch.Line.Args) < 2 {
+			return
+		}
+		if in.Twitch.Line.Nick == c.twitchUsername {
+			return
+		}
+		channel := in.Twitch.Line.Args[0]
+		if channel != c.channel {
+			return
+		}
+		msg := c.respond(
+			in.Twitch.Line.Args[1],
+			in.Twitch.Line.Nick,
+			strings.TrimPrefix(channel, "#"),
+		)
+		if msg == "" {
+			return
+		}
+		out.Twitch = &stream.TXTwitch{
+			Username: c.twitchUsername,
+			To:       channel,
+			Message:  msg,
+		}
+	case stream.Discord:
+		mc := in.Discord.MessageCreate
+		if mc == nil || mc.Message == nil || mc.Author == nil || mc.Author.Bot {
+			return
+		}
+		msg := c.respond(mc.Content, mc.Author.Username, mc.ChannelID)
+		if msg == "" {
+			return
+		}
+		out.Discord = &stream.TXDiscord{
+			UserID:  c.userID,
+			Type:    stream.Channel,
+			To:      mc.ChannelID,
+			Message: msg,
+		}
+	default:
+		return
+	}
+	c.sender.Send(out)
+}
+
+// respond returns the reply to the message or an empty string if the
+// message does not invoke a custom command.
+func (c *CustomCommandsFeature) respond(body, user, channel string) string {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return ""
+	}
+	trigger := fields[0]
+
+	cmds, err := c.store.CustomCommands(c.userID)
+	if err != nil {
+		log.Printf("CustomCommandsFeature: unable to get custom commands for user: %s: %s", c.userID, err)
+		return ""
+	}
+	var cmd *store.CustomCommand
+	for i := range cmds {
+		if cmds[i].Trigger == trigger {
+			cmd = &cmds[i]
+			break
+		}
+	}
+	if cmd == nil {
+		return ""
+	}
+
+	count, err := c.store.IncrementCustomCommandCount(c.userID, trigger)
+	if err != nil {
+		log.Printf("CustomCommandsFeature: unable to count custom command %s for user: %s: %s", trigger, c.userID, err)
+		return ""
+	}
+
+	// chatters must not be able to run chat commands such as /ban through
+	// responses that start with their args
+	args := strings.TrimLeft(strings.TrimPrefix(strings.TrimSpace(body), trigger), " /.")
+	return strings.NewReplacer(
+		"{user}", user,
+		"{channel}", channel,
+		"{args}", args,
+		"{count}", strconv.Itoa(count),
+	).Replace(cmd.Response)
+}
+
+// Start is a NOOP.
+func (c *CustomCommandsFeature) Start() {}
+
+// Stop is a NOOP.
+func (c *CustomCommandsFeature) Stop() {}
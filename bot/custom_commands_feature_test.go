@@ -0,0 +1,165 @@
+package bot_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/bwmarrin/discordgo"
+	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestCustomCommandsRespondInTwitchChannel(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyCustomCommandsStore{
+		cmds: []store.CustomCommand{
+			{
+				Trigger:  "!hug",
+				Response: "{user} hugs {args} in {channel} ({count})",
+				Count:    41,
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewCustomCommandsFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "!hug  everyone "))
+
+	expect(spyStore.incrementCalledWith).To.Equal("!hug")
+	expect(spySender.sendCalls()).To.Equal([]stream.TXMessage{
+		{
+			Type: stream.Twitch,
+			Twitch: &stream.TXTwitch{
+				Username: "test-bot",
+				To:       "#test-streamer",
+				Message:  "test-chatter hugs everyone in test-streamer (42)",
+			},
+		},
+	})
+}
+
+func TestCustomCommandsDoNotLetArgsRunChatCommands(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyCustomCommandsStore{
+		cmds: []store.CustomCommand{
+			{
+				Trigger:  "!say",
+				Response: "{args}",
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewCustomCommandsFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "!say ./ban test-streamer"))
+
+	calls := spySender.sendCalls()
+	expect(len(calls)).To.Equal(1).Else.FailNow()
+	expect(calls[0].Twitch.Message).To.Equal("ban test-streamer")
+}
+
+func TestCustomCommandsRespondInDiscordChannel(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyCustomCommandsStore{
+		cmds: []store.CustomCommand{
+			{
+				Trigger:  "!schedule",
+				Response: "{user}: we stream every day",
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewCustomCommandsFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	f.HandleMessage(stream.RXMessage{
+		Type: stream.Discord,
+		Discord: &stream.RXDiscord{
+			MessageCreate: &discordgo.MessageCreate{
+				Message: &discordgo.Message{
+					ChannelID: "test-channel-id",
+					Content:   "!schedule",
+					Author: &discordgo.User{
+						Username: "test-chatter",
+					},
+				},
+			},
+		},
+	})
+
+	expect(spySender.sendCalls()).To.Equal([]stream.TXMessage{
+		{
+			Type: stream.Discord,
+			Discord: &stream.TXDiscord{
+				UserID:  "test-user-id",
+				Type:    stream.Channel,
+				To:      "test-channel-id",
+				Message: "test-chatter: we stream every day",
+			},
+		},
+	})
+}
+
+func TestCustomCommandsIgnoreOtherMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyCustomCommandsStore{
+		cmds: []store.CustomCommand{
+			{
+				Trigger:  "!hug",
+				Response: "hugs",
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewCustomCommandsFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "hello !hug"))
+	f.HandleMessage(twitchMessage("test-chatter", "!hugs"))
+	f.HandleMessage(twitchMessage("test-bot", "!hug"))
+
+	expect(spyStore.incrementCalledWith).To.Equal("")
+	expect(len(spySender.sendCalls())).To.Equal(0)
+}
+
+func TestCustomCommandsIgnoreOtherChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyCustomCommandsStore{
+		cmds: []store.CustomCommand{
+			{
+				Trigger:  "!hug",
+				Response: "hugs",
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewCustomCommandsFeature("test-user-id", "test-bot", "#test-friend", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "!hug"))
+
+	expect(spyStore.incrementCalledWith).To.Equal("")
+	expect(len(spySender.sendCalls())).To.Equal(0)
+}
+
+func TestCustomCommandsDoNotRespondWhenStoreFails(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyCustomCommandsStore{
+		err: errors.New("test-error"),
+	}
+	spySender := &SpySender{}
+	f := bot.NewCustomCommandsFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "!hug"))
+
+	expect(len(spySender.sendCalls())).To.Equal(0)
+}
+
+func twitchMessage(nick, body string) stream.RXMessage {
+	return taggedTwitchMessage(nick, body, nil)
+}
@@ -10,16 +10,18 @@ import (
 // EchoFeature echos messages back to the user.
 type EchoFeature struct {
 	cmd            string
+	userID         string
 	twitchUsername string
-	sman           *stream.Manager
+	sender         Sender
 }
 
 // NewEchoFeature returns a new echo feature.
-func NewEchoFeature(cmd, twitchUsername string, sman *stream.Manager) *EchoFeature {
+func NewEchoFeature(cmd, userID, twitchUsername string, sender Sender) *EchoFeature {
 	return &EchoFeature{
 		cmd:            cmd,
+		userID:         userID,
 		twitchUsername: twitchUsername,
-		sman:           sman,
+		sender:         sender,
 	}
 }
 
@@ -46,17 +48,22 @@ func (e *EchoFeature) HandleMessage(in stream.RXMessage) {
 			Message:  fmt.Sprintf("/w %s %s", in.Twitch.Line.Nick, msg),
 		}
 	case stream.Discord:
-		msg := e.matchMessage(in.Discord.MessageCreate.Content)
+		mc := in.Discord.MessageCreate
+		if mc == nil || mc.Message == nil || mc.Author == nil {
+			return
+		}
+		msg := e.matchMessage(mc.Content)
 		if msg == "" {
 			return
 		}
 		out.Discord = &stream.TXDiscord{
+			UserID:  e.userID,
 			Type:    stream.Private,
-			To:      in.Discord.MessageCreate.Author.ID,
+			To:      mc.Author.ID,
 			Message: msg,
 		}
 	}
-	e.sman.Send(out)
+	e.sender.Send(out)
 }
 
 func (e *EchoFeature) matchMessage(msg string) string {
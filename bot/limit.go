@@ -0,0 +1,196 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// maxCooldowns is how many cooldowns are tracked before expired cooldowns are
+// removed.
+const maxCooldowns = 1024
+
+// Matcher returns the command that a message invokes. Messages that do not
+// invoke a command return false.
+type Matcher func(ms stream.RXMessage) (cmd string, ok bool)
+
+// CommandMatcher matches messages where the first word is one of the
+// triggers.
+func CommandMatcher(triggers ...string) Matcher {
+	return func(ms stream.RXMessage) (string, bool) {
+		cmd, ok := AnyCommand(ms)
+		if !ok {
+			return "", false
+		}
+		for _, trigger := range triggers {
+			if cmd == trigger {
+				return cmd, true
+			}
+		}
+		return "", false
+	}
+}
+
+// AnyCommand matches every chat message that starts with "!", treating the
+// first word as the command. This is useful for features that have commands
+// that are not known ahead of time.
+func AnyCommand(ms stream.RXMessage) (string, bool) {
+	_, body, ok := chatMessage(ms)
+	if !ok {
+		return "", false
+	}
+	fields := strings.Fields(body)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return "", false
+	}
+	return fields[0], true
+}
+
+// chatMessage returns the ID of the chatter and the body of a chat message.
+// It returns false if the message is not a chat message.
+func chatMessage(ms stream.RXMessage) (chatter, body string, ok bool) {
+	switch ms.Type {
+	case stream.Twitch:
+		if ms.Twitch == nil || ms.Twitch.Line == nil {
+			return "", "", false
+		}
+		if ms.Twitch.Line.Cmd != "PRIVMSG" || len(ms.Twitch.Line.Args) < 2 {
+			return "", "", false
+		}
+		return ms.Twitch.Line.Nick, ms.Twitch.Line.Args[1], true
+	case stream.Discord:
+		if ms.Discord == nil || ms.Discord.MessageCreate == nil {
+			return "", "", false
+		}
+		mc := ms.Discord.MessageCreate
+		if mc.Message == nil || mc.Author == nil {
+			return "", "", false
+		}
+		return mc.Author.ID, mc.Content, true
+	default:
+		return "", "", false
+	}
+}
+
+// LimitedFeature wraps a feature so that commands are only passed to it when
+// the chatter has the required permission and the command is not cooling
+// down. Messages that do not invoke a command are always passed through.
+type LimitedFeature struct {
+	feature        Feature
+	match          Matcher
+	permission     Permission
+	globalCooldown time.Duration
+	userCooldown   time.Duration
+
+	mu     sync.Mutex
+	global map[string]time.Time
+	users  map[string]time.Time
+}
+
+// LimitOption is used to configure a LimitedFeature.
+type LimitOption func(*LimitedFeature)
+
+// WithPermission sets the permission level a chatter needs to invoke the
+// commands. The default is Everyone.
+func WithPermission(p Permission) LimitOption {
+	return func(l *LimitedFeature) {
+		l.permission = p
+	}
+}
+
+// WithGlobalCooldown sets how long after a command is invoked before it can
+// be invoked again by anyone.
+func WithGlobalCooldown(d time.Duration) LimitOption {
+	return func(l *LimitedFeature) {
+		l.globalCooldown = d
+	}
+}
+
+// WithUserCooldown sets how long after a command is invoked before it can be
+// invoked again by the same chatter.
+func WithUserCooldown(d time.Duration) LimitOption {
+	return func(l *LimitedFeature) {
+		l.userCooldown = d
+	}
+}
+
+// Limit wraps the feature with permission and cooldown checks for the
+// commands found by the matcher. Cooldowns are tracked separately for each
+// command.
+func Limit(f Feature, match Matcher, opts ...LimitOption) *LimitedFeature {
+	l := &LimitedFeature{
+		feature:    f,
+		match:      match,
+		permission: Everyone,
+		global:     make(map[string]time.Time),
+		users:      make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// HandleMessage passes the message to the wrapped feature if it is allowed.
+func (l *LimitedFeature) HandleMessage(ms stream.RXMessage) {
+	cmd, ok := l.match(ms)
+	if !ok {
+		l.feature.HandleMessage(ms)
+		return
+	}
+	if ChatterPermission(ms) < l.permission {
+		return
+	}
+	chatter, _, _ := chatMessage(ms)
+	if !l.allow(cmd, chatter, time.Now()) {
+		return
+	}
+	l.feature.HandleMessage(ms)
+}
+
+// allow returns true if the command is not cooling down for the chatter. If
+// it is allowed the cooldowns for the command are started.
+func (l *LimitedFeature) allow(cmd, chatter string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	userKey := cmd + " " + chatter
+	if now.Before(l.global[cmd]) || now.Before(l.users[userKey]) {
+		return false
+	}
+
+	if l.globalCooldown > 0 {
+		l.global[cmd] = now.Add(l.globalCooldown)
+		expireCooldowns(l.global, now)
+	}
+	if l.userCooldown > 0 {
+		l.users[userKey] = now.Add(l.userCooldown)
+		expireCooldowns(l.users, now)
+	}
+	return true
+}
+
+// expireCooldowns removes the cooldowns that have finished once too many
+// are being tracked.
+func expireCooldowns(cooldowns map[string]time.Time, now time.Time) {
+	if len(cooldowns) <= maxCooldowns {
+		return
+	}
+	for k, until := range cooldowns {
+		if !now.Before(until) {
+			delete(cooldowns, k)
+		}
+	}
+}
+
+// Start starts the wrapped feature.
+func (l *LimitedFeature) Start() {
+	l.feature.Start()
+}
+
+// Stop stops the wrapped feature.
+func (l *LimitedFeature) Stop() {
+	l.feature.Stop()
+}
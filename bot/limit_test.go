@@ -0,0 +1,127 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/fluffle/goirc/client"
+	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestLimitPassesThroughMessagesThatAreNotCommands(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	l := bot.Limit(
+		f,
+		bot.CommandMatcher("!test"),
+		bot.WithPermission(bot.Broadcaster),
+		bot.WithGlobalCooldown(time.Hour),
+	)
+
+	l.HandleMessage(twitchMessage("test-chatter", "hello"))
+	l.HandleMessage(twitchMessage("test-chatter", "hello"))
+
+	expect(len(f.HandleMessageCalled)).To.Equal(2)
+}
+
+func TestAnyCommandOnlyMatchesCommands(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	l := bot.Limit(f, bot.AnyCommand, bot.WithGlobalCooldown(time.Hour))
+
+	l.HandleMessage(twitchMessage("test-chatter", "hello"))
+	l.HandleMessage(twitchMessage("test-chatter", "hello"))
+	l.HandleMessage(twitchMessage("test-chatter", "!hello"))
+	l.HandleMessage(twitchMessage("test-chatter", "!hello"))
+
+	expect(len(f.HandleMessageCalled)).To.Equal(3)
+}
+
+func TestLimitRequiresPermission(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	l := bot.Limit(f, bot.CommandMatcher("!test"), bot.WithPermission(bot.Moderator))
+
+	l.HandleMessage(taggedTwitchMessage("test-chatter", "!test", map[string]string{
+		"badges": "subscriber/1",
+	}))
+	expect(len(f.HandleMessageCalled)).To.Equal(0)
+
+	l.HandleMessage(taggedTwitchMessage("test-chatter", "!test", map[string]string{
+		"badges": "moderator/1",
+	}))
+	expect(len(f.HandleMessageCalled)).To.Equal(1)
+
+	l.HandleMessage(taggedTwitchMessage("test-chatter", "!test", map[string]string{
+		"badges": "broadcaster/1",
+	}))
+	expect(len(f.HandleMessageCalled)).To.Equal(2)
+}
+
+func TestLimitEnforcesGlobalCooldowns(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	l := bot.Limit(f, bot.AnyCommand, bot.WithGlobalCooldown(100*time.Millisecond))
+
+	l.HandleMessage(twitchMessage("test-chatter-a", "!a"))
+	l.HandleMessage(twitchMessage("test-chatter-b", "!a"))
+	expect(len(f.HandleMessageCalled)).To.Equal(1)
+
+	l.HandleMessage(twitchMessage("test-chatter-b", "!b"))
+	expect(len(f.HandleMessageCalled)).To.Equal(2)
+
+	time.Sleep(150 * time.Millisecond)
+	l.HandleMessage(twitchMessage("test-chatter-b", "!a"))
+	expect(len(f.HandleMessageCalled)).To.Equal(3)
+}
+
+func TestLimitEnforcesUserCooldowns(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	l := bot.Limit(f, bot.AnyCommand, bot.WithUserCooldown(100*time.Millisecond))
+
+	l.HandleMessage(twitchMessage("test-chatter-a", "!a"))
+	l.HandleMessage(twitchMessage("test-chatter-a", "!a with args"))
+	expect(len(f.HandleMessageCalled)).To.Equal(1)
+
+	l.HandleMessage(twitchMessage("test-chatter-b", "!a"))
+	expect(len(f.HandleMessageCalled)).To.Equal(2)
+
+	time.Sleep(150 * time.Millisecond)
+	l.HandleMessage(twitchMessage("test-chatter-a", "!a"))
+	expect(len(f.HandleMessageCalled)).To.Equal(3)
+}
+
+func TestLimitStartsAndStopsTheWrappedFeature(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	l := bot.Limit(f, bot.AnyCommand)
+
+	l.Start()
+	l.Stop()
+
+	expect(len(f.StartCalled)).To.Equal(1)
+	expect(len(f.StopCalled)).To.Equal(1)
+}
+
+func taggedTwitchMessage(nick, body string, tags map[string]string) stream.RXMessage {
+	return stream.RXMessage{
+		Type: stream.Twitch,
+		Twitch: &stream.RXTwitch{
+			Line: &client.Line{
+				Nick: nick,
+				Cmd:  "PRIVMSG",
+				Args: []string{"#test-streamer", body},
+				Tags: tags,
+			},
+		},
+	}
+}
@@ -1,6 +1,9 @@
 package bot
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Manager keeps track of active bots.
 type Manager struct {
@@ -45,3 +48,57 @@ func (m *Manager) Absent(userID string, f func()) {
 		f()
 	}
 }
+
+// StartBot creates a bot for the user with the provided func and starts it.
+// Nothing is done if a bot is already running for the user.
+func (m *Manager) StartBot(userID string, create func() (*Bot, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.m[userID]
+	if ok {
+		return nil
+	}
+	b, err := create()
+	if err != nil {
+		return err
+	}
+	go b.Start()
+	m.m[userID] = b
+	return nil
+}
+
+// StopBot stops the bot for a given user ID and removes it. It blocks until
+// the bot has stopped reading messages.
+func (m *Manager) StopBot(userID string) {
+	m.mu.Lock()
+	b, ok := m.m[userID]
+	delete(m.m, userID)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	b.Stop()()
+}
+
+// Shutdown stops and removes all bots. It blocks until they have stopped or
+// the context is done, in which case the context's error is returned.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	bots := make([]*Bot, 0, len(m.m))
+	for userID, b := range m.m {
+		bots = append(bots, b)
+		delete(m.m, userID)
+	}
+	m.mu.Unlock()
+
+	for _, b := range bots {
+		b.Stop()
+	}
+	for _, b := range bots {
+		err := b.Shutdown(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
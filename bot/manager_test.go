@@ -0,0 +1,127 @@
+package bot_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/bot"
+)
+
+func TestManagerStartsAndStopsBots(t *testing.T) {
+	expect := expect.New(t)
+
+	f := newMockFeature()
+	pub, endpoints := setupPubSocket(expect)
+	defer func() {
+		err := pub.Close()
+		if err != nil {
+			log.Printf("got err while closing pub socket: %s", err)
+		}
+	}()
+	expected, toSend := testMessage(expect, "test-message")
+
+	m := bot.NewManager()
+	err := m.StartBot("test-user-id", func() (*bot.Bot, error) {
+		b, err := bot.New([]string{"test-topic"}, bot.WithSubEndpoints(endpoints))
+		if err != nil {
+			return nil, err
+		}
+		b.SetFeature("test-feature", f)
+		return b, nil
+	})
+	expect(err).To.Be.Nil()
+	expect(m.GetBot("test-user-id") != nil).To.Be.True()
+
+	_, err = pub.SendMessage("test-topic", toSend)
+	expect(err).To.Be.Nil()
+
+	select {
+	case actual := <-f.HandleMessageInput.Ms:
+		expect(expected.Twitch.Line.Raw).To.Equal(actual.Twitch.Line.Raw)
+	case <-time.After(3 * time.Second):
+		fmt.Println("timed out waiting for bot to dispatch message")
+		t.Fail()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		m.StopBot("test-user-id")
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		fmt.Println("timed out waiting for bot to stop")
+		t.Fail()
+	}
+	expect(m.GetBot("test-user-id") == nil).To.Be.True()
+}
+
+func TestManagerDoesNotStartBotsTwice(t *testing.T) {
+	expect := expect.New(t)
+
+	m := bot.NewManager()
+	m.SetBot("test-user-id", &bot.Bot{})
+
+	var called bool
+	err := m.StartBot("test-user-id", func() (*bot.Bot, error) {
+		called = true
+		return nil, errors.New("test-error")
+	})
+	expect(err).To.Be.Nil()
+	expect(called).To.Be.False()
+}
+
+func TestManagerReturnsErrorsCreatingBots(t *testing.T) {
+	expect := expect.New(t)
+
+	m := bot.NewManager()
+	err := m.StartBot("test-user-id", func() (*bot.Bot, error) {
+		return nil, errors.New("test-error")
+	})
+	expect(err).Not.To.Be.Nil()
+	expect(m.GetBot("test-user-id") == nil).To.Be.True()
+}
+
+func TestManagerShutdownStopsAllBots(t *testing.T) {
+	expect := expect.New(t)
+
+	pub, endpoints := setupPubSocket(expect)
+	defer func() {
+		err := pub.Close()
+		if err != nil {
+			log.Printf("got err while closing pub socket: %s", err)
+		}
+	}()
+
+	m := bot.NewManager()
+	var features []*mockFeature
+	for _, userID := range []string{"test-user-id-1", "test-user-id-2"} {
+		f := newMockFeature()
+		features = append(features, f)
+		err := m.StartBot(userID, func() (*bot.Bot, error) {
+			b, err := bot.New([]string{"test-topic"}, bot.WithSubEndpoints(endpoints))
+			if err != nil {
+				return nil, err
+			}
+			b.SetFeature("test-feature", f)
+			return b, nil
+		})
+		expect(err).To.Be.Nil().Else.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	expect(m.Shutdown(ctx)).To.Be.Nil()
+
+	for _, f := range features {
+		expect(len(f.StopCalled)).To.Equal(1)
+	}
+	expect(m.GetBot("test-user-id-1") == nil).To.Be.True()
+	expect(m.GetBot("test-user-id-2") == nil).To.Be.True()
+}
@@ -0,0 +1,322 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+const (
+	// strikeWindow is how long a chatter's violations count towards them
+	// being timed out or banned.
+	strikeWindow = time.Hour
+	// maxStrikes is how many chatters have their strikes tracked before
+	// expired strikes are removed.
+	maxStrikes = 1024
+	// defaultTimeout is used when the rules do not have a timeout duration.
+	defaultTimeout = 10 * time.Minute
+)
+
+// linkPattern matches things that look like links such as example.com or
+// https://www.example.com/path. The first group is the domain.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://)?((?:[a-z0-9-]+\.)+[a-z]{2,})\b`)
+
+// ModerationStore gets a user's moderation rules and keeps track of the
+// strikes of chatters in their channel.
+type ModerationStore interface {
+	ModerationRules(userID string) (rules store.ModerationRules, err error)
+	ModerationStrikes(userID string) (strikes []store.Strike, err error)
+	SetModerationStrike(userID string, strike store.Strike) (err error)
+	DeleteModerationStrikes(userID string, before time.Time) (err error)
+}
+
+// ModerationFeature enforces the streamer's moderation rules in their Twitch
+// channel. The first violation of a chatter has their message deleted and
+// they are warned. Further violations time them out until they reach the
+// number of violations that get them banned. Moderators and the broadcaster
+// are not moderated. Strikes are kept in the store so escalation carries on
+// when the feature is restarted.
+type ModerationFeature struct {
+	userID         string
+	twitchUsername string
+	channel        string
+	store          ModerationStore
+	sender         Sender
+
+	mu       sync.Mutex
+	strikes  map[string]store.Strike
+	patterns map[string]*regexp.Regexp
+}
+
+// NewModerationFeature returns a new moderation feature for the user. Only
+// messages sent to the channel are moderated. Moderation commands are sent
+// as twitchUsername which needs to be a moderator of the channel.
+func NewModerationFeature(
+	userID string,
+	twitchUsername string,
+	channel string,
+	store ModerationStore,
+	sender Sender,
+) *ModerationFeature {
+	return &ModerationFeature{
+		userID:         userID,
+		twitchUsername: twitchUsername,
+		channel:        channel,
+		store:          store,
+		sender:         sender,
+		strikes:        make(map[string]store.Strike),
+		patterns:       make(map[string]*regexp.Regexp),
+	}
+}
+
+// HandleMessage checks Twitch chat messages against the moderation rules and
+// acts on any violations.
+func (m *ModerationFeature) HandleMessage(in stream.RXMessage) {
+	if in.Type != stream.Twitch {
+		return
+	}
+	chatter, body, ok := chatMessage(in)
+	if !ok || chatter == m.twitchUsername || in.Twitch.Line.Args[0] != m.channel {
+		return
+	}
+	perm := ChatterPermission(in)
+	if perm >= Moderator {
+		return
+	}
+
+	rules, err := m.store.ModerationRules(m.userID)
+	if err != nil {
+		log.Printf("ModerationFeature: unable to get moderation rules for user: %s: %s", m.userID, err)
+		return
+	}
+	if !rules.Enabled {
+		return
+	}
+
+	tags := stream.ParseTwitchTags(in.Twitch.Line.Tags)
+	reason := m.violation(rules, body, tags.Emotes, perm)
+	if reason == "" {
+		return
+	}
+	m.enforce(rules, chatter, tags.ID, reason, time.Now())
+}
+
+// violation returns the reason the message violates the rules or an empty
+// string if it does not.
+func (m *ModerationFeature) violation(
+	rules store.ModerationRules,
+	body string,
+	emotes []stream.TwitchEmote,
+	perm Permission,
+) string {
+	lower := strings.ToLower(body)
+	for _, phrase := range rules.BannedPhrases {
+		if phrase != "" && strings.Contains(lower, strings.ToLower(phrase)) {
+			return "using banned phrases"
+		}
+	}
+	for _, p := range rules.BannedPatterns {
+		re := m.pattern(p)
+		if re != nil && re.MatchString(body) {
+			return "using banned phrases"
+		}
+	}
+	if rules.BlockLinks && perm < VIP && hasDisallowedLink(body, rules.AllowedDomains) {
+		return "posting links"
+	}
+	if rules.MaxEmotes > 0 && len(emotes) > rules.MaxEmotes {
+		return "spamming emotes"
+	}
+	if rules.MaxCapsPercent > 0 && excessiveCaps(body, emotes, rules) {
+		return "using excessive caps"
+	}
+	return ""
+}
+
+// pattern returns the compiled pattern. Patterns are compiled once and then
+// cached. Nil is returned if the pattern does not compile.
+func (m *ModerationFeature) pattern(p string) *regexp.Regexp {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	re, ok := m.patterns[p]
+	if ok {
+		return re
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		log.Printf("ModerationFeature: unable to compile pattern for user: %s: %s", m.userID, err)
+	}
+	m.patterns[p] = re
+	return re
+}
+
+// enforce warns, times out or bans the chatter depending on how many
+// violations they have had recently.
+func (m *ModerationFeature) enforce(
+	rules store.ModerationRules,
+	chatter string,
+	msgID string,
+	reason string,
+	now time.Time,
+) {
+	count := m.strike(chatter, now)
+	switch {
+	case rules.BanAfter > 0 && count >= rules.BanAfter:
+		m.send(fmt.Sprintf("/ban %s %s", chatter, reason))
+	case count > 1:
+		timeout := rules.TimeoutDuration
+		if timeout < time.Second {
+			timeout = defaultTimeout
+		}
+		seconds := strconv.Itoa(int(timeout / time.Second))
+		m.send(fmt.Sprintf("/timeout %s %s %s", chatter, seconds, reason))
+	default:
+		if msgID != "" {
+			m.send("/delete " + msgID)
+		}
+		m.send(fmt.Sprintf("@%s please stop %s, this is your only warning", chatter, reason))
+	}
+}
+
+// strike records a violation for the chatter and returns how many they have
+// had within the strike window.
+func (m *ModerationFeature) strike(chatter string, now time.Time) int {
+	m.mu.Lock()
+	s := m.strikes[chatter]
+	if now.Sub(s.Last) > strikeWindow {
+		s.Count = 0
+	}
+	s.Chatter = chatter
+	s.Count++
+	s.Last = now
+	m.strikes[chatter] = s
+
+	prune := len(m.strikes) > maxStrikes
+	if prune {
+		for c, cs := range m.strikes {
+			if now.Sub(cs.Last) > strikeWindow {
+				delete(m.strikes, c)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	err := m.store.SetModerationStrike(m.userID, s)
+	if err != nil {
+		log.Printf("ModerationFeature: unable to set strike for user: %s: %s", m.userID, err)
+	}
+	if prune {
+		m.deleteExpiredStrikes(now)
+	}
+	return s.Count
+}
+
+// deleteExpiredStrikes removes strikes from the store that are outside of the
+// strike window.
+func (m *ModerationFeature) deleteExpiredStrikes(now time.Time) {
+	err := m.store.DeleteModerationStrikes(m.userID, now.Add(-strikeWindow))
+	if err != nil {
+		log.Printf("ModerationFeature: unable to delete strikes for user: %s: %s", m.userID, err)
+	}
+}
+
+// send sends a message to the Twitch channel as the bot.
+func (m *ModerationFeature) send(msg string) {
+	m.sender.Send(stream.TXMessage{
+		Type: stream.Twitch,
+		Twitch: &stream.TXTwitch{
+			Username: m.twitchUsername,
+			To:       m.channel,
+			Message:  msg,
+		},
+	})
+}
+
+// Start loads the strikes that are still within the strike window from the
+// store and removes the rest.
+func (m *ModerationFeature) Start() {
+	strikes, err := m.store.ModerationStrikes(m.userID)
+	if err != nil {
+		log.Printf("ModerationFeature: unable to get strikes for user: %s: %s", m.userID, err)
+		return
+	}
+	now := time.Now()
+	expired := false
+	m.mu.Lock()
+	for _, s := range strikes {
+		if now.Sub(s.Last) > strikeWindow {
+			expired = true
+			continue
+		}
+		m.strikes[s.Chatter] = s
+	}
+	m.mu.Unlock()
+	if expired {
+		m.deleteExpiredStrikes(now)
+	}
+}
+
+// Stop is a NOOP.
+func (m *ModerationFeature) Stop() {}
+
+// hasDisallowedLink returns true if the body contains a link to a domain that
+// is not allowed.
+func hasDisallowedLink(body string, allowed []string) bool {
+	for _, match := range linkPattern.FindAllStringSubmatch(body, -1) {
+		if !domainAllowed(strings.ToLower(match[1]), allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainAllowed returns true if the domain or one of its parents is allowed.
+func domainAllowed(domain string, allowed []string) bool {
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimPrefix(a, "."))
+		if a == "" {
+			continue
+		}
+		if domain == a || strings.HasSuffix(domain, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// excessiveCaps returns true if too many of the letters in the body are
+// capitalized. Letters that are part of emotes are not counted.
+func excessiveCaps(body string, emotes []stream.TwitchEmote, rules store.ModerationRules) bool {
+	var letters, upper int
+	for i, r := range []rune(body) {
+		if inEmote(i, emotes) || !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	if letters == 0 || letters < rules.MinCapsLength {
+		return false
+	}
+	return upper*100 > rules.MaxCapsPercent*letters
+}
+
+// inEmote returns true if the character index is part of an emote.
+func inEmote(i int, emotes []stream.TwitchEmote) bool {
+	for _, e := range emotes {
+		if i >= e.Start && i <= e.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestModerationEscalatesFromWarningToTimeoutToBan(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyModerationStore{
+		rules: store.ModerationRules{
+			Enabled:         true,
+			BannedPhrases:   []string{"Bad Phrase"},
+			TimeoutDuration: 5 * time.Minute,
+			BanAfter:        3,
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewModerationFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	for i := 0; i < 3; i++ {
+		f.HandleMessage(taggedTwitchMessage("test-chatter", "a bad phrase", map[string]string{
+			"id": "test-msg-id",
+		}))
+	}
+
+	expect(moderationMessages(spySender.sendCalls())).To.Equal([]string{
+		"/delete test-msg-id",
+		"@test-chatter please stop using banned phrases, this is your only warning",
+		"/timeout test-chatter 300 using banned phrases",
+		"/ban test-chatter using banned phrases",
+	})
+	for _, ms := range spySender.sendCalls() {
+		expect(ms.Twitch.Username).To.Equal("test-bot")
+		expect(ms.Twitch.To).To.Equal("#test-streamer")
+	}
+}
+
+func TestModerationViolations(t *testing.T) {
+	rules := store.ModerationRules{
+		Enabled:        true,
+		BannedPatterns: []string{`(?i)b[a4]d\s*w[o0]rd`},
+		BlockLinks:     true,
+		AllowedDomains: []string{"twitch.tv"},
+		MaxCapsPercent: 50,
+		MinCapsLength:  5,
+		MaxEmotes:      2,
+	}
+	cases := map[string]struct {
+		body   string
+		tags   map[string]string
+		reason string
+	}{
+		"pattern": {
+			body:   "what a B4D WoRd",
+			reason: "using banned phrases",
+		},
+		"link": {
+			body:   "go to https://example.com/free",
+			reason: "posting links",
+		},
+		"bare link": {
+			body:   "go to example.com",
+			reason: "posting links",
+		},
+		"allowed link": {
+			body: "watch clips.twitch.tv/test",
+		},
+		"link from vip": {
+			body: "go to example.com",
+			tags: map[string]string{"badges": "vip/1"},
+		},
+		"caps": {
+			body:   "STOP THE STREAM",
+			reason: "using excessive caps",
+		},
+		"short caps": {
+			body: "GG",
+		},
+		"caps in emotes": {
+			body: "LUL PogChamp ok",
+			tags: map[string]string{"emotes": "425618:0-2/88:4-11"},
+		},
+		"emotes": {
+			body:   "Kappa Kappa Kappa",
+			tags:   map[string]string{"emotes": "25:0-4,6-10,12-16"},
+			reason: "spamming emotes",
+		},
+		"moderator": {
+			body: "STOP THE STREAM",
+			tags: map[string]string{"mod": "1"},
+		},
+		"clean": {
+			body: "hello there, nice stream",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			expect := expect.New(t)
+
+			spySender := &SpySender{}
+			f := bot.NewModerationFeature(
+				"test-user-id",
+				"test-bot",
+				"#test-streamer",
+				&SpyModerationStore{rules: rules},
+				spySender,
+			)
+
+			f.HandleMessage(taggedTwitchMessage("test-chatter", tc.body, tc.tags))
+
+			if tc.reason == "" {
+				expect(len(spySender.sendCalls())).To.Equal(0)
+				return
+			}
+			expect(moderationMessages(spySender.sendCalls())).To.Equal([]string{
+				"@test-chatter please stop " + tc.reason + ", this is your only warning",
+			})
+		})
+	}
+}
+
+func TestModerationStrikesSurviveRestarts(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyModerationStore{
+		rules: store.ModerationRules{
+			Enabled:       true,
+			BannedPhrases: []string{"bad"},
+			BanAfter:      2,
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewModerationFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+	f.Start()
+	f.HandleMessage(twitchMessage("test-chatter", "bad"))
+	f.Stop()
+
+	expect(spyStore.strikes["test-chatter"].Count).To.Equal(1)
+
+	spySender = &SpySender{}
+	f = bot.NewModerationFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+	f.Start()
+	f.HandleMessage(twitchMessage("test-chatter", "bad"))
+
+	expect(moderationMessages(spySender.sendCalls())).To.Equal([]string{
+		"/ban test-chatter using banned phrases",
+	})
+	expect(spyStore.strikes["test-chatter"].Count).To.Equal(2)
+}
+
+func TestModerationRemovesExpiredStrikesOnStart(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyModerationStore{
+		rules: store.ModerationRules{
+			Enabled:       true,
+			BannedPhrases: []string{"bad"},
+			BanAfter:      2,
+		},
+		strikes: map[string]store.Strike{
+			"test-chatter": {
+				Chatter: "test-chatter",
+				Count:   1,
+				Last:    time.Now().Add(-2 * time.Hour),
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewModerationFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+	f.Start()
+
+	expect(spyStore.deleteCalledWithBefore.IsZero()).To.Be.False()
+	expect(len(spyStore.strikes)).To.Equal(0)
+
+	f.HandleMessage(twitchMessage("test-chatter", "bad"))
+
+	expect(moderationMessages(spySender.sendCalls())).To.Equal([]string{
+		"@test-chatter please stop using banned phrases, this is your only warning",
+	})
+}
+
+func TestModerationIgnoresOtherChannels(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyModerationStore{
+		rules: store.ModerationRules{
+			Enabled:       true,
+			BannedPhrases: []string{"bad"},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewModerationFeature("test-user-id", "test-bot", "#test-friend", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "bad"))
+
+	expect(len(spySender.sendCalls())).To.Equal(0)
+	expect(len(spyStore.strikes)).To.Equal(0)
+}
+
+func TestModerationDoesNothingWhenDisabled(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyModerationStore{
+		rules: store.ModerationRules{
+			BannedPhrases: []string{"bad"},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewModerationFeature("test-user-id", "test-bot", "#test-streamer", spyStore, spySender)
+
+	f.HandleMessage(twitchMessage("test-chatter", "bad"))
+
+	expect(len(spySender.sendCalls())).To.Equal(0)
+}
+
+func moderationMessages(sent []stream.TXMessage) []string {
+	var msgs []string
+	for _, ms := range sent {
+		msgs = append(msgs, ms.Twitch.Message)
+	}
+	return msgs
+}
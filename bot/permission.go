@@ -0,0 +1,46 @@
+package bot
+
+import "github.com/jasonkeene/anubot-server/stream"
+
+// Permission is the level of privilege a chatter has in a channel. Higher
+// levels include all of the privileges of the levels below them.
+type Permission int
+
+const (
+	// Everyone is any chatter.
+	Everyone Permission = iota
+	// Subscriber is a chatter that is subscribed to the channel.
+	Subscriber
+	// VIP is a chatter that has been made a VIP of the channel.
+	VIP
+	// Moderator is a chatter that moderates the channel.
+	Moderator
+	// Broadcaster is the owner of the channel.
+	Broadcaster
+)
+
+// ChatterPermission returns the permission level of the chatter that sent
+// the message. For Twitch this is derived from the IRCv3 badges, mod and
+// subscriber tags. Discord messages are always from Everyone.
+func ChatterPermission(ms stream.RXMessage) Permission {
+	if ms.Type != stream.Twitch || ms.Twitch == nil || ms.Twitch.Line == nil {
+		return Everyone
+	}
+	line := ms.Twitch.Line
+	tags := stream.ParseTwitchTags(line.Tags)
+
+	switch {
+	case tags.HasBadge("broadcaster"):
+		return Broadcaster
+	case len(line.Args) > 0 && line.Nick != "" && line.Args[0] == "#"+line.Nick:
+		return Broadcaster
+	case tags.HasBadge("moderator") || tags.Mod:
+		return Moderator
+	case tags.HasBadge("vip"):
+		return VIP
+	case tags.HasBadge("subscriber") || tags.HasBadge("founder") || tags.Subscriber:
+		return Subscriber
+	default:
+		return Everyone
+	}
+}
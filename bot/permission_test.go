@@ -0,0 +1,44 @@
+package bot_test
+
+import (
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestChatterPermissionIsDerivedFromTags(t *testing.T) {
+	expect := expect.New(t)
+
+	cases := []struct {
+		nick     string
+		tags     map[string]string
+		expected bot.Permission
+	}{
+		{"test-chatter", nil, bot.Everyone},
+		{"test-chatter", map[string]string{"badges": ""}, bot.Everyone},
+		{"test-chatter", map[string]string{"badges": "subscriber/12"}, bot.Subscriber},
+		{"test-chatter", map[string]string{"subscriber": "1"}, bot.Subscriber},
+		{"test-chatter", map[string]string{"badges": "founder/0"}, bot.Subscriber},
+		{"test-chatter", map[string]string{"badges": "vip/1,subscriber/3"}, bot.VIP},
+		{"test-chatter", map[string]string{"badges": "moderator/1,subscriber/3"}, bot.Moderator},
+		{"test-chatter", map[string]string{"mod": "1"}, bot.Moderator},
+		{"test-chatter", map[string]string{"badges": "broadcaster/1"}, bot.Broadcaster},
+		{"test-streamer", nil, bot.Broadcaster},
+	}
+	for _, c := range cases {
+		ms := taggedTwitchMessage(c.nick, "test-body", c.tags)
+		expect(bot.ChatterPermission(ms)).To.Equal(c.expected)
+	}
+}
+
+func TestChatterPermissionForDiscordIsEveryone(t *testing.T) {
+	expect := expect.New(t)
+
+	ms := stream.RXMessage{
+		Type:    stream.Discord,
+		Discord: &stream.RXDiscord{},
+	}
+	expect(bot.ChatterPermission(ms)).To.Equal(bot.Everyone)
+}
@@ -0,0 +1,236 @@
+package bot
+
+import (
+	"log"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+const (
+	// commandGlobalCooldown is how long a command can not be used by anyone
+	// after it is used.
+	commandGlobalCooldown = 5 * time.Second
+	// commandUserCooldown is how long a command can not be used by a chatter
+	// after they use it.
+	commandUserCooldown = 30 * time.Second
+)
+
+// CredentialsProvider provides Twitch credentials.
+type CredentialsProvider interface {
+	TwitchCredentials(userID string) (creds store.TwitchCredentials, err error)
+}
+
+// DiscordCredentialsProvider provides the Discord guild a user has linked.
+type DiscordCredentialsProvider interface {
+	DiscordCredentials(userID string) (creds store.DiscordCredentials, err error)
+}
+
+// RunnerStore provides the data needed to run a user's bot.
+type RunnerStore interface {
+	CredentialsProvider
+	DiscordCredentialsProvider
+	CustomCommandsStore
+	ModerationStore
+	TimersStore
+}
+
+// StreamManager is used to connect and send to third party chat.
+type StreamManager interface {
+	Sender
+	ConnectTwitch(user, pass, channel string)
+	ConnectDiscord(userID, token string)
+	DisconnectDiscord(userID string) func()
+}
+
+// Runner runs a bot for each user that has authenticated both their streamer
+// and bot users with Twitch. Users that have linked a Discord guild also have
+// the Discord bot connected for them.
+type Runner struct {
+	manager         *Manager
+	store           RunnerStore
+	streamManager   StreamManager
+	subEndpoints    []string
+	bus             bus.Bus
+	discordBotToken string
+}
+
+// RunnerOption is used to configure a Runner.
+type RunnerOption func(*Runner)
+
+// WithRunnerSubEndpoints allows you to override the default endpoints that
+// the bots will attempt to subscribe to.
+func WithRunnerSubEndpoints(endpoints []string) RunnerOption {
+	return func(r *Runner) {
+		r.subEndpoints = endpoints
+	}
+}
+
+// WithRunnerBus allows you to override the default bus that the bots
+// subscribe with.
+func WithRunnerBus(b bus.Bus) RunnerOption {
+	return func(r *Runner) {
+		r.bus = b
+	}
+}
+
+// WithDiscordBotToken allows the runner to connect the Discord bot for users
+// that have linked a guild. Without a token Discord is never connected.
+func WithDiscordBotToken(token string) RunnerOption {
+	return func(r *Runner) {
+		r.discordBotToken = token
+	}
+}
+
+// NewRunner returns a new Runner that registers the bots it starts with the
+// manager.
+func NewRunner(
+	manager *Manager,
+	store RunnerStore,
+	streamManager StreamManager,
+	opts ...RunnerOption,
+) *Runner {
+	r := &Runner{
+		manager:       manager,
+		store:         store,
+		streamManager: streamManager,
+		subEndpoints:  []string{"inproc://dispatch-pub"},
+		bus:           bus.Default,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// StartBot connects the user's bot to their channel and starts a bot to
+// respond to the messages it receives. Nothing is done if the user has not
+// finished authenticating with Twitch or if their bot is already running. The
+// bot also responds to messages from the user's linked Discord guild.
+func (r *Runner) StartBot(userID string) {
+	creds, err := r.store.TwitchCredentials(userID)
+	if err != nil {
+		log.Printf("Runner.StartBot: unable to get creds for user: %s: %s", userID, err)
+		return
+	}
+	if !creds.StreamerAuthenticated || !creds.BotAuthenticated {
+		return
+	}
+
+	r.streamManager.ConnectTwitch(
+		creds.BotUsername,
+		"oauth:"+creds.BotPassword,
+		"#"+creds.StreamerUsername,
+	)
+	// lines in the streamer's channel are owned by the streamer while they
+	// are connected and otherwise by the bot
+	topics := []string{
+		stream.TwitchOwnerTopic(creds.BotTwitchUserID),
+		stream.TwitchChannelTopic(creds.StreamerTwitchUserID, "#"+creds.StreamerUsername),
+	}
+	if ownerID := r.discordOwnerID(userID); ownerID != "" {
+		topics = append(topics, "discord:"+ownerID)
+	}
+	err = r.manager.StartBot(userID, func() (*Bot, error) {
+		b, err := New(
+			topics,
+			WithSubEndpoints(r.subEndpoints),
+			WithBus(r.bus),
+		)
+		if err != nil {
+			return nil, err
+		}
+		b.SetFeature("moderation", NewModerationFeature(
+			userID,
+			creds.BotUsername,
+			"#"+creds.StreamerUsername,
+			r.store,
+			r.streamManager,
+		))
+		b.SetFeature("timers", NewTimersFeature(
+			userID,
+			creds.BotUsername,
+			"#"+creds.StreamerUsername,
+			r.store,
+			r.streamManager,
+		))
+		b.SetFeature("echo", Limit(
+			NewEchoFeature("!echo", userID, creds.BotUsername, r.streamManager),
+			CommandMatcher("!echo"),
+			// echo makes the bot say anything so it is kept from chatters
+			WithPermission(Moderator),
+			WithGlobalCooldown(commandGlobalCooldown),
+			WithUserCooldown(commandUserCooldown),
+		))
+		b.SetFeature("custom-commands", Limit(
+			NewCustomCommandsFeature(
+				userID,
+				creds.BotUsername,
+				"#"+creds.StreamerUsername,
+				r.store,
+				r.streamManager,
+			),
+			AnyCommand,
+			WithPermission(Everyone),
+			WithGlobalCooldown(commandGlobalCooldown),
+			WithUserCooldown(commandUserCooldown),
+		))
+		return b, nil
+	})
+	if err != nil {
+		log.Printf("Runner.StartBot: unable to start bot for user: %s: %s", userID, err)
+		return
+	}
+	log.Printf("Runner.StartBot: bot is running for user: %s", userID)
+}
+
+// StopBot stops the user's bot if it is running.
+func (r *Runner) StopBot(userID string) {
+	r.manager.StopBot(userID)
+}
+
+// StartDiscord connects the Discord bot for the user and restarts their bot
+// so that it responds to messages from their guild. Nothing is done if the
+// user has not linked a guild.
+func (r *Runner) StartDiscord(userID string) {
+	if r.discordOwnerID(userID) == "" {
+		return
+	}
+	r.streamManager.ConnectDiscord(userID, "Bot "+r.discordBotToken)
+	r.restartBot(userID)
+}
+
+// StopDiscord disconnects the Discord bot for the user and restarts their bot
+// so that it no longer responds to messages from their guild.
+func (r *Runner) StopDiscord(userID string) {
+	r.streamManager.DisconnectDiscord(userID)()
+	r.restartBot(userID)
+}
+
+// discordOwnerID returns the owner of the guild the user has linked or an
+// empty string if Discord should not be connected for the user.
+func (r *Runner) discordOwnerID(userID string) string {
+	if r.discordBotToken == "" {
+		return ""
+	}
+	creds, err := r.store.DiscordCredentials(userID)
+	if err != nil {
+		log.Printf("Runner: unable to get discord creds for user: %s: %s", userID, err)
+		return ""
+	}
+	if !creds.Authenticated {
+		return ""
+	}
+	return creds.OwnerID
+}
+
+// restartBot restarts the user's bot if it is running.
+func (r *Runner) restartBot(userID string) {
+	if r.manager.GetBot(userID) == nil {
+		return
+	}
+	r.StopBot(userID)
+	r.StartBot(userID)
+}
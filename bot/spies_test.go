@@ -0,0 +1,99 @@
+package bot_test
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+type SpySender struct {
+	mu   sync.Mutex
+	sent []stream.TXMessage
+}
+
+func (s *SpySender) Send(ms stream.TXMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, ms)
+}
+
+func (s *SpySender) sendCalls() []stream.TXMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+type SpyCustomCommandsStore struct {
+	cmds []store.CustomCommand
+	err  error
+
+	incrementCalledWith string
+}
+
+func (s *SpyCustomCommandsStore) CustomCommands(userID string) ([]store.CustomCommand, error) {
+	return s.cmds, s.err
+}
+
+func (s *SpyCustomCommandsStore) IncrementCustomCommandCount(userID, trigger string) (int, error) {
+	s.incrementCalledWith = trigger
+	for i := range s.cmds {
+		if s.cmds[i].Trigger == trigger {
+			s.cmds[i].Count++
+			return s.cmds[i].Count, nil
+		}
+	}
+	return 0, store.ErrUnknownCustomCommand
+}
+
+type SpyModerationStore struct {
+	rules   store.ModerationRules
+	strikes map[string]store.Strike
+	err     error
+
+	deleteCalledWithBefore time.Time
+}
+
+func (s *SpyModerationStore) ModerationRules(userID string) (store.ModerationRules, error) {
+	return s.rules, s.err
+}
+
+func (s *SpyModerationStore) ModerationStrikes(userID string) ([]store.Strike, error) {
+	var strikes []store.Strike
+	for _, st := range s.strikes {
+		strikes = append(strikes, st)
+	}
+	sort.Slice(strikes, func(i, j int) bool {
+		return strikes[i].Chatter < strikes[j].Chatter
+	})
+	return strikes, s.err
+}
+
+func (s *SpyModerationStore) SetModerationStrike(userID string, strike store.Strike) error {
+	if s.strikes == nil {
+		s.strikes = make(map[string]store.Strike)
+	}
+	s.strikes[strike.Chatter] = strike
+	return s.err
+}
+
+func (s *SpyModerationStore) DeleteModerationStrikes(userID string, before time.Time) error {
+	s.deleteCalledWithBefore = before
+	for chatter, st := range s.strikes {
+		if st.Last.Before(before) {
+			delete(s.strikes, chatter)
+		}
+	}
+	return s.err
+}
+
+type SpyTimersStore struct {
+	timers []store.Timer
+	err    error
+}
+
+func (s *SpyTimersStore) Timers(userID string) ([]store.Timer, error) {
+	return s.timers, s.err
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// defaultTimersTick is how often timers are checked to see if they are due.
+const defaultTimersTick = 30 * time.Second
+
+// TimersStore gets a user's timers.
+type TimersStore interface {
+	Timers(userID string) (timers []store.Timer, err error)
+}
+
+// TimersFeature posts the streamer's timers in their Twitch channel. A timer
+// is posted once its interval has passed and enough chat messages have been
+// sent since it was last posted.
+type TimersFeature struct {
+	userID         string
+	twitchUsername string
+	channel        string
+	store          TimersStore
+	sender         Sender
+	tick           time.Duration
+
+	mu    sync.Mutex
+	lines int
+	posts map[string]timerPost
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// timerPost records when a timer was last posted and how many chat messages
+// had been sent at that time.
+type timerPost struct {
+	at    time.Time
+	lines int
+}
+
+// TimersOption is used to configure a TimersFeature.
+type TimersOption func(*TimersFeature)
+
+// WithTimersTick sets how often timers are checked to see if they are due.
+func WithTimersTick(d time.Duration) TimersOption {
+	return func(t *TimersFeature) {
+		t.tick = d
+	}
+}
+
+// NewTimersFeature returns a new timers feature for the user. Timers are
+// posted to the channel as twitchUsername.
+func NewTimersFeature(
+	userID string,
+	twitchUsername string,
+	channel string,
+	store TimersStore,
+	sender Sender,
+	opts ...TimersOption,
+) *TimersFeature {
+	t := &TimersFeature{
+		userID:         userID,
+		twitchUsername: twitchUsername,
+		channel:        channel,
+		store:          store,
+		sender:         sender,
+		tick:           defaultTimersTick,
+		posts:          make(map[string]timerPost),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// HandleMessage counts the chat messages sent to the channel.
+func (t *TimersFeature) HandleMessage(in stream.RXMessage) {
+	if in.Type != stream.Twitch {
+		return
+	}
+	chatter, _, ok := chatMessage(in)
+	if !ok || chatter == t.twitchUsername || in.Twitch.Line.Args[0] != t.channel {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines++
+}
+
+// Start spawns a goroutine that periodically posts the timers that are due.
+func (t *TimersFeature) Start() {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go t.run()
+}
+
+// Stop stops posting timers and blocks until the goroutine has finished.
+func (t *TimersFeature) Stop() {
+	close(t.stop)
+	<-t.done
+}
+
+func (t *TimersFeature) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-ticker.C:
+			for _, msg := range t.due(now) {
+				t.sender.Send(stream.TXMessage{
+					Type: stream.Twitch,
+					Twitch: &stream.TXTwitch{
+						Username: t.twitchUsername,
+						To:       t.channel,
+						Message:  msg,
+					},
+				})
+			}
+		}
+	}
+}
+
+// due returns the messages of the timers that should be posted now and
+// records that they were posted. Timers that have not been seen before are
+// not posted until their interval has passed.
+func (t *TimersFeature) due(now time.Time) []string {
+	timers, err := t.store.Timers(t.userID)
+	if err != nil {
+		log.Printf("TimersFeature: unable to get timers for user: %s: %s", t.userID, err)
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var msgs []string
+	seen := make(map[string]bool, len(timers))
+	for _, timer := range timers {
+		seen[timer.Name] = true
+		post, ok := t.posts[timer.Name]
+		if ok && (now.Sub(post.at) < timer.Interval || t.lines-post.lines < timer.MinLines) {
+			continue
+		}
+		t.posts[timer.Name] = timerPost{
+			at:    now,
+			lines: t.lines,
+		}
+		if ok {
+			msgs = append(msgs, timer.Message)
+		}
+	}
+	for name := range t.posts {
+		if !seen[name] {
+			delete(t.posts, name)
+		}
+	}
+	return msgs
+}
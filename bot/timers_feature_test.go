@@ -0,0 +1,91 @@
+package bot_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestTimersArePostedAfterEnoughActivity(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyTimersStore{
+		timers: []store.Timer{
+			{
+				Name:     "socials",
+				Message:  "follow me on twitter",
+				Interval: 20 * time.Millisecond,
+				MinLines: 2,
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewTimersFeature(
+		"test-user-id",
+		"test-bot",
+		"#test-streamer",
+		spyStore,
+		spySender,
+		bot.WithTimersTick(5*time.Millisecond),
+	)
+	f.Start()
+	defer f.Stop()
+
+	f.HandleMessage(twitchMessage("test-chatter", "hello"))
+	f.HandleMessage(twitchMessage("test-bot", "not counted"))
+	time.Sleep(50 * time.Millisecond)
+	expect(len(spySender.sendCalls())).To.Equal(0)
+
+	f.HandleMessage(twitchMessage("test-chatter", "hello again"))
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(spySender.sendCalls()) == 0 {
+		if time.Now().After(deadline) {
+			fmt.Println("timed out waiting for timer to be posted")
+			t.FailNow()
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	expect(spySender.sendCalls()[0]).To.Equal(stream.TXMessage{
+		Type: stream.Twitch,
+		Twitch: &stream.TXTwitch{
+			Username: "test-bot",
+			To:       "#test-streamer",
+			Message:  "follow me on twitter",
+		},
+	})
+}
+
+func TestTimersAreNotPostedAfterStopping(t *testing.T) {
+	expect := expect.New(t)
+
+	spyStore := &SpyTimersStore{
+		timers: []store.Timer{
+			{
+				Name:     "socials",
+				Message:  "follow me on twitter",
+				Interval: 10 * time.Millisecond,
+			},
+		},
+	}
+	spySender := &SpySender{}
+	f := bot.NewTimersFeature(
+		"test-user-id",
+		"test-bot",
+		"#test-streamer",
+		spyStore,
+		spySender,
+		bot.WithTimersTick(time.Millisecond),
+	)
+	f.Start()
+	f.Stop()
+
+	sent := len(spySender.sendCalls())
+	time.Sleep(50 * time.Millisecond)
+	expect(len(spySender.sendCalls())).To.Equal(sent)
+}
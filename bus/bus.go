@@ -0,0 +1,94 @@
+// Package bus carries messages between the components of anubot. It has
+// the same shape as the ZeroMQ sockets the components were written
+// against: publishers send messages on topics to the subscribers of those
+// topics and pushers send messages to one of the pullers they are
+// connected to.
+//
+// Components are bound to or connect to endpoints. Memory is a pure Go
+// implementation that treats endpoints as names and can only connect
+// components in the same process. The bus/zmq package has an
+// implementation that uses ZeroMQ sockets so components can be run in
+// separate processes.
+package bus
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when no message was received before the
+	// timeout.
+	ErrTimeout = errors.New("bus: no message was received before the timeout")
+	// ErrWouldBlock is returned when a message can not be pushed without
+	// waiting.
+	ErrWouldBlock = errors.New("bus: message can not be pushed without waiting")
+	// ErrClosed is returned when sending or receiving on a closed
+	// publisher, subscription, pusher or puller.
+	ErrClosed = errors.New("bus: closed")
+)
+
+// Forever is used as a timeout to wait for a message until one is received.
+const Forever time.Duration = -1
+
+// Mode is how a pusher or puller is attached to its endpoints.
+type Mode int
+
+const (
+	// Connect connects to endpoints that are bound by other components.
+	Connect Mode = iota
+	// Bind binds the endpoints so other components can connect to them.
+	// An endpoint can only be bound by one component at a time.
+	Bind
+)
+
+// Bus creates publishers, subscriptions, pushers and pullers.
+type Bus interface {
+	// Publisher returns a Publisher that is bound to the endpoints.
+	Publisher(endpoints []string) (Publisher, error)
+	// Subscribe returns a Subscription connected to the publishers bound
+	// to the endpoints. It receives the messages published on topics that
+	// start with one of the given topics.
+	Subscribe(endpoints []string, topics []string) (Subscription, error)
+	// Pusher returns a Pusher that is bound or connected to the endpoints.
+	Pusher(mode Mode, endpoints []string) (Pusher, error)
+	// Puller returns a Puller that is bound or connected to the endpoints.
+	Puller(mode Mode, endpoints []string) (Puller, error)
+}
+
+// Publisher publishes messages on topics. Messages are dropped for
+// subscribers that are not keeping up.
+type Publisher interface {
+	Publish(topic string, msg []byte) error
+	Close() error
+}
+
+// Subscription receives the messages published on the topics it is
+// subscribed to.
+type Subscription interface {
+	// Receive waits up to the timeout for a message. A timeout of zero
+	// does not wait and a timeout of Forever waits until a message is
+	// received. ErrTimeout is returned if no message was received.
+	Receive(timeout time.Duration) (topic string, msg []byte, err error)
+	Close() error
+}
+
+// Pusher sends messages that are made up of one or more frames to one of
+// the pullers it is attached to.
+type Pusher interface {
+	// Push waits until the message can be queued.
+	Push(frames ...[]byte) error
+	// TryPush returns ErrWouldBlock if the message can not be queued
+	// without waiting.
+	TryPush(frames ...[]byte) error
+	Close() error
+}
+
+// Puller receives the messages sent by pushers.
+type Puller interface {
+	// Pull waits up to the timeout for a message. A timeout of zero does
+	// not wait and a timeout of Forever waits until a message is received.
+	// ErrTimeout is returned if no message was received.
+	Pull(timeout time.Duration) ([][]byte, error)
+	Close() error
+}
@@ -0,0 +1,397 @@
+package bus
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hwm is how many messages are queued for each subscription and endpoint
+// before publishing drops messages and pushing waits. It matches the
+// default high water mark of ZeroMQ sockets.
+const hwm = 1000
+
+// Default is the bus used by components that are not given one. It is in
+// memory so components in the same process can reach each other without
+// any configuration.
+var Default Bus = NewMemory()
+
+// Memory is a Bus that uses Go channels. Endpoints are only names so any
+// string can be used, for instance the inproc:// endpoints used with
+// ZeroMQ. Messages are not copied so they must not be modified once they
+// have been sent.
+type Memory struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpoint
+}
+
+// endpoint has the subscriptions connected to a publisher and the queue of
+// pushed messages for an endpoint. Endpoints are created when they are
+// first used and are kept once they are no longer bound so that
+// components can connect before and after the endpoint is bound.
+type endpoint struct {
+	bound bool
+	subs  map[*memorySubscription]struct{}
+	queue chan [][]byte
+}
+
+// NewMemory returns a new in memory bus. Components must use the same
+// Memory to reach each other.
+func NewMemory() *Memory {
+	return &Memory{
+		endpoints: make(map[string]*endpoint),
+	}
+}
+
+// endpoint returns the endpoint with the name, creating it if it does not
+// exist. The lock must be held.
+func (m *Memory) endpoint(name string) *endpoint {
+	e, ok := m.endpoints[name]
+	if !ok {
+		e = &endpoint{
+			subs:  make(map[*memorySubscription]struct{}),
+			queue: make(chan [][]byte, hwm),
+		}
+		m.endpoints[name] = e
+	}
+	return e
+}
+
+// bind marks the endpoints as bound. No endpoints are bound if any of them
+// already are.
+func (m *Memory) bind(names []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		if m.endpoint(name).bound {
+			return fmt.Errorf("bus: endpoint is already bound: %s", name)
+		}
+	}
+	for _, name := range names {
+		m.endpoint(name).bound = true
+	}
+	return nil
+}
+
+// unbind allows the endpoints to be bound again.
+func (m *Memory) unbind(names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		m.endpoint(name).bound = false
+	}
+}
+
+// queues returns the queues for the endpoints.
+func (m *Memory) queues(names []string) []chan [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queues := make([]chan [][]byte, 0, len(names))
+	for _, name := range names {
+		queues = append(queues, m.endpoint(name).queue)
+	}
+	return queues
+}
+
+// Publisher returns a Publisher that is bound to the endpoints.
+func (m *Memory) Publisher(endpoints []string) (Publisher, error) {
+	err := m.bind(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryPublisher{
+		m:         m,
+		endpoints: endpoints,
+	}, nil
+}
+
+// Subscribe returns a Subscription connected to the endpoints.
+func (m *Memory) Subscribe(endpoints []string, topics []string) (Subscription, error) {
+	s := &memorySubscription{
+		m:         m,
+		endpoints: endpoints,
+		topics:    topics,
+		messages:  make(chan memoryMessage, hwm),
+		done:      make(chan struct{}),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range endpoints {
+		m.endpoint(name).subs[s] = struct{}{}
+	}
+	return s, nil
+}
+
+// Pusher returns a Pusher that is bound or connected to the endpoints.
+func (m *Memory) Pusher(mode Mode, endpoints []string) (Pusher, error) {
+	if mode == Bind {
+		err := m.bind(endpoints)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &memoryPusher{
+		queue: newQueue(m, mode, endpoints),
+	}, nil
+}
+
+// Puller returns a Puller that is bound or connected to the endpoints.
+func (m *Memory) Puller(mode Mode, endpoints []string) (Puller, error) {
+	if mode == Bind {
+		err := m.bind(endpoints)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &memoryPuller{
+		queue: newQueue(m, mode, endpoints),
+	}, nil
+}
+
+type memoryPublisher struct {
+	m         *Memory
+	endpoints []string
+	// closed is guarded by the lock of the bus.
+	closed bool
+}
+
+// Publish sends the message to the subscriptions connected to the
+// publisher's endpoints that are subscribed to the topic. The message is
+// dropped for subscriptions that have too many messages queued.
+func (p *memoryPublisher) Publish(topic string, msg []byte) error {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	for _, name := range p.endpoints {
+		for s := range p.m.endpoints[name].subs {
+			if !s.subscribed(topic) {
+				continue
+			}
+			select {
+			case s.messages <- memoryMessage{topic: topic, msg: msg}:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+// Close unbinds the publisher's endpoints.
+func (p *memoryPublisher) Close() error {
+	p.m.mu.Lock()
+	if p.closed {
+		p.m.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.m.mu.Unlock()
+	p.m.unbind(p.endpoints)
+	return nil
+}
+
+type memoryMessage struct {
+	topic string
+	msg   []byte
+}
+
+type memorySubscription struct {
+	m         *Memory
+	endpoints []string
+	topics    []string
+	messages  chan memoryMessage
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *memorySubscription) subscribed(topic string) bool {
+	for _, t := range s.topics {
+		if strings.HasPrefix(topic, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Receive waits up to the timeout for a message to be published.
+func (s *memorySubscription) Receive(timeout time.Duration) (string, []byte, error) {
+	select {
+	case <-s.done:
+		return "", nil, ErrClosed
+	case m := <-s.messages:
+		return m.topic, m.msg, nil
+	default:
+	}
+	if timeout == 0 {
+		return "", nil, ErrTimeout
+	}
+	expired, stop := after(timeout)
+	defer stop()
+	select {
+	case <-s.done:
+		return "", nil, ErrClosed
+	case m := <-s.messages:
+		return m.topic, m.msg, nil
+	case <-expired:
+		return "", nil, ErrTimeout
+	}
+}
+
+// Close disconnects the subscription from its endpoints.
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		s.m.mu.Lock()
+		defer s.m.mu.Unlock()
+		for _, name := range s.endpoints {
+			delete(s.m.endpoints[name].subs, s)
+		}
+		close(s.done)
+	})
+	return nil
+}
+
+// queue is shared by pushers and pullers. Messages are sent to and
+// received from the queues of all of the endpoints.
+type queue struct {
+	m         *Memory
+	mode      Mode
+	endpoints []string
+	queues    []chan [][]byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newQueue(m *Memory, mode Mode, endpoints []string) *queue {
+	return &queue{
+		m:         m,
+		mode:      mode,
+		endpoints: endpoints,
+		queues:    m.queues(endpoints),
+		done:      make(chan struct{}),
+	}
+}
+
+// closed returns true if the queue has been closed.
+func (q *queue) closed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close unbinds the endpoints if they were bound. Messages that have been
+// queued remain queued for the next puller.
+func (q *queue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.done)
+		if q.mode == Bind {
+			q.m.unbind(q.endpoints)
+		}
+	})
+	return nil
+}
+
+type memoryPusher struct {
+	*queue
+}
+
+// Push waits until one of the endpoints has room for the message.
+func (p *memoryPusher) Push(frames ...[]byte) error {
+	return p.send(frames, true)
+}
+
+// TryPush queues the message if one of the endpoints has room for it.
+func (p *memoryPusher) TryPush(frames ...[]byte) error {
+	return p.send(frames, false)
+}
+
+func (p *memoryPusher) send(frames [][]byte, wait bool) error {
+	if p.closed() {
+		return ErrClosed
+	}
+	cases := []reflect.SelectCase{{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(p.done),
+	}}
+	for _, q := range p.queues {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectSend,
+			Chan: reflect.ValueOf(q),
+			Send: reflect.ValueOf(frames),
+		})
+	}
+	if !wait {
+		cases = append(cases, reflect.SelectCase{
+			Dir: reflect.SelectDefault,
+		})
+	}
+	chosen, _, _ := reflect.Select(cases)
+	switch chosen {
+	case 0:
+		return ErrClosed
+	case len(p.queues) + 1:
+		return ErrWouldBlock
+	}
+	return nil
+}
+
+type memoryPuller struct {
+	*queue
+}
+
+// Pull waits up to the timeout for a message to be queued on one of the
+// endpoints.
+func (p *memoryPuller) Pull(timeout time.Duration) ([][]byte, error) {
+	if p.closed() {
+		return nil, ErrClosed
+	}
+	cases := []reflect.SelectCase{{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(p.done),
+	}}
+	for _, q := range p.queues {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(q),
+		})
+	}
+	switch {
+	case timeout == 0:
+		cases = append(cases, reflect.SelectCase{
+			Dir: reflect.SelectDefault,
+		})
+	case timeout > 0:
+		expired, stop := after(timeout)
+		defer stop()
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(expired),
+		})
+	}
+	chosen, v, _ := reflect.Select(cases)
+	switch chosen {
+	case 0:
+		return nil, ErrClosed
+	case len(p.queues) + 1:
+		return nil, ErrTimeout
+	}
+	return v.Interface().([][]byte), nil
+}
+
+// after returns a channel that receives once the timeout has passed and a
+// function that releases its timer. The channel never receives if the
+// timeout is Forever.
+func after(timeout time.Duration) (<-chan time.Time, func()) {
+	if timeout < 0 {
+		return nil, func() {}
+	}
+	t := time.NewTimer(timeout)
+	return t.C, func() {
+		t.Stop()
+	}
+}
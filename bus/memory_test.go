@@ -0,0 +1,133 @@
+package bus_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/bus"
+)
+
+func TestMemoryPublishesToSubscribedTopics(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+
+	sub, err := b.Subscribe([]string{"test-pub"}, []string{"test-topic:"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer sub.Close()
+	pub, err := b.Publisher([]string{"test-pub"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer pub.Close()
+
+	expect(pub.Publish("other-topic", []byte("test-other"))).To.Be.Nil()
+	expect(pub.Publish("test-topic:a", []byte("test-message"))).To.Be.Nil()
+
+	topic, msg, err := sub.Receive(time.Second)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(topic).To.Equal("test-topic:a")
+	expect(string(msg)).To.Equal("test-message")
+
+	_, _, err = sub.Receive(0)
+	expect(err).To.Equal(bus.ErrTimeout)
+}
+
+func TestMemoryPushesToOnePuller(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+
+	pull, err := b.Puller(bus.Bind, []string{"test-queue"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	push, err := b.Pusher(bus.Connect, []string{"test-queue"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer push.Close()
+
+	expect(push.Push([]byte("test-seq"), []byte("test-message"))).To.Be.Nil()
+	parts, err := pull.Pull(time.Second)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(parts).To.Equal([][]byte{[]byte("test-seq"), []byte("test-message")})
+
+	_, err = pull.Pull(10 * time.Millisecond)
+	expect(err).To.Equal(bus.ErrTimeout)
+
+	// messages stay queued for the next puller to bind the endpoint
+	expect(pull.Close()).To.Be.Nil()
+	_, err = pull.Pull(0)
+	expect(err).To.Equal(bus.ErrClosed)
+	expect(push.Push([]byte("test-queued"))).To.Be.Nil()
+
+	pull, err = b.Puller(bus.Bind, []string{"test-queue"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer pull.Close()
+	parts, err = pull.Pull(0)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(parts).To.Equal([][]byte{[]byte("test-queued")})
+}
+
+func TestMemoryTryPushDoesNotWaitForRoom(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+
+	push, err := b.Pusher(bus.Bind, []string{"test-full"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer push.Close()
+
+	for {
+		err = push.TryPush([]byte("test-message"))
+		if err != nil {
+			break
+		}
+	}
+	expect(err).To.Equal(bus.ErrWouldBlock)
+}
+
+func TestMemoryEndpointsCanOnlyBeBoundOnce(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+
+	pub, err := b.Publisher([]string{"test-bound"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	_, err = b.Pusher(bus.Bind, []string{"test-other", "test-bound"})
+	expect(err).Not.To.Be.Nil()
+
+	// none of the endpoints are bound when binding fails
+	push, err := b.Pusher(bus.Bind, []string{"test-other"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(push.Close()).To.Be.Nil()
+
+	expect(pub.Close()).To.Be.Nil()
+	pub, err = b.Publisher([]string{"test-bound"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(pub.Close()).To.Be.Nil()
+}
+
+func TestMemoryCloseStopsWaiting(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+
+	sub, err := b.Subscribe([]string{"test-pub"}, []string{""})
+	expect(err).To.Be.Nil().Else.FailNow()
+	pull, err := b.Puller(bus.Connect, []string{"test-queue"})
+	expect(err).To.Be.Nil().Else.FailNow()
+
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := sub.Receive(bus.Forever)
+		errs <- err
+	}()
+	go func() {
+		_, err := pull.Pull(bus.Forever)
+		errs <- err
+	}()
+
+	expect(sub.Close()).To.Be.Nil()
+	expect(pull.Close()).To.Be.Nil()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			expect(err).To.Equal(bus.ErrClosed)
+		case <-time.After(time.Second):
+			t.Fatal("receiving did not stop when closed")
+		}
+	}
+}
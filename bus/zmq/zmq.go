@@ -0,0 +1,190 @@
+//go:build zmq
+// +build zmq
+
+// Package zmq provides a bus.Bus that uses ZeroMQ sockets so components can
+// communicate across processes over tcp:// or ipc:// endpoints. It
+// requires libzmq so it is only built with the zmq build tag.
+package zmq
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/pebbe/zmq4"
+
+	"github.com/jasonkeene/anubot-server/bus"
+)
+
+// Bus creates ZeroMQ sockets. Publishers are pub sockets, subscriptions are
+// sub sockets, pushers are push sockets and pullers are pull sockets.
+type Bus struct{}
+
+// New returns a new ZeroMQ bus.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Publisher returns a Publisher that is bound to the endpoints.
+func (*Bus) Publisher(endpoints []string) (bus.Publisher, error) {
+	s, err := newSocket(zmq4.PUB, bus.Bind, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return &publisher{s: s}, nil
+}
+
+// Subscribe returns a Subscription connected to the endpoints.
+func (*Bus) Subscribe(endpoints []string, topics []string) (bus.Subscription, error) {
+	s, err := newSocket(zmq4.SUB, bus.Connect, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range topics {
+		err = s.SetSubscribe(topic)
+		if err != nil {
+			closeOnError(s)
+			return nil, err
+		}
+	}
+	return &subscription{receiver{s: s, timeout: bus.Forever}}, nil
+}
+
+// Pusher returns a Pusher that is bound or connected to the endpoints.
+func (*Bus) Pusher(mode bus.Mode, endpoints []string) (bus.Pusher, error) {
+	s, err := newSocket(zmq4.PUSH, mode, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return &pusher{s: s}, nil
+}
+
+// Puller returns a Puller that is bound or connected to the endpoints.
+func (*Bus) Puller(mode bus.Mode, endpoints []string) (bus.Puller, error) {
+	s, err := newSocket(zmq4.PULL, mode, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return &puller{receiver{s: s, timeout: bus.Forever}}, nil
+}
+
+func newSocket(t zmq4.Type, mode bus.Mode, endpoints []string) (*zmq4.Socket, error) {
+	s, err := zmq4.NewSocket(t)
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range endpoints {
+		if mode == bus.Bind {
+			err = s.Bind(endpoint)
+		} else {
+			err = s.Connect(endpoint)
+		}
+		if err != nil {
+			closeOnError(s)
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// closeOnError closes a socket that could not be set up. The error from
+// setting it up is more useful so an error closing it is ignored.
+func closeOnError(s *zmq4.Socket) {
+	_ = s.Close()
+}
+
+// wouldBlock returns true if the error is because the socket was not ready.
+func wouldBlock(err error) bool {
+	return zmq4.AsErrno(err) == zmq4.Errno(syscall.EAGAIN)
+}
+
+type publisher struct {
+	s *zmq4.Socket
+}
+
+func (p *publisher) Publish(topic string, msg []byte) error {
+	_, err := p.s.SendMessage(topic, msg)
+	return err
+}
+
+func (p *publisher) Close() error {
+	return p.s.Close()
+}
+
+type pusher struct {
+	s *zmq4.Socket
+}
+
+func (p *pusher) Push(frames ...[]byte) error {
+	_, err := p.s.SendMessage(frames)
+	return err
+}
+
+func (p *pusher) TryPush(frames ...[]byte) error {
+	_, err := p.s.SendMessageDontwait(frames)
+	if err != nil && wouldBlock(err) {
+		return bus.ErrWouldBlock
+	}
+	return err
+}
+
+func (p *pusher) Close() error {
+	return p.s.Close()
+}
+
+// receiver reads messages from a socket. The receive timeout of the socket
+// is only changed when a different timeout is used. Sockets start out
+// waiting forever which zmq4 represents as -1, the same as bus.Forever.
+type receiver struct {
+	s       *zmq4.Socket
+	timeout time.Duration
+}
+
+func (r *receiver) receive(timeout time.Duration) ([][]byte, error) {
+	var flags zmq4.Flag
+	switch {
+	case timeout == 0:
+		flags = zmq4.DONTWAIT
+	case timeout != r.timeout:
+		err := r.s.SetRcvtimeo(timeout)
+		if err != nil {
+			return nil, err
+		}
+		r.timeout = timeout
+	}
+	parts, err := r.s.RecvMessageBytes(flags)
+	if err != nil {
+		if wouldBlock(err) {
+			return nil, bus.ErrTimeout
+		}
+		return nil, err
+	}
+	return parts, nil
+}
+
+func (r *receiver) Close() error {
+	return r.s.Close()
+}
+
+type subscription struct {
+	receiver
+}
+
+func (s *subscription) Receive(timeout time.Duration) (string, []byte, error) {
+	parts, err := s.receive(timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("received message had invalid length: %#v", parts)
+	}
+	return string(parts[0]), parts[1], nil
+}
+
+type puller struct {
+	receiver
+}
+
+func (p *puller) Pull(timeout time.Duration) ([][]byte, error) {
+	return p.receive(timeout)
+}
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"encoding/base64"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/fluffle/goirc/logging/golog"
-	"github.com/spf13/viper"
 
 	"github.com/jasonkeene/anubot-server/api"
-	"github.com/jasonkeene/anubot-server/dispatch"
+	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/cmd/internal/config"
+	discordOauth "github.com/jasonkeene/anubot-server/discord/oauth"
 	"github.com/jasonkeene/anubot-server/store"
 	"github.com/jasonkeene/anubot-server/stream"
+	"github.com/jasonkeene/anubot-server/stream/remote"
 	"github.com/jasonkeene/anubot-server/twitch"
 	"github.com/jasonkeene/anubot-server/twitch/oauth"
 )
@@ -21,64 +23,124 @@ func init() {
 	golog.Init()
 }
 
+// streamController is used by the API and bots to connect and send to third
+// party chat. It is either a local stream.Manager or a remote.Client for
+// remote ones.
+type streamController interface {
+	ConnectTwitch(user, pass, channel string)
+	PartTwitchChannel(user, channel string) error
+	TwitchChannels(user string) []string
+	ConnectDiscord(userID, token string)
+	DisconnectDiscord(userID string) func()
+	DiscordChannels(userID, guildID string) ([]stream.DiscordChannel, error)
+	IsDiscordGuildMember(userID, guildID, memberID string) (bool, error)
+	Send(msg stream.TXMessage)
+}
+
 func main() {
 	// load config
-	v := viper.New()
-	v.SetEnvPrefix("anubot")
-	v.AutomaticEnv()
+	v := config.New()
+	nonceTTL := v.GetDuration("oauth_nonce_ttl")
+	pubEndpoints := config.Endpoints(v, config.DispatchPubEndpoints)
+
+	// create store
+	st := config.Store(v)
+
+	// setup oauth token refresher
+	refresher := oauth.NewRefresher(
+		v.GetString("twitch_oauth_client_id"),
+		v.GetString("twitch_oauth_client_secret"),
+		st,
+	)
 
 	// setup twitch api client
 	twitchClient := twitch.New(
 		v.GetString("twitch_api_url"),
 		v.GetString("twitch_oauth_client_id"),
+		twitch.WithTokenRefresher(refresher),
 	)
 
-	// create store
-	backend := v.GetString("store_backend")
-	var st store.Store
-	switch backend {
-	case "postgres":
-		key, err := base64.RawStdEncoding.DecodeString(v.GetString("encryption_key"))
+	// when remote stream managers are configured the dispatcher, puller and
+	// stream managers run in their own processes, otherwise they run here
+	var (
+		streamManager streamController
+		components    []config.Component
+	)
+	streamerEndpoints := config.Endpoints(v, config.StreamerEndpoints)
+	if len(streamerEndpoints) > 0 {
+		client, err := remote.NewClient(
+			streamerEndpoints,
+			config.Endpoints(v, config.StreamerReplyEndpoints),
+			remote.WithBus(config.Bus(v)),
+		)
 		if err != nil {
-			log.Panicf("unable to decode encryption key: %s", err)
+			log.Panicf("unable to connect to stream managers: %s", err)
 		}
-		st, err = store.NewPostgres(v.GetString("store_postgres_url"), key)
-		if err != nil {
-			log.Panicf("unable to open postgres database: %s", err)
+		defer func() {
+			err := client.Close()
+			if err != nil {
+				log.Printf("unable to close stream manager client: %s", err)
+			}
+		}()
+		streamManager = client
+	} else {
+		dispatcher := config.Dispatcher(v)
+		puller := config.Puller(v, st)
+		go puller.Start()
+		manager := config.StreamManager(v, twitchClient, refresher)
+		streamManager = manager
+
+		components = []config.Component{
+			{Name: "stream manager", Shutdown: manager.Shutdown},
+			{Name: "dispatcher", Shutdown: dispatcher.Shutdown},
+			{Name: "puller", Shutdown: puller.Shutdown},
 		}
-		err = st.(*store.Postgres).Ping()
+	}
+
+	// setup pruner to enforce message retention policies
+	pruner := store.NewPruner(st)
+	go pruner.Start()
+
+	// setup sweeper to remove abandoned oauth nonces
+	sweeper := store.NewSweeper(st)
+	go sweeper.Start()
+
+	// create bot manager and start bots for users that have already
+	// authenticated with twitch, when running multiple API processes only
+	// one of them should run bots
+	v.SetDefault("run_bots", true)
+	botManager := bot.NewManager()
+	apiOpts := []api.Option{
+		api.WithBus(config.Bus(v)),
+		api.WithSubEndpoints(pubEndpoints),
+	}
+	if v.GetBool("run_bots") {
+		botRunner := bot.NewRunner(
+			botManager,
+			st,
+			streamManager,
+			bot.WithRunnerBus(config.Bus(v)),
+			bot.WithRunnerSubEndpoints(pubEndpoints),
+			bot.WithDiscordBotToken(v.GetString("discord_bot_token")),
+		)
+		userIDs, err := st.TwitchAuthenticatedUsers()
 		if err != nil {
-			log.Panicf("unable to ping postgres database: %s", err)
+			log.Panicf("unable to get twitch authenticated users: %s", err)
 		}
-	case "bolt":
-		var err error
-		st, err = store.NewBolt(v.GetString("store_bolt_path"))
-		if err != nil {
-			log.Panicf("unable to create bolt database: %s", err)
+		for _, userID := range userIDs {
+			go botRunner.StartBot(userID)
 		}
-	case "dummy":
-		log.Panicf("dummy store backend is not wired up")
-	default:
-		log.Panicf("unknown store backend: %s", backend)
-	}
-
-	// create message dispatcher
-	dispatch.Start()
 
-	// setup puller to store messages
-	puller, err := store.NewPuller(st)
-	if err != nil {
-		log.Panicf("pull not able to connect, got err: %s", err)
+		// connect discord for users that have already linked a guild
+		discordUserIDs, err := st.DiscordAuthenticatedUsers()
+		if err != nil {
+			log.Panicf("unable to get discord authenticated users: %s", err)
+		}
+		for _, userID := range discordUserIDs {
+			go botRunner.StartDiscord(userID)
+		}
+		apiOpts = append(apiOpts, api.WithBotRunner(botRunner))
 	}
-	go puller.Start()
-
-	// create bot manager
-	// TODO: consider who is responsible for making sure a given user's bot is
-	// running
-	//botManager := bot.NewManager()
-
-	// create stream manager
-	streamManager := stream.NewManager(twitchClient)
 
 	mux := http.NewServeMux()
 
@@ -89,9 +151,20 @@ func main() {
 		v.GetString("twitch_oauth_redirect_uri"),
 		st,
 		twitchClient,
+		oauth.WithCallbackTTL(nonceTTL),
 	)
 	mux.Handle("/v1/twitch_oauth/done", doneHandler)
 
+	// wire up discord oauth handler
+	discordDoneHandler := discordOauth.NewDoneHandler(
+		v.GetString("discord_oauth_client_id"),
+		v.GetString("discord_oauth_client_secret"),
+		v.GetString("discord_oauth_redirect_uri"),
+		st,
+		discordOauth.WithCallbackTTL(nonceTTL),
+	)
+	mux.Handle("/v1/discord_oauth/done", discordDoneHandler)
+
 	// setup websocket API server
 	api := api.New(
 		streamManager,
@@ -100,9 +173,19 @@ func main() {
 		doneHandler,
 		v.GetString("twitch_oauth_client_id"),
 		v.GetString("twitch_oauth_redirect_uri"),
+		append(apiOpts, api.WithDiscordOauth(
+			v.GetString("discord_oauth_client_id"),
+			v.GetString("discord_oauth_redirect_uri"),
+			discordDoneHandler,
+		))...,
 	)
 	mux.Handle("/v1/ws", api)
 
+	// expose counts of dispatched, dropped and stored messages
+	if v.GetBool("debug_vars") {
+		mux.Handle("/debug/vars", expvar.Handler())
+	}
+
 	// bind websocket API
 	v.SetDefault("port", 8080)
 	port := v.GetInt("port")
@@ -113,20 +196,31 @@ func main() {
 		// TODO: consider timeouts
 	}
 
+	serverErrs := make(chan error, 1)
 	certFile := v.GetString("tls_cert_file")
 	keyFile := v.GetString("tls_key_file")
-	if certFile != "" && keyFile != "" {
-		fmt.Println("listening for tls on port", port)
-		err = server.ListenAndServeTLS(certFile, keyFile)
-		if err != nil {
-			log.Panic("ListenAndServeTLS: " + err.Error())
+	go func() {
+		if certFile != "" && keyFile != "" {
+			fmt.Println("listening for tls on port", port)
+			serverErrs <- fmt.Errorf("ListenAndServeTLS: %s", server.ListenAndServeTLS(certFile, keyFile))
+			return
 		}
-		return
-	}
-
-	fmt.Println("listening on port", port)
-	err = server.ListenAndServe()
+		fmt.Println("listening on port", port)
+		serverErrs <- fmt.Errorf("ListenAndServe: %s", server.ListenAndServe())
+	}()
+
+	config.WaitForSignal(serverErrs)
+
+	// components are shut down in the order messages flow through them so
+	// that messages that have been received are stored before exiting
+	config.Shutdown(v, append([]config.Component{
+		{Name: "http server", Shutdown: server.Shutdown},
+		{Name: "bots", Shutdown: botManager.Shutdown},
+	}, components...)...)
+	pruner.Stop()()
+	sweeper.Stop()()
+	err := st.Close()
 	if err != nil {
-		log.Panic("ListenAndServe: " + err.Error())
+		log.Printf("unable to close store: %s", err)
 	}
 }
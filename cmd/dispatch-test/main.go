@@ -8,9 +8,9 @@ import (
 	"os/signal"
 
 	"github.com/fluffle/goirc/logging/golog"
-	"github.com/pebbe/zmq4"
 	"github.com/spf13/viper"
 
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/dispatch"
 	"github.com/jasonkeene/anubot-server/stream"
 	"github.com/jasonkeene/anubot-server/twitch"
@@ -27,7 +27,6 @@ func main() {
 	twitchStreamerUser := os.Getenv("TWITCH_USER_USER")
 	twitchStreamerPass := os.Getenv("TWITCH_USER_PASS")
 	twitchStreamerChannel := "#" + twitchStreamerUser
-	twitchStreamerTopic := "twitch:" + twitchStreamerUser
 	twitchBotUser := os.Getenv("TWITCH_BOT_USER")
 	twitchBotPass := os.Getenv("TWITCH_BOT_PASS")
 	discordBotPass := os.Getenv("DISCORD_BOT_PASS")
@@ -51,7 +50,13 @@ func main() {
 		twitchStreamerPass,
 		twitchStreamerChannel,
 	)
-	manager.ConnectDiscord(discordBotPass)
+	manager.ConnectDiscord(twitchStreamerUser, discordBotPass)
+
+	twitchStreamerID, err := twitch.UserID(twitchStreamerUser)
+	if err != nil {
+		log.Panicf("unable to fetch user id for streamer, got err: %s", err)
+	}
+	twitchStreamerTopic := stream.TwitchOwnerTopic(twitchStreamerID)
 
 	sub := createSub("inproc://dispatch-pub", "")
 	topicSub := createSub("inproc://dispatch-pub", twitchStreamerTopic)
@@ -63,21 +68,20 @@ func main() {
 
 	<-interrupt
 	twitchWait := manager.DisconnectTwitch(twitchBotUser)
-	discordWait := manager.DisconnectDiscord()
+	discordWait := manager.DisconnectDiscord(twitchStreamerUser)
 	twitchWait()
 	discordWait()
 }
 
-func readFromSub(sub *zmq4.Socket) {
+func readFromSub(sub bus.Subscription) {
 	for {
-		rb, err := sub.RecvMessageBytes(0)
+		topic, msg, err := sub.Receive(bus.Forever)
 		if err != nil {
 			log.Printf("messages not read, got err: %s", err)
 			continue
 		}
-		topic := rb[0]
 		var message stream.RXMessage
-		err = json.Unmarshal(rb[1], &message)
+		err = json.Unmarshal(msg, &message)
 		if err != nil {
 			log.Printf("could not unmarshal, got err: %s", err)
 			continue
@@ -86,15 +90,15 @@ func readFromSub(sub *zmq4.Socket) {
 	}
 }
 
-func readFromPull(pull *zmq4.Socket) {
+func readFromPull(pull bus.Puller) {
 	for {
-		rb, err := pull.RecvBytes(0)
+		rb, err := pull.Pull(bus.Forever)
 		if err != nil {
 			log.Printf("messages not read, got err: %s", err)
 			continue
 		}
 		var message stream.RXMessage
-		err = json.Unmarshal(rb, &message)
+		err = json.Unmarshal(rb[len(rb)-1], &message)
 		if err != nil {
 			log.Printf("could not unmarshal, got err: %s", err)
 			continue
@@ -103,28 +107,16 @@ func readFromPull(pull *zmq4.Socket) {
 	}
 }
 
-func createSub(connect, topic string) *zmq4.Socket {
-	sub, err := zmq4.NewSocket(zmq4.SUB)
-	if err != nil {
-		log.Panicf("sub not created, got err: %s", err)
-	}
-	err = sub.Connect(connect)
+func createSub(connect, topic string) bus.Subscription {
+	sub, err := bus.Default.Subscribe([]string{connect}, []string{topic})
 	if err != nil {
 		log.Panicf("sub not able to connect, got err: %s", err)
 	}
-	err = sub.SetSubscribe(topic)
-	if err != nil {
-		log.Panicf("sub not able to set topic, got err: %s", err)
-	}
 	return sub
 }
 
-func createPull(connect string) *zmq4.Socket {
-	pull, err := zmq4.NewSocket(zmq4.PULL)
-	if err != nil {
-		log.Panicf("pull not created, got err: %s", err)
-	}
-	err = pull.Connect(connect)
+func createPull(connect string) bus.Puller {
+	pull, err := bus.Default.Puller(bus.Connect, []string{connect})
 	if err != nil {
 		log.Panicf("pull not able to connect, got err: %s", err)
 	}
@@ -0,0 +1,18 @@
+// Command dispatcher runs a dispatcher on its own. Stream managers push
+// messages to it, the API and bots subscribe to it and pullers pull from it.
+package main
+
+import (
+	"github.com/jasonkeene/anubot-server/cmd/internal/config"
+)
+
+func main() {
+	v := config.New()
+	dispatcher := config.Dispatcher(v)
+
+	config.WaitForSignal(nil)
+	config.Shutdown(v, config.Component{
+		Name:     "dispatcher",
+		Shutdown: dispatcher.Shutdown,
+	})
+}
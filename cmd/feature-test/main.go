@@ -7,10 +7,10 @@ import (
 	"os/signal"
 
 	"github.com/fluffle/goirc/logging/golog"
-	"github.com/pebbe/zmq4"
 	"github.com/spf13/viper"
 
 	"github.com/jasonkeene/anubot-server/bot"
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/dispatch"
 	"github.com/jasonkeene/anubot-server/stream"
 	"github.com/jasonkeene/anubot-server/twitch"
@@ -56,11 +56,20 @@ func main() {
 		twitchStreamerPass,
 		twitchStreamerChannel,
 	)
-	manager.ConnectDiscord("Bot " + discordBotPass)
+	manager.ConnectDiscord(discordUserID, "Bot "+discordBotPass)
 
+	twitchBotID, err := twitch.UserID(twitchBotUser)
+	if err != nil {
+		panic(err)
+	}
+	twitchStreamerID, err := twitch.UserID(twitchStreamerUser)
+	if err != nil {
+		panic(err)
+	}
 	b, err := bot.New(
 		[]string{
-			"twitch:" + twitchBotUser,
+			stream.TwitchOwnerTopic(twitchBotID),
+			stream.TwitchChannelTopic(twitchStreamerID, twitchStreamerChannel),
 			"discord:" + discordUserID,
 		},
 	)
@@ -70,25 +79,25 @@ func main() {
 	go b.Start()
 	defer b.Stop()
 
-	f := bot.NewEchoFeature("!echo", twitchBotUser, manager)
+	f := bot.NewEchoFeature("!echo", discordUserID, twitchBotUser, manager)
 	b.SetFeature("echo", f)
 
 	<-interrupt
 	twitchWait := manager.DisconnectTwitch(twitchBotUser)
-	discordWait := manager.DisconnectDiscord()
+	discordWait := manager.DisconnectDiscord(discordUserID)
 	twitchWait()
 	discordWait()
 }
 
-func readFromPull(pull *zmq4.Socket) {
+func readFromPull(pull bus.Puller) {
 	for {
-		rb, err := pull.RecvBytes(0)
+		rb, err := pull.Pull(bus.Forever)
 		if err != nil {
 			log.Printf("messages not read, got err: %s", err)
 			continue
 		}
 		var message stream.RXMessage
-		err = json.Unmarshal(rb, &message)
+		err = json.Unmarshal(rb[len(rb)-1], &message)
 		if err != nil {
 			log.Printf("could not unmarshal, got err: %s", err)
 			continue
@@ -96,12 +105,8 @@ func readFromPull(pull *zmq4.Socket) {
 	}
 }
 
-func createPull(connect string) *zmq4.Socket {
-	pull, err := zmq4.NewSocket(zmq4.PULL)
-	if err != nil {
-		log.Panicf("pull not created, got err: %s", err)
-	}
-	err = pull.Connect(connect)
+func createPull(connect string) bus.Puller {
+	pull, err := bus.Default.Puller(bus.Connect, []string{connect})
 	if err != nil {
 		log.Panicf("pull not able to connect, got err: %s", err)
 	}
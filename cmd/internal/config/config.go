@@ -0,0 +1,213 @@
+// Package config provides the configuration shared by the anubot commands.
+// Configuration is read from environment variables prefixed with ANUBOT_.
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/dispatch"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// Endpoints that the components use to communicate. Each may be set to a
+// space separated list of tcp://, ipc:// or inproc:// endpoints when using
+// the zmq bus. The defaults are only reachable within a single process.
+const (
+	// DispatchPullEndpoints are where stream managers push messages to the
+	// dispatcher.
+	DispatchPullEndpoints = "dispatch_pull_endpoints"
+	// DispatchPubEndpoints are where the dispatcher publishes messages to
+	// the API and bots.
+	DispatchPubEndpoints = "dispatch_pub_endpoints"
+	// DispatchPushEndpoints are where the dispatcher pushes messages to
+	// pullers.
+	DispatchPushEndpoints = "dispatch_push_endpoints"
+	// DispatchAckEndpoints are where pullers acknowledge spooled messages.
+	DispatchAckEndpoints = "dispatch_ack_endpoints"
+	// StreamerEndpoints are where stream managers accept commands. When
+	// set the API controls the stream managers at these endpoints instead
+	// of running its own.
+	StreamerEndpoints = "streamer_endpoints"
+	// StreamerReplyEndpoints are where stream managers reply to commands.
+	// The API needs one for each of the streamer endpoints, in the same
+	// order.
+	StreamerReplyEndpoints = "streamer_reply_endpoints"
+)
+
+// New returns a viper that reads the configuration from the environment.
+func New() *viper.Viper {
+	v := viper.New()
+	v.SetEnvPrefix("anubot")
+	v.AutomaticEnv()
+	v.SetDefault("bus", "memory")
+	v.SetDefault(DispatchPullEndpoints, []string{"inproc://dispatch-pull"})
+	v.SetDefault(DispatchPubEndpoints, []string{"inproc://dispatch-pub"})
+	v.SetDefault(DispatchPushEndpoints, []string{"inproc://dispatch-push"})
+	v.SetDefault(DispatchAckEndpoints, []string{"inproc://dispatch-ack"})
+	v.SetDefault(StreamerEndpoints, []string{})
+	v.SetDefault(StreamerReplyEndpoints, []string{})
+	v.SetDefault("stream_dispatch_buffer", 1000)
+	v.SetDefault("shutdown_timeout", 30*time.Second)
+	v.SetDefault("oauth_nonce_ttl", store.DefaultNonceTTL)
+	return v
+}
+
+// buses are the buses that can be configured. The zmq bus is only
+// registered when built with the zmq tag as it requires libzmq.
+var buses = map[string]func() bus.Bus{
+	"memory": func() bus.Bus {
+		return bus.Default
+	},
+}
+
+// Bus returns the configured bus. The zmq bus is needed to run the
+// components in separate processes. The memory bus does not use libzmq but
+// only connects components running in the same process.
+func Bus(v *viper.Viper) bus.Bus {
+	name := v.GetString("bus")
+	newBus, ok := buses[name]
+	if !ok {
+		if name == "zmq" {
+			log.Panicf("zmq bus is not available, build with -tags zmq")
+		}
+		log.Panicf("unknown bus: %s", name)
+	}
+	return newBus()
+}
+
+// Endpoints returns the endpoints configured for the key.
+func Endpoints(v *viper.Viper, key string) []string {
+	return v.GetStringSlice(key)
+}
+
+// Store opens the configured store backend. Only the postgres backend can
+// be shared by multiple processes.
+func Store(v *viper.Viper) store.Store {
+	nonceTTL := v.GetDuration("oauth_nonce_ttl")
+	backend := v.GetString("store_backend")
+	switch backend {
+	case "postgres":
+		key, err := base64.RawStdEncoding.DecodeString(v.GetString("encryption_key"))
+		if err != nil {
+			log.Panicf("unable to decode encryption key: %s", err)
+		}
+		st, err := store.NewPostgres(
+			v.GetString("store_postgres_url"),
+			key,
+			store.WithNonceTTL(nonceTTL),
+		)
+		if err != nil {
+			log.Panicf("unable to open postgres database: %s", err)
+		}
+		err = st.Ping()
+		if err != nil {
+			log.Panicf("unable to ping postgres database: %s", err)
+		}
+		return st
+	case "bolt":
+		st, err := store.NewBolt(
+			v.GetString("store_bolt_path"),
+			store.WithNonceTTL(nonceTTL),
+		)
+		if err != nil {
+			log.Panicf("unable to create bolt database: %s", err)
+		}
+		return st
+	case "dummy":
+		log.Panicf("dummy store backend is not wired up")
+	default:
+		log.Panicf("unknown store backend: %s", backend)
+	}
+	return nil
+}
+
+// Dispatcher starts a dispatcher bound to the configured endpoints. When a
+// spool path is configured messages are kept on disk until they have been
+// stored.
+func Dispatcher(v *viper.Viper) *dispatch.Dispatcher {
+	opts := []dispatch.Option{
+		dispatch.WithBus(Bus(v)),
+		dispatch.WithPullEndpoints(Endpoints(v, DispatchPullEndpoints)),
+		dispatch.WithPubEndpoints(Endpoints(v, DispatchPubEndpoints)),
+		dispatch.WithPushEndpoints(Endpoints(v, DispatchPushEndpoints)),
+		dispatch.WithAckEndpoints(Endpoints(v, DispatchAckEndpoints)),
+	}
+	if path := v.GetString("dispatch_spool_path"); path != "" {
+		opts = append(opts, dispatch.WithSpool(path))
+	}
+	return dispatch.Start(opts...)
+}
+
+// Puller creates a puller that stores the messages pushed by the
+// dispatcher. It needs to be started.
+func Puller(v *viper.Viper, st store.MessageStorer) *store.Puller {
+	puller, err := store.NewPuller(
+		st,
+		store.WithBus(Bus(v)),
+		store.WithPullEndpoints(Endpoints(v, DispatchPushEndpoints)),
+		store.WithAckEndpoints(Endpoints(v, DispatchAckEndpoints)),
+	)
+	if err != nil {
+		log.Panicf("pull not able to connect, got err: %s", err)
+	}
+	return puller
+}
+
+// StreamManager creates a stream manager that pushes to the dispatcher.
+func StreamManager(
+	v *viper.Viper,
+	twitch stream.TwitchUserIDFetcher,
+	refresher stream.TokenRefresher,
+) *stream.Manager {
+	return stream.NewManager(
+		twitch,
+		stream.WithBus(Bus(v)),
+		stream.WithPushEndpoints(Endpoints(v, DispatchPullEndpoints)),
+		stream.WithTokenRefresher(refresher),
+		stream.WithDispatchBuffer(v.GetInt("stream_dispatch_buffer")),
+	)
+}
+
+// WaitForSignal blocks until the process is asked to terminate or an error
+// is received.
+func WaitForSignal(errs <-chan error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errs:
+		log.Panic(err)
+	case sig := <-signals:
+		log.Printf("received signal: %s, shutting down", sig)
+	}
+}
+
+// Component is something that needs to be shut down before exiting.
+type Component struct {
+	Name     string
+	Shutdown func(context.Context) error
+}
+
+// Shutdown shuts down the components in order. Components should be given
+// in the order messages flow through them so that messages that have been
+// received are stored before exiting. All of the components share the
+// configured shutdown timeout.
+func Shutdown(v *viper.Viper, components ...Component) {
+	ctx, cancel := context.WithTimeout(context.Background(), v.GetDuration("shutdown_timeout"))
+	defer cancel()
+	for _, c := range components {
+		err := c.Shutdown(ctx)
+		if err != nil {
+			log.Printf("unable to shut down %s cleanly: %s", c.Name, err)
+		}
+	}
+}
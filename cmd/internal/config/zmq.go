@@ -0,0 +1,15 @@
+//go:build zmq
+// +build zmq
+
+package config
+
+import (
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/bus/zmq"
+)
+
+func init() {
+	buses["zmq"] = func() bus.Bus {
+		return zmq.New()
+	}
+}
@@ -0,0 +1,26 @@
+// Command puller runs a puller on its own that stores the messages pushed
+// by the dispatcher.
+package main
+
+import (
+	"log"
+
+	"github.com/jasonkeene/anubot-server/cmd/internal/config"
+)
+
+func main() {
+	v := config.New()
+	st := config.Store(v)
+	puller := config.Puller(v, st)
+	go puller.Start()
+
+	config.WaitForSignal(nil)
+	config.Shutdown(v, config.Component{
+		Name:     "puller",
+		Shutdown: puller.Shutdown,
+	})
+	err := st.Close()
+	if err != nil {
+		log.Printf("unable to close store: %s", err)
+	}
+}
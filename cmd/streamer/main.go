@@ -0,0 +1,68 @@
+// Command streamer runs a stream manager on its own that maintains the
+// connections to twitch and Discord. The API controls it through the
+// streamer endpoints and it pushes the messages it receives to the
+// dispatcher. It needs to be built with the zmq tag and use the zmq bus to
+// be reachable from other processes.
+package main
+
+import (
+	"log"
+
+	"github.com/fluffle/goirc/logging/golog"
+
+	"github.com/jasonkeene/anubot-server/cmd/internal/config"
+	"github.com/jasonkeene/anubot-server/stream/remote"
+	"github.com/jasonkeene/anubot-server/twitch"
+	"github.com/jasonkeene/anubot-server/twitch/oauth"
+)
+
+func init() {
+	golog.Init()
+}
+
+func main() {
+	v := config.New()
+	endpoints := config.Endpoints(v, config.StreamerEndpoints)
+	if len(endpoints) == 0 {
+		log.Panicf("no streamer endpoints are configured")
+	}
+	replyEndpoints := config.Endpoints(v, config.StreamerReplyEndpoints)
+	if len(replyEndpoints) == 0 {
+		log.Panicf("no streamer reply endpoints are configured")
+	}
+
+	// the store is used to save tokens that have been refreshed
+	st := config.Store(v)
+	refresher := oauth.NewRefresher(
+		v.GetString("twitch_oauth_client_id"),
+		v.GetString("twitch_oauth_client_secret"),
+		st,
+	)
+	twitchClient := twitch.New(
+		v.GetString("twitch_api_url"),
+		v.GetString("twitch_oauth_client_id"),
+		twitch.WithTokenRefresher(refresher),
+	)
+
+	manager := config.StreamManager(v, twitchClient, refresher)
+	server, err := remote.NewServer(
+		manager,
+		endpoints,
+		replyEndpoints,
+		remote.WithBus(config.Bus(v)),
+	)
+	if err != nil {
+		log.Panicf("unable to bind streamer endpoints: %s", err)
+	}
+	go server.Start()
+
+	config.WaitForSignal(nil)
+	config.Shutdown(v,
+		config.Component{Name: "server", Shutdown: server.Shutdown},
+		config.Component{Name: "stream manager", Shutdown: manager.Shutdown},
+	)
+	err = st.Close()
+	if err != nil {
+		log.Printf("unable to close store: %s", err)
+	}
+}
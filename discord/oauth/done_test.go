@@ -0,0 +1,131 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestThatFinishingTheFlowLinksTheGuild(t *testing.T) {
+	expect := expect.New(t)
+
+	var form url.Values
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = r.PostForm
+		fmt.Fprint(w, `{
+			"access_token": "test-access-token",
+			"guild": {"id": "test-guild-id", "owner_id": "test-owner-id"}
+		}`)
+	}))
+	defer discord.Close()
+
+	ns := &fakeNonceStore{exists: true}
+	h := NewDoneHandler(
+		"test-client-id",
+		"test-client-secret",
+		"test-redirect-uri",
+		ns,
+		WithTokenURL(discord.URL),
+	)
+	var called bool
+	h.RegisterCompletionCallback("test-nonce", func() {
+		called = true
+	})
+
+	req := httptest.NewRequest("GET", "/v1/discord_oauth/done?state=test-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusOK)
+	expect(form.Get("client_id")).To.Equal("test-client-id")
+	expect(form.Get("client_secret")).To.Equal("test-client-secret")
+	expect(form.Get("redirect_uri")).To.Equal("test-redirect-uri")
+	expect(form.Get("code")).To.Equal("test-code")
+	expect(ns.finishedNonce).To.Equal("test-nonce")
+	expect(ns.finishedOwnerID).To.Equal("test-owner-id")
+	expect(ns.finishedGuildID).To.Equal("test-guild-id")
+	expect(called).To.Be.True()
+}
+
+func TestThatResponsesWithoutAGuildAreRejected(t *testing.T) {
+	expect := expect.New(t)
+
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token": "test-access-token"}`)
+	}))
+	defer discord.Close()
+
+	ns := &fakeNonceStore{exists: true}
+	h := NewDoneHandler("", "", "", ns, WithTokenURL(discord.URL))
+
+	req := httptest.NewRequest("GET", "/v1/discord_oauth/done?state=test-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusBadRequest)
+	expect(ns.finishedNonce).To.Equal("")
+}
+
+func TestThatCallbacksForBadNoncesAreDiscarded(t *testing.T) {
+	expect := expect.New(t)
+
+	h := NewDoneHandler("", "", "", &fakeNonceStore{})
+	var called bool
+	h.RegisterCompletionCallback("expired-nonce", func() {
+		called = true
+	})
+
+	req := httptest.NewRequest("GET", "/v1/discord_oauth/done?state=expired-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusBadRequest)
+	expect(called).To.Be.False()
+}
+
+func TestThatTheURLAddsTheBotToAGuild(t *testing.T) {
+	expect := expect.New(t)
+
+	u, err := url.Parse(URL("test-client-id", "test-redirect-uri", "test-nonce"))
+	expect(err).To.Be.Nil().Else.FailNow()
+
+	values := u.Query()
+	expect(values.Get("client_id")).To.Equal("test-client-id")
+	expect(values.Get("redirect_uri")).To.Equal("test-redirect-uri")
+	expect(values.Get("state")).To.Equal("test-nonce")
+	expect(values.Get("scope")).To.Equal("bot")
+	expect(values.Get("response_type")).To.Equal("code")
+}
+
+type fakeNonceStore struct {
+	exists bool
+
+	finishedNonce   string
+	finishedOwnerID string
+	finishedGuildID string
+}
+
+func (s *fakeNonceStore) DiscordOauthNonceExists(nonce string) (bool, error) {
+	return s.exists, nil
+}
+
+func (s *fakeNonceStore) FinishDiscordOauthNonce(nonce, ownerID, guildID string) error {
+	if !s.exists {
+		return store.ErrUnknownNonce
+	}
+	s.finishedNonce = nonce
+	s.finishedOwnerID = ownerID
+	s.finishedGuildID = guildID
+	return nil
+}
@@ -0,0 +1,154 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/internal/oauthflow"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// NonceStore is used to to store and operate on Discord oauth nonces.
+type NonceStore interface {
+	DiscordOauthNonceExists(nonce string) (exists bool, err error)
+	FinishDiscordOauthNonce(nonce, ownerID, guildID string) (err error)
+}
+
+const (
+	discordBaseURL = "https://discordapp.com/api/"
+	authorizeURL   = discordBaseURL + "oauth2/authorize"
+	tokenURL       = discordBaseURL + "oauth2/token"
+	scopes         = "bot"
+	// permissions allows the bot to read and send messages, read message
+	// history, add reactions and manage messages.
+	permissions = "76864"
+)
+
+// tokenResponse is the part of the response from Discord when finishing the
+// oauth flow that identifies the guild the bot was added to.
+type tokenResponse struct {
+	Guild struct {
+		ID      string `json:"id"`
+		OwnerID string `json:"owner_id"`
+	} `json:"guild"`
+}
+
+func parseTokenResponse(data []byte) (tokenResponse, error) {
+	var tr tokenResponse
+	err := json.Unmarshal(data, &tr)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if tr.Guild.ID == "" || tr.Guild.OwnerID == "" {
+		return tokenResponse{}, errors.New("guild not present in token response")
+	}
+	return tr, nil
+}
+
+// DoneHandler is where the redirect URI hits to finish the Discord oauth
+// flow that adds the bot to a user's guild.
+type DoneHandler struct {
+	*oauthflow.DoneHandler
+}
+
+// doneConfig is what a DoneHandler is created with.
+type doneConfig struct {
+	tokenURL    string
+	callbackTTL time.Duration
+}
+
+// DoneHandlerOption is used to configure a DoneHandler.
+type DoneHandlerOption func(*doneConfig)
+
+// WithCallbackTTL allows you to override how long completion callbacks are
+// kept for oauth flows that never complete. This should match the nonce TTL
+// of the store.
+func WithCallbackTTL(ttl time.Duration) DoneHandlerOption {
+	return func(c *doneConfig) {
+		c.callbackTTL = ttl
+	}
+}
+
+// WithTokenURL allows you to override the URL the code is exchanged at.
+func WithTokenURL(u string) DoneHandlerOption {
+	return func(c *doneConfig) {
+		c.tokenURL = u
+	}
+}
+
+// NewDoneHandler creates a new handler to finish the oauth flow.
+func NewDoneHandler(
+	discordOauthClientID,
+	discordOauthClientSecret,
+	discordOauthRedirectURI string,
+	ns NonceStore,
+	opts ...DoneHandlerOption,
+) *DoneHandler {
+	cfg := doneConfig{
+		tokenURL:    tokenURL,
+		callbackTTL: store.DefaultNonceTTL,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &DoneHandler{
+		DoneHandler: oauthflow.NewDoneHandler(
+			"discord",
+			cfg.tokenURL,
+			"Done connecting your Discord server.",
+			flow{
+				discordOauthClientID:     discordOauthClientID,
+				discordOauthClientSecret: discordOauthClientSecret,
+				discordOauthRedirectURI:  discordOauthRedirectURI,
+				ns:                       ns,
+			},
+			cfg.callbackTTL,
+		),
+	}
+}
+
+// flow exchanges codes for the guild the bot was added to and links it to
+// the user.
+type flow struct {
+	discordOauthClientID     string
+	discordOauthClientSecret string
+	discordOauthRedirectURI  string
+	ns                       NonceStore
+}
+
+func (f flow) NonceExists(nonce string) (bool, error) {
+	return f.ns.DiscordOauthNonceExists(nonce)
+}
+
+func (f flow) Payload(nonce, code string) url.Values {
+	payload := url.Values{}
+	payload.Set("client_id", f.discordOauthClientID)
+	payload.Set("client_secret", f.discordOauthClientSecret)
+	payload.Set("redirect_uri", f.discordOauthRedirectURI)
+	payload.Set("grant_type", "authorization_code")
+	payload.Set("code", code)
+	return payload
+}
+
+func (f flow) Finish(nonce string, token []byte) error {
+	tr, err := parseTokenResponse(token)
+	if err != nil {
+		return err
+	}
+	return f.ns.FinishDiscordOauthNonce(nonce, tr.Guild.OwnerID, tr.Guild.ID)
+}
+
+// URL returns a URL that will start the oauth flow to add the bot to a
+// guild the user manages.
+func URL(clientID, redirectURI, nonce string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", scopes)
+	v.Set("permissions", permissions)
+	v.Set("client_id", clientID)
+	v.Set("state", nonce)
+	return authorizeURL + "?" + v.Encode()
+}
@@ -0,0 +1,54 @@
+package dispatch
+
+import "time"
+
+// defaultDedupWindow is how long the keys of dispatched messages are
+// remembered for. Copies of a line are received by each connection within
+// moments of each other so this only needs to cover delays in delivery.
+const defaultDedupWindow = 10 * time.Second
+
+// dedup remembers the keys of recently dispatched messages.
+type dedup struct {
+	window time.Duration
+	keys   map[string]time.Time
+	// order has the keys in the order they were seen so they can be
+	// forgotten once they are outside of the window.
+	order []dedupEntry
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+func newDedup(window time.Duration) *dedup {
+	return &dedup{
+		window: window,
+		keys:   make(map[string]time.Time),
+	}
+}
+
+// seen returns true if the key was seen within the window. Otherwise the key
+// is remembered and it returns false.
+func (d *dedup) seen(key string, now time.Time) bool {
+	d.expire(now)
+	if _, ok := d.keys[key]; ok {
+		return true
+	}
+	d.keys[key] = now
+	d.order = append(d.order, dedupEntry{
+		key:  key,
+		seen: now,
+	})
+	return false
+}
+
+// expire forgets the keys that were seen before the window.
+func (d *dedup) expire(now time.Time) {
+	cutoff := now.Add(-d.window)
+	i := 0
+	for ; i < len(d.order) && !d.order[i].seen.After(cutoff); i++ {
+		delete(d.keys, d.order[i].key)
+	}
+	d.order = d.order[i:]
+}
@@ -1,24 +1,71 @@
 package dispatch
 
 import (
+	"context"
+	"expvar"
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/pebbe/zmq4"
+	"github.com/jasonkeene/anubot-server/bus"
 )
 
+var (
+	// pollTimeout is how long the dispatcher waits for messages before
+	// checking if it has been stopped, reading acks and redelivering
+	// spooled messages.
+	pollTimeout = 100 * time.Millisecond
+	// maxBatch is the most messages that are read from the pull socket
+	// before they are spooled.
+	maxBatch = 1000
+	// spoolWindow is the most spooled messages that are waiting to be
+	// acknowledged at once.
+	spoolWindow = 100
+	// defaultAckTimeout is how long to wait for a spooled message to be
+	// acknowledged before it is pushed again.
+	defaultAckTimeout = 10 * time.Second
+)
+
+// metrics are published with expvar. Dropped messages are counted by
+// push_dropped and spool_errors.
+var metrics = expvar.NewMap("dispatch")
+
 // Dispatcher receives messages and sends them to the appropriate locations.
 // It is meant to be easily horizontally scalable.
 //
-// Messages are read from a pull socket as a two frame zmq message. The first
-// frame is the topic used to publish the message. The second frame is the
-// actual message data.
+// Messages are pulled from the bus as a two frame message. The first frame
+// is the topic used to publish the message. The second frame is the actual
+// message data. An optional third frame is a key that identifies the
+// message. Messages with a key that was seen within the dedup window are
+// dropped so that lines received by more than one connection are only
+// dispatched once.
+//
+// Messages are published on the bus and pushed to be stored. Pushing does
+// not wait for a puller so messages are dropped if none are keeping up.
+// When a spool is configured messages are instead written to disk and
+// pushed along with their sequence number until a puller acknowledges them
+// by pushing the sequence number back. Messages that are still in the spool
+// when the dispatcher starts are pushed again.
 type Dispatcher struct {
 	pullEndpoints []string
 	pubEndpoints  []string
 	pushEndpoints []string
-	pull          *zmq4.Socket
-	pub           *zmq4.Socket
-	push          *zmq4.Socket
+	ackEndpoints  []string
+	bus           bus.Bus
+	pull          bus.Puller
+	pub           bus.Publisher
+	push          bus.Pusher
+	ack           bus.Puller
+	dedup         *dedup
+	spoolPath     string
+	spool         *spool
+	ackTimeout    time.Duration
+	// inflight has when spooled messages were last pushed.
+	inflight map[uint64]time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
 // Option is used to configure a Dispatcher.
@@ -45,16 +92,73 @@ func WithPushEndpoints(endpoints []string) Option {
 	}
 }
 
+// WithBus allows you to override the default bus.
+func WithBus(b bus.Bus) Option {
+	return func(d *Dispatcher) {
+		d.bus = b
+	}
+}
+
+// WithDedupWindow allows you to override how long the keys of dispatched
+// messages are remembered for.
+func WithDedupWindow(window time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.dedup.window = window
+	}
+}
+
+// WithAckEndpoints allows you to override the default ack endpoints. They
+// are only bound when a spool is configured.
+func WithAckEndpoints(endpoints []string) Option {
+	return func(d *Dispatcher) {
+		d.ackEndpoints = endpoints
+	}
+}
+
+// WithSpool writes messages to a spool at the given path until they are
+// acknowledged by a puller so they are not lost when no puller is keeping
+// up or the dispatcher is restarted.
+func WithSpool(path string) Option {
+	return func(d *Dispatcher) {
+		d.spoolPath = path
+	}
+}
+
+// WithAckTimeout allows you to override how long to wait for spooled
+// messages to be acknowledged before they are pushed again.
+func WithAckTimeout(timeout time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.ackTimeout = timeout
+	}
+}
+
 // Start creates a new dispatcher and starts it.
 func Start(opts ...Option) *Dispatcher {
 	d := &Dispatcher{
 		pullEndpoints: []string{"inproc://dispatch-pull"},
 		pubEndpoints:  []string{"inproc://dispatch-pub"},
 		pushEndpoints: []string{"inproc://dispatch-push"},
+		ackEndpoints:  []string{"inproc://dispatch-ack"},
+		bus:           bus.Default,
+		dedup:         newDedup(defaultDedupWindow),
+		ackTimeout:    defaultAckTimeout,
+		inflight:      make(map[uint64]time.Time),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 	for _, opt := range opts {
 		opt(d)
 	}
+	if d.spoolPath != "" {
+		var err error
+		d.spool, err = openSpool(d.spoolPath)
+		if err != nil {
+			log.Panicf("Start: can not open spool: %s", err)
+		}
+		metrics.Set("spool_depth", expvar.Func(func() interface{} {
+			return d.spool.depth()
+		}))
+	}
 	d.setupSockets()
 	go d.run()
 	return d
@@ -62,61 +166,244 @@ func Start(opts ...Option) *Dispatcher {
 
 func (d *Dispatcher) setupSockets() {
 	var err error
-	d.pull, err = zmq4.NewSocket(zmq4.PULL)
+	d.pull, err = d.bus.Puller(bus.Bind, d.pullEndpoints)
 	if err != nil {
-		log.Panicf("Dispatcher.setupSockets: can not create dispatcher pull socket: %s", err)
+		log.Panicf("Dispatcher.setupSockets: can not bind pull socket: %s", err)
 	}
-	for _, endpoint := range d.pullEndpoints {
-		err = d.pull.Bind(endpoint)
-		if err != nil {
-			log.Panicf("Dispatcher.setupSockets: can not bind pull socket: %s", err)
-		}
+
+	d.pub, err = d.bus.Publisher(d.pubEndpoints)
+	if err != nil {
+		log.Panicf("Dispatcher.setupSockets: can not bind publish socket: %s", err)
 	}
 
-	d.pub, err = zmq4.NewSocket(zmq4.PUB)
+	d.push, err = d.bus.Pusher(bus.Bind, d.pushEndpoints)
 	if err != nil {
-		log.Panicf("Dispatcher.setupSockets: can not create dispatcher publish socket: %s", err)
+		log.Panicf("Dispatcher.setupSockets: can not bind push socket: %s", err)
+	}
+
+	if d.spool == nil {
+		return
+	}
+	d.ack, err = d.bus.Puller(bus.Bind, d.ackEndpoints)
+	if err != nil {
+		log.Panicf("Dispatcher.setupSockets: can not bind ack socket: %s", err)
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	defer d.closeSockets()
+
+	for {
+		select {
+		case <-d.stop:
+			// messages that have already been received are dispatched
+			// and spooled messages are given a last chance to be pushed
+			for d.receive(0) > 0 {
+			}
+			d.receiveAcks()
+			d.deliver(time.Now())
+			return
+		default:
+		}
+
+		d.receive(pollTimeout)
+		d.receiveAcks()
+		d.deliver(time.Now())
 	}
-	for _, endpoint := range d.pubEndpoints {
-		err = d.pub.Bind(endpoint)
+}
+
+// receive waits up to the timeout for a message and then dispatches it
+// along with the messages that have already been received without waiting
+// for more. It returns how many messages were read.
+func (d *Dispatcher) receive(timeout time.Duration) int {
+	var (
+		spooled [][]byte
+		i       int
+	)
+	for ; i < maxBatch; i++ {
+		if i > 0 {
+			timeout = 0
+		}
+		parts, err := d.pull.Pull(timeout)
 		if err != nil {
-			log.Panicf("Dispatcher.setupSockets: can not bind publish socket: %s", err)
+			if err != bus.ErrTimeout {
+				log.Printf("Dispatcher.receive: error occurred when reading from pull socket: %s", err)
+			}
+			break
+		}
+		message, ok := d.dispatch(parts)
+		if !ok {
+			continue
+		}
+		if d.spool == nil {
+			d.pushNow(message)
+			continue
 		}
+		spooled = append(spooled, message)
 	}
+	d.spoolMessages(spooled)
+	return i
+}
 
-	d.push, err = zmq4.NewSocket(zmq4.PUSH)
+// dispatch publishes the message and returns it so that it can be pushed.
+// It returns false if the message is not valid or is a duplicate.
+func (d *Dispatcher) dispatch(parts [][]byte) ([]byte, bool) {
+	if len(parts) != 2 && len(parts) != 3 {
+		log.Printf("Dispatcher.dispatch: not the right count of parts, expected 2 or 3, was: %v", parts)
+		return nil, false
+	}
+	topic := parts[0]
+	message := parts[1]
+	if len(parts) == 3 && d.dedup.seen(string(parts[2]), time.Now()) {
+		metrics.Add("duplicates", 1)
+		return nil, false
+	}
+	metrics.Add("dispatched", 1)
+
+	err := d.pub.Publish(string(topic), message)
 	if err != nil {
-		log.Panicf("Dispatcher.setupSockets: can not create dispatcher push socket: %s", err)
+		log.Printf("Dispatcher.dispatch: got error publishing message: %s", err)
 	}
-	for _, endpoint := range d.pushEndpoints {
-		err = d.push.Bind(endpoint)
-		if err != nil {
-			log.Panicf("Dispatcher.setupSockets: can not bind push socket: %s", err)
+	return message, true
+}
+
+// pushNow pushes the message without spooling it. The message is dropped
+// if it can not be pushed immediately.
+func (d *Dispatcher) pushNow(message []byte) {
+	err := d.push.TryPush(message)
+	if err != nil {
+		metrics.Add("push_dropped", 1)
+		log.Printf("Dispatcher.pushNow: got error pushing message: %s", err)
+	}
+}
+
+// spoolMessages writes the messages to the spool to be delivered. If they
+// can not be spooled they are pushed without being spooled.
+func (d *Dispatcher) spoolMessages(messages [][]byte) {
+	if len(messages) == 0 {
+		return
+	}
+	err := d.spool.add(messages)
+	if err != nil {
+		metrics.Add("spool_errors", 1)
+		log.Printf("Dispatcher.spoolMessages: got error spooling messages: %s", err)
+		for _, message := range messages {
+			d.pushNow(message)
 		}
+		return
 	}
+	metrics.Add("spooled", int64(len(messages)))
 }
 
-func (d *Dispatcher) run() {
-	for {
-		parts, err := d.pull.RecvMessageBytes(0)
-		if err != nil {
-			log.Printf("Dispatcher.run: error occurred when reading from pull socket: %s", err)
+// deliver pushes the oldest spooled messages that have not been pushed or
+// have not been acknowledged within the ack timeout.
+func (d *Dispatcher) deliver(now time.Time) {
+	if d.spool == nil {
+		return
+	}
+	entries, err := d.spool.pending(spoolWindow)
+	if err != nil {
+		log.Printf("Dispatcher.deliver: got error reading spool: %s", err)
+		return
+	}
+	for _, e := range entries {
+		pushed, ok := d.inflight[e.seq]
+		if ok && now.Sub(pushed) < d.ackTimeout {
 			continue
 		}
-		if len(parts) != 2 {
-			log.Printf("Dispatcher.run: not the right count of parts, expected 2, was: %v", parts)
-			continue
+		err := d.push.TryPush([]byte(strconv.FormatUint(e.seq, 10)), e.message)
+		if err != nil {
+			// no puller is ready for messages, they remain in the spool
+			// until one is
+			return
 		}
-		topic := parts[0]
-		message := parts[1]
+		if ok {
+			metrics.Add("redelivered", 1)
+		}
+		d.inflight[e.seq] = now
+	}
+}
 
-		_, err = d.pub.SendMessage(topic, message)
+// receiveAcks removes the messages that pullers have acknowledged from the
+// spool.
+func (d *Dispatcher) receiveAcks() {
+	if d.spool == nil {
+		return
+	}
+	var seqs []uint64
+	for i := 0; i < maxBatch; i++ {
+		parts, err := d.ack.Pull(0)
 		if err != nil {
-			log.Printf("Dispatcher.run: got error publishing message: %s", err)
+			if err != bus.ErrTimeout {
+				log.Printf("Dispatcher.receiveAcks: error occurred when reading from ack socket: %s", err)
+			}
+			break
+		}
+		if len(parts) != 1 {
+			log.Printf("Dispatcher.receiveAcks: not the right count of parts, expected 1, was: %v", parts)
+			continue
 		}
-		_, err = d.push.SendBytes(message, zmq4.DONTWAIT)
+		seq, err := strconv.ParseUint(string(parts[0]), 10, 64)
 		if err != nil {
-			log.Printf("Dispatcher.run: got error pushing message: %s", err)
+			log.Printf("Dispatcher.receiveAcks: got invalid ack: %q", parts[0])
+			continue
 		}
+		seqs = append(seqs, seq)
+	}
+	if len(seqs) == 0 {
+		return
+	}
+	err := d.spool.ack(seqs)
+	if err != nil {
+		log.Printf("Dispatcher.receiveAcks: got error removing acknowledged messages from spool: %s", err)
+		return
+	}
+	for _, seq := range seqs {
+		delete(d.inflight, seq)
+	}
+	metrics.Add("acked", int64(len(seqs)))
+}
+
+func (d *Dispatcher) closeSockets() {
+	err := d.pull.Close()
+	if err != nil {
+		log.Printf("Dispatcher.closeSockets: got err while closing pull socket: %s", err)
+	}
+	err = d.pub.Close()
+	if err != nil {
+		log.Printf("Dispatcher.closeSockets: got err while closing pub socket: %s", err)
+	}
+	err = d.push.Close()
+	if err != nil {
+		log.Printf("Dispatcher.closeSockets: got err while closing push socket: %s", err)
+	}
+	if d.spool == nil {
+		return
+	}
+	err = d.ack.Close()
+	if err != nil {
+		log.Printf("Dispatcher.closeSockets: got err while closing ack socket: %s", err)
+	}
+	err = d.spool.close()
+	if err != nil {
+		log.Printf("Dispatcher.closeSockets: got err while closing spool: %s", err)
+	}
+}
+
+// Shutdown stops reading from the pull socket, dispatches the messages that
+// have already been received and then closes the sockets. Spooled messages
+// that have not been acknowledged remain in the spool to be pushed when the
+// dispatcher is started again. If the context is
+// done before shutdown has completed its error is returned.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
@@ -1,45 +1,195 @@
 package dispatch_test
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/a8m/expect"
-	"github.com/pebbe/zmq4"
 
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/dispatch"
 )
 
 func TestDispatcher(t *testing.T) {
 	expect := expect.New(t)
 
+	b := bus.NewMemory()
 	ep := endpoints()
 	dispatch.Start(
+		dispatch.WithBus(b),
 		dispatch.WithPullEndpoints([]string{ep["pull"]}),
 		dispatch.WithPubEndpoints([]string{ep["pub"]}),
 		dispatch.WithPushEndpoints([]string{ep["push"]}),
 	)
 
-	push := setupPushSocket(expect, ep["pull"])
-	sub := setupSubSocket(expect, "test-topic", ep["pub"])
-	pull := setupPullSocket(expect, ep["push"])
+	push := setupPusher(expect, b, ep["pull"])
+	sub := setupSubscription(expect, b, "test-topic", ep["pub"])
+	pull := setupPuller(expect, b, ep["push"])
 
-	_, err := push.SendBytes([]byte("test-topic"), zmq4.SNDMORE)
+	err := push.Push([]byte("test-topic"), []byte("test-content"))
 	expect(err).To.Be.Nil().Else.FailNow()
-	_, err = push.SendBytes([]byte("test-content"), 0)
+
+	topic, msg, err := sub.Receive(time.Second)
 	expect(err).To.Be.Nil().Else.FailNow()
+	expect(topic).To.Equal("test-topic")
+	expect(msg).To.Equal([]byte("test-content"))
 
-	parts, err := sub.RecvMessageBytes(0)
+	parts, err := pull.Pull(time.Second)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(parts).To.Equal([][]byte{[]byte("test-content")})
+}
+
+func TestDispatcherShutdownDrainsReceivedMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	b := bus.NewMemory()
+	ep := endpoints()
+	start := func() *dispatch.Dispatcher {
+		return dispatch.Start(
+			dispatch.WithBus(b),
+			dispatch.WithPullEndpoints([]string{ep["pull"]}),
+			dispatch.WithPubEndpoints([]string{ep["pub"]}),
+			dispatch.WithPushEndpoints([]string{ep["push"]}),
+		)
+	}
+	d := start()
+
+	push := setupPusher(expect, b, ep["pull"])
+	pull := setupPuller(expect, b, ep["push"])
+	for i := 0; i < 3; i++ {
+		err := push.Push([]byte("test-topic"), []byte(fmt.Sprintf("test-content-%d", i)))
+		expect(err).To.Be.Nil().Else.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	expect(d.Shutdown(ctx)).To.Be.Nil().Else.FailNow()
+
+	for i := 0; i < 3; i++ {
+		parts, err := pull.Pull(time.Second)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(parts).To.Equal([][]byte{[]byte(fmt.Sprintf("test-content-%d", i))})
+	}
+
+	// the endpoints can be bound again once the sockets have been closed
+	d = start()
+	expect(d.Shutdown(ctx)).To.Be.Nil()
+}
+
+func TestDispatcherDropsDuplicateMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	b := bus.NewMemory()
+	ep := endpoints()
+	d := dispatch.Start(
+		dispatch.WithBus(b),
+		dispatch.WithPullEndpoints([]string{ep["pull"]}),
+		dispatch.WithPubEndpoints([]string{ep["pub"]}),
+		dispatch.WithPushEndpoints([]string{ep["push"]}),
+		dispatch.WithDedupWindow(time.Minute),
+	)
+	defer d.Shutdown(context.Background())
+
+	push := setupPusher(expect, b, ep["pull"])
+	pull := setupPuller(expect, b, ep["push"])
+
+	messages := [][]string{
+		{"test-topic", "test-content-0", "test-key-0"},
+		{"test-topic", "test-content-0-copy", "test-key-0"},
+		{"test-topic", "test-content-1", "test-key-1"},
+		{"test-topic", "test-content-2"},
+		{"test-topic", "test-content-2"},
+	}
+	for _, m := range messages {
+		var frames [][]byte
+		for _, f := range m {
+			frames = append(frames, []byte(f))
+		}
+		err := push.Push(frames...)
+		expect(err).To.Be.Nil().Else.FailNow()
+	}
+
+	for _, content := range []string{
+		"test-content-0",
+		"test-content-1",
+		"test-content-2",
+		"test-content-2",
+	} {
+		parts, err := pull.Pull(time.Second)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(parts).To.Equal([][]byte{[]byte(content)})
+	}
+	_, err := pull.Pull(time.Second)
+	expect(err).To.Equal(bus.ErrTimeout)
+}
+
+func TestDispatcherRedeliversSpooledMessagesUntilAcknowledged(t *testing.T) {
+	expect := expect.New(t)
+	path, cleanup := tempFile(t)
+	defer cleanup()
+
+	b := bus.NewMemory()
+	ep := endpoints()
+	start := func() *dispatch.Dispatcher {
+		return dispatch.Start(
+			dispatch.WithBus(b),
+			dispatch.WithPullEndpoints([]string{ep["pull"]}),
+			dispatch.WithPubEndpoints([]string{ep["pub"]}),
+			dispatch.WithPushEndpoints([]string{ep["push"]}),
+			dispatch.WithAckEndpoints([]string{ep["ack"]}),
+			dispatch.WithSpool(path),
+			dispatch.WithAckTimeout(100*time.Millisecond),
+		)
+	}
+	shutdown := func(d *dispatch.Dispatcher) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		expect(d.Shutdown(ctx)).To.Be.Nil().Else.FailNow()
+	}
+	d := start()
+
+	push := setupPusher(expect, b, ep["pull"])
+	pull := setupPuller(expect, b, ep["push"])
+	ack := setupPusher(expect, b, ep["ack"])
+
+	err := push.Push([]byte("test-topic"), []byte("test-content"))
+	expect(err).To.Be.Nil().Else.FailNow()
+
+	parts, err := pull.Pull(time.Second)
 	expect(err).To.Be.Nil().Else.FailNow()
 	expect(len(parts)).To.Equal(2)
-	expect(parts[0]).To.Equal([]byte("test-topic"))
+	seq := parts[0]
 	expect(parts[1]).To.Equal([]byte("test-content"))
 
-	pullContent, err := pull.RecvBytes(0)
+	// the message is pushed again when it is not acknowledged in time
+	parts, err = pull.Pull(time.Second)
 	expect(err).To.Be.Nil().Else.FailNow()
-	expect(pullContent).To.Equal([]byte("test-content"))
+	expect(parts).To.Equal([][]byte{seq, []byte("test-content")})
+
+	// and when the dispatcher is restarted
+	shutdown(d)
+	drain(pull)
+	d = start()
+	parts, err = pull.Pull(time.Second)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(parts).To.Equal([][]byte{seq, []byte("test-content")})
+
+	expect(ack.Push(seq)).To.Be.Nil().Else.FailNow()
+	time.Sleep(200 * time.Millisecond)
+	drain(pull)
+
+	// acknowledged messages are not pushed once restarted
+	shutdown(d)
+	d = start()
+	defer shutdown(d)
+	_, err = pull.Pull(time.Second)
+	expect(err).To.Equal(bus.ErrTimeout)
 }
 
 func endpoints() map[string]string {
@@ -47,6 +197,32 @@ func endpoints() map[string]string {
 		"pull": "inproc://test-dispatch-pull-" + randString(),
 		"pub":  "inproc://test-dispatch-pub-" + randString(),
 		"push": "inproc://test-dispatch-push-" + randString(),
+		"ack":  "inproc://test-dispatch-ack-" + randString(),
+	}
+}
+
+// drain discards the messages that have already been pushed.
+func drain(pull bus.Puller) {
+	for {
+		_, err := pull.Pull(0)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func tempFile(t *testing.T) (string, func()) {
+	tf, err := ioutil.TempFile("", "")
+	if err != nil {
+		fmt.Println("could not obtain a temporary file")
+		t.FailNow()
+	}
+	return tf.Name(), func() {
+		err := os.Remove(tf.Name())
+		if err != nil {
+			log.Println("unable to remove temp file")
+			t.FailNow()
+		}
 	}
 }
 
@@ -59,25 +235,20 @@ func randString() string {
 	return fmt.Sprintf("%x", b)
 }
 
-func setupPushSocket(expect expect.Expectation, endpoint string) *zmq4.Socket {
-	push, err := zmq4.NewSocket(zmq4.PUSH)
+func setupPusher(expect expect.Expectation, b bus.Bus, endpoint string) bus.Pusher {
+	push, err := b.Pusher(bus.Connect, []string{endpoint})
 	expect(err).To.Be.Nil().Else.FailNow()
-	expect(push.Connect(endpoint)).To.Be.Nil().Else.FailNow()
 	return push
 }
 
-func setupSubSocket(expect expect.Expectation, topic, endpoint string) *zmq4.Socket {
-	sub, err := zmq4.NewSocket(zmq4.SUB)
-	expect(err).To.Be.Nil().Else.FailNow()
-	err = sub.SetSubscribe(topic)
+func setupSubscription(expect expect.Expectation, b bus.Bus, topic, endpoint string) bus.Subscription {
+	sub, err := b.Subscribe([]string{endpoint}, []string{topic})
 	expect(err).To.Be.Nil().Else.FailNow()
-	expect(sub.Connect(endpoint)).To.Be.Nil().Else.FailNow()
 	return sub
 }
 
-func setupPullSocket(expect expect.Expectation, endpoint string) *zmq4.Socket {
-	pull, err := zmq4.NewSocket(zmq4.PULL)
+func setupPuller(expect expect.Expectation, b bus.Bus, endpoint string) bus.Puller {
+	pull, err := b.Puller(bus.Connect, []string{endpoint})
 	expect(err).To.Be.Nil().Else.FailNow()
-	expect(pull.Connect(endpoint)).To.Be.Nil().Else.FailNow()
 	return pull
 }
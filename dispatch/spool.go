@@ -0,0 +1,119 @@
+package dispatch
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var spoolBucket = []byte("spool")
+
+// spool is an on-disk queue of messages that have been dispatched but not
+// yet acknowledged as stored by a puller. Messages are kept until they are
+// acknowledged so they survive restarts of the dispatcher.
+type spool struct {
+	db *bolt.DB
+}
+
+// spoolEntry is a message in the spool along with its sequence number.
+type spoolEntry struct {
+	seq     uint64
+	message []byte
+}
+
+func openSpool(path string) (*spool, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(spoolBucket)
+		return err
+	})
+	if err != nil {
+		closeErr := db.Close()
+		if closeErr != nil {
+			log.Printf("openSpool: got an error closing bolt db while in error state: %s", closeErr)
+		}
+		return nil, err
+	}
+	return &spool{db: db}, nil
+}
+
+// add adds the messages to the end of the spool.
+func (s *spool) add(messages [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(spoolBucket)
+		for _, message := range messages {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = b.Put(seqKey(seq), message)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ack removes the messages with the sequence numbers from the spool.
+func (s *spool) ack(seqs []uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(spoolBucket)
+		for _, seq := range seqs {
+			err := b.Delete(seqKey(seq))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pending returns up to limit of the oldest messages that have not been
+// acknowledged.
+func (s *spool) pending(limit int) ([]spoolEntry, error) {
+	var entries []spoolEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(spoolBucket).Cursor()
+		for k, v := c.First(); k != nil && len(entries) < limit; k, v = c.Next() {
+			entries = append(entries, spoolEntry{
+				seq:     binary.BigEndian.Uint64(k),
+				message: append([]byte(nil), v...),
+			})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// depth returns how many messages have not been acknowledged.
+func (s *spool) depth() int {
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(spoolBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (s *spool) close() error {
+	return s.db.Close()
+}
+
+// seqKey encodes the sequence number so that keys sort in the order the
+// messages were appended.
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
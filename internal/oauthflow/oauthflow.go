@@ -0,0 +1,198 @@
+// Package oauthflow finishes the oauth authorization code flows that link
+// twitch and Discord accounts. The parts that differ between them, how the
+// nonce is checked, how the code is exchanged and what is stored, are
+// provided by a Provider.
+package oauthflow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const responsePage = `
+<!doctype html>
+<html class="no-js" lang="">
+<head>
+<style type="text/css">
+body {
+	text-align: center;
+	line-height: 100vh;
+	font-family: Arial, Helvetica, sans-serif;
+	font-size: 14px;
+}
+</style>
+</head>
+<body>
+%s
+</body>
+</html>
+`
+
+var httpClient = &http.Client{
+	Timeout: time.Second * 5,
+}
+
+// Provider is the part of an oauth flow that is specific to a service.
+type Provider interface {
+	// NonceExists returns true if the nonce belongs to a flow that has not
+	// been finished.
+	NonceExists(nonce string) (exists bool, err error)
+	// Payload returns the form that is posted to exchange the code for a
+	// token.
+	Payload(nonce, code string) url.Values
+	// Finish is given the body of the token response to complete the flow
+	// for the nonce.
+	Finish(nonce string, token []byte) (err error)
+}
+
+// DoneHandler is where the redirect URI hits to finish an oauth flow.
+type DoneHandler struct {
+	name        string
+	tokenURL    string
+	done        string
+	provider    Provider
+	callbackTTL time.Duration
+
+	mu        sync.Mutex
+	callbacks map[string]callback
+}
+
+// callback is invoked when the oauth flow for a nonce is complete.
+type callback struct {
+	f       func()
+	created time.Time
+}
+
+// NewDoneHandler creates a new handler to finish the oauth flow for the
+// service with the given name. The code is exchanged at the token URL and
+// the done message is shown to the user once the flow is finished.
+// Callbacks for flows that do not finish are kept for the callback TTL.
+func NewDoneHandler(
+	name string,
+	tokenURL string,
+	done string,
+	provider Provider,
+	callbackTTL time.Duration,
+) *DoneHandler {
+	return &DoneHandler{
+		name:        name,
+		tokenURL:    tokenURL,
+		done:        done,
+		provider:    provider,
+		callbackTTL: callbackTTL,
+		callbacks:   make(map[string]callback),
+	}
+}
+
+// RegisterCompletionCallback allows you to register a callback that is
+// invoked when the oauth flow is complete. Callbacks for flows that are not
+// completed within the callback TTL are discarded.
+func (h *DoneHandler) RegisterCompletionCallback(nonce string, f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for n, cb := range h.callbacks {
+		if h.callbackTTL > 0 && now.Sub(cb.created) > h.callbackTTL {
+			delete(h.callbacks, n)
+		}
+	}
+	h.callbacks[nonce] = callback{
+		f:       f,
+		created: now,
+	}
+}
+
+// takeCallback removes and returns the callback for the nonce.
+func (h *DoneHandler) takeCallback(nonce string) (func(), bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cb, ok := h.callbacks[nonce]
+	delete(h.callbacks, nonce)
+	return cb.f, ok
+}
+
+// ServeHTTP handles the response from the service after the user has
+// authorized access.
+func (h *DoneHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	// validate nonce
+	nonce := values.Get("state")
+	ok, err := h.provider.NonceExists(nonce)
+	if !ok || err != nil {
+		log.Printf("bad %s nonce", h.name)
+		h.takeCallback(nonce)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// validate code
+	code := values.Get("code")
+	if code == "" {
+		log.Printf("code not set in %s oauth response", h.name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// create request to exchange the code
+	payload := h.provider.Payload(nonce, code)
+	req, err := http.NewRequest("POST", h.tokenURL, strings.NewReader(payload.Encode()))
+	if err != nil {
+		log.Printf("unable to create request for posting to %s oauth for token: %s", h.name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// make request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("error in response from post to %s oauth for token: %s", h.name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("DoneHandler got err in closing resp body: %s", err)
+		}
+	}()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("got %d response code from post to %s oauth for token", resp.StatusCode, h.name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// read response body
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("unable to read response body from post to %s oauth for token: %s", h.name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.provider.Finish(nonce, d)
+	if err != nil {
+		log.Printf("unable to finish %s oauth: %s", h.name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// call any callbacks
+	cb, ok := h.takeCallback(nonce)
+	if ok {
+		cb()
+	}
+
+	// write out response
+	fmt.Fprintf(w, responsePage, h.done)
+}
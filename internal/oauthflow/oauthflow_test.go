@@ -0,0 +1,123 @@
+package oauthflow
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+)
+
+func TestThatFinishingTheFlowCallsTheCallback(t *testing.T) {
+	expect := expect.New(t)
+
+	var form url.Values
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		form = r.PostForm
+		fmt.Fprint(w, "test-token")
+	}))
+	defer service.Close()
+
+	p := &fakeProvider{exists: true}
+	h := NewDoneHandler("test", service.URL, "test-done", p, time.Minute)
+	var called bool
+	h.RegisterCompletionCallback("test-nonce", func() {
+		called = true
+	})
+
+	req := httptest.NewRequest("GET", "/done?state=test-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusOK)
+	expect(strings.Contains(rec.Body.String(), "test-done")).To.Be.True()
+	expect(form.Get("code")).To.Equal("test-code")
+	expect(p.finishedNonce).To.Equal("test-nonce")
+	expect(p.finishedToken).To.Equal("test-token")
+	expect(called).To.Be.True()
+	expect(len(h.callbacks)).To.Equal(0)
+}
+
+func TestThatFailingToFinishIsABadRequest(t *testing.T) {
+	expect := expect.New(t)
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "test-token")
+	}))
+	defer service.Close()
+
+	p := &fakeProvider{exists: true, err: errors.New("test-error")}
+	h := NewDoneHandler("test", service.URL, "test-done", p, time.Minute)
+	var called bool
+	h.RegisterCompletionCallback("test-nonce", func() {
+		called = true
+	})
+
+	req := httptest.NewRequest("GET", "/done?state=test-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusBadRequest)
+	expect(called).To.Be.False()
+}
+
+func TestThatAbandonedCallbacksAreDiscarded(t *testing.T) {
+	expect := expect.New(t)
+
+	h := NewDoneHandler("test", "", "", nil, 10*time.Millisecond)
+	h.RegisterCompletionCallback("abandoned-nonce", func() {})
+	time.Sleep(20 * time.Millisecond)
+	h.RegisterCompletionCallback("new-nonce", func() {})
+
+	expect(len(h.callbacks)).To.Equal(1)
+	_, ok := h.callbacks["new-nonce"]
+	expect(ok).To.Be.True()
+}
+
+func TestThatCallbacksForBadNoncesAreDiscarded(t *testing.T) {
+	expect := expect.New(t)
+
+	h := NewDoneHandler("test", "", "", &fakeProvider{}, time.Minute)
+	h.RegisterCompletionCallback("expired-nonce", func() {})
+
+	req := httptest.NewRequest("GET", "/done?state=expired-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusBadRequest)
+	expect(len(h.callbacks)).To.Equal(0)
+}
+
+type fakeProvider struct {
+	exists bool
+	err    error
+
+	finishedNonce string
+	finishedToken string
+}
+
+func (p *fakeProvider) NonceExists(nonce string) (bool, error) {
+	return p.exists, nil
+}
+
+func (p *fakeProvider) Payload(nonce, code string) url.Values {
+	return url.Values{
+		"code": []string{code},
+	}
+}
+
+func (p *fakeProvider) Finish(nonce string, token []byte) error {
+	p.finishedNonce = nonce
+	p.finishedToken = string(token)
+	return p.err
+}
@@ -1,11 +1,13 @@
 package store
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"sort"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -16,11 +18,12 @@ import (
 
 // Bolt is a store backend for boltdb.
 type Bolt struct {
-	db *bolt.DB
+	db  *bolt.DB
+	cfg backendConfig
 }
 
 // NewBolt creates a new bolt store.
-func NewBolt(path string) (*Bolt, error) {
+func NewBolt(path string, opts ...BackendOption) (*Bolt, error) {
 	db, err := bolt.Open(path, 0600, &bolt.Options{
 		Timeout: time.Second,
 	})
@@ -28,9 +31,19 @@ func NewBolt(path string) (*Bolt, error) {
 		return nil, err
 	}
 	b := &Bolt{
-		db: db,
+		db:  db,
+		cfg: newBackendConfig(opts),
+	}
+	indexed, err := b.hasMessageIndex()
+	if err == nil {
+		err = b.createBuckets()
+	}
+	if err == nil {
+		err = b.migrateMessages()
+	}
+	if err == nil && !indexed {
+		err = b.reindexMessages()
 	}
-	err = b.createBuckets()
 	if err != nil {
 		closeErr := db.Close()
 		if closeErr != nil {
@@ -41,6 +54,17 @@ func NewBolt(path string) (*Bolt, error) {
 	return b, nil
 }
 
+// hasMessageIndex returns true if the search index bucket exists. It is
+// missing from databases written before messages were indexed.
+func (b *Bolt) hasMessageIndex() (bool, error) {
+	var indexed bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		indexed = tx.Bucket([]byte("message_index")) != nil
+		return nil
+	})
+	return indexed, err
+}
+
 func (b *Bolt) createBuckets() error {
 	return b.db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte("users"))
@@ -51,11 +75,63 @@ func (b *Bolt) createBuckets() error {
 		if err != nil {
 			return err
 		}
+		_, err = tx.CreateBucketIfNotExists([]byte("discord_nonces"))
+		if err != nil {
+			return err
+		}
 		_, err = tx.CreateBucketIfNotExists([]byte("messages"))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("message_index"))
 		return err
 	})
 }
 
+// migrateMessages converts any messages stored in the legacy layout, where
+// each owner's messages were a single JSON array, into nested buckets.
+func (b *Bolt) migrateMessages() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range legacyKeys(tx.Bucket([]byte("messages"))) {
+			log.Printf("migrating bolt messages for %s", key)
+			err := migrateMessageRecord(string(key), tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// reindexMessages adds every stored message to the search index. It is run
+// once when the index is created for a database that already has messages.
+func (b *Bolt) reindexMessages() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		log.Print("indexing bolt messages for search")
+		return tx.Bucket([]byte("messages")).ForEach(func(k, v []byte) error {
+			// only nested buckets have messages once they are migrated
+			if v != nil {
+				return nil
+			}
+			return reindexMessageRecord(string(k), tx)
+		})
+	})
+}
+
+// legacyKeys returns the keys in the bucket that have values rather than
+// nested buckets.
+func legacyKeys(b *bolt.Bucket) [][]byte {
+	var legacy [][]byte
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		// nested buckets have nil values
+		if v != nil {
+			legacy = append(legacy, append([]byte(nil), k...))
+		}
+	}
+	return legacy
+}
+
 // Close cleans up the boltdb resources.
 func (b *Bolt) Close() error {
 	return b.db.Close()
@@ -106,9 +182,15 @@ func (b *Bolt) AuthenticateUser(username, password string) (string, bool, error)
 // OauthNonce gets the oauth nonce for a given user if it exists.
 func (b *Bolt) OauthNonce(userID string, tu TwitchUser) (nonce string, err error) {
 	err = b.db.View(func(tx *bolt.Tx) error {
-		var err error
-		nonce, err = getNonceRecordByUserID(userID, tu, tx)
-		return err
+		nr, err := getNonceRecordByUserID(userID, tu, tx)
+		if err != nil {
+			return err
+		}
+		if b.cfg.nonceExpired(nr.Created, time.Now()) {
+			return ErrUnknownNonce
+		}
+		nonce = nr.Nonce
+		return nil
 	})
 	if err != nil {
 		return "", ErrUnknownNonce
@@ -126,6 +208,10 @@ func (b *Bolt) StoreOauthNonce(userID string, tu TwitchUser, nonce string) error
 	}
 
 	return b.db.Update(func(tx *bolt.Tx) error {
+		err := deleteNonceRecordsByUserID(userID, tu, tx)
+		if err != nil {
+			return err
+		}
 		return upsertNonceRecord(nr, tx)
 	})
 }
@@ -133,11 +219,19 @@ func (b *Bolt) StoreOauthNonce(userID string, tu TwitchUser, nonce string) error
 // OauthNonceExists tells you if the provided nonce was recently created and
 // not yet finished.
 func (b *Bolt) OauthNonceExists(nonce string) (bool, error) {
+	var exists bool
 	err := b.db.View(func(tx *bolt.Tx) error {
-		_, err := getNonceRecord(nonce, tx)
-		return err
+		nr, err := getNonceRecord(nonce, tx)
+		if err == ErrUnknownNonce {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exists = !b.cfg.nonceExpired(nr.Created, time.Now())
+		return nil
 	})
-	return err == nil, err
+	return exists, err
 }
 
 // FinishOauthNonce completes the oauth flow, removing the nonce and storing
@@ -153,6 +247,9 @@ func (b *Bolt) FinishOauthNonce(
 		if err != nil {
 			return err
 		}
+		if b.cfg.nonceExpired(nr.Created, time.Now()) {
+			return ErrUnknownNonce
+		}
 
 		ur, err := getUserRecord(nr.UserID, tx)
 		if err != nil {
@@ -181,6 +278,65 @@ func (b *Bolt) FinishOauthNonce(
 	})
 }
 
+// SweepOauthNonces removes Twitch and Discord oauth nonces that have
+// expired.
+func (b *Bolt) SweepOauthNonces() (int, error) {
+	now := time.Now()
+	var removed int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var expired []string
+		err := tx.Bucket([]byte("nonces")).ForEach(func(k, v []byte) error {
+			var nr nonceRecord
+			err := json.Unmarshal(v, &nr)
+			if err != nil {
+				return err
+			}
+			if b.cfg.nonceExpired(nr.Created, now) {
+				expired = append(expired, nr.Nonce)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, nonce := range expired {
+			err = deleteNonceRecord(nonce, tx)
+			if err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+
+		expired = nil
+		err = tx.Bucket([]byte("discord_nonces")).ForEach(func(k, v []byte) error {
+			var nr discordNonceRecord
+			err := json.Unmarshal(v, &nr)
+			if err != nil {
+				return err
+			}
+			if b.cfg.nonceExpired(nr.Created, now) {
+				expired = append(expired, nr.Nonce)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, nonce := range expired {
+			err = deleteDiscordNonceRecord(nonce, tx)
+			if err != nil {
+				return err
+			}
+		}
+		removed += len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
 // TwitchCredentials gives you the twitch credentials for a given users.
 func (b *Bolt) TwitchCredentials(userID string) (TwitchCredentials, error) {
 	var ur userRecord
@@ -222,6 +378,197 @@ func (b *Bolt) TwitchClearAuth(userID string) error {
 	})
 }
 
+// DiscordCredentials gives you the Discord guild the user has linked.
+func (b *Bolt) DiscordCredentials(userID string) (DiscordCredentials, error) {
+	var ur userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ur, err = getUserRecord(userID, tx)
+		return err
+	})
+	if err != nil {
+		return DiscordCredentials{}, err
+	}
+	return ur.discordCredentials(), nil
+}
+
+// StoreDiscordGuild links the Discord guild with the given owner to the
+// user.
+func (b *Bolt) StoreDiscordGuild(userID, ownerID, guildID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		ur.DiscordOwnerID = ownerID
+		ur.DiscordGuildID = guildID
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// DiscordOauthNonce gets the nonce of the user's Discord oauth flow if it
+// exists.
+func (b *Bolt) DiscordOauthNonce(userID string) (string, error) {
+	var nonce string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		nr, err := getDiscordNonceRecordByUserID(userID, tx)
+		if err != nil {
+			return err
+		}
+		if b.cfg.nonceExpired(nr.Created, time.Now()) {
+			return ErrUnknownNonce
+		}
+		nonce = nr.Nonce
+		return nil
+	})
+	if err != nil {
+		return "", ErrUnknownNonce
+	}
+	return nonce, nil
+}
+
+// StoreDiscordOauthNonce stores the nonce of the user's Discord oauth flow,
+// replacing any nonce that was abandoned.
+func (b *Bolt) StoreDiscordOauthNonce(userID, nonce string) error {
+	nr := discordNonceRecord{
+		Nonce:   nonce,
+		UserID:  userID,
+		Created: time.Now(),
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := deleteDiscordNonceRecordsByUserID(userID, tx)
+		if err != nil {
+			return err
+		}
+		return upsertDiscordNonceRecord(nr, tx)
+	})
+}
+
+// DiscordOauthNonceExists tells you if the provided Discord nonce was
+// recently created and not yet finished.
+func (b *Bolt) DiscordOauthNonceExists(nonce string) (bool, error) {
+	var exists bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		nr, err := getDiscordNonceRecord(nonce, tx)
+		if err == ErrUnknownNonce {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exists = !b.cfg.nonceExpired(nr.Created, time.Now())
+		return nil
+	})
+	return exists, err
+}
+
+// FinishDiscordOauthNonce completes the Discord oauth flow, removing the
+// nonce and linking the guild the bot was added to.
+func (b *Bolt) FinishDiscordOauthNonce(nonce, ownerID, guildID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		nr, err := getDiscordNonceRecord(nonce, tx)
+		if err != nil {
+			return err
+		}
+		if b.cfg.nonceExpired(nr.Created, time.Now()) {
+			return ErrUnknownNonce
+		}
+
+		ur, err := getUserRecord(nr.UserID, tx)
+		if err != nil {
+			return err
+		}
+		ur.DiscordOwnerID = ownerID
+		ur.DiscordGuildID = guildID
+
+		err = deleteDiscordNonceRecord(nr.Nonce, tx)
+		if err != nil {
+			return err
+		}
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// DiscordClearAuth unlinks the user's Discord guild.
+func (b *Bolt) DiscordClearAuth(userID string) error {
+	return b.StoreDiscordGuild(userID, "", "")
+}
+
+// DiscordAuthenticatedUsers gets the IDs of the users that have linked a
+// Discord guild.
+func (b *Bolt) DiscordAuthenticatedUsers() ([]string, error) {
+	var userIDs []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("users")).ForEach(func(k, v []byte) error {
+			var ur userRecord
+			err := json.Unmarshal(v, &ur)
+			if err != nil {
+				return err
+			}
+			if ur.discordCredentials().Authenticated {
+				userIDs = append(userIDs, ur.UserID)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// TwitchAuthenticatedUsers gets the IDs of the users that have authenticated
+// both their streamer and bot users with twitch.
+func (b *Bolt) TwitchAuthenticatedUsers() ([]string, error) {
+	var userIDs []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("users")).ForEach(func(k, v []byte) error {
+			var ur userRecord
+			err := json.Unmarshal(v, &ur)
+			if err != nil {
+				return err
+			}
+			if ur.StreamerOD.AccessToken != "" && ur.BotOD.AccessToken != "" {
+				userIDs = append(userIDs, ur.UserID)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// TwitchOauthData gets the oauth data for the given twitch user.
+func (b *Bolt) TwitchOauthData(twitchUsername string) (OauthData, error) {
+	var od OauthData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		ur, err := getUserRecordByTwitchUsername(twitchUsername, tx)
+		if err != nil {
+			return err
+		}
+		od, _ = ur.twitchOauthData(twitchUsername)
+		return nil
+	})
+	if err != nil {
+		return OauthData{}, err
+	}
+	return od, nil
+}
+
+// UpdateTwitchOauthData replaces the oauth data for the given twitch user.
+func (b *Bolt) UpdateTwitchOauthData(twitchUsername string, od OauthData) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecordByTwitchUsername(twitchUsername, tx)
+		if err != nil {
+			return err
+		}
+		ur.setTwitchOauthData(twitchUsername, od)
+		return upsertUserRecord(ur, tx)
+	})
+}
+
 // StoreMessage stores a message for a given user for later searching and
 // scrollback history.
 func (b *Bolt) StoreMessage(msg stream.RXMessage) error {
@@ -232,51 +579,512 @@ func (b *Bolt) StoreMessage(msg stream.RXMessage) error {
 
 // FetchRecentMessages gets the recent messages for the user's channel.
 func (b *Bolt) FetchRecentMessages(userID string) ([]stream.RXMessage, error) {
-	creds, err := b.TwitchCredentials(userID)
+	msgs, _, err := b.FetchMessages(userID, "", 500)
+	return msgs, err
+}
+
+// FetchRecentDiscordMessages gets the recent messages from the user's linked
+// Discord guild in chronological order.
+func (b *Bolt) FetchRecentDiscordMessages(userID string) ([]stream.RXMessage, error) {
+	creds, err := b.DiscordCredentials(userID)
 	if err != nil {
 		return nil, err
 	}
+	if !creds.Authenticated {
+		return nil, nil
+	}
+
+	var messages []stream.RXMessage
+	err = b.db.View(func(tx *bolt.Tx) error {
+		mc, err := newMessageCursor("discord:"+creds.OwnerID, math.MaxUint64, tx)
+		if err != nil {
+			return err
+		}
+		for len(messages) < recentDiscordLimit && mc.ok {
+			messages = append(messages, mc.msg)
+			err := mc.prev()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not query messages: %s", err)
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return guildMessages(messages, creds.GuildID), nil
+}
+
+// FetchMessages gets a page of messages for the user's channel in
+// chronological order. The cursor holds the sequence of the oldest message
+// returned so far from both the streamer and bot buckets.
+func (b *Bolt) FetchMessages(userID, cursor string, limit int) ([]stream.RXMessage, string, error) {
+	if limit < 1 {
+		return nil, "", ErrInvalidLimit
+	}
+	streamerBefore, botBefore, err := parseBoltCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creds, err := b.TwitchCredentials(userID)
+	if err != nil {
+		return nil, "", err
+	}
 	if !creds.StreamerAuthenticated || !creds.BotAuthenticated {
-		return nil, errors.New("user is not authenticated with twitch")
+		return nil, "", errors.New("user is not authenticated with twitch")
 	}
 
-	var mr messageRecord
+	var (
+		messages []stream.RXMessage
+		next     string
+	)
 	err = b.db.View(func(tx *bolt.Tx) error {
+		streamer, err := newMessageCursor("twitch:"+strconv.Itoa(creds.StreamerTwitchUserID), streamerBefore, tx)
+		if err != nil {
+			return err
+		}
+		bot, err := newMessageCursor("twitch:"+strconv.Itoa(creds.BotTwitchUserID), botBefore, tx)
+		if err != nil {
+			return err
+		}
+
+		// merge both buckets newest first
+		for len(messages) < limit && (streamer.ok || bot.ok) {
+			mc := streamer
+			if !streamer.ok || (bot.ok && !messageTime(bot.msg).Before(messageTime(streamer.msg))) {
+				mc = bot
+			}
+			messages = append(messages, mc.msg)
+			if mc == streamer {
+				streamerBefore = mc.seq
+			} else {
+				botBefore = mc.seq
+			}
+			err := mc.prev()
+			if err != nil {
+				return err
+			}
+		}
+		if streamer.ok || bot.ok {
+			next = fmt.Sprintf("%d:%d", streamerBefore, botBefore)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("could not query messages: %s", err)
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, next, nil
+}
+
+// parseBoltCursor returns the sequences that the streamer and bot messages
+// need to be older than. An empty cursor starts from the newest messages.
+func parseBoltCursor(cursor string) (streamerBefore, botBefore uint64, err error) {
+	if cursor == "" {
+		return math.MaxUint64, math.MaxUint64, nil
+	}
+	parts := strings.Split(cursor, ":")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidCursor
+	}
+	streamerBefore, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	botBefore, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return streamerBefore, botBefore, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// RetentionPolicy gets the user's message retention policy.
+func (b *Bolt) RetentionPolicy(userID string) (RetentionPolicy, error) {
+	var ur userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
 		var err error
-		mr, err = getMessageRecord("twitch:"+strconv.Itoa(creds.StreamerTwitchUserID), tx)
+		ur, err = getUserRecord(userID, tx)
 		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not query messages for streamer: %s", err)
+		return RetentionPolicy{}, err
 	}
-	messages := []stream.RXMessage(mr)
+	return ur.Retention, nil
+}
 
-	err = b.db.View(func(tx *bolt.Tx) error {
+// SetRetentionPolicy sets the user's message retention policy.
+func (b *Bolt) SetRetentionPolicy(userID string, policy RetentionPolicy) error {
+	if !policy.valid() {
+		return ErrInvalidRetentionPolicy
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		ur.Retention = policy
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// pruneBatchSize is how many expired messages are removed in each write
+// transaction so pruning does not hold up storing new messages for long.
+const pruneBatchSize = 1000
+
+// PruneMessages removes messages that have expired according to each user's
+// retention policy.
+func (b *Bolt) PruneMessages() (int, error) {
+	now := time.Now()
+	var records []userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("users")).ForEach(func(k, v []byte) error {
+			var ur userRecord
+			err := json.Unmarshal(v, &ur)
+			if err != nil {
+				return err
+			}
+			if !ur.Retention.unlimited() {
+				records = append(records, ur)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	for _, ur := range records {
+		for _, key := range ur.messageKeys() {
+			n, err := b.pruneOwnerMessages(key, ur.Retention, now)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// pruneOwnerMessages removes the expired messages from the owner's bucket.
+// Expired messages are found in read transactions and removed in batches.
+// Messages stored while pruning are not counted so they never cause older
+// messages to be pruned early.
+func (b *Bolt) pruneOwnerMessages(key string, p RetentionPolicy, now time.Time) (int, error) {
+	var remaining map[string]int
+	err := b.db.View(func(tx *bolt.Tx) error {
 		var err error
-		mr, err = getMessageRecord("twitch:"+strconv.Itoa(creds.BotTwitchUserID), tx)
+		remaining, err = countChannelMessages(key, tx)
 		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not query messages for bot: %s", err)
+		return 0, err
 	}
-	messages = append(messages, []stream.RXMessage(mr)...)
 
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].Twitch.Line.Time.Before(messages[j].Twitch.Line.Time)
+	var (
+		removed int
+		after   uint64
+	)
+	for {
+		var (
+			expired []expiredMessage
+			done    bool
+		)
+		err = b.db.View(func(tx *bolt.Tx) error {
+			var err error
+			expired, after, done, err = findExpiredMessages(key, after, pruneBatchSize, remaining, p, now, tx)
+			return err
+		})
+		if err != nil {
+			return removed, err
+		}
+
+		if len(expired) > 0 {
+			err = b.db.Update(func(tx *bolt.Tx) error {
+				for _, e := range expired {
+					err := deleteMessage(key, e.seq, e.msg, tx)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return removed, err
+			}
+			removed += len(expired)
+		}
+
+		if done {
+			return removed, nil
+		}
+	}
+}
+
+// CustomCommands gets the user's custom commands ordered by trigger.
+func (b *Bolt) CustomCommands(userID string) ([]CustomCommand, error) {
+	var ur userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ur, err = getUserRecord(userID, tx)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+	return sortCustomCommands(ur.Commands), nil
+}
 
-	return messages[:min(len(messages), 500)], nil
+// SetCustomCommand creates or replaces the response of the user's custom
+// command with the same trigger.
+func (b *Bolt) SetCustomCommand(userID string, cmd CustomCommand) error {
+	if !cmd.valid() {
+		return ErrInvalidCustomCommand
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		if ur.Commands == nil {
+			ur.Commands = make(map[string]CustomCommand)
+		}
+		cmd.Count = ur.Commands[cmd.Trigger].Count
+		ur.Commands[cmd.Trigger] = cmd
+		return upsertUserRecord(ur, tx)
+	})
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// DeleteCustomCommand removes the user's custom command with the given
+// trigger.
+func (b *Bolt) DeleteCustomCommand(userID, trigger string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		if _, ok := ur.Commands[trigger]; !ok {
+			return ErrUnknownCustomCommand
+		}
+		delete(ur.Commands, trigger)
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// IncrementCustomCommandCount records that the user's custom command was
+// used, returning the updated count.
+func (b *Bolt) IncrementCustomCommandCount(userID, trigger string) (int, error) {
+	var count int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		cmd, ok := ur.Commands[trigger]
+		if !ok {
+			return ErrUnknownCustomCommand
+		}
+		cmd.Count++
+		count = cmd.Count
+		ur.Commands[trigger] = cmd
+		return upsertUserRecord(ur, tx)
+	})
+	if err != nil {
+		return 0, err
 	}
-	return b
+	return count, nil
+}
+
+// ModerationRules gets the rules used to moderate the user's channel.
+func (b *Bolt) ModerationRules(userID string) (ModerationRules, error) {
+	var ur userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ur, err = getUserRecord(userID, tx)
+		return err
+	})
+	if err != nil {
+		return ModerationRules{}, err
+	}
+	return ur.Moderation, nil
+}
+
+// SetModerationRules sets the rules used to moderate the user's channel.
+func (b *Bolt) SetModerationRules(userID string, rules ModerationRules) error {
+	if !rules.valid() {
+		return ErrInvalidModerationRules
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		ur.Moderation = rules
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// ModerationStrikes gets the strikes of chatters in the user's channel
+// ordered by chatter.
+func (b *Bolt) ModerationStrikes(userID string) ([]Strike, error) {
+	var ur userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ur, err = getUserRecord(userID, tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortStrikes(ur.Strikes), nil
+}
+
+// SetModerationStrike creates or replaces the strike of the chatter in the
+// user's channel.
+func (b *Bolt) SetModerationStrike(userID string, strike Strike) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		if ur.Strikes == nil {
+			ur.Strikes = make(map[string]Strike)
+		}
+		ur.Strikes[strike.Chatter] = strike
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// DeleteModerationStrikes removes the strikes in the user's channel whose
+// last violation was before the given time.
+func (b *Bolt) DeleteModerationStrikes(userID string, before time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		for chatter, s := range ur.Strikes {
+			if s.Last.Before(before) {
+				delete(ur.Strikes, chatter)
+			}
+		}
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// Timers gets the user's timers ordered by name.
+func (b *Bolt) Timers(userID string) ([]Timer, error) {
+	var ur userRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ur, err = getUserRecord(userID, tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortTimers(ur.Timers), nil
+}
+
+// SetTimer creates or replaces the user's timer with the same name.
+func (b *Bolt) SetTimer(userID string, timer Timer) error {
+	if !timer.valid() {
+		return ErrInvalidTimer
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		if ur.Timers == nil {
+			ur.Timers = make(map[string]Timer)
+		}
+		ur.Timers[timer.Name] = timer
+		return upsertUserRecord(ur, tx)
+	})
+}
+
+// DeleteTimer removes the user's timer with the given name.
+func (b *Bolt) DeleteTimer(userID, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ur, err := getUserRecord(userID, tx)
+		if err != nil {
+			return err
+		}
+		if _, ok := ur.Timers[name]; !ok {
+			return ErrUnknownTimer
+		}
+		delete(ur.Timers, name)
+		return upsertUserRecord(ur, tx)
+	})
 }
 
 // QueryMessages allows the user to search for messages that match a
 // search string.
 func (b *Bolt) QueryMessages(userID, search string) ([]stream.RXMessage, error) {
-	panic("not implemented")
+	tc, err := b.TwitchCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := b.DiscordCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := searchTerms(search)
+	keys := searchKeys(tc, dc)
+	if len(terms) == 0 || len(keys) == 0 {
+		return nil, nil
+	}
+
+	var results []searchResult
+	err = b.db.View(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			scores := make(map[uint64]int)
+			for _, term := range terms {
+				postings, err := getMessagePostings(key, term, tx)
+				if err != nil {
+					return err
+				}
+				for _, seq := range postings {
+					scores[seq]++
+				}
+			}
+
+			for seq, score := range scores {
+				msg, ok, err := getMessage(key, seq, tx)
+				if err != nil {
+					return err
+				}
+				if !ok || !searchable(msg, dc) {
+					continue
+				}
+				results = append(results, searchResult{
+					msg:   msg,
+					score: score,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not query messages: %s", err)
+	}
+
+	return rankResults(results, 500), nil
 }
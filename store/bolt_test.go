@@ -0,0 +1,143 @@
+package store_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/boltdb/bolt"
+	"github.com/fluffle/goirc/client"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestThatBoltMigratesLegacyMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	path, cleanup := tempFile(t)
+	defer cleanup()
+	userID := setupLegacyUser(expect, path)
+
+	start := time.Now()
+	legacy := []stream.RXMessage{
+		legacyMessage("first message", start),
+		legacyMessage("second message", start.Add(time.Second)),
+	}
+	db, err := bolt.Open(path, 0600, nil)
+	expect(err).To.Be.Nil().Else.FailNow()
+	err = db.Update(func(tx *bolt.Tx) error {
+		lb, err := json.Marshal(legacy)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte("messages")).Put([]byte("twitch:12345"), lb)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("message_index")).Put(
+			[]byte("twitch:12345:message"),
+			[]byte("[0,1]"),
+		)
+	})
+	expect(err).To.Be.Nil()
+	expect(db.Close()).To.Be.Nil()
+
+	b, err = store.NewBolt(path)
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer b.Close()
+
+	err = b.StoreMessage(legacyMessage("third message", start.Add(2*time.Second)))
+	expect(err).To.Be.Nil()
+
+	messages, cursor, err := b.FetchMessages(userID, "", 10)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(cursor).To.Equal("")
+	expect(len(messages)).To.Equal(3).Else.FailNow()
+	expect(messages[0].Twitch.Line.Args[1]).To.Equal("first message")
+	expect(messages[1].Twitch.Line.Args[1]).To.Equal("second message")
+	expect(messages[2].Twitch.Line.Args[1]).To.Equal("third message")
+
+	messages, err = b.QueryMessages(userID, "message")
+	expect(err).To.Be.Nil()
+	expect(len(messages)).To.Equal(3)
+	messages, err = b.QueryMessages(userID, "first")
+	expect(err).To.Be.Nil()
+	expect(len(messages)).To.Equal(1)
+}
+
+func TestThatBoltIndexesMessagesStoredBeforeTheIndex(t *testing.T) {
+	expect := expect.New(t)
+
+	path, cleanup := tempFile(t)
+	defer cleanup()
+	userID := setupLegacyUser(expect, path)
+
+	db, err := bolt.Open(path, 0600, nil)
+	expect(err).To.Be.Nil().Else.FailNow()
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte("message_index"))
+		if err != nil {
+			return err
+		}
+		b, err := tx.Bucket([]byte("messages")).CreateBucket([]byte("twitch:12345"))
+		if err != nil {
+			return err
+		}
+		mb, err := json.Marshal(legacyMessage("unindexed message", time.Now()))
+		if err != nil {
+			return err
+		}
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, 1)
+		return b.Put(k, mb)
+	})
+	expect(err).To.Be.Nil()
+	expect(db.Close()).To.Be.Nil()
+
+	b, err := store.NewBolt(path)
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer b.Close()
+
+	messages, err := b.QueryMessages(userID, "unindexed")
+	expect(err).To.Be.Nil()
+	expect(len(messages)).To.Equal(1)
+}
+
+// setupLegacyUser registers a user that is authenticated with twitch in a
+// new bolt database at the path and closes it so the database can be
+// modified to look like it was written by an earlier version.
+func setupLegacyUser(expect func(v interface{}) *expect.Expect, path string) string {
+	b, err := store.NewBolt(path)
+	expect(err).To.Be.Nil().Else.FailNow()
+	userID, err := b.RegisterUser("test-user", "test-pass")
+	expect(err).To.Be.Nil()
+	od := store.OauthData{
+		AccessToken: "test-access-token",
+	}
+	err = b.StoreOauthNonce(userID, store.Streamer, "streamer-nonce")
+	expect(err).To.Be.Nil()
+	err = b.FinishOauthNonce("streamer-nonce", "test-streamer-user", 12345, od)
+	expect(err).To.Be.Nil()
+	err = b.StoreOauthNonce(userID, store.Bot, "bot-nonce")
+	expect(err).To.Be.Nil()
+	err = b.FinishOauthNonce("bot-nonce", "test-bot-user", 54321, od)
+	expect(err).To.Be.Nil()
+	expect(b.Close()).To.Be.Nil()
+	return userID
+}
+
+func legacyMessage(body string, t time.Time) stream.RXMessage {
+	return stream.RXMessage{
+		Type: stream.Twitch,
+		Twitch: &stream.RXTwitch{
+			OwnerID: 12345,
+			Line: &client.Line{
+				Cmd:  "PRIVMSG",
+				Args: []string{"#test-streamer-user", body},
+				Time: t,
+			},
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// CustomCommand is a chat command defined by the streamer. When a chat
+// message starts with the trigger the bot replies with the response.
+type CustomCommand struct {
+	// Trigger is the first word of a message that invokes the command. It
+	// starts with "!".
+	Trigger string `json:"trigger"`
+	// Response is the template used to reply to the command.
+	Response string `json:"response"`
+	// Count is how many times the command has been used.
+	Count int `json:"count"`
+}
+
+// valid returns true if the trigger is a single word that starts with "!"
+// and there is a response.
+func (c CustomCommand) valid() bool {
+	return len(c.Trigger) > 1 &&
+		strings.HasPrefix(c.Trigger, "!") &&
+		!strings.ContainsAny(c.Trigger, " \t\r\n") &&
+		strings.TrimSpace(c.Response) != ""
+}
+
+// sortCustomCommands returns the commands ordered by trigger.
+func sortCustomCommands(cmds map[string]CustomCommand) []CustomCommand {
+	sorted := make([]CustomCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		sorted = append(sorted, cmd)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Trigger < sorted[j].Trigger
+	})
+	return sorted
+}
@@ -0,0 +1,28 @@
+package store
+
+import "github.com/jasonkeene/anubot-server/stream"
+
+// recentDiscordLimit is how many recent Discord messages are fetched.
+const recentDiscordLimit = 500
+
+// guildMessages returns the messages that were received in the guild.
+// Messages that were stored before their guild was recorded are kept.
+func guildMessages(msgs []stream.RXMessage, guildID string) []stream.RXMessage {
+	kept := msgs[:0]
+	for _, msg := range msgs {
+		if !inGuild(msg, guildID) {
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	return kept
+}
+
+// inGuild returns true if the Discord message was received in the guild or
+// was stored before its guild was recorded.
+func inGuild(msg stream.RXMessage, guildID string) bool {
+	if msg.Discord == nil {
+		return false
+	}
+	return msg.Discord.GuildID == "" || msg.Discord.GuildID == guildID
+}
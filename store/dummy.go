@@ -14,18 +14,22 @@ import (
 
 // Dummy is a store backend that stores everything in memory.
 type Dummy struct {
-	mu       sync.Mutex
-	users    users
-	nonces   map[string]nonceRecord
-	messages map[string][]stream.RXMessage
+	cfg           backendConfig
+	mu            sync.Mutex
+	users         users
+	nonces        map[string]nonceRecord
+	discordNonces map[string]discordNonceRecord
+	messages      map[string][]stream.RXMessage
 }
 
 // NewDummy creates a new Dummy store.
-func NewDummy() *Dummy {
+func NewDummy(opts ...BackendOption) *Dummy {
 	return &Dummy{
-		users:    make(users),
-		nonces:   make(map[string]nonceRecord),
-		messages: make(map[string][]stream.RXMessage),
+		cfg:           newBackendConfig(opts),
+		users:         make(users),
+		nonces:        make(map[string]nonceRecord),
+		discordNonces: make(map[string]discordNonceRecord),
+		messages:      make(map[string][]stream.RXMessage),
 	}
 }
 
@@ -71,8 +75,9 @@ func (d *Dummy) AuthenticateUser(username, password string) (string, bool, error
 func (d *Dummy) OauthNonce(userID string, tu TwitchUser) (nonce string, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	now := time.Now()
 	for nonce, nr := range d.nonces {
-		if nr.UserID == userID && nr.TU == tu {
+		if nr.UserID == userID && nr.TU == tu && !d.cfg.nonceExpired(nr.Created, now) {
 			return nonce, nil
 		}
 	}
@@ -91,6 +96,11 @@ func (d *Dummy) StoreOauthNonce(userID string, tu TwitchUser, nonce string) erro
 		return errors.New("bad twitch user type in CreateOauthNonce")
 	}
 
+	for n, nr := range d.nonces {
+		if nr.UserID == userID && nr.TU == tu {
+			delete(d.nonces, n)
+		}
+	}
 	d.nonces[nonce] = nonceRecord{
 		Nonce:   nonce,
 		UserID:  userID,
@@ -105,8 +115,8 @@ func (d *Dummy) StoreOauthNonce(userID string, tu TwitchUser, nonce string) erro
 func (d *Dummy) OauthNonceExists(nonce string) (bool, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	_, ok := d.nonces[nonce]
-	return ok, nil
+	nr, ok := d.nonces[nonce]
+	return ok && !d.cfg.nonceExpired(nr.Created, time.Now()), nil
 }
 
 // FinishOauthNonce completes the oauth flow, removing the nonce and storing
@@ -121,7 +131,7 @@ func (d *Dummy) FinishOauthNonce(
 	defer d.mu.Unlock()
 
 	nr, ok := d.nonces[nonce]
-	if !ok {
+	if !ok || d.cfg.nonceExpired(nr.Created, time.Now()) {
 		return ErrUnknownNonce
 	}
 
@@ -144,6 +154,29 @@ func (d *Dummy) FinishOauthNonce(
 	return nil
 }
 
+// SweepOauthNonces removes Twitch and Discord oauth nonces that have
+// expired.
+func (d *Dummy) SweepOauthNonces() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var removed int
+	for nonce, nr := range d.nonces {
+		if d.cfg.nonceExpired(nr.Created, now) {
+			delete(d.nonces, nonce)
+			removed++
+		}
+	}
+	for nonce, nr := range d.discordNonces {
+		if d.cfg.nonceExpired(nr.Created, now) {
+			delete(d.discordNonces, nonce)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
 // TwitchCredentials gives you the twitch credentials for a given users.
 func (d *Dummy) TwitchCredentials(userID string) (TwitchCredentials, error) {
 	d.mu.Lock()
@@ -176,6 +209,154 @@ func (d *Dummy) TwitchClearAuth(userID string) error {
 	return nil
 }
 
+// DiscordCredentials gives you the Discord guild the user has linked.
+func (d *Dummy) DiscordCredentials(userID string) (DiscordCredentials, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return DiscordCredentials{}, ErrUnknownUserID
+	}
+	return ur.discordCredentials(), nil
+}
+
+// StoreDiscordGuild links the Discord guild with the given owner to the
+// user.
+func (d *Dummy) StoreDiscordGuild(userID, ownerID, guildID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	ur.DiscordOwnerID = ownerID
+	ur.DiscordGuildID = guildID
+	d.users[userID] = ur
+	return nil
+}
+
+// DiscordOauthNonce gets the nonce of the user's Discord oauth flow if it
+// exists.
+func (d *Dummy) DiscordOauthNonce(userID string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for nonce, nr := range d.discordNonces {
+		if nr.UserID == userID && !d.cfg.nonceExpired(nr.Created, now) {
+			return nonce, nil
+		}
+	}
+	return "", ErrUnknownNonce
+}
+
+// StoreDiscordOauthNonce stores the nonce of the user's Discord oauth flow,
+// replacing any nonce that was abandoned.
+func (d *Dummy) StoreDiscordOauthNonce(userID, nonce string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for n, nr := range d.discordNonces {
+		if nr.UserID == userID {
+			delete(d.discordNonces, n)
+		}
+	}
+	d.discordNonces[nonce] = discordNonceRecord{
+		Nonce:   nonce,
+		UserID:  userID,
+		Created: time.Now(),
+	}
+	return nil
+}
+
+// DiscordOauthNonceExists tells you if the provided Discord nonce was
+// recently created and not yet finished.
+func (d *Dummy) DiscordOauthNonceExists(nonce string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nr, ok := d.discordNonces[nonce]
+	return ok && !d.cfg.nonceExpired(nr.Created, time.Now()), nil
+}
+
+// FinishDiscordOauthNonce completes the Discord oauth flow, removing the
+// nonce and linking the guild the bot was added to.
+func (d *Dummy) FinishDiscordOauthNonce(nonce, ownerID, guildID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nr, ok := d.discordNonces[nonce]
+	if !ok || d.cfg.nonceExpired(nr.Created, time.Now()) {
+		return ErrUnknownNonce
+	}
+	ur, ok := d.users[nr.UserID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	ur.DiscordOwnerID = ownerID
+	ur.DiscordGuildID = guildID
+
+	delete(d.discordNonces, nonce)
+	d.users[nr.UserID] = ur
+	return nil
+}
+
+// DiscordClearAuth unlinks the user's Discord guild.
+func (d *Dummy) DiscordClearAuth(userID string) error {
+	return d.StoreDiscordGuild(userID, "", "")
+}
+
+// DiscordAuthenticatedUsers gets the IDs of the users that have linked a
+// Discord guild.
+func (d *Dummy) DiscordAuthenticatedUsers() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var userIDs []string
+	for id, ur := range d.users {
+		if ur.discordCredentials().Authenticated {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs, nil
+}
+
+// TwitchAuthenticatedUsers gets the IDs of the users that have authenticated
+// both their streamer and bot users with twitch.
+func (d *Dummy) TwitchAuthenticatedUsers() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var userIDs []string
+	for id, ur := range d.users {
+		if ur.StreamerOD.AccessToken != "" && ur.BotOD.AccessToken != "" {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs, nil
+}
+
+// TwitchOauthData gets the oauth data for the given twitch user.
+func (d *Dummy) TwitchOauthData(twitchUsername string) (OauthData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ur := range d.users {
+		if od, ok := ur.twitchOauthData(twitchUsername); ok {
+			return od, nil
+		}
+	}
+	return OauthData{}, ErrUnknownUsername
+}
+
+// UpdateTwitchOauthData replaces the oauth data for the given twitch user.
+func (d *Dummy) UpdateTwitchOauthData(twitchUsername string, od OauthData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, ur := range d.users {
+		if ur.setTwitchOauthData(twitchUsername, od) {
+			d.users[id] = ur
+			return nil
+		}
+	}
+	return ErrUnknownUsername
+}
+
 // StoreMessage stores a message for a given user for later searching and
 // scrollback history.
 func (d *Dummy) StoreMessage(msg stream.RXMessage) error {
@@ -183,6 +364,8 @@ func (d *Dummy) StoreMessage(msg stream.RXMessage) error {
 	if err != nil {
 		return err
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	// TODO: dedupe messages?
 	d.messages[key] = append(d.messages[key], msg)
 	return nil
@@ -190,29 +373,326 @@ func (d *Dummy) StoreMessage(msg stream.RXMessage) error {
 
 // FetchRecentMessages gets the recent messages for the user's channel.
 func (d *Dummy) FetchRecentMessages(userID string) ([]stream.RXMessage, error) {
-	creds, err := d.TwitchCredentials(userID)
+	msgs, _, err := d.FetchMessages(userID, "", 500)
+	return msgs, err
+}
+
+// FetchRecentDiscordMessages gets the recent messages from the user's linked
+// Discord guild in chronological order.
+func (d *Dummy) FetchRecentDiscordMessages(userID string) ([]stream.RXMessage, error) {
+	creds, err := d.DiscordCredentials(userID)
 	if err != nil {
 		return nil, err
 	}
+	if !creds.Authenticated {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	var messages []stream.RXMessage
+	messages = append(messages, d.messages["discord:"+creds.OwnerID]...)
+	d.mu.Unlock()
+
+	messages = guildMessages(messages, creds.GuildID)
+	if len(messages) > recentDiscordLimit {
+		messages = messages[len(messages)-recentDiscordLimit:]
+	}
+	return messages, nil
+}
+
+// FetchMessages gets a page of messages for the user's channel in
+// chronological order.
+func (d *Dummy) FetchMessages(userID, cursor string, limit int) ([]stream.RXMessage, string, error) {
+	creds, err := d.TwitchCredentials(userID)
+	if err != nil {
+		return nil, "", err
+	}
 	if !creds.StreamerAuthenticated || !creds.BotAuthenticated {
-		return nil, errors.New("user is not authenticated with twitch")
+		return nil, "", errors.New("user is not authenticated with twitch")
 	}
 
-	messages := d.messages["twitch:"+strconv.Itoa(creds.StreamerTwitchUserID)]
-	messages = append(
-		messages,
-		d.messages["twitch:"+strconv.Itoa(creds.BotTwitchUserID)]...,
-	)
+	d.mu.Lock()
+	var messages []stream.RXMessage
+	messages = append(messages, d.messages["twitch:"+strconv.Itoa(creds.StreamerTwitchUserID)]...)
+	messages = append(messages, d.messages["twitch:"+strconv.Itoa(creds.BotTwitchUserID)]...)
+	d.mu.Unlock()
 
-	sort.Slice(messages, func(i, j int) bool {
+	sort.SliceStable(messages, func(i, j int) bool {
 		return messages[i].Twitch.Line.Time.Before(messages[j].Twitch.Line.Time)
 	})
 
-	return messages[:min(len(messages), 500)], nil
+	return pageMessages(messages, cursor, limit)
+}
+
+// RetentionPolicy gets the user's message retention policy.
+func (d *Dummy) RetentionPolicy(userID string) (RetentionPolicy, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return RetentionPolicy{}, ErrUnknownUserID
+	}
+	return ur.Retention, nil
+}
+
+// SetRetentionPolicy sets the user's message retention policy.
+func (d *Dummy) SetRetentionPolicy(userID string, policy RetentionPolicy) error {
+	if !policy.valid() {
+		return ErrInvalidRetentionPolicy
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	ur.Retention = policy
+	d.users[userID] = ur
+	return nil
+}
+
+// PruneMessages removes messages that have expired according to each user's
+// retention policy.
+func (d *Dummy) PruneMessages() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var removed int
+	for _, ur := range d.users {
+		if ur.Retention.unlimited() {
+			continue
+		}
+		for _, key := range ur.messageKeys() {
+			kept := retainMessages(d.messages[key], ur.Retention, now)
+			removed += len(d.messages[key]) - len(kept)
+			d.messages[key] = kept
+		}
+	}
+	return removed, nil
+}
+
+// CustomCommands gets the user's custom commands ordered by trigger.
+func (d *Dummy) CustomCommands(userID string) ([]CustomCommand, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return nil, ErrUnknownUserID
+	}
+	return sortCustomCommands(ur.Commands), nil
+}
+
+// SetCustomCommand creates or replaces the response of the user's custom
+// command with the same trigger.
+func (d *Dummy) SetCustomCommand(userID string, cmd CustomCommand) error {
+	if !cmd.valid() {
+		return ErrInvalidCustomCommand
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	if ur.Commands == nil {
+		ur.Commands = make(map[string]CustomCommand)
+	}
+	cmd.Count = ur.Commands[cmd.Trigger].Count
+	ur.Commands[cmd.Trigger] = cmd
+	d.users[userID] = ur
+	return nil
+}
+
+// DeleteCustomCommand removes the user's custom command with the given
+// trigger.
+func (d *Dummy) DeleteCustomCommand(userID, trigger string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	if _, ok := ur.Commands[trigger]; !ok {
+		return ErrUnknownCustomCommand
+	}
+	delete(ur.Commands, trigger)
+	return nil
+}
+
+// IncrementCustomCommandCount records that the user's custom command was
+// used, returning the updated count.
+func (d *Dummy) IncrementCustomCommandCount(userID, trigger string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return 0, ErrUnknownUserID
+	}
+	cmd, ok := ur.Commands[trigger]
+	if !ok {
+		return 0, ErrUnknownCustomCommand
+	}
+	cmd.Count++
+	ur.Commands[trigger] = cmd
+	return cmd.Count, nil
+}
+
+// ModerationRules gets the rules used to moderate the user's channel.
+func (d *Dummy) ModerationRules(userID string) (ModerationRules, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ModerationRules{}, ErrUnknownUserID
+	}
+	return ur.Moderation, nil
+}
+
+// SetModerationRules sets the rules used to moderate the user's channel.
+func (d *Dummy) SetModerationRules(userID string, rules ModerationRules) error {
+	if !rules.valid() {
+		return ErrInvalidModerationRules
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	ur.Moderation = rules
+	d.users[userID] = ur
+	return nil
+}
+
+// ModerationStrikes gets the strikes of chatters in the user's channel
+// ordered by chatter.
+func (d *Dummy) ModerationStrikes(userID string) ([]Strike, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return nil, ErrUnknownUserID
+	}
+	return sortStrikes(ur.Strikes), nil
+}
+
+// SetModerationStrike creates or replaces the strike of the chatter in the
+// user's channel.
+func (d *Dummy) SetModerationStrike(userID string, strike Strike) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	if ur.Strikes == nil {
+		ur.Strikes = make(map[string]Strike)
+	}
+	ur.Strikes[strike.Chatter] = strike
+	d.users[userID] = ur
+	return nil
+}
+
+// DeleteModerationStrikes removes the strikes in the user's channel whose
+// last violation was before the given time.
+func (d *Dummy) DeleteModerationStrikes(userID string, before time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	for chatter, s := range ur.Strikes {
+		if s.Last.Before(before) {
+			delete(ur.Strikes, chatter)
+		}
+	}
+	return nil
+}
+
+// Timers gets the user's timers ordered by name.
+func (d *Dummy) Timers(userID string) ([]Timer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return nil, ErrUnknownUserID
+	}
+	return sortTimers(ur.Timers), nil
+}
+
+// SetTimer creates or replaces the user's timer with the same name.
+func (d *Dummy) SetTimer(userID string, timer Timer) error {
+	if !timer.valid() {
+		return ErrInvalidTimer
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	if ur.Timers == nil {
+		ur.Timers = make(map[string]Timer)
+	}
+	ur.Timers[timer.Name] = timer
+	d.users[userID] = ur
+	return nil
+}
+
+// DeleteTimer removes the user's timer with the given name.
+func (d *Dummy) DeleteTimer(userID, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ur, ok := d.users[userID]
+	if !ok {
+		return ErrUnknownUserID
+	}
+	if _, ok := ur.Timers[name]; !ok {
+		return ErrUnknownTimer
+	}
+	delete(ur.Timers, name)
+	return nil
 }
 
 // QueryMessages allows the user to search for messages that match a search
 // string.
 func (d *Dummy) QueryMessages(userID, search string) ([]stream.RXMessage, error) {
-	panic("not implemented")
+	tc, err := d.TwitchCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := d.DiscordCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := searchTerms(search)
+	keys := searchKeys(tc, dc)
+	if len(terms) == 0 || len(keys) == 0 {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var results []searchResult
+	for _, key := range keys {
+		for _, msg := range d.messages[key] {
+			if !searchable(msg, dc) {
+				continue
+			}
+			score := scoreMessage(msg, terms)
+			if score == 0 {
+				continue
+			}
+			results = append(results, searchResult{
+				msg:   msg,
+				score: score,
+			})
+		}
+	}
+
+	return rankResults(results, 500), nil
 }
@@ -18,4 +18,33 @@ var (
 	// ErrInvalidTwitchUserType is returned when providing an invalid twitch
 	// user type.
 	ErrInvalidTwitchUserType = errors.New("invalid twitch user type")
+
+	// ErrInvalidCursor is returned when providing a cursor that was not
+	// returned from a previous call to fetch messages.
+	ErrInvalidCursor = errors.New("invalid message cursor")
+	// ErrInvalidLimit is returned when asking for a page of messages with a
+	// limit less than one.
+	ErrInvalidLimit = errors.New("invalid message limit")
+
+	// ErrInvalidRetentionPolicy is returned when providing a retention policy
+	// with negative limits.
+	ErrInvalidRetentionPolicy = errors.New("invalid retention policy")
+
+	// ErrInvalidCustomCommand is returned when providing a custom command
+	// without a single word trigger or without a response.
+	ErrInvalidCustomCommand = errors.New("invalid custom command")
+	// ErrUnknownCustomCommand is returned when providing a trigger for a
+	// custom command that does not exist.
+	ErrUnknownCustomCommand = errors.New("custom command does not exist")
+
+	// ErrInvalidModerationRules is returned when providing moderation rules
+	// with patterns that do not compile or with negative limits.
+	ErrInvalidModerationRules = errors.New("invalid moderation rules")
+
+	// ErrInvalidTimer is returned when providing a timer without a name or
+	// message or with an interval shorter than a minute.
+	ErrInvalidTimer = errors.New("invalid timer")
+	// ErrUnknownTimer is returned when providing the name of a timer that
+	// does not exist.
+	ErrUnknownTimer = errors.New("timer does not exist")
 )
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"strconv"
+
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// pageMessages returns a page of at most limit messages that are older than
+// the cursor. The messages are expected to be in chronological order.
+//
+// The cursor is the number of messages that are older than the page. This
+// stays stable as new messages are appended to the end of the slice.
+func pageMessages(
+	messages []stream.RXMessage,
+	cursor string,
+	limit int,
+) ([]stream.RXMessage, string, error) {
+	if limit < 1 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	end := len(messages)
+	if cursor != "" {
+		var err error
+		end, err = strconv.Atoi(cursor)
+		if err != nil || end < 0 || end > len(messages) {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	var next string
+	if start > 0 {
+		next = strconv.Itoa(start)
+	}
+	return messages[start:end], next, nil
+}
@@ -0,0 +1,6 @@
+DROP TRIGGER tsv_on_message ON message;
+
+DROP INDEX message_body_tsv_idx;
+
+ALTER TABLE message DROP COLUMN body_tsv;
+ALTER TABLE message DROP COLUMN body;
@@ -0,0 +1,14 @@
+ALTER TABLE message ADD COLUMN body TEXT NOT NULL DEFAULT '';
+ALTER TABLE message ADD COLUMN body_tsv TSVECTOR;
+
+UPDATE message SET body = COALESCE(message::json->'twitch'->'line'->'Args'->>1, '') WHERE source = 'Twitch';
+UPDATE message SET body = COALESCE(message::json->'discord'->'message_create'->>'content', '') WHERE source = 'Discord';
+UPDATE message SET body_tsv = TO_TSVECTOR('pg_catalog.english', body);
+
+CREATE INDEX message_body_tsv_idx ON message USING GIN(body_tsv);
+
+CREATE TRIGGER tsv_on_message
+BEFORE INSERT OR UPDATE
+ON message
+FOR EACH ROW
+EXECUTE PROCEDURE tsvector_update_trigger(body_tsv, 'pg_catalog.english', body);
@@ -0,0 +1,3 @@
+DROP INDEX message_twitch_owner_id_message_id_idx;
+
+ALTER TABLE message DROP COLUMN message_id;
@@ -0,0 +1,3 @@
+ALTER TABLE message ADD COLUMN message_id BIGSERIAL PRIMARY KEY;
+
+CREATE INDEX message_twitch_owner_id_message_id_idx ON message (twitch_owner_id, message_id);
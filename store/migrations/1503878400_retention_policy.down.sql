@@ -0,0 +1,4 @@
+DROP INDEX message_created_idx;
+
+ALTER TABLE "user" DROP COLUMN retention_max_count;
+ALTER TABLE "user" DROP COLUMN retention_max_age;
@@ -0,0 +1,4 @@
+ALTER TABLE "user" ADD COLUMN retention_max_age BIGINT NOT NULL DEFAULT 0; -- seconds
+ALTER TABLE "user" ADD COLUMN retention_max_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX message_created_idx ON message (created);
@@ -0,0 +1 @@
+DROP TABLE custom_command;
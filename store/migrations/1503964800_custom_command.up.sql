@@ -0,0 +1,17 @@
+CREATE TABLE custom_command (
+    created  TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+    modified TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+
+    user_id  UUID REFERENCES "user",
+    trigger  VARCHAR(255),
+    response TEXT NOT NULL,
+    count    INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(user_id, trigger)
+);
+
+CREATE TRIGGER row_mod_on_custom_command
+BEFORE UPDATE
+ON custom_command
+FOR EACH ROW
+EXECUTE PROCEDURE update_row_modified();
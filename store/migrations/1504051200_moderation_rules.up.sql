@@ -0,0 +1 @@
+ALTER TABLE "user" ADD COLUMN moderation_rules TEXT NOT NULL DEFAULT '{}'; -- json
@@ -0,0 +1,18 @@
+CREATE TABLE timer (
+    created          TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+    modified         TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+
+    user_id          UUID REFERENCES "user",
+    name             VARCHAR(255),
+    message          TEXT NOT NULL,
+    interval_seconds BIGINT NOT NULL,
+    min_lines        INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(user_id, name)
+);
+
+CREATE TRIGGER row_mod_on_timer
+BEFORE UPDATE
+ON timer
+FOR EACH ROW
+EXECUTE PROCEDURE update_row_modified();
@@ -0,0 +1,2 @@
+ALTER TABLE "user" DROP COLUMN discord_guild_id;
+ALTER TABLE "user" DROP COLUMN discord_owner_id;
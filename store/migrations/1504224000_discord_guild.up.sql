@@ -0,0 +1,2 @@
+ALTER TABLE "user" ADD COLUMN discord_owner_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE "user" ADD COLUMN discord_guild_id VARCHAR(255) NOT NULL DEFAULT '';
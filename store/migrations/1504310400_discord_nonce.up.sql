@@ -0,0 +1,16 @@
+CREATE TABLE discord_nonce (
+    created  TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+    modified TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+
+    user_id UUID REFERENCES "user",
+    nonce   TEXT,
+
+    PRIMARY KEY(user_id),
+    UNIQUE(nonce)
+);
+
+CREATE TRIGGER row_mod_on_discord_nonce
+BEFORE UPDATE
+ON discord_nonce
+FOR EACH ROW
+EXECUTE PROCEDURE update_row_modified();
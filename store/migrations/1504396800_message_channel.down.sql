@@ -0,0 +1,3 @@
+DROP INDEX message_discord_owner_id_message_id_idx;
+
+ALTER TABLE message DROP COLUMN channel;
@@ -0,0 +1,20 @@
+ALTER TABLE message ADD COLUMN channel VARCHAR(255) NOT NULL DEFAULT '';
+
+UPDATE message SET channel = COALESCE(
+    NULLIF(message::json->'twitch'->>'channel', ''),
+    CASE WHEN message::json->'twitch'->'line'->'Args'->>0 LIKE '#%'
+        THEN message::json->'twitch'->'line'->'Args'->>0
+    END,
+    ''
+) WHERE source='Twitch';
+
+UPDATE message SET channel = COALESCE(
+    message::json->'discord'->'message_create'->>'channel_id',
+    message::json->'discord'->'message_update'->>'channel_id',
+    message::json->'discord'->'message_delete'->>'channel_id',
+    message::json->'discord'->'message_reaction_add'->>'channel_id',
+    message::json->'discord'->'message_reaction_remove'->>'channel_id',
+    ''
+) WHERE source='Discord';
+
+CREATE INDEX message_discord_owner_id_message_id_idx ON message (discord_owner_id, message_id);
@@ -0,0 +1,17 @@
+CREATE TABLE moderation_strike (
+    created  TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+    modified TIMESTAMP WITH TIME ZONE DEFAULT CLOCK_TIMESTAMP(),
+
+    user_id  UUID REFERENCES "user",
+    chatter  VARCHAR(255),
+    count    INTEGER NOT NULL,
+    last     TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY(user_id, chatter)
+);
+
+CREATE TRIGGER row_mod_on_moderation_strike
+BEFORE UPDATE
+ON moderation_strike
+FOR EACH ROW
+EXECUTE PROCEDURE update_row_modified();
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ModerationRules controls how the bot moderates a user's channel. A zero
+// value disables moderation.
+type ModerationRules struct {
+	// Enabled turns moderation on or off.
+	Enabled bool `json:"enabled"`
+	// BannedPhrases are phrases that are not allowed in messages. They are
+	// matched without regard to case.
+	BannedPhrases []string `json:"banned_phrases"`
+	// BannedPatterns are regular expressions that messages must not match.
+	BannedPatterns []string `json:"banned_patterns"`
+	// BlockLinks disallows links from chatters that are not VIPs,
+	// moderators or the broadcaster.
+	BlockLinks bool `json:"block_links"`
+	// AllowedDomains are domains that may be linked even when links are
+	// blocked. Subdomains are also allowed.
+	AllowedDomains []string `json:"allowed_domains"`
+	// MaxCapsPercent is the percentage of letters in a message that may be
+	// capitalized. Zero means there is no limit.
+	MaxCapsPercent int `json:"max_caps_percent"`
+	// MinCapsLength is how many letters a message needs before it is
+	// checked for excessive caps.
+	MinCapsLength int `json:"min_caps_length"`
+	// MaxEmotes is how many emotes a message may contain. Zero means there
+	// is no limit.
+	MaxEmotes int `json:"max_emotes"`
+	// TimeoutDuration is how long chatters are timed out for after their
+	// first warning.
+	TimeoutDuration time.Duration `json:"timeout_duration"`
+	// BanAfter is how many violations a chatter may have before they are
+	// banned. Zero means chatters are never banned.
+	BanAfter int `json:"ban_after"`
+}
+
+// valid returns true if the patterns compile and none of the limits are
+// negative.
+func (r ModerationRules) valid() bool {
+	for _, p := range r.BannedPatterns {
+		_, err := regexp.Compile(p)
+		if err != nil {
+			return false
+		}
+	}
+	return r.MaxCapsPercent >= 0 && r.MaxCapsPercent <= 100 &&
+		r.MinCapsLength >= 0 &&
+		r.MaxEmotes >= 0 &&
+		r.TimeoutDuration >= 0 &&
+		r.BanAfter >= 0
+}
+
+// Strike records the recent violations of a chatter in a user's channel.
+type Strike struct {
+	// Chatter is the username of the chatter.
+	Chatter string `json:"chatter"`
+	// Count is how many violations the chatter has had.
+	Count int `json:"count"`
+	// Last is when the chatter last violated the rules.
+	Last time.Time `json:"last"`
+}
+
+// sortStrikes returns the strikes ordered by chatter.
+func sortStrikes(strikes map[string]Strike) []Strike {
+	sorted := make([]Strike, 0, len(strikes))
+	for _, s := range strikes {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Chatter < sorted[j].Chatter
+	})
+	return sorted
+}
@@ -0,0 +1,39 @@
+package store
+
+import "time"
+
+// DefaultNonceTTL is how long an oauth nonce is valid for if not configured
+// otherwise.
+const DefaultNonceTTL = 10 * time.Minute
+
+// BackendOption is used to configure a storage backend.
+type BackendOption func(*backendConfig)
+
+type backendConfig struct {
+	nonceTTL time.Duration
+}
+
+// WithNonceTTL allows you to override how long oauth nonces are valid for.
+// Nonces older than the TTL are treated as though they do not exist. A TTL
+// of zero disables expiry.
+func WithNonceTTL(ttl time.Duration) BackendOption {
+	return func(c *backendConfig) {
+		c.nonceTTL = ttl
+	}
+}
+
+func newBackendConfig(opts []BackendOption) backendConfig {
+	c := backendConfig{
+		nonceTTL: DefaultNonceTTL,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// nonceExpired returns true if a nonce created at the given time is older
+// than the TTL.
+func (c backendConfig) nonceExpired(created, now time.Time) bool {
+	return c.nonceTTL > 0 && now.Sub(created) > c.nonceTTL
+}
@@ -0,0 +1,47 @@
+package store
+
+import "time"
+
+// periodic runs a job on an interval until it is stopped. It is embedded by
+// the types that do periodic maintenance of a store.
+type periodic struct {
+	interval time.Duration
+	job      func()
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newPeriodic(interval time.Duration, job func()) *periodic {
+	return &periodic{
+		interval: interval,
+		job:      job,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the job on an interval. It needs to run in its own goroutine.
+func (p *periodic) Start() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		p.job()
+	}
+}
+
+// Stop signals to the goroutine running the job to stop. It returns a
+// function that can be used to block until the job has finished.
+func (p *periodic) Stop() (wait func()) {
+	close(p.stop)
+	return func() {
+		<-p.done
+	}
+}
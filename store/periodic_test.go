@@ -0,0 +1,66 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestPrunerPrunesOnAnInterval(t *testing.T) {
+	expect := expect.New(t)
+
+	s := &spyMaintainer{
+		called: make(chan struct{}, 10),
+	}
+	p := store.NewPruner(s, store.WithPruneInterval(time.Millisecond))
+	go p.Start()
+
+	expect(s.wait()).To.Be.True()
+	p.Stop()()
+}
+
+func TestSweeperSweepsOnAnInterval(t *testing.T) {
+	expect := expect.New(t)
+
+	s := &spyMaintainer{
+		called: make(chan struct{}, 10),
+	}
+	sw := store.NewSweeper(s, store.WithSweepInterval(time.Millisecond))
+	go sw.Start()
+
+	expect(s.wait()).To.Be.True()
+	sw.Stop()()
+}
+
+type spyMaintainer struct {
+	called chan struct{}
+}
+
+func (s *spyMaintainer) PruneMessages() (int, error) {
+	s.record()
+	return 1, nil
+}
+
+func (s *spyMaintainer) SweepOauthNonces() (int, error) {
+	s.record()
+	return 1, nil
+}
+
+func (s *spyMaintainer) record() {
+	select {
+	case s.called <- struct{}{}:
+	default:
+	}
+}
+
+func (s *spyMaintainer) wait() bool {
+	select {
+	case <-s.called:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"math"
+	"strconv"
+	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
 
@@ -18,10 +21,11 @@ import (
 type Postgres struct {
 	db  *sql.DB
 	key []byte
+	cfg backendConfig
 }
 
 // NewPostgres creates a new postgres store.
-func NewPostgres(url string, key []byte) (*Postgres, error) {
+func NewPostgres(url string, key []byte, opts ...BackendOption) (*Postgres, error) {
 	_, err := chacha20poly1305.New(key)
 	if err != nil {
 		return nil, err
@@ -33,6 +37,7 @@ func NewPostgres(url string, key []byte) (*Postgres, error) {
 	return &Postgres{
 		db:  db,
 		key: key,
+		cfg: newBackendConfig(opts),
 	}, nil
 }
 
@@ -133,13 +138,13 @@ func (p *Postgres) OauthNonce(userID string, tu TwitchUser) (nonce string, err e
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`SELECT nonce FROM nonce WHERE user_id=$1 AND twitch_user=$2`)
+	stmt, err := tx.Prepare(`SELECT nonce FROM nonce WHERE user_id=$1 AND twitch_user=$2 AND ` + nonceLiveCondition(3))
 	if err != nil {
 		return "", err
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(userID, tu.String()).Scan(&nonce)
+	err = stmt.QueryRow(userID, tu.String(), p.cfg.nonceTTL.Seconds()).Scan(&nonce)
 	if err != nil {
 		return "", err
 	}
@@ -154,6 +159,12 @@ func (p *Postgres) StoreOauthNonce(userID string, tu TwitchUser, nonce string) (
 	}
 	defer tx.Rollback()
 
+	// replace any nonce that was abandoned
+	_, err = tx.Exec(`DELETE FROM nonce WHERE user_id=$1 AND twitch_user=$2`, userID, tu.String())
+	if err != nil {
+		return err
+	}
+
 	istmt, err := tx.Prepare(`INSERT INTO nonce (user_id, twitch_user, nonce) VALUES ($1, $2, $3)`)
 	if err != nil {
 		return err
@@ -177,20 +188,23 @@ func (p *Postgres) OauthNonceExists(nonce string) (exists bool, err error) {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`SELECT COUNT(*) AS n FROM nonce WHERE nonce=$1`)
+	stmt, err := tx.Prepare(`SELECT COUNT(*) AS n FROM nonce WHERE nonce=$1 AND ` + nonceLiveCondition(2))
 	if err != nil {
 		return false, err
 	}
 	defer stmt.Close()
 
 	var n int
-	err = stmt.QueryRow(nonce).Scan(&n)
+	err = stmt.QueryRow(nonce, p.cfg.nonceTTL.Seconds()).Scan(&n)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
 		}
 		return false, err
 	}
+	if n == 0 {
+		return false, nil
+	}
 	if n != 1 {
 		return false, errors.New("invalid count of nonce")
 	}
@@ -215,7 +229,7 @@ func (p *Postgres) FinishOauthNonce(nonce, twitchUsername string, twitchUserID i
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`SELECT user_id, twitch_user FROM nonce WHERE nonce=$1`)
+	stmt, err := tx.Prepare(`SELECT user_id, twitch_user FROM nonce WHERE nonce=$1 AND ` + nonceLiveCondition(2))
 	if err != nil {
 		return err
 	}
@@ -225,8 +239,11 @@ func (p *Postgres) FinishOauthNonce(nonce, twitchUsername string, twitchUserID i
 		userID     string
 		twitchUser string
 	)
-	err = stmt.QueryRow(nonce).Scan(&userID, &twitchUser)
+	err = stmt.QueryRow(nonce, p.cfg.nonceTTL.Seconds()).Scan(&userID, &twitchUser)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUnknownNonce
+		}
 		return err
 	}
 
@@ -266,6 +283,37 @@ func (p *Postgres) FinishOauthNonce(nonce, twitchUsername string, twitchUserID i
 	return tx.Commit()
 }
 
+// SweepOauthNonces removes Twitch and Discord oauth nonces that have
+// expired.
+func (p *Postgres) SweepOauthNonces() (removed int, err error) {
+	if p.cfg.nonceTTL <= 0 {
+		return 0, nil
+	}
+	for _, table := range []string{"nonce", "discord_nonce"} {
+		result, err := p.db.Exec(
+			`DELETE FROM `+table+` WHERE NOT `+nonceLiveCondition(1),
+			p.cfg.nonceTTL.Seconds(),
+		)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		removed += int(n)
+	}
+	return removed, nil
+}
+
+// nonceLiveCondition filters out nonces older than the TTL. The TTL is given
+// in seconds as the query parameter at the given position. A TTL of zero
+// disables expiry.
+func nonceLiveCondition(param int) string {
+	p := "$" + strconv.Itoa(param) + "::float8"
+	return "(" + p + " = 0 OR created > CLOCK_TIMESTAMP() - " + p + " * INTERVAL '1 second')"
+}
+
 // TwitchCredentials gives you the twitch credentials for a given users.
 func (p *Postgres) TwitchCredentials(userID string) (creds TwitchCredentials, err error) {
 	tx, err := p.db.Begin()
@@ -368,104 +416,244 @@ func (p *Postgres) TwitchClearAuth(userID string) (err error) {
 	return tx.Commit()
 }
 
-// StoreMessage stores a message for a given user for later searching and
-// scrollback history.
-func (p *Postgres) StoreMessage(msg stream.RXMessage) (err error) {
-	message, err := json.Marshal(msg)
+// DiscordCredentials gives you the Discord guild the user has linked.
+func (p *Postgres) DiscordCredentials(userID string) (creds DiscordCredentials, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return DiscordCredentials{}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT discord_owner_id, discord_guild_id FROM "user" WHERE user_id=$1`)
+	if err != nil {
+		return DiscordCredentials{}, err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(userID).Scan(&creds.OwnerID, &creds.GuildID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return DiscordCredentials{}, ErrUnknownUserID
+		}
+		return DiscordCredentials{}, err
+	}
+	creds.Authenticated = creds.GuildID != ""
+
+	err = tx.Commit()
+	if err != nil {
+		return DiscordCredentials{}, err
+	}
+	return creds, nil
+}
+
+// StoreDiscordGuild links the Discord guild with the given owner to the
+// user.
+func (p *Postgres) StoreDiscordGuild(userID, ownerID, guildID string) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE "user" SET discord_owner_id=$2, discord_guild_id=$3 WHERE user_id=$1`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, ownerID, guildID)
 	if err != nil {
 		return err
 	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownUserID
+	}
+
+	return tx.Commit()
+}
+
+// DiscordOauthNonce gets the nonce of the user's Discord oauth flow if it
+// exists.
+func (p *Postgres) DiscordOauthNonce(userID string) (nonce string, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT nonce FROM discord_nonce WHERE user_id=$1 AND ` + nonceLiveCondition(2))
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(userID, p.cfg.nonceTTL.Seconds()).Scan(&nonce)
+	if err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
 
+// StoreDiscordOauthNonce stores the nonce of the user's Discord oauth flow,
+// replacing any nonce that was abandoned.
+func (p *Postgres) StoreDiscordOauthNonce(userID, nonce string) (err error) {
 	tx, err := p.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	switch msg.Type {
-	case stream.Twitch:
-		if msg.Twitch == nil {
-			return errors.New("invalid twitch message")
-		}
-		stmt, err := tx.Prepare(`INSERT INTO message (source, message, twitch_owner_id) VALUES ($1, $2, $3)`)
-		if err != nil {
-			return err
-		}
-		defer stmt.Close()
-		_, err = stmt.Exec(msg.Type.String(), message, msg.Twitch.OwnerID)
-		if err != nil {
-			return err
-		}
-	case stream.Discord:
-		if msg.Discord == nil {
-			return errors.New("invalid discord message")
-		}
-		stmt, err := tx.Prepare(`INSERT INTO message (source, message, discord_owner_id) VALUES ($1, $2, $3)`)
-		if err != nil {
-			return err
-		}
-		defer stmt.Close()
-		_, err = stmt.Exec(msg.Type.String(), message, msg.Discord.OwnerID)
-		if err != nil {
-			return err
-		}
-	default:
-		return errors.New("invalid message type")
+	// replace any nonce that was abandoned
+	_, err = tx.Exec(`DELETE FROM discord_nonce WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+
+	istmt, err := tx.Prepare(`INSERT INTO discord_nonce (user_id, nonce) VALUES ($1, $2)`)
+	if err != nil {
+		return err
+	}
+	defer istmt.Close()
+
+	_, err = istmt.Exec(userID, nonce)
+	if err != nil {
+		return err
 	}
 
 	return tx.Commit()
 }
 
-// FetchRecentMessages gets the recent messages for the user's channel.
-func (p *Postgres) FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error) {
+// DiscordOauthNonceExists tells you if the provided Discord nonce was
+// recently created and not yet finished.
+func (p *Postgres) DiscordOauthNonceExists(nonce string) (exists bool, err error) {
 	tx, err := p.db.Begin()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`SELECT streamer_id, bot_id FROM "user" WHERE user_id=$1`)
+	stmt, err := tx.Prepare(`SELECT COUNT(*) AS n FROM discord_nonce WHERE nonce=$1 AND ` + nonceLiveCondition(2))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	defer stmt.Close()
-	var (
-		twitchStreamerID int
-		twitchBotID      int
-	)
-	err = stmt.QueryRow(userID).Scan(&twitchStreamerID, &twitchBotID)
+
+	var n int
+	err = stmt.QueryRow(nonce, p.cfg.nonceTTL.Seconds()).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// FinishDiscordOauthNonce completes the Discord oauth flow, removing the
+// nonce and linking the guild the bot was added to.
+func (p *Postgres) FinishDiscordOauthNonce(nonce, ownerID, guildID string) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRow(
+		`DELETE FROM discord_nonce WHERE nonce=$1 AND `+nonceLiveCondition(2)+` RETURNING user_id`,
+		nonce,
+		p.cfg.nonceTTL.Seconds(),
+	).Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return ErrUnknownNonce
 		}
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE "user" SET discord_owner_id=$2, discord_guild_id=$3 WHERE user_id=$1`,
+		userID,
+		ownerID,
+		guildID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DiscordClearAuth unlinks the user's Discord guild.
+func (p *Postgres) DiscordClearAuth(userID string) (err error) {
+	return p.StoreDiscordGuild(userID, "", "")
+}
+
+// DiscordAuthenticatedUsers gets the IDs of the users that have linked a
+// Discord guild.
+func (p *Postgres) DiscordAuthenticatedUsers() (userIDs []string, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	mstmt, err := tx.Prepare(`SELECT message FROM message WHERE source='Twitch' AND (twitch_owner_id=$1 OR twitch_owner_id=$2) LIMIT 500`)
+	stmt, err := tx.Prepare(`SELECT user_id FROM "user" WHERE discord_guild_id != ''`)
 	if err != nil {
 		return nil, err
 	}
-	defer mstmt.Close()
-	rows, err := mstmt.Query(twitchStreamerID, twitchBotID)
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []stream.RXMessage
 	for rows.Next() {
-		var messageBytes []byte
-		err := rows.Scan(&messageBytes)
+		var userID string
+		err = rows.Scan(&userID)
 		if err != nil {
 			return nil, err
 		}
+		userIDs = append(userIDs, userID)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
 
-		var message stream.RXMessage
-		err = json.Unmarshal(messageBytes, &message)
+// TwitchAuthenticatedUsers gets the IDs of the users that have authenticated
+// both their streamer and bot users with twitch.
+func (p *Postgres) TwitchAuthenticatedUsers() (userIDs []string, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT user_id FROM "user" WHERE streamer_oauth_data != '' AND bot_oauth_data != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, message)
+		userIDs = append(userIDs, userID)
 	}
 	err = rows.Err()
 	if err != nil {
@@ -476,11 +664,938 @@ func (p *Postgres) FetchRecentMessages(userID string) (msgs []stream.RXMessage,
 	if err != nil {
 		return nil, err
 	}
-	return messages, nil
+	return userIDs, nil
 }
 
-// QueryMessages allows the user to search for messages that match a
-// search string.
-func (p *Postgres) QueryMessages(userID string, search string) (msgs []stream.RXMessage, err error) {
-	panic("not implemented")
+// TwitchOauthData gets the oauth data for the given twitch user.
+func (p *Postgres) TwitchOauthData(twitchUsername string) (od OauthData, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return OauthData{}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT streamer_username, streamer_oauth_data, bot_oauth_data FROM "user" WHERE streamer_username=$1 OR bot_username=$1`)
+	if err != nil {
+		return OauthData{}, err
+	}
+	defer stmt.Close()
+
+	var (
+		streamerUsername  string
+		streamerOauthData string
+		botOauthData      string
+	)
+	err = stmt.QueryRow(twitchUsername).Scan(
+		&streamerUsername,
+		&streamerOauthData,
+		&botOauthData,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return OauthData{}, ErrUnknownUsername
+		}
+		return OauthData{}, err
+	}
+
+	box := botOauthData
+	if streamerUsername == twitchUsername {
+		box = streamerOauthData
+	}
+	if len(box) == 0 {
+		return OauthData{}, nil
+	}
+	plain, err := Decrypt(box, p.key)
+	if err != nil {
+		return OauthData{}, err
+	}
+	err = json.Unmarshal(plain, &od)
+	if err != nil {
+		return OauthData{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return OauthData{}, err
+	}
+	return od, nil
+}
+
+// UpdateTwitchOauthData replaces the oauth data for the given twitch user.
+func (p *Postgres) UpdateTwitchOauthData(twitchUsername string, od OauthData) (err error) {
+	odJSON, err := json.Marshal(od)
+	if err != nil {
+		return err
+	}
+	box, err := Encrypt(odJSON, p.key)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var updated int64
+	for _, query := range []string{
+		`UPDATE "user" SET streamer_oauth_data=$2 WHERE streamer_username=$1`,
+		`UPDATE "user" SET bot_oauth_data=$2 WHERE bot_username=$1`,
+	} {
+		result, err := tx.Exec(query, twitchUsername, box)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		updated += n
+	}
+	if updated == 0 {
+		return ErrUnknownUsername
+	}
+
+	return tx.Commit()
+}
+
+// StoreMessage stores a message for a given user for later searching and
+// scrollback history.
+func (p *Postgres) StoreMessage(msg stream.RXMessage) (err error) {
+	message, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch msg.Type {
+	case stream.Twitch:
+		if msg.Twitch == nil {
+			return errors.New("invalid twitch message")
+		}
+		stmt, err := tx.Prepare(`INSERT INTO message (source, message, body, channel, twitch_owner_id) VALUES ($1, $2, $3, $4, $5)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		_, err = stmt.Exec(msg.Type.String(), message, messageBody(msg), messageChannel(msg), msg.Twitch.OwnerID)
+		if err != nil {
+			return err
+		}
+	case stream.Discord:
+		if msg.Discord == nil {
+			return errors.New("invalid discord message")
+		}
+		stmt, err := tx.Prepare(`INSERT INTO message (source, message, body, channel, discord_owner_id) VALUES ($1, $2, $3, $4, $5)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		_, err = stmt.Exec(msg.Type.String(), message, messageBody(msg), messageChannel(msg), msg.Discord.OwnerID)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("invalid message type")
+	}
+
+	return tx.Commit()
+}
+
+// FetchRecentMessages gets the recent messages for the user's channel.
+func (p *Postgres) FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error) {
+	msgs, _, err = p.FetchMessages(userID, "", 500)
+	return msgs, err
+}
+
+// FetchRecentDiscordMessages gets the recent messages from the user's linked
+// Discord guild in chronological order.
+func (p *Postgres) FetchRecentDiscordMessages(userID string) (msgs []stream.RXMessage, err error) {
+	creds, err := p.DiscordCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !creds.Authenticated {
+		return nil, nil
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT message FROM message WHERE source='Discord' AND discord_owner_id=$1 ORDER BY message_id DESC LIMIT $2`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(creds.OwnerID, recentDiscordLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs, err = scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return guildMessages(msgs, creds.GuildID), nil
+}
+
+// FetchMessages gets a page of messages for the user's channel in
+// chronological order. The cursor is the ID of the oldest message of the
+// previous page.
+func (p *Postgres) FetchMessages(userID, cursor string, limit int) (msgs []stream.RXMessage, next string, err error) {
+	if limit < 1 {
+		return nil, "", ErrInvalidLimit
+	}
+	before := int64(math.MaxInt64)
+	if cursor != "" {
+		before, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before < 1 {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	twitchStreamerID, twitchBotID, err := twitchOwnerIDs(userID, tx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	mstmt, err := tx.Prepare(`SELECT message_id, message FROM message WHERE source='Twitch' AND (twitch_owner_id=$1 OR twitch_owner_id=$2) AND message_id < $3 ORDER BY message_id DESC LIMIT $4`)
+	if err != nil {
+		return nil, "", err
+	}
+	defer mstmt.Close()
+	// one extra row is requested to know if there is another page
+	rows, err := mstmt.Query(twitchStreamerID, twitchBotID, before, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var (
+			id           int64
+			messageBytes []byte
+		)
+		err := rows.Scan(&id, &messageBytes)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var message stream.RXMessage
+		err = json.Unmarshal(messageBytes, &message)
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, id)
+		msgs = append(msgs, message)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+		next = strconv.FormatInt(ids[limit-1], 10)
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+	return msgs, next, nil
+}
+
+// QueryMessages allows the user to search for messages that match a
+// search string.
+func (p *Postgres) QueryMessages(userID string, search string) (msgs []stream.RXMessage, err error) {
+	tc, err := p.TwitchCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := p.DiscordCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	twitchAuthenticated := tc.StreamerAuthenticated && tc.BotAuthenticated
+	if !twitchAuthenticated && !dc.Authenticated {
+		return nil, nil
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	mstmt, err := tx.Prepare(`SELECT message FROM message, plainto_tsquery('pg_catalog.english', $1) AS query WHERE (($2 AND source='Twitch' AND (twitch_owner_id=$3 OR twitch_owner_id=$4)) OR ($5 AND source='Discord' AND discord_owner_id=$6)) AND body_tsv @@ query ORDER BY ts_rank(body_tsv, query) DESC, created DESC LIMIT 500`)
+	if err != nil {
+		return nil, err
+	}
+	defer mstmt.Close()
+	rows, err := mstmt.Query(
+		search,
+		twitchAuthenticated,
+		tc.StreamerTwitchUserID,
+		tc.BotTwitchUserID,
+		dc.Authenticated,
+		dc.OwnerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := messages[:0]
+	for _, msg := range messages {
+		if searchable(msg, dc) {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, nil
+}
+
+// RetentionPolicy gets the user's message retention policy.
+func (p *Postgres) RetentionPolicy(userID string) (policy RetentionPolicy, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT retention_max_age, retention_max_count FROM "user" WHERE user_id=$1`)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	defer stmt.Close()
+
+	var maxAge int64
+	err = stmt.QueryRow(userID).Scan(&maxAge, &policy.MaxCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return RetentionPolicy{}, ErrUnknownUserID
+		}
+		return RetentionPolicy{}, err
+	}
+	policy.MaxAge = time.Duration(maxAge) * time.Second
+
+	err = tx.Commit()
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	return policy, nil
+}
+
+// SetRetentionPolicy sets the user's message retention policy. The max age
+// is stored with second precision.
+func (p *Postgres) SetRetentionPolicy(userID string, policy RetentionPolicy) (err error) {
+	if !policy.valid() {
+		return ErrInvalidRetentionPolicy
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE "user" SET retention_max_age=$2, retention_max_count=$3 WHERE user_id=$1`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, int64(policy.MaxAge/time.Second), policy.MaxCount)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownUserID
+	}
+
+	return tx.Commit()
+}
+
+// PruneMessages removes messages that have expired according to each user's
+// retention policy.
+func (p *Postgres) PruneMessages() (removed int, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`DELETE FROM message USING "user"
+		WHERE "user".retention_max_age > 0
+		AND message.source='Twitch'
+		AND message.twitch_owner_id != 0
+		AND (message.twitch_owner_id="user".streamer_id OR message.twitch_owner_id="user".bot_id)
+		AND message.created < CLOCK_TIMESTAMP() - "user".retention_max_age * INTERVAL '1 second'`,
+		`DELETE FROM message USING "user"
+		WHERE "user".retention_max_age > 0
+		AND message.source='Discord'
+		AND "user".discord_guild_id != ''
+		AND message.discord_owner_id="user".discord_owner_id
+		AND message.created < CLOCK_TIMESTAMP() - "user".retention_max_age * INTERVAL '1 second'`,
+		`DELETE FROM message WHERE message_id IN (
+			SELECT message_id FROM (
+				SELECT message.message_id, "user".retention_max_count, ROW_NUMBER() OVER (
+					PARTITION BY message.twitch_owner_id, message.channel ORDER BY message.message_id DESC
+				) AS n
+				FROM message JOIN "user"
+				ON message.twitch_owner_id="user".streamer_id OR message.twitch_owner_id="user".bot_id
+				WHERE "user".retention_max_count > 0
+				AND message.source='Twitch'
+				AND message.twitch_owner_id != 0
+			) AS ranked
+			WHERE n > retention_max_count
+		)`,
+		`DELETE FROM message WHERE message_id IN (
+			SELECT message_id FROM (
+				SELECT message.message_id, "user".retention_max_count, ROW_NUMBER() OVER (
+					PARTITION BY message.discord_owner_id, message.channel ORDER BY message.message_id DESC
+				) AS n
+				FROM message JOIN "user"
+				ON message.discord_owner_id="user".discord_owner_id
+				WHERE "user".retention_max_count > 0
+				AND message.source='Discord'
+				AND "user".discord_guild_id != ''
+			) AS ranked
+			WHERE n > retention_max_count
+		)`,
+	}
+	for _, query := range queries {
+		result, err := tx.Exec(query)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		removed += int(n)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// CustomCommands gets the user's custom commands ordered by trigger.
+func (p *Postgres) CustomCommands(userID string) (cmds []CustomCommand, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT trigger, response, count FROM custom_command WHERE user_id=$1 ORDER BY trigger`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cmds = []CustomCommand{}
+	for rows.Next() {
+		var cmd CustomCommand
+		err = rows.Scan(&cmd.Trigger, &cmd.Response, &cmd.Count)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// SetCustomCommand creates or replaces the response of the user's custom
+// command with the same trigger.
+func (p *Postgres) SetCustomCommand(userID string, cmd CustomCommand) (err error) {
+	if !cmd.valid() {
+		return ErrInvalidCustomCommand
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO custom_command (user_id, trigger, response)
+		SELECT user_id, $2, $3 FROM "user" WHERE user_id=$1
+		ON CONFLICT (user_id, trigger) DO UPDATE SET response=EXCLUDED.response
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, cmd.Trigger, cmd.Response)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownUserID
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCustomCommand removes the user's custom command with the given
+// trigger.
+func (p *Postgres) DeleteCustomCommand(userID, trigger string) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM custom_command WHERE user_id=$1 AND trigger=$2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, trigger)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownCustomCommand
+	}
+
+	return tx.Commit()
+}
+
+// IncrementCustomCommandCount records that the user's custom command was
+// used, returning the updated count.
+func (p *Postgres) IncrementCustomCommandCount(userID, trigger string) (count int, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE custom_command SET count=count+1 WHERE user_id=$1 AND trigger=$2 RETURNING count`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(userID, trigger).Scan(&count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrUnknownCustomCommand
+		}
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ModerationRules gets the rules used to moderate the user's channel. The
+// rules are stored as JSON.
+func (p *Postgres) ModerationRules(userID string) (rules ModerationRules, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return ModerationRules{}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT moderation_rules FROM "user" WHERE user_id=$1`)
+	if err != nil {
+		return ModerationRules{}, err
+	}
+	defer stmt.Close()
+
+	var rulesJSON string
+	err = stmt.QueryRow(userID).Scan(&rulesJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ModerationRules{}, ErrUnknownUserID
+		}
+		return ModerationRules{}, err
+	}
+	err = json.Unmarshal([]byte(rulesJSON), &rules)
+	if err != nil {
+		return ModerationRules{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ModerationRules{}, err
+	}
+	return rules, nil
+}
+
+// SetModerationRules sets the rules used to moderate the user's channel.
+func (p *Postgres) SetModerationRules(userID string, rules ModerationRules) (err error) {
+	if !rules.valid() {
+		return ErrInvalidModerationRules
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE "user" SET moderation_rules=$2 WHERE user_id=$1`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, string(rulesJSON))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownUserID
+	}
+
+	return tx.Commit()
+}
+
+// ModerationStrikes gets the strikes of chatters in the user's channel
+// ordered by chatter.
+func (p *Postgres) ModerationStrikes(userID string) (strikes []Strike, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT chatter, count, last FROM moderation_strike WHERE user_id=$1 ORDER BY chatter`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strikes = []Strike{}
+	for rows.Next() {
+		var s Strike
+		err = rows.Scan(&s.Chatter, &s.Count, &s.Last)
+		if err != nil {
+			return nil, err
+		}
+		strikes = append(strikes, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return strikes, nil
+}
+
+// SetModerationStrike creates or replaces the strike of the chatter in the
+// user's channel.
+func (p *Postgres) SetModerationStrike(userID string, strike Strike) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO moderation_strike (user_id, chatter, count, last)
+		SELECT user_id, $2, $3, $4 FROM "user" WHERE user_id=$1
+		ON CONFLICT (user_id, chatter) DO UPDATE SET
+			count=EXCLUDED.count,
+			last=EXCLUDED.last
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, strike.Chatter, strike.Count, strike.Last)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownUserID
+	}
+
+	return tx.Commit()
+}
+
+// DeleteModerationStrikes removes the strikes in the user's channel whose
+// last violation was before the given time.
+func (p *Postgres) DeleteModerationStrikes(userID string, before time.Time) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM moderation_strike WHERE user_id=$1 AND last<$2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Timers gets the user's timers ordered by name.
+func (p *Postgres) Timers(userID string) (timers []Timer, err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`SELECT name, message, interval_seconds, min_lines FROM timer WHERE user_id=$1 ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timers = []Timer{}
+	for rows.Next() {
+		var (
+			t        Timer
+			interval int64
+		)
+		err = rows.Scan(&t.Name, &t.Message, &interval, &t.MinLines)
+		if err != nil {
+			return nil, err
+		}
+		t.Interval = time.Duration(interval) * time.Second
+		timers = append(timers, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return timers, nil
+}
+
+// SetTimer creates or replaces the user's timer with the same name.
+func (p *Postgres) SetTimer(userID string, timer Timer) (err error) {
+	if !timer.valid() {
+		return ErrInvalidTimer
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO timer (user_id, name, message, interval_seconds, min_lines)
+		SELECT user_id, $2, $3, $4, $5 FROM "user" WHERE user_id=$1
+		ON CONFLICT (user_id, name) DO UPDATE SET
+			message=EXCLUDED.message,
+			interval_seconds=EXCLUDED.interval_seconds,
+			min_lines=EXCLUDED.min_lines
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(
+		userID,
+		timer.Name,
+		timer.Message,
+		int64(timer.Interval/time.Second),
+		timer.MinLines,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownUserID
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTimer removes the user's timer with the given name.
+func (p *Postgres) DeleteTimer(userID, name string) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM timer WHERE user_id=$1 AND name=$2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(userID, name)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownTimer
+	}
+
+	return tx.Commit()
+}
+
+// twitchOwnerIDs returns the Twitch user IDs of the user's streamer and bot.
+// Messages are stored under these IDs.
+func twitchOwnerIDs(userID string, tx *sql.Tx) (streamerID, botID int, err error) {
+	stmt, err := tx.Prepare(`SELECT streamer_id, bot_id FROM "user" WHERE user_id=$1`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(userID).Scan(&streamerID, &botID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return streamerID, botID, nil
+}
+
+// scanMessages reads the message column of every row.
+func scanMessages(rows *sql.Rows) ([]stream.RXMessage, error) {
+	var messages []stream.RXMessage
+	for rows.Next() {
+		var messageBytes []byte
+		err := rows.Scan(&messageBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		var message stream.RXMessage
+		err = json.Unmarshal(messageBytes, &message)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	err := rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
 }
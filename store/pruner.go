@@ -0,0 +1,52 @@
+package store
+
+import (
+	"log"
+	"time"
+)
+
+// MessagePruner prunes messages according to each user's retention policy.
+type MessagePruner interface {
+	PruneMessages() (removed int, err error)
+}
+
+// Pruner periodically removes messages that have expired according to each
+// user's retention policy. It needs to be started.
+type Pruner struct {
+	*periodic
+	store MessagePruner
+}
+
+// PrunerOption is used to configure a Pruner.
+type PrunerOption func(*Pruner)
+
+// WithPruneInterval allows you to override the default interval between
+// prunes.
+func WithPruneInterval(d time.Duration) PrunerOption {
+	return func(p *Pruner) {
+		p.interval = d
+	}
+}
+
+// NewPruner returns a new pruner.
+func NewPruner(store MessagePruner, opts ...PrunerOption) *Pruner {
+	p := &Pruner{
+		store: store,
+	}
+	p.periodic = newPeriodic(10*time.Minute, p.prune)
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Pruner) prune() {
+	removed, err := p.store.PruneMessages()
+	if err != nil {
+		log.Printf("could not prune messages, got err: %s", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("pruned %d messages", removed)
+	}
+}
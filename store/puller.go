@@ -1,25 +1,39 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
+	"expvar"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/jasonkeene/anubot-server/bus"
 	"github.com/jasonkeene/anubot-server/stream"
-
-	"github.com/pebbe/zmq4"
 )
 
+// pullerMetrics are published with expvar.
+var pullerMetrics = expvar.NewMap("puller")
+
 // MessageStorer stores messages.
 type MessageStorer interface {
 	StoreMessage(msg stream.RXMessage) (err error)
 }
 
-// Puller pulls messages from dispatch and stores them.
+// Puller pulls messages from dispatch and stores them. Messages that were
+// spooled by the dispatcher are pushed along with their sequence number and
+// are acknowledged once they have been stored. Messages that could not be
+// stored are not acknowledged so the dispatcher pushes them again. As a
+// result a message may be stored more than once.
 type Puller struct {
 	store         MessageStorer
-	pull          *zmq4.Socket
+	bus           bus.Bus
+	pull          bus.Puller
 	pullEndpoints []string
+	ack           bus.Pusher
+	ackEndpoints  []string
 	stop          chan struct{}
+	stopOnce      sync.Once
 	done          chan struct{}
 }
 
@@ -33,11 +47,27 @@ func WithPullEndpoints(endpoints []string) Option {
 	}
 }
 
+// WithAckEndpoints allows you to override the default ack endpoints.
+func WithAckEndpoints(endpoints []string) Option {
+	return func(p *Puller) {
+		p.ackEndpoints = endpoints
+	}
+}
+
+// WithBus allows you to override the default bus.
+func WithBus(b bus.Bus) Option {
+	return func(p *Puller) {
+		p.bus = b
+	}
+}
+
 // NewPuller returns a new puller.
 func NewPuller(store MessageStorer, opts ...Option) (*Puller, error) {
 	p := &Puller{
 		store:         store,
+		bus:           bus.Default,
 		pullEndpoints: []string{"inproc://dispatch-push"},
+		ackEndpoints:  []string{"inproc://dispatch-ack"},
 		stop:          make(chan struct{}),
 		done:          make(chan struct{}),
 	}
@@ -52,57 +82,144 @@ func NewPuller(store MessageStorer, opts ...Option) (*Puller, error) {
 }
 
 func (p *Puller) setupSockets() error {
-	pull, err := zmq4.NewSocket(zmq4.PULL)
+	pull, err := p.bus.Puller(bus.Connect, p.pullEndpoints)
 	if err != nil {
 		return err
 	}
-	for _, endpoint := range p.pullEndpoints {
-		err = pull.Connect(endpoint)
-		if err != nil {
-			return err
+	ack, err := p.bus.Pusher(bus.Connect, p.ackEndpoints)
+	if err != nil {
+		closeErr := pull.Close()
+		if closeErr != nil {
+			log.Printf("got err while closing pull socket: %s", closeErr)
 		}
+		return err
 	}
 	p.pull = pull
+	p.ack = ack
 	return nil
 }
 
 // Start reads messages from pull socket and stores them. It needs to run in
-// its own goroutine.
+// its own goroutine. Once stopped, messages that have already been received
+// are stored before the socket is closed.
 func (p *Puller) Start() {
 	defer close(p.done)
+	defer func() {
+		err := p.pull.Close()
+		if err != nil {
+			log.Printf("got err while closing pull socket: %s", err)
+		}
+		err = p.ack.Close()
+		if err != nil {
+			log.Printf("got err while closing ack socket: %s", err)
+		}
+	}()
 
 	for {
 		select {
 		case <-p.stop:
+			p.flush()
 			return
 		default:
 		}
 
-		rb, err := p.pull.RecvBytes(0)
+		// waiting for a limited time allows the puller to notice it has
+		// been stopped when no messages are being pushed
+		parts, err := p.pull.Pull(time.Second)
 		if err != nil {
+			if err == bus.ErrTimeout {
+				continue
+			}
 			log.Printf("messages not read, got err: %s", err)
 			continue
 		}
-		var ms stream.RXMessage
-		err = json.Unmarshal(rb, &ms)
+		p.handle(parts)
+	}
+}
+
+// flush stores the messages that have already been received without waiting
+// for more.
+func (p *Puller) flush() {
+	for {
+		parts, err := p.pull.Pull(0)
 		if err != nil {
-			log.Printf("could not unmarshal, got err: %s", err)
-			continue
+			if err != bus.ErrTimeout {
+				log.Printf("messages not flushed, got err: %s", err)
+			}
+			return
 		}
+		p.handle(parts)
+	}
+}
 
-		err = p.store.StoreMessage(ms)
+// handle stores the message. Spooled messages are pushed as two frames, the
+// first being their sequence number, and are acknowledged once they no
+// longer need to be pushed again.
+func (p *Puller) handle(parts [][]byte) {
+	var seq []byte
+	switch len(parts) {
+	case 1:
+	case 2:
+		seq = parts[0]
+	default:
+		log.Printf("not the right count of parts, expected 1 or 2, was: %v", parts)
+		return
+	}
+
+	if p.storeMessage(parts[len(parts)-1]) && seq != nil {
+		err := p.ack.TryPush(seq)
 		if err != nil {
-			log.Printf("could not store message, got err: %s", err)
-			continue
+			log.Printf("could not acknowledge message, got err: %s", err)
 		}
 	}
 }
 
+// storeMessage stores the message. It returns false if storing should be
+// retried.
+func (p *Puller) storeMessage(rb []byte) bool {
+	var ms stream.RXMessage
+	err := json.Unmarshal(rb, &ms)
+	if err != nil {
+		log.Printf("could not unmarshal, got err: %s", err)
+		return true
+	}
+
+	if ms.Type == stream.Twitch && ms.Twitch != nil && ms.Twitch.State != nil {
+		// changes to the state of twitch connections are not chat
+		// and are not stored
+		return true
+	}
+
+	err = p.store.StoreMessage(ms)
+	if err != nil {
+		pullerMetrics.Add("store_errors", 1)
+		log.Printf("could not store message, got err: %s", err)
+		return false
+	}
+	pullerMetrics.Add("stored", 1)
+	return true
+}
+
 // Stop signals to the goroutine reading messages to stop. It returns a
 // function that can be used to block until reading has finished.
 func (p *Puller) Stop() (wait func()) {
-	close(p.stop)
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
 	return func() {
 		<-p.done
 	}
 }
+
+// Shutdown stops reading messages and blocks until the messages that have
+// already been received are stored. If the context is done first its error
+// is returned.
+func (p *Puller) Shutdown(ctx context.Context) error {
+	p.Stop()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
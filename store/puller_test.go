@@ -0,0 +1,104 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestPullerStoresReceivedMessagesOnShutdown(t *testing.T) {
+	expect := expect.New(t)
+
+	b := bus.NewMemory()
+	push, err := b.Pusher(bus.Bind, []string{"inproc://test-puller-shutdown"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer push.Close()
+
+	storer := &spyMessageStorer{}
+	p, err := store.NewPuller(
+		storer,
+		store.WithBus(b),
+		store.WithPullEndpoints([]string{"inproc://test-puller-shutdown"}),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+
+	mb, err := json.Marshal(stream.RXMessage{
+		Type: stream.Discord,
+	})
+	expect(err).To.Be.Nil().Else.FailNow()
+	for i := 0; i < 3; i++ {
+		err = push.Push(mb)
+		expect(err).To.Be.Nil().Else.FailNow()
+	}
+
+	// the puller is stopped before it is started so the messages are only
+	// stored by flushing the socket
+	p.Stop()
+	p.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expect(p.Shutdown(ctx)).To.Be.Nil()
+	expect(storer.count()).To.Equal(3)
+}
+
+func TestPullerAcknowledgesSpooledMessagesOnceStored(t *testing.T) {
+	expect := expect.New(t)
+
+	b := bus.NewMemory()
+	push, err := b.Pusher(bus.Bind, []string{"inproc://test-puller-ack-push"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer push.Close()
+	ack, err := b.Puller(bus.Bind, []string{"inproc://test-puller-ack"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer ack.Close()
+
+	storer := &spyMessageStorer{}
+	p, err := store.NewPuller(
+		storer,
+		store.WithBus(b),
+		store.WithPullEndpoints([]string{"inproc://test-puller-ack-push"}),
+		store.WithAckEndpoints([]string{"inproc://test-puller-ack"}),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+	go p.Start()
+	defer p.Stop()()
+
+	mb, err := json.Marshal(stream.RXMessage{
+		Type: stream.Discord,
+	})
+	expect(err).To.Be.Nil().Else.FailNow()
+	err = push.Push([]byte("42"), mb)
+	expect(err).To.Be.Nil().Else.FailNow()
+
+	parts, err := ack.Pull(time.Second)
+	expect(err).To.Be.Nil().Else.FailNow()
+	expect(parts).To.Equal([][]byte{[]byte("42")})
+	expect(storer.count()).To.Equal(1)
+}
+
+type spyMessageStorer struct {
+	mu       sync.Mutex
+	messages []stream.RXMessage
+}
+
+func (s *spyMessageStorer) StoreMessage(msg stream.RXMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *spyMessageStorer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"strconv"
@@ -21,6 +22,71 @@ type userRecord struct {
 	BotUsername      string    `json:"bot_username"`
 	BotOD            OauthData `json:"bot_od"`
 	BotID            int       `json:"bot_id"`
+	DiscordOwnerID   string    `json:"discord_owner_id"`
+	DiscordGuildID   string    `json:"discord_guild_id"`
+
+	Retention  RetentionPolicy          `json:"retention"`
+	Commands   map[string]CustomCommand `json:"commands"`
+	Moderation ModerationRules          `json:"moderation"`
+	Strikes    map[string]Strike        `json:"strikes"`
+	Timers     map[string]Timer         `json:"timers"`
+}
+
+// discordCredentials returns the Discord guild the user has linked.
+func (ur userRecord) discordCredentials() DiscordCredentials {
+	return DiscordCredentials{
+		Authenticated: ur.DiscordGuildID != "",
+		OwnerID:       ur.DiscordOwnerID,
+		GuildID:       ur.DiscordGuildID,
+	}
+}
+
+// messageKeys returns the keys of the owners whose messages belong to the
+// user. These are the user's streamer and bot along with the owner of their
+// linked Discord guild.
+func (ur userRecord) messageKeys() []string {
+	var keys []string
+	for _, id := range []int{ur.StreamerID, ur.BotID} {
+		if id != 0 {
+			keys = append(keys, "twitch:"+strconv.Itoa(id))
+		}
+	}
+	if ur.DiscordGuildID != "" {
+		keys = append(keys, "discord:"+ur.DiscordOwnerID)
+	}
+	return keys
+}
+
+// twitchOauthData returns the oauth data for the streamer or bot user with
+// the given twitch username.
+func (ur userRecord) twitchOauthData(twitchUsername string) (OauthData, bool) {
+	switch {
+	case twitchUsername == "":
+		return OauthData{}, false
+	case ur.StreamerUsername == twitchUsername:
+		return ur.StreamerOD, true
+	case ur.BotUsername == twitchUsername:
+		return ur.BotOD, true
+	default:
+		return OauthData{}, false
+	}
+}
+
+// setTwitchOauthData sets the oauth data for the streamer or bot user with
+// the given twitch username. It returns false if neither user matches.
+func (ur *userRecord) setTwitchOauthData(twitchUsername string, od OauthData) bool {
+	switch {
+	case twitchUsername == "":
+		return false
+	case ur.StreamerUsername == twitchUsername:
+		ur.StreamerOD = od
+		return true
+	case ur.BotUsername == twitchUsername:
+		ur.BotOD = od
+		return true
+	default:
+		return false
+	}
 }
 
 type nonceRecord struct {
@@ -30,8 +96,6 @@ type nonceRecord struct {
 	Created time.Time  `json:"created"`
 }
 
-type messageRecord []stream.RXMessage
-
 func upsertNonceRecord(nr nonceRecord, tx *bolt.Tx) error {
 	b := tx.Bucket([]byte("nonces"))
 
@@ -42,7 +106,7 @@ func upsertNonceRecord(nr nonceRecord, tx *bolt.Tx) error {
 	return b.Put([]byte(nr.Nonce), nrb)
 }
 
-func getNonceRecordByUserID(userID string, tu TwitchUser, tx *bolt.Tx) (string, error) {
+func getNonceRecordByUserID(userID string, tu TwitchUser, tx *bolt.Tx) (nonceRecord, error) {
 	b := tx.Bucket([]byte("nonces"))
 
 	c := b.Cursor()
@@ -53,10 +117,26 @@ func getNonceRecordByUserID(userID string, tu TwitchUser, tx *bolt.Tx) (string,
 			continue
 		}
 		if nr.UserID == userID && nr.TU == tu {
-			return nr.Nonce, nil
+			return nr, nil
+		}
+	}
+	return nonceRecord{}, ErrUnknownNonce
+}
+
+func deleteNonceRecordsByUserID(userID string, tu TwitchUser, tx *bolt.Tx) error {
+	for {
+		nr, err := getNonceRecordByUserID(userID, tu, tx)
+		if err == ErrUnknownNonce {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = deleteNonceRecord(nr.Nonce, tx)
+		if err != nil {
+			return err
 		}
 	}
-	return "", ErrUnknownNonce
 }
 
 func getNonceRecord(nonce string, tx *bolt.Tx) (nonceRecord, error) {
@@ -81,6 +161,78 @@ func deleteNonceRecord(nonce string, tx *bolt.Tx) error {
 	return b.Delete([]byte(nonce))
 }
 
+// discordNonceRecord tracks the nonce of a user's Discord oauth flow.
+type discordNonceRecord struct {
+	Nonce   string    `json:"nonce"`
+	UserID  string    `json:"user_id"`
+	Created time.Time `json:"created"`
+}
+
+func upsertDiscordNonceRecord(nr discordNonceRecord, tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("discord_nonces"))
+
+	nrb, err := json.Marshal(nr)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(nr.Nonce), nrb)
+}
+
+func getDiscordNonceRecordByUserID(userID string, tx *bolt.Tx) (discordNonceRecord, error) {
+	b := tx.Bucket([]byte("discord_nonces"))
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var nr discordNonceRecord
+		err := json.Unmarshal(v, &nr)
+		if err != nil {
+			continue
+		}
+		if nr.UserID == userID {
+			return nr, nil
+		}
+	}
+	return discordNonceRecord{}, ErrUnknownNonce
+}
+
+func deleteDiscordNonceRecordsByUserID(userID string, tx *bolt.Tx) error {
+	for {
+		nr, err := getDiscordNonceRecordByUserID(userID, tx)
+		if err == ErrUnknownNonce {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = deleteDiscordNonceRecord(nr.Nonce, tx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func getDiscordNonceRecord(nonce string, tx *bolt.Tx) (discordNonceRecord, error) {
+	b := tx.Bucket([]byte("discord_nonces"))
+
+	read := b.Get([]byte(nonce))
+	if read == nil {
+		return discordNonceRecord{}, ErrUnknownNonce
+	}
+
+	var nr discordNonceRecord
+	err := json.Unmarshal(read, &nr)
+	if err != nil {
+		return discordNonceRecord{}, err
+	}
+	return nr, nil
+}
+
+func deleteDiscordNonceRecord(nonce string, tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("discord_nonces"))
+
+	return b.Delete([]byte(nonce))
+}
+
 func upsertUserRecord(ur userRecord, tx *bolt.Tx) error {
 	b := tx.Bucket([]byte("users"))
 
@@ -124,49 +276,311 @@ func getUserRecordByUsername(username string, tx *bolt.Tx) (userRecord, error) {
 	return userRecord{}, ErrUnknownUsername
 }
 
-func upsertMessage(msg stream.RXMessage, tx *bolt.Tx) error {
-	b := tx.Bucket([]byte("messages"))
+func getUserRecordByTwitchUsername(twitchUsername string, tx *bolt.Tx) (userRecord, error) {
+	b := tx.Bucket([]byte("users"))
 
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var ur userRecord
+		err := json.Unmarshal(v, &ur)
+		if err != nil {
+			continue
+		}
+		if _, ok := ur.twitchOauthData(twitchUsername); ok {
+			return ur, nil
+		}
+	}
+	return userRecord{}, ErrUnknownUsername
+}
+
+// upsertMessage appends the message to its owner's bucket under the next
+// sequence number.
+func upsertMessage(msg stream.RXMessage, tx *bolt.Tx) error {
 	key, err := getMessageKey(msg)
 	if err != nil {
-		println("return via nil key")
 		return err
 	}
 
-	mrb := b.Get([]byte(key))
-	var mr messageRecord
-	if mrb != nil {
-		err = json.Unmarshal(mrb, &mr)
+	b, err := tx.Bucket([]byte("messages")).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	mb, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	err = b.Put(itob(seq), mb)
+	if err != nil {
+		return err
+	}
+
+	return indexMessage(key, seq, msg, tx)
+}
+
+// indexMessage adds the sequence of the message in its owner's bucket to the
+// postings of every term in the message body. The postings of each term are
+// a nested bucket keyed by sequence so adding one does not rewrite the
+// others.
+func indexMessage(key string, seq uint64, msg stream.RXMessage, tx *bolt.Tx) error {
+	index := tx.Bucket([]byte("message_index"))
+
+	for _, term := range searchTerms(messageBody(msg)) {
+		b, err := index.CreateBucketIfNotExists(postingsKey(key, term))
+		if err != nil {
+			return err
+		}
+		err = b.Put(itob(seq), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexMessageRecord adds every message in the owner's bucket to the
+// search index.
+func reindexMessageRecord(key string, tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("messages")).Bucket([]byte(key))
+	return b.ForEach(func(k, v []byte) error {
+		var msg stream.RXMessage
+		err := json.Unmarshal(v, &msg)
 		if err != nil {
-			println("return via unmarshal err")
 			return err
 		}
+		return indexMessage(key, binary.BigEndian.Uint64(k), msg, tx)
+	})
+}
+
+// getMessagePostings returns the sequences of the messages in the owner's
+// bucket that contain the given term.
+func getMessagePostings(key, term string, tx *bolt.Tx) ([]uint64, error) {
+	b := tx.Bucket([]byte("message_index")).Bucket(postingsKey(key, term))
+	if b == nil {
+		return nil, nil
 	}
 
-	mr = append(mr, msg)
-	mrb, err = json.Marshal(mr)
+	var postings []uint64
+	err := b.ForEach(func(k, _ []byte) error {
+		postings = append(postings, binary.BigEndian.Uint64(k))
+		return nil
+	})
 	if err != nil {
-		println("return via marshal err")
-		return err
+		return nil, err
 	}
+	return postings, nil
+}
 
-	return b.Put([]byte(key), mrb)
+// postingsKey is the key of the bucket that has the postings of the term
+// for the owner.
+func postingsKey(key, term string) []byte {
+	return []byte(key + ":" + term)
 }
 
-func getMessageRecord(key string, tx *bolt.Tx) (messageRecord, error) {
-	b := tx.Bucket([]byte("messages"))
+// getMessage returns the message stored under the given sequence in the
+// owner's bucket.
+func getMessage(key string, seq uint64, tx *bolt.Tx) (stream.RXMessage, bool, error) {
+	b := tx.Bucket([]byte("messages")).Bucket([]byte(key))
+	if b == nil {
+		return stream.RXMessage{}, false, nil
+	}
 
-	read := b.Get([]byte(key))
+	read := b.Get(itob(seq))
 	if read == nil {
-		return make(messageRecord, 0), nil
+		return stream.RXMessage{}, false, nil
 	}
 
-	var mr messageRecord
-	err := json.Unmarshal(read, &mr)
+	var msg stream.RXMessage
+	err := json.Unmarshal(read, &msg)
 	if err != nil {
-		return nil, err
+		return stream.RXMessage{}, false, err
+	}
+	return msg, true, nil
+}
+
+// messageCursor walks an owner's bucket backwards starting before a given
+// sequence.
+type messageCursor struct {
+	c   *bolt.Cursor
+	seq uint64
+	msg stream.RXMessage
+	ok  bool
+}
+
+// newMessageCursor returns a cursor positioned at the newest message in the
+// owner's bucket with a sequence less than before.
+func newMessageCursor(key string, before uint64, tx *bolt.Tx) (*messageCursor, error) {
+	mc := &messageCursor{}
+	b := tx.Bucket([]byte("messages")).Bucket([]byte(key))
+	if b == nil {
+		return mc, nil
+	}
+	mc.c = b.Cursor()
+
+	k, v := mc.c.Seek(itob(before))
+	if k == nil {
+		k, v = mc.c.Last()
+	} else {
+		k, v = mc.c.Prev()
+	}
+	return mc, mc.load(k, v)
+}
+
+// prev moves the cursor to the next older message.
+func (mc *messageCursor) prev() error {
+	k, v := mc.c.Prev()
+	return mc.load(k, v)
+}
+
+func (mc *messageCursor) load(k, v []byte) error {
+	if k == nil {
+		mc.ok = false
+		return nil
+	}
+	mc.seq = binary.BigEndian.Uint64(k)
+	mc.msg = stream.RXMessage{}
+	mc.ok = true
+	return json.Unmarshal(v, &mc.msg)
+}
+
+// expiredMessage is a message that is to be pruned.
+type expiredMessage struct {
+	seq uint64
+	msg stream.RXMessage
+}
+
+// countChannelMessages returns how many messages are in the owner's bucket
+// for each channel.
+func countChannelMessages(key string, tx *bolt.Tx) (map[string]int, error) {
+	counts := make(map[string]int)
+	b := tx.Bucket([]byte("messages")).Bucket([]byte(key))
+	if b == nil {
+		return counts, nil
+	}
+	err := b.ForEach(func(_, v []byte) error {
+		var msg stream.RXMessage
+		err := json.Unmarshal(v, &msg)
+		if err != nil {
+			return err
+		}
+		counts[messageChannel(msg)]++
+		return nil
+	})
+	return counts, err
+}
+
+// findExpiredMessages walks the owner's bucket from the oldest message after
+// the given sequence and returns at most limit messages that have expired.
+// The remaining counts are how many messages each channel has from the
+// current position onwards and are decremented as messages are walked
+// past. It returns the sequence of the last message walked past and true if
+// there are no more messages.
+func findExpiredMessages(
+	key string,
+	after uint64,
+	limit int,
+	remaining map[string]int,
+	p RetentionPolicy,
+	now time.Time,
+	tx *bolt.Tx,
+) ([]expiredMessage, uint64, bool, error) {
+	b := tx.Bucket([]byte("messages")).Bucket([]byte(key))
+	if b == nil {
+		return nil, after, true, nil
+	}
+
+	var expired []expiredMessage
+	c := b.Cursor()
+	for k, v := c.Seek(itob(after + 1)); k != nil; k, v = c.Next() {
+		var msg stream.RXMessage
+		err := json.Unmarshal(v, &msg)
+		if err != nil {
+			return nil, after, false, err
+		}
+		after = binary.BigEndian.Uint64(k)
+
+		channel := messageChannel(msg)
+		if p.expired(msg, remaining[channel], now) {
+			expired = append(expired, expiredMessage{
+				seq: after,
+				msg: msg,
+			})
+		}
+		remaining[channel]--
+
+		if len(expired) == limit {
+			return expired, after, false, nil
+		}
+	}
+	return expired, after, true, nil
+}
+
+// deleteMessage removes the message stored under the given sequence from the
+// owner's bucket along with its postings in the search index.
+func deleteMessage(key string, seq uint64, msg stream.RXMessage, tx *bolt.Tx) error {
+	index := tx.Bucket([]byte("message_index"))
+
+	for _, term := range searchTerms(messageBody(msg)) {
+		pk := postingsKey(key, term)
+		b := index.Bucket(pk)
+		if b == nil {
+			continue
+		}
+		err := b.Delete(itob(seq))
+		if err != nil {
+			return err
+		}
+		// terms without any postings are removed so the index does not
+		// grow with every term that was ever used
+		if k, _ := b.Cursor().First(); k == nil {
+			err = index.DeleteBucket(pk)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	b := tx.Bucket([]byte("messages")).Bucket([]byte(key))
+	if b == nil {
+		return nil
 	}
-	return mr, nil
+	return b.Delete(itob(seq))
+}
+
+// migrateMessageRecord moves an owner's messages from the legacy layout, a
+// single JSON array stored under the owner key, into a nested bucket keyed
+// by sequence.
+func migrateMessageRecord(key string, tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("messages"))
+
+	var legacy []stream.RXMessage
+	err := json.Unmarshal(b.Get([]byte(key)), &legacy)
+	if err != nil {
+		return err
+	}
+	err = b.Delete([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range legacy {
+		err = upsertMessage(msg, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itob encodes a sequence so that keys sort in sequence order.
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
 }
 
 func getMessageKey(msg stream.RXMessage) (string, error) {
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"time"
+
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// RetentionPolicy controls how much chat history is kept for each of a
+// user's channels. It applies to the messages received by the user's
+// streamer and bot and to the messages from their linked Discord guild. A
+// zero value for either field means there is no limit.
+type RetentionPolicy struct {
+	// MaxAge is how long messages are kept.
+	MaxAge time.Duration `json:"max_age"`
+	// MaxCount is how many of the most recent messages are kept for each
+	// channel the streamer, bot or Discord guild has received messages in.
+	MaxCount int `json:"max_count"`
+}
+
+// valid returns true if the policy does not contain negative limits.
+func (p RetentionPolicy) valid() bool {
+	return p.MaxAge >= 0 && p.MaxCount >= 0
+}
+
+// unlimited returns true if the policy does not limit history at all.
+func (p RetentionPolicy) unlimited() bool {
+	return p.MaxAge == 0 && p.MaxCount == 0
+}
+
+// expired returns true if a message should be pruned. The n argument is the
+// position of the message counting back from the most recent message in the
+// same channel, starting at one.
+func (p RetentionPolicy) expired(msg stream.RXMessage, n int, now time.Time) bool {
+	if p.MaxCount > 0 && n > p.MaxCount {
+		return true
+	}
+	return p.MaxAge > 0 && messageTime(msg).Before(now.Add(-p.MaxAge))
+}
+
+// retainMessages returns the messages that have not expired. The messages
+// are expected to be in the order they were stored.
+func retainMessages(msgs []stream.RXMessage, p RetentionPolicy, now time.Time) []stream.RXMessage {
+	expired := make([]bool, len(msgs))
+	counts := make(map[string]int)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		channel := messageChannel(msgs[i])
+		counts[channel]++
+		expired[i] = p.expired(msgs[i], counts[channel], now)
+	}
+
+	var kept []stream.RXMessage
+	for i := range msgs {
+		if expired[i] {
+			continue
+		}
+		kept = append(kept, msgs[i])
+	}
+	return kept
+}
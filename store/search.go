@@ -0,0 +1,215 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// messageBody extracts the text content of a message so that it can be
+// indexed for searching.
+func messageBody(msg stream.RXMessage) string {
+	switch msg.Type {
+	case stream.Twitch:
+		if msg.Twitch == nil || msg.Twitch.Line == nil {
+			return ""
+		}
+		if len(msg.Twitch.Line.Args) < 2 {
+			return ""
+		}
+		switch msg.Twitch.Line.Cmd {
+		case "CLEARCHAT", "CLEARMSG", "ROOMSTATE", "HOSTTARGET":
+			// these are not chat messages, their arguments are usernames,
+			// channels or the content of messages that were deleted
+			return ""
+		}
+		return msg.Twitch.Line.Args[1]
+	case stream.Discord:
+		m := discordMessage(msg.Discord)
+		if m == nil {
+			return ""
+		}
+		return m.Content
+	default:
+		return ""
+	}
+}
+
+// messageTime returns when the message was sent. Twitch messages without a
+// tmi-sent-ts tag fall back to when the message was received.
+func messageTime(msg stream.RXMessage) time.Time {
+	switch msg.Type {
+	case stream.Twitch:
+		if msg.Twitch == nil || msg.Twitch.Line == nil {
+			return time.Time{}
+		}
+		sent := stream.ParseTwitchTags(msg.Twitch.Line.Tags).SentAt
+		if !sent.IsZero() {
+			return sent
+		}
+		return msg.Twitch.Line.Time
+	case stream.Discord:
+		if msg.Discord == nil {
+			return time.Time{}
+		}
+		ts := msg.Discord.Time
+		m := discordMessage(msg.Discord)
+		if m != nil {
+			t, err := m.Timestamp.Parse()
+			if err == nil {
+				ts = t
+			}
+			if msg.Discord.MessageUpdate != nil {
+				t, err := m.EditedTimestamp.Parse()
+				if err == nil {
+					ts = t
+				}
+			}
+		}
+		return ts
+	default:
+		return time.Time{}
+	}
+}
+
+// messageChannel returns the channel the message was sent to. For Discord
+// this is the ID of the channel. It is empty for Twitch messages that were
+// not sent to a channel, such as whispers, and Discord events that did not
+// happen in a channel.
+func messageChannel(msg stream.RXMessage) string {
+	switch msg.Type {
+	case stream.Twitch:
+		if msg.Twitch == nil {
+			return ""
+		}
+		if msg.Twitch.Channel != "" {
+			return msg.Twitch.Channel
+		}
+		return stream.LineChannel(msg.Twitch.Line)
+	case stream.Discord:
+		rx := msg.Discord
+		switch {
+		case rx == nil:
+			return ""
+		case rx.MessageDelete != nil && rx.MessageDelete.Message != nil:
+			return rx.MessageDelete.ChannelID
+		case rx.MessageReactionAdd != nil && rx.MessageReactionAdd.MessageReaction != nil:
+			return rx.MessageReactionAdd.ChannelID
+		case rx.MessageReactionRemove != nil && rx.MessageReactionRemove.MessageReaction != nil:
+			return rx.MessageReactionRemove.ChannelID
+		}
+		m := discordMessage(rx)
+		if m == nil {
+			return ""
+		}
+		return m.ChannelID
+	default:
+		return ""
+	}
+}
+
+// discordMessage returns the Discord message with content that was created
+// or edited. It returns nil for other Discord events.
+func discordMessage(rx *stream.RXDiscord) *discordgo.Message {
+	switch {
+	case rx == nil:
+		return nil
+	case rx.MessageCreate != nil:
+		return rx.MessageCreate.Message
+	case rx.MessageUpdate != nil:
+		return rx.MessageUpdate.Message
+	default:
+		return nil
+	}
+}
+
+// searchTerms splits text into unique lower cased terms. It is used both
+// when indexing messages and when parsing search strings so the two always
+// agree on what a term is.
+func searchTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := make(map[string]struct{}, len(fields))
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		terms = append(terms, f)
+	}
+	return terms
+}
+
+// searchKeys returns the keys of the owners whose messages are searched for
+// a user. These are the user's streamer and bot accounts once they have
+// authenticated with twitch and the owner of their linked Discord guild.
+func searchKeys(tc TwitchCredentials, dc DiscordCredentials) []string {
+	var keys []string
+	if tc.StreamerAuthenticated && tc.BotAuthenticated {
+		keys = append(keys,
+			"twitch:"+strconv.Itoa(tc.StreamerTwitchUserID),
+			"twitch:"+strconv.Itoa(tc.BotTwitchUserID),
+		)
+	}
+	if dc.Authenticated {
+		keys = append(keys, "discord:"+dc.OwnerID)
+	}
+	return keys
+}
+
+// searchable returns true if the message can be returned from a search.
+// The owner of a linked guild may have its bot in other guilds so only
+// Discord messages from the linked guild are searchable.
+func searchable(msg stream.RXMessage, dc DiscordCredentials) bool {
+	if msg.Type != stream.Discord {
+		return true
+	}
+	return inGuild(msg, dc.GuildID)
+}
+
+// searchResult is a message that matched a search along with how many of the
+// search terms it matched.
+type searchResult struct {
+	msg   stream.RXMessage
+	score int
+}
+
+// rankResults orders results by how many terms they matched, most recent
+// first when tied, and returns at most limit messages.
+func rankResults(results []searchResult, limit int) []stream.RXMessage {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return messageTime(results[i].msg).After(messageTime(results[j].msg))
+	})
+
+	msgs := make([]stream.RXMessage, 0, min(len(results), limit))
+	for _, r := range results[:min(len(results), limit)] {
+		msgs = append(msgs, r.msg)
+	}
+	return msgs
+}
+
+// scoreMessage returns how many of the terms are present in the message.
+func scoreMessage(msg stream.RXMessage, terms []string) int {
+	body := make(map[string]struct{})
+	for _, t := range searchTerms(messageBody(msg)) {
+		body[t] = struct{}{}
+	}
+	var score int
+	for _, t := range terms {
+		if _, ok := body[t]; ok {
+			score++
+		}
+	}
+	return score
+}
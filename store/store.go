@@ -1,6 +1,10 @@
 package store
 
-import "github.com/jasonkeene/anubot-server/stream"
+import (
+	"time"
+
+	"github.com/jasonkeene/anubot-server/stream"
+)
 
 // Store is the interface all storage backends implement.
 type Store interface {
@@ -21,13 +25,18 @@ type Store interface {
 	StoreOauthNonce(userID string, tu TwitchUser, nonce string) (err error)
 
 	// OauthNonceExists tells you if the provided nonce was recently created
-	// and not yet finished.
+	// and not yet finished. Nonces older than the configured TTL do not
+	// exist.
 	OauthNonceExists(nonce string) (exists bool, err error)
 
 	// FinishOauthNonce completes the oauth flow, removing the nonce and
 	// storing the oauth data.
 	FinishOauthNonce(nonce, twitchUsername string, twitchUserID int, od OauthData) (err error)
 
+	// SweepOauthNonces removes Twitch and Discord oauth nonces that have
+	// expired.
+	SweepOauthNonces() (removed int, err error)
+
 	// TwitchCredentials gives you the status of the user's authentication
 	// with twitch.
 	TwitchCredentials(userID string) (creds TwitchCredentials, err error)
@@ -35,6 +44,48 @@ type Store interface {
 	// TwitchClearAuth removes all the auth data for twitch for the user.
 	TwitchClearAuth(userID string) (err error)
 
+	// TwitchAuthenticatedUsers gets the IDs of the users that have
+	// authenticated both their streamer and bot users with twitch.
+	TwitchAuthenticatedUsers() (userIDs []string, err error)
+
+	// TwitchOauthData gets the oauth data for the given twitch user. This
+	// can be either a streamer or bot user.
+	TwitchOauthData(twitchUsername string) (od OauthData, err error)
+
+	// UpdateTwitchOauthData replaces the oauth data for the given twitch
+	// user. This is used when the access token has been refreshed.
+	UpdateTwitchOauthData(twitchUsername string, od OauthData) (err error)
+
+	// DiscordCredentials gives you the Discord guild the user has linked.
+	DiscordCredentials(userID string) (creds DiscordCredentials, err error)
+
+	// StoreDiscordGuild links the Discord guild with the given owner to the
+	// user.
+	StoreDiscordGuild(userID, ownerID, guildID string) (err error)
+
+	// DiscordOauthNonce gets the nonce of the user's Discord oauth flow if
+	// it exists.
+	DiscordOauthNonce(userID string) (nonce string, err error)
+
+	// StoreDiscordOauthNonce stores the nonce of the user's Discord oauth
+	// flow, replacing any nonce that was abandoned.
+	StoreDiscordOauthNonce(userID, nonce string) (err error)
+
+	// DiscordOauthNonceExists tells you if the provided Discord nonce was
+	// recently created and not yet finished.
+	DiscordOauthNonceExists(nonce string) (exists bool, err error)
+
+	// FinishDiscordOauthNonce completes the Discord oauth flow, removing the
+	// nonce and linking the guild the bot was added to.
+	FinishDiscordOauthNonce(nonce, ownerID, guildID string) (err error)
+
+	// DiscordClearAuth unlinks the user's Discord guild.
+	DiscordClearAuth(userID string) (err error)
+
+	// DiscordAuthenticatedUsers gets the IDs of the users that have linked a
+	// Discord guild.
+	DiscordAuthenticatedUsers() (userIDs []string, err error)
+
 	// StoreMessage stores a message for a given user for later searching and
 	// scrollback history.
 	StoreMessage(msg stream.RXMessage) (err error)
@@ -42,9 +93,76 @@ type Store interface {
 	// FetchRecentMessages gets the recent messages for the user's channel.
 	FetchRecentMessages(userID string) (msgs []stream.RXMessage, err error)
 
+	// FetchRecentDiscordMessages gets the recent messages from the user's
+	// linked Discord guild in chronological order.
+	FetchRecentDiscordMessages(userID string) (msgs []stream.RXMessage, err error)
+
+	// FetchMessages gets a page of messages for the user's channel in
+	// chronological order. Only messages older than the cursor are returned.
+	// An empty cursor starts from the most recent message. The cursor
+	// returned can be used to fetch the next page of older messages and is
+	// empty when there are no older messages.
+	FetchMessages(userID, cursor string, limit int) (msgs []stream.RXMessage, next string, err error)
+
 	// QueryMessages allows the user to search for messages that match a
-	// search string.
+	// search string. Messages from the user's twitch channel and linked
+	// Discord guild are searched. Nothing is returned when the user has
+	// neither authenticated with twitch nor linked a guild.
 	QueryMessages(userID, search string) (msgs []stream.RXMessage, err error)
+
+	// RetentionPolicy gets the user's message retention policy.
+	RetentionPolicy(userID string) (policy RetentionPolicy, err error)
+
+	// SetRetentionPolicy sets the user's message retention policy. It takes
+	// effect the next time messages are pruned.
+	SetRetentionPolicy(userID string, policy RetentionPolicy) (err error)
+
+	// PruneMessages removes messages that have expired according to each
+	// user's retention policy.
+	PruneMessages() (removed int, err error)
+
+	// CustomCommands gets the user's custom commands ordered by trigger.
+	CustomCommands(userID string) (cmds []CustomCommand, err error)
+
+	// SetCustomCommand creates or replaces the response of the user's custom
+	// command with the same trigger. The count of an existing command is
+	// kept.
+	SetCustomCommand(userID string, cmd CustomCommand) (err error)
+
+	// DeleteCustomCommand removes the user's custom command with the given
+	// trigger.
+	DeleteCustomCommand(userID, trigger string) (err error)
+
+	// IncrementCustomCommandCount records that the user's custom command was
+	// used, returning the updated count.
+	IncrementCustomCommandCount(userID, trigger string) (count int, err error)
+
+	// ModerationRules gets the rules used to moderate the user's channel.
+	ModerationRules(userID string) (rules ModerationRules, err error)
+
+	// SetModerationRules sets the rules used to moderate the user's channel.
+	SetModerationRules(userID string, rules ModerationRules) (err error)
+
+	// ModerationStrikes gets the strikes of chatters in the user's channel
+	// ordered by chatter.
+	ModerationStrikes(userID string) (strikes []Strike, err error)
+
+	// SetModerationStrike creates or replaces the strike of the chatter in
+	// the user's channel.
+	SetModerationStrike(userID string, strike Strike) (err error)
+
+	// DeleteModerationStrikes removes the strikes in the user's channel
+	// whose last violation was before the given time.
+	DeleteModerationStrikes(userID string, before time.Time) (err error)
+
+	// Timers gets the user's timers ordered by name.
+	Timers(userID string) (timers []Timer, err error)
+
+	// SetTimer creates or replaces the user's timer with the same name.
+	SetTimer(userID string, timer Timer) (err error)
+
+	// DeleteTimer removes the user's timer with the given name.
+	DeleteTimer(userID, name string) (err error)
 }
 
 // TwitchCredentials represents a user's twitch authentication information for
@@ -60,3 +178,13 @@ type TwitchCredentials struct {
 	BotPassword          string
 	BotTwitchUserID      int
 }
+
+// DiscordCredentials represents the Discord guild a user has linked.
+type DiscordCredentials struct {
+	Authenticated bool
+
+	// OwnerID is the Discord user ID of the owner of the guild.
+	OwnerID string
+	// GuildID is the ID of the guild.
+	GuildID string
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/a8m/expect"
+	"github.com/bwmarrin/discordgo"
 	"github.com/fluffle/goirc/client"
 	"github.com/jasonkeene/anubot-server/store"
 	"github.com/jasonkeene/anubot-server/stream"
@@ -211,17 +213,1024 @@ func TestThatYouCanStoreMessages(t *testing.T) {
 	}
 }
 
-func setupBackends(t *testing.T) ([]store.Store, func()) {
-	bolt, cleanup := setupBolt(t)
+func TestThatDiscordOauthFlowWorks(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		_, err = b.DiscordOauthNonce(userID)
+		expect(err).Not.To.Be.Nil()
+
+		err = b.StoreDiscordOauthNonce(userID, "abandoned-nonce")
+		expect(err).To.Be.Nil()
+		err = b.StoreDiscordOauthNonce(userID, "discord-nonce")
+		expect(err).To.Be.Nil()
+
+		nonce, err := b.DiscordOauthNonce(userID)
+		expect(err).To.Be.Nil()
+		expect(nonce).To.Equal("discord-nonce")
+		ok, err := b.DiscordOauthNonceExists("abandoned-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.False()
+		ok, err = b.DiscordOauthNonceExists("discord-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.True()
+
+		err = b.FinishDiscordOauthNonce("abandoned-nonce", "test-owner-id", "test-guild-id")
+		expect(err).To.Equal(store.ErrUnknownNonce)
+		err = b.FinishDiscordOauthNonce("discord-nonce", "test-owner-id", "test-guild-id")
+		expect(err).To.Be.Nil()
+
+		ok, err = b.DiscordOauthNonceExists("discord-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.False()
+		creds, err := b.DiscordCredentials(userID)
+		expect(err).To.Be.Nil()
+		expect(creds).To.Equal(store.DiscordCredentials{
+			Authenticated: true,
+			OwnerID:       "test-owner-id",
+			GuildID:       "test-guild-id",
+		})
+		userIDs, err := b.DiscordAuthenticatedUsers()
+		expect(err).To.Be.Nil()
+		expect(userIDs).To.Equal([]string{userID})
+
+		err = b.DiscordClearAuth(userID)
+		expect(err).To.Be.Nil()
+
+		creds, err = b.DiscordCredentials(userID)
+		expect(err).To.Be.Nil()
+		expect(creds).To.Equal(store.DiscordCredentials{})
+		userIDs, err = b.DiscordAuthenticatedUsers()
+		expect(err).To.Be.Nil()
+		expect(len(userIDs)).To.Equal(0)
+	}
+}
+
+func TestThatYouCanFetchRecentDiscordMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		creds, err := b.DiscordCredentials(userID)
+		expect(err).To.Be.Nil()
+		expect(creds.Authenticated).To.Be.False()
+		messages, err := b.FetchRecentDiscordMessages(userID)
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(0)
+
+		err = b.StoreDiscordGuild(userID, "test-owner-id", "test-guild-id")
+		expect(err).To.Be.Nil()
+		creds, err = b.DiscordCredentials(userID)
+		expect(err).To.Be.Nil()
+		expect(creds).To.Equal(store.DiscordCredentials{
+			Authenticated: true,
+			OwnerID:       "test-owner-id",
+			GuildID:       "test-guild-id",
+		})
+
+		for _, m := range []struct {
+			guildID string
+			content string
+		}{
+			{"test-guild-id", "test-message-1"},
+			{"other-guild-id", "other-message"},
+			{"test-guild-id", "test-message-2"},
+		} {
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Discord,
+				Discord: &stream.RXDiscord{
+					OwnerID: "test-owner-id",
+					GuildID: m.guildID,
+					MessageCreate: &discordgo.MessageCreate{
+						Message: &discordgo.Message{
+							Content: m.content,
+						},
+					},
+				},
+			})
+			expect(err).To.Be.Nil().Else.FailNow()
+		}
+
+		messages, err = b.FetchRecentDiscordMessages(userID)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(2).Else.FailNow()
+		expect(messages[0].Discord.MessageCreate.Content).To.Equal("test-message-1")
+		expect(messages[1].Discord.MessageCreate.Content).To.Equal("test-message-2")
+	}
+}
+
+func TestThatDiscordEventsAreStored(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+		err = b.StoreDiscordGuild(userID, "test-owner-id", "test-guild-id")
+		expect(err).To.Be.Nil()
+
+		for _, rx := range []*stream.RXDiscord{
+			{
+				MessageUpdate: &discordgo.MessageUpdate{
+					Message: &discordgo.Message{
+						ID:      "test-message-id",
+						Content: "edited-message",
+					},
+				},
+			},
+			{
+				MessageReactionAdd: &discordgo.MessageReactionAdd{
+					MessageReaction: &discordgo.MessageReaction{
+						MessageID: "test-message-id",
+						UserID:    "test-reactor-id",
+					},
+				},
+			},
+			{
+				MessageDelete: &discordgo.MessageDelete{
+					Message: &discordgo.Message{
+						ID: "test-message-id",
+					},
+				},
+			},
+		} {
+			rx.OwnerID = "test-owner-id"
+			rx.GuildID = "test-guild-id"
+			err = b.StoreMessage(stream.RXMessage{
+				Type:    stream.Discord,
+				Discord: rx,
+			})
+			expect(err).To.Be.Nil().Else.FailNow()
+		}
+
+		messages, err := b.FetchRecentDiscordMessages(userID)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(3).Else.FailNow()
+		expect(messages[0].Discord.MessageUpdate.Content).To.Equal("edited-message")
+		expect(messages[1].Discord.MessageReactionAdd.UserID).To.Equal("test-reactor-id")
+		expect(messages[2].Discord.MessageDelete.ID).To.Equal("test-message-id")
+	}
+}
+
+func TestThatYouCanPageThroughMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		od := store.OauthData{
+			AccessToken:  "test-access-token",
+			RefreshToken: "test-refresh-token",
+			Scope:        []string{"test-scope"},
+		}
+		streamerNonce := "streamer-nonce"
+		err = b.StoreOauthNonce(userID, store.Streamer, streamerNonce)
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce(streamerNonce, "test-streamer-user", 12345, od)
+		expect(err).To.Be.Nil()
+
+		botNonce := "bot-nonce"
+		err = b.StoreOauthNonce(userID, store.Bot, botNonce)
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce(botNonce, "test-bot-user", 54321, od)
+		expect(err).To.Be.Nil()
+
+		start := time.Now()
+		for i := 1; i <= 5; i++ {
+			ownerID := 12345
+			if i%2 == 0 {
+				ownerID = 54321
+			}
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: ownerID,
+					Line: &client.Line{
+						Raw:  fmt.Sprintf("test-message-%d", i),
+						Time: start.Add(time.Duration(i) * time.Second),
+					},
+				},
+			})
+			expect(err).To.Be.Nil().Else.FailNow()
+		}
+
+		messages, cursor, err := b.FetchMessages(userID, "", 2)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(2).Else.FailNow()
+		expect(messages[0].Twitch.Line.Raw).To.Equal("test-message-4")
+		expect(messages[1].Twitch.Line.Raw).To.Equal("test-message-5")
+		expect(cursor).Not.To.Equal("")
+
+		messages, cursor, err = b.FetchMessages(userID, cursor, 2)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(2).Else.FailNow()
+		expect(messages[0].Twitch.Line.Raw).To.Equal("test-message-2")
+		expect(messages[1].Twitch.Line.Raw).To.Equal("test-message-3")
+		expect(cursor).Not.To.Equal("")
+
+		messages, cursor, err = b.FetchMessages(userID, cursor, 2)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(1).Else.FailNow()
+		expect(messages[0].Twitch.Line.Raw).To.Equal("test-message-1")
+		expect(cursor).To.Equal("")
+
+		_, _, err = b.FetchMessages(userID, "bad-cursor", 2)
+		expect(err).To.Equal(store.ErrInvalidCursor)
+		_, _, err = b.FetchMessages(userID, "", 0)
+		expect(err).To.Equal(store.ErrInvalidLimit)
+	}
+}
+
+func TestThatRetentionPoliciesCanBeSet(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		policy, err := b.RetentionPolicy(userID)
+		expect(err).To.Be.Nil()
+		expect(policy).To.Equal(store.RetentionPolicy{})
+
+		err = b.SetRetentionPolicy(userID, store.RetentionPolicy{
+			MaxAge:   time.Hour,
+			MaxCount: 100,
+		})
+		expect(err).To.Be.Nil()
+		policy, err = b.RetentionPolicy(userID)
+		expect(err).To.Be.Nil()
+		expect(policy).To.Equal(store.RetentionPolicy{
+			MaxAge:   time.Hour,
+			MaxCount: 100,
+		})
+
+		err = b.SetRetentionPolicy(userID, store.RetentionPolicy{MaxCount: -1})
+		expect(err).To.Equal(store.ErrInvalidRetentionPolicy)
+		_, err = b.RetentionPolicy("unknown-user-id")
+		expect(err).To.Equal(store.ErrUnknownUserID)
+	}
+}
+
+func TestThatMessagesArePrunedByRetentionPolicy(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		od := store.OauthData{
+			AccessToken: "test-access-token",
+		}
+		err = b.StoreOauthNonce(userID, store.Streamer, "streamer-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("streamer-nonce", "test-streamer-user", 12345, od)
+		expect(err).To.Be.Nil()
+		err = b.StoreOauthNonce(userID, store.Bot, "bot-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("bot-nonce", "test-bot-user", 54321, od)
+		expect(err).To.Be.Nil()
+
+		now := time.Now()
+		for i := 1; i <= 5; i++ {
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 12345,
+					Line: &client.Line{
+						Cmd:  "PRIVMSG",
+						Args: []string{"#test-streamer-user", fmt.Sprintf("message %d", i)},
+						Time: now.Add(time.Duration(i) * time.Second),
+					},
+				},
+			})
+			expect(err).To.Be.Nil()
+		}
+
+		removed, err := b.PruneMessages()
+		expect(err).To.Be.Nil()
+		expect(removed).To.Equal(0)
+
+		err = b.SetRetentionPolicy(userID, store.RetentionPolicy{MaxCount: 3})
+		expect(err).To.Be.Nil()
+		removed, err = b.PruneMessages()
+		expect(err).To.Be.Nil()
+		expect(removed).To.Equal(2)
+
+		messages, err := b.FetchRecentMessages(userID)
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(3).Else.FailNow()
+		expect(messages[0].Twitch.Line.Args[1]).To.Equal("message 3")
+
+		messages, err = b.QueryMessages(userID, "message")
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(3)
+	}
+}
+
+func TestThatMessagesArePrunedForEachChannel(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		od := store.OauthData{
+			AccessToken: "test-access-token",
+		}
+		err = b.StoreOauthNonce(userID, store.Streamer, "streamer-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("streamer-nonce", "test-streamer-user", 12345, od)
+		expect(err).To.Be.Nil()
+		err = b.StoreOauthNonce(userID, store.Bot, "bot-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("bot-nonce", "test-bot-user", 54321, od)
+		expect(err).To.Be.Nil()
+		err = b.StoreDiscordGuild(userID, "test-owner-id", "test-guild-id")
+		expect(err).To.Be.Nil()
+
+		now := time.Now()
+		for _, channel := range []string{"#test-streamer-user", "#test-friend"} {
+			for i := 1; i <= 3; i++ {
+				err = b.StoreMessage(stream.RXMessage{
+					Type: stream.Twitch,
+					Twitch: &stream.RXTwitch{
+						OwnerID: 12345,
+						Channel: channel,
+						Line: &client.Line{
+							Cmd:  "PRIVMSG",
+							Args: []string{channel, fmt.Sprintf("message %d", i)},
+							Time: now.Add(time.Duration(i) * time.Second),
+						},
+					},
+				})
+				expect(err).To.Be.Nil()
+			}
+		}
+		for _, channelID := range []string{"test-channel-id", "test-channel-id", "test-channel-id", "other-channel-id"} {
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Discord,
+				Discord: &stream.RXDiscord{
+					OwnerID: "test-owner-id",
+					GuildID: "test-guild-id",
+					MessageCreate: &discordgo.MessageCreate{
+						Message: &discordgo.Message{
+							ChannelID: channelID,
+							Content:   "discord message",
+						},
+					},
+				},
+			})
+			expect(err).To.Be.Nil()
+		}
+
+		err = b.SetRetentionPolicy(userID, store.RetentionPolicy{MaxCount: 2})
+		expect(err).To.Be.Nil()
+		removed, err := b.PruneMessages()
+		expect(err).To.Be.Nil()
+		expect(removed).To.Equal(3)
+
+		messages, err := b.QueryMessages(userID, "message")
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(7)
+	}
+}
+
+func TestThatMessagesArePrunedByAge(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		// postgres expires messages based on when they were stored
+		if _, ok := b.(*store.Postgres); ok {
+			continue
+		}
+
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+		err = b.StoreOauthNonce(userID, store.Streamer, "streamer-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("streamer-nonce", "test-streamer-user", 12345, store.OauthData{
+			AccessToken: "test-access-token",
+		})
+		expect(err).To.Be.Nil()
+
+		for _, age := range []time.Duration{2 * time.Hour, time.Minute} {
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: 12345,
+					Line: &client.Line{
+						Raw:  fmt.Sprintf("test-message-%s", age),
+						Time: time.Now().Add(-age),
+					},
+				},
+			})
+			expect(err).To.Be.Nil()
+		}
+
+		err = b.SetRetentionPolicy(userID, store.RetentionPolicy{MaxAge: time.Hour})
+		expect(err).To.Be.Nil()
+		removed, err := b.PruneMessages()
+		expect(err).To.Be.Nil()
+		expect(removed).To.Equal(1)
+	}
+}
+
+func TestThatYouCanUpdateTwitchOauthData(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		od := store.OauthData{
+			AccessToken:  "test-access-token",
+			RefreshToken: "test-refresh-token",
+			Scope:        []string{"test-scope"},
+		}
+		err = b.StoreOauthNonce(userID, store.Streamer, "streamer-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("streamer-nonce", "test-streamer-user", 12345, od)
+		expect(err).To.Be.Nil()
+		err = b.StoreOauthNonce(userID, store.Bot, "bot-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("bot-nonce", "test-bot-user", 54321, od)
+		expect(err).To.Be.Nil()
+
+		actual, err := b.TwitchOauthData("test-bot-user")
+		expect(err).To.Be.Nil()
+		expect(actual).To.Equal(od)
+
+		refreshed := store.OauthData{
+			AccessToken:  "new-access-token",
+			RefreshToken: "new-refresh-token",
+			Scope:        []string{"test-scope"},
+		}
+		err = b.UpdateTwitchOauthData("test-bot-user", refreshed)
+		expect(err).To.Be.Nil()
+
+		actual, err = b.TwitchOauthData("test-bot-user")
+		expect(err).To.Be.Nil()
+		expect(actual).To.Equal(refreshed)
+		actual, err = b.TwitchOauthData("test-streamer-user")
+		expect(err).To.Be.Nil()
+		expect(actual).To.Equal(od)
+
+		creds, err := b.TwitchCredentials(userID)
+		expect(err).To.Be.Nil()
+		expect(creds.BotPassword).To.Equal("new-access-token")
+
+		_, err = b.TwitchOauthData("unknown-user")
+		expect(err).To.Equal(store.ErrUnknownUsername)
+		err = b.UpdateTwitchOauthData("unknown-user", refreshed)
+		expect(err).To.Equal(store.ErrUnknownUsername)
+	}
+}
+
+func TestThatYouCanListTwitchAuthenticatedUsers(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+		_, err = b.RegisterUser("other-user", "other-pass")
+		expect(err).To.Be.Nil()
+
+		od := store.OauthData{
+			AccessToken:  "test-access-token",
+			RefreshToken: "test-refresh-token",
+			Scope:        []string{"test-scope"},
+		}
+		err = b.StoreOauthNonce(userID, store.Streamer, "streamer-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("streamer-nonce", "test-streamer-user", 12345, od)
+		expect(err).To.Be.Nil()
+
+		userIDs, err := b.TwitchAuthenticatedUsers()
+		expect(err).To.Be.Nil()
+		expect(len(userIDs)).To.Equal(0)
+
+		err = b.StoreOauthNonce(userID, store.Bot, "bot-nonce")
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce("bot-nonce", "test-bot-user", 54321, od)
+		expect(err).To.Be.Nil()
+
+		userIDs, err = b.TwitchAuthenticatedUsers()
+		expect(err).To.Be.Nil()
+		expect(userIDs).To.Equal([]string{userID})
+
+		err = b.TwitchClearAuth(userID)
+		expect(err).To.Be.Nil()
+
+		userIDs, err = b.TwitchAuthenticatedUsers()
+		expect(err).To.Be.Nil()
+		expect(len(userIDs)).To.Equal(0)
+	}
+}
+
+func TestThatOauthNoncesExpire(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t, store.WithNonceTTL(50*time.Millisecond))
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		err = b.StoreOauthNonce(userID, store.Streamer, "stale-nonce")
+		expect(err).To.Be.Nil()
+		err = b.StoreDiscordOauthNonce(userID, "stale-discord-nonce")
+		expect(err).To.Be.Nil()
+		ok, err := b.OauthNonceExists("stale-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.True()
+
+		time.Sleep(100 * time.Millisecond)
+
+		ok, err = b.OauthNonceExists("stale-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.False()
+		_, err = b.OauthNonce(userID, store.Streamer)
+		expect(err).Not.To.Be.Nil()
+		err = b.FinishOauthNonce("stale-nonce", "test-streamer-user", 12345, store.OauthData{
+			AccessToken: "test-access-token",
+		})
+		expect(err).To.Equal(store.ErrUnknownNonce)
+		ok, err = b.DiscordOauthNonceExists("stale-discord-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.False()
+		err = b.FinishDiscordOauthNonce("stale-discord-nonce", "test-owner-id", "test-guild-id")
+		expect(err).To.Equal(store.ErrUnknownNonce)
+
+		removed, err := b.SweepOauthNonces()
+		expect(err).To.Be.Nil()
+		expect(removed).To.Equal(2)
+
+		err = b.StoreOauthNonce(userID, store.Streamer, "fresh-nonce")
+		expect(err).To.Be.Nil()
+		nonce, err := b.OauthNonce(userID, store.Streamer)
+		expect(err).To.Be.Nil()
+		expect(nonce).To.Equal("fresh-nonce")
+		removed, err = b.SweepOauthNonces()
+		expect(err).To.Be.Nil()
+		expect(removed).To.Equal(0)
+	}
+}
+
+func TestThatStoringANonceReplacesAnAbandonedNonce(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		err = b.StoreOauthNonce(userID, store.Streamer, "abandoned-nonce")
+		expect(err).To.Be.Nil()
+		err = b.StoreOauthNonce(userID, store.Streamer, "new-nonce")
+		expect(err).To.Be.Nil()
+
+		ok, err := b.OauthNonceExists("abandoned-nonce")
+		expect(err).To.Be.Nil()
+		expect(ok).To.Be.False()
+		nonce, err := b.OauthNonce(userID, store.Streamer)
+		expect(err).To.Be.Nil()
+		expect(nonce).To.Equal("new-nonce")
+	}
+}
+
+func TestThatYouCanQueryMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		messages, err := b.QueryMessages(userID, "game")
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(0)
+
+		od := store.OauthData{
+			AccessToken:  "test-access-token",
+			RefreshToken: "test-refresh-token",
+			Scope:        []string{"test-scope"},
+		}
+		streamerNonce := "streamer-nonce"
+		err = b.StoreOauthNonce(userID, store.Streamer, streamerNonce)
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce(streamerNonce, "test-streamer-user", 12345, od)
+		expect(err).To.Be.Nil()
+
+		botNonce := "bot-nonce"
+		err = b.StoreOauthNonce(userID, store.Bot, botNonce)
+		expect(err).To.Be.Nil()
+		err = b.FinishOauthNonce(botNonce, "test-bot-user", 54321, od)
+		expect(err).To.Be.Nil()
+
+		now := time.Now()
+		bodies := map[int]string{
+			12345: "what game is this?",
+			54321: "this game is great, what a game",
+			99999: "some other channel talking about a game",
+		}
+		for ownerID, body := range bodies {
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Twitch,
+				Twitch: &stream.RXTwitch{
+					OwnerID: ownerID,
+					Line: &client.Line{
+						Cmd:  "PRIVMSG",
+						Args: []string{"#test-streamer-user", body},
+						Time: now,
+					},
+				},
+			})
+			expect(err).To.Be.Nil().Else.FailNow()
+		}
+		err = b.StoreMessage(stream.RXMessage{
+			Type: stream.Twitch,
+			Twitch: &stream.RXTwitch{
+				OwnerID: 12345,
+				Line: &client.Line{
+					Cmd:  "PRIVMSG",
+					Args: []string{"#test-streamer-user", "unrelated"},
+					Time: now,
+				},
+			},
+		})
+		expect(err).To.Be.Nil().Else.FailNow()
+
+		messages, err = b.QueryMessages(userID, "Great GAME")
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(2).Else.FailNow()
+		expect(messages[0].Twitch.Line.Args[1]).To.Equal("this game is great, what a game")
+		expect(messages[1].Twitch.Line.Args[1]).To.Equal("what game is this?")
+
+		messages, err = b.QueryMessages(userID, "nothing matches")
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(0)
+	}
+}
+
+func TestThatYouCanQueryDiscordMessages(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		for _, m := range []struct {
+			guildID string
+			content string
+		}{
+			{"test-guild-id", "what game is this?"},
+			{"other-guild-id", "another game"},
+			{"test-guild-id", "unrelated"},
+		} {
+			err = b.StoreMessage(stream.RXMessage{
+				Type: stream.Discord,
+				Discord: &stream.RXDiscord{
+					OwnerID: "test-owner-id",
+					GuildID: m.guildID,
+					MessageCreate: &discordgo.MessageCreate{
+						Message: &discordgo.Message{
+							Content: m.content,
+						},
+					},
+				},
+			})
+			expect(err).To.Be.Nil().Else.FailNow()
+		}
+
+		messages, err := b.QueryMessages(userID, "game")
+		expect(err).To.Be.Nil()
+		expect(len(messages)).To.Equal(0)
+
+		err = b.StoreDiscordGuild(userID, "test-owner-id", "test-guild-id")
+		expect(err).To.Be.Nil()
+
+		messages, err = b.QueryMessages(userID, "game")
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(len(messages)).To.Equal(1).Else.FailNow()
+		expect(messages[0].Discord.MessageCreate.Content).To.Equal("what game is this?")
+	}
+}
+
+func TestThatCustomCommandsCanBeManaged(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		cmds, err := b.CustomCommands(userID)
+		expect(err).To.Be.Nil()
+		expect(len(cmds)).To.Equal(0)
+
+		err = b.SetCustomCommand(userID, store.CustomCommand{
+			Trigger:  "!uptime",
+			Response: "{user} asked for uptime",
+		})
+		expect(err).To.Be.Nil()
+		err = b.SetCustomCommand(userID, store.CustomCommand{
+			Trigger:  "!discord",
+			Response: "join us at discord",
+		})
+		expect(err).To.Be.Nil()
+
+		count, err := b.IncrementCustomCommandCount(userID, "!uptime")
+		expect(err).To.Be.Nil()
+		expect(count).To.Equal(1)
+		count, err = b.IncrementCustomCommandCount(userID, "!uptime")
+		expect(err).To.Be.Nil()
+		expect(count).To.Equal(2)
+
+		err = b.SetCustomCommand(userID, store.CustomCommand{
+			Trigger:  "!uptime",
+			Response: "{user} asked for uptime {count} times",
+		})
+		expect(err).To.Be.Nil()
+
+		cmds, err = b.CustomCommands(userID)
+		expect(err).To.Be.Nil()
+		expect(cmds).To.Equal([]store.CustomCommand{
+			{
+				Trigger:  "!discord",
+				Response: "join us at discord",
+			},
+			{
+				Trigger:  "!uptime",
+				Response: "{user} asked for uptime {count} times",
+				Count:    2,
+			},
+		})
+
+		err = b.DeleteCustomCommand(userID, "!discord")
+		expect(err).To.Be.Nil()
+		err = b.DeleteCustomCommand(userID, "!discord")
+		expect(err).To.Equal(store.ErrUnknownCustomCommand)
+		_, err = b.IncrementCustomCommandCount(userID, "!discord")
+		expect(err).To.Equal(store.ErrUnknownCustomCommand)
+
+		cmds, err = b.CustomCommands(userID)
+		expect(err).To.Be.Nil()
+		expect(len(cmds)).To.Equal(1)
+
+		err = b.SetCustomCommand(userID, store.CustomCommand{
+			Trigger:  "two words",
+			Response: "test-response",
+		})
+		expect(err).To.Equal(store.ErrInvalidCustomCommand)
+		err = b.SetCustomCommand(userID, store.CustomCommand{
+			Trigger:  "hug",
+			Response: "test-response",
+		})
+		expect(err).To.Equal(store.ErrInvalidCustomCommand)
+		err = b.SetCustomCommand(userID, store.CustomCommand{
+			Trigger: "!empty",
+		})
+		expect(err).To.Equal(store.ErrInvalidCustomCommand)
+	}
+}
+
+func TestThatModerationRulesCanBeManaged(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		rules, err := b.ModerationRules(userID)
+		expect(err).To.Be.Nil()
+		expect(rules.Enabled).To.Be.False()
+
+		expected := store.ModerationRules{
+			Enabled:         true,
+			BannedPhrases:   []string{"bad phrase"},
+			BannedPatterns:  []string{`b[a4]d`},
+			BlockLinks:      true,
+			AllowedDomains:  []string{"twitch.tv"},
+			MaxCapsPercent:  70,
+			MinCapsLength:   10,
+			MaxEmotes:       5,
+			TimeoutDuration: 10 * time.Minute,
+			BanAfter:        3,
+		}
+		err = b.SetModerationRules(userID, expected)
+		expect(err).To.Be.Nil()
+
+		rules, err = b.ModerationRules(userID)
+		expect(err).To.Be.Nil()
+		expect(rules).To.Equal(expected)
+
+		err = b.SetModerationRules(userID, store.ModerationRules{
+			BannedPatterns: []string{"("},
+		})
+		expect(err).To.Equal(store.ErrInvalidModerationRules)
+		err = b.SetModerationRules(userID, store.ModerationRules{
+			MaxCapsPercent: 101,
+		})
+		expect(err).To.Equal(store.ErrInvalidModerationRules)
+
+		_, err = b.ModerationRules("unknown-user-id")
+		expect(err).To.Equal(store.ErrUnknownUserID)
+	}
+}
+
+func TestThatModerationStrikesCanBeManaged(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		strikes, err := b.ModerationStrikes(userID)
+		expect(err).To.Be.Nil()
+		expect(len(strikes)).To.Equal(0)
+
+		now := time.Unix(1504483200, 0)
+		err = b.SetModerationStrike(userID, store.Strike{
+			Chatter: "test-chatter-b",
+			Count:   1,
+			Last:    now.Add(-2 * time.Hour),
+		})
+		expect(err).To.Be.Nil()
+		err = b.SetModerationStrike(userID, store.Strike{
+			Chatter: "test-chatter-a",
+			Count:   1,
+			Last:    now.Add(-time.Minute),
+		})
+		expect(err).To.Be.Nil()
+		err = b.SetModerationStrike(userID, store.Strike{
+			Chatter: "test-chatter-a",
+			Count:   2,
+			Last:    now,
+		})
+		expect(err).To.Be.Nil()
+
+		strikes, err = b.ModerationStrikes(userID)
+		expect(err).To.Be.Nil()
+		expect(len(strikes)).To.Equal(2)
+		expect(strikes[0].Chatter).To.Equal("test-chatter-a")
+		expect(strikes[0].Count).To.Equal(2)
+		expect(strikes[0].Last.Equal(now)).To.Be.True()
+		expect(strikes[1].Chatter).To.Equal("test-chatter-b")
+		expect(strikes[1].Count).To.Equal(1)
+
+		err = b.DeleteModerationStrikes(userID, now.Add(-time.Hour))
+		expect(err).To.Be.Nil()
+
+		strikes, err = b.ModerationStrikes(userID)
+		expect(err).To.Be.Nil()
+		expect(len(strikes)).To.Equal(1)
+		expect(strikes[0].Chatter).To.Equal("test-chatter-a")
+
+		err = b.SetModerationStrike("unknown-user-id", store.Strike{
+			Chatter: "test-chatter",
+			Count:   1,
+			Last:    now,
+		})
+		expect(err).To.Equal(store.ErrUnknownUserID)
+	}
+}
+
+func TestThatTimersCanBeManaged(t *testing.T) {
+	expect := expect.New(t)
+
+	backends, cleanup := setupBackends(t)
+	defer cleanup()
+
+	for _, b := range backends {
+		userID, err := b.RegisterUser("test-user", "test-pass")
+		expect(err).To.Be.Nil()
+
+		timers, err := b.Timers(userID)
+		expect(err).To.Be.Nil()
+		expect(len(timers)).To.Equal(0)
+
+		err = b.SetTimer(userID, store.Timer{
+			Name:     "socials",
+			Message:  "follow me on twitter",
+			Interval: 15 * time.Minute,
+			MinLines: 10,
+		})
+		expect(err).To.Be.Nil()
+		err = b.SetTimer(userID, store.Timer{
+			Name:     "discord",
+			Message:  "join us at discord",
+			Interval: 30 * time.Minute,
+		})
+		expect(err).To.Be.Nil()
+		err = b.SetTimer(userID, store.Timer{
+			Name:     "socials",
+			Message:  "follow me on twitter and instagram",
+			Interval: 20 * time.Minute,
+			MinLines: 5,
+		})
+		expect(err).To.Be.Nil()
+
+		timers, err = b.Timers(userID)
+		expect(err).To.Be.Nil()
+		expect(timers).To.Equal([]store.Timer{
+			{
+				Name:     "discord",
+				Message:  "join us at discord",
+				Interval: 30 * time.Minute,
+			},
+			{
+				Name:     "socials",
+				Message:  "follow me on twitter and instagram",
+				Interval: 20 * time.Minute,
+				MinLines: 5,
+			},
+		})
+
+		err = b.DeleteTimer(userID, "discord")
+		expect(err).To.Be.Nil()
+		err = b.DeleteTimer(userID, "discord")
+		expect(err).To.Equal(store.ErrUnknownTimer)
+
+		timers, err = b.Timers(userID)
+		expect(err).To.Be.Nil()
+		expect(len(timers)).To.Equal(1)
+
+		err = b.SetTimer(userID, store.Timer{
+			Name:     "too-fast",
+			Message:  "spam",
+			Interval: time.Second,
+		})
+		expect(err).To.Equal(store.ErrInvalidTimer)
+		err = b.SetTimer(userID, store.Timer{
+			Name:     "empty",
+			Interval: time.Hour,
+		})
+		expect(err).To.Equal(store.ErrInvalidTimer)
+	}
+}
+
+func setupBackends(t *testing.T, opts ...store.BackendOption) ([]store.Store, func()) {
+	bolt, cleanup := setupBolt(t, opts...)
 	stores := []store.Store{
 		bolt,
-		store.NewDummy(),
+		store.NewDummy(opts...),
 	}
 	cleanups := []func(){
 		cleanup,
 	}
 	if os.Getenv("ANUBOT_TEST_POSTGRES") != "" {
-		pg, cleanup := setupPostgres(t)
+		pg, cleanup := setupPostgres(t, opts...)
 		stores = append(stores, pg)
 		cleanups = append(cleanups, cleanup)
 	}
@@ -232,9 +1241,9 @@ func setupBackends(t *testing.T) ([]store.Store, func()) {
 	}
 }
 
-func setupBolt(t *testing.T) (*store.Bolt, func()) {
+func setupBolt(t *testing.T, opts ...store.BackendOption) (*store.Bolt, func()) {
 	path, cleanup := tempFile(t)
-	b, err := store.NewBolt(path)
+	b, err := store.NewBolt(path, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -248,10 +1257,11 @@ func setupBolt(t *testing.T) (*store.Bolt, func()) {
 	}
 }
 
-func setupPostgres(t *testing.T) (*store.Postgres, func()) {
+func setupPostgres(t *testing.T, opts ...store.BackendOption) (*store.Postgres, func()) {
 	pg, err := store.NewPostgres(
 		os.Getenv("ANUBOT_TEST_POSTGRES"),
 		randomKey(),
+		opts...,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -286,8 +1296,11 @@ func truncatePostgres() error {
 	defer tx.Rollback()
 
 	tables := []string{
+		"custom_command",
+		"discord_nonce",
 		"message",
 		"nonce",
+		"timer",
 		"user",
 	}
 	for _, table := range tables {
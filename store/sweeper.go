@@ -0,0 +1,52 @@
+package store
+
+import (
+	"log"
+	"time"
+)
+
+// NonceSweeper removes oauth nonces that have expired.
+type NonceSweeper interface {
+	SweepOauthNonces() (removed int, err error)
+}
+
+// Sweeper periodically removes expired oauth nonces so that abandoned oauth
+// flows do not accumulate. It needs to be started.
+type Sweeper struct {
+	*periodic
+	store NonceSweeper
+}
+
+// SweeperOption is used to configure a Sweeper.
+type SweeperOption func(*Sweeper)
+
+// WithSweepInterval allows you to override the default interval between
+// sweeps.
+func WithSweepInterval(d time.Duration) SweeperOption {
+	return func(s *Sweeper) {
+		s.interval = d
+	}
+}
+
+// NewSweeper returns a new sweeper.
+func NewSweeper(store NonceSweeper, opts ...SweeperOption) *Sweeper {
+	s := &Sweeper{
+		store: store,
+	}
+	s.periodic = newPeriodic(time.Minute, s.sweep)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sweeper) sweep() {
+	removed, err := s.store.SweepOauthNonces()
+	if err != nil {
+		log.Printf("could not sweep oauth nonces, got err: %s", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("swept %d expired oauth nonces", removed)
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// minTimerInterval is the shortest interval that a timer may have.
+const minTimerInterval = time.Minute
+
+// Timer is a message that the bot posts periodically in the streamer's
+// channel.
+type Timer struct {
+	// Name identifies the timer.
+	Name string `json:"name"`
+	// Message is what the bot posts.
+	Message string `json:"message"`
+	// Interval is how long the bot waits between posts.
+	Interval time.Duration `json:"interval"`
+	// MinLines is how many chat messages need to be sent since the last post
+	// before the timer posts again.
+	MinLines int `json:"min_lines"`
+}
+
+// valid returns true if the timer has a name, a message, an interval of at
+// least a minute and a non-negative number of lines.
+func (t Timer) valid() bool {
+	return strings.TrimSpace(t.Name) != "" &&
+		strings.TrimSpace(t.Message) != "" &&
+		t.Interval >= minTimerInterval &&
+		t.MinLines >= 0
+}
+
+// sortTimers returns the timers ordered by name.
+func sortTimers(timers map[string]Timer) []Timer {
+	sorted := make([]Timer, 0, len(timers))
+	for _, t := range timers {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
@@ -3,10 +3,17 @@ package stream
 import (
 	"errors"
 	"log"
+	"net/http"
+	"sort"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
+// ErrDiscordNotConnected occurs when Discord is used for a user that does not
+// have a connection to Discord.
+var ErrDiscordNotConnected = errors.New("discord is not connected for user")
+
 type discordConn struct {
 	d  chan dispatchMessage
 	dg *discordgo.Session
@@ -28,6 +35,7 @@ func (c *discordConn) send(m TXMessage) {
 		ch, err := c.dg.UserChannelCreate(m.Discord.To)
 		if err != nil {
 			log.Printf("discordConn.send: error getting DM channel: %s", err)
+			return
 		}
 		_, err = c.dg.ChannelMessageSend(ch.ID, m.Discord.Message)
 		if err != nil {
@@ -39,11 +47,51 @@ func (c *discordConn) send(m TXMessage) {
 	}
 }
 
+// channels returns the text channels in the guild ordered as they are
+// displayed in Discord.
+func (c *discordConn) channels(guildID string) ([]DiscordChannel, error) {
+	chs, err := c.dg.GuildChannels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	var channels []DiscordChannel
+	for _, ch := range chs {
+		if ch.Type != "text" {
+			continue
+		}
+		channels = append(channels, DiscordChannel{
+			ID:       ch.ID,
+			Name:     ch.Name,
+			Topic:    ch.Topic,
+			Position: ch.Position,
+		})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Position < channels[j].Position
+	})
+	return channels, nil
+}
+
+// isMember returns true if the Discord user is a member of the guild.
+func (c *discordConn) isMember(guildID, memberID string) (bool, error) {
+	_, err := c.dg.GuildMember(guildID, memberID)
+	if err != nil {
+		rerr, ok := err.(*discordgo.RESTError)
+		if ok && rerr.Response != nil && rerr.Response.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (c *discordConn) close() error {
 	return c.dg.Close()
 }
 
-func connectDiscord(token string, d chan dispatchMessage) (*discordConn, error) {
+// connectDiscord opens a connection to discord with the bot token. It is a
+// variable so tests can stub out discord.
+var connectDiscord = func(token string, d chan dispatchMessage) (*discordConn, error) {
 	dg, err := discordgo.New(token)
 	dg.LogLevel = discordgo.LogDebug
 	if err != nil {
@@ -58,44 +106,103 @@ func connectDiscord(token string, d chan dispatchMessage) (*discordConn, error)
 		dg: dg,
 	}
 	dg.AddHandler(dc.messageCreate)
+	dg.AddHandler(dc.messageUpdate)
+	dg.AddHandler(dc.messageDelete)
+	dg.AddHandler(dc.messageReactionAdd)
+	dg.AddHandler(dc.messageReactionRemove)
+	dg.AddHandler(dc.guildMemberAdd)
 	return dc, nil
 }
 
 func (c *discordConn) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	ownerID, err := c.resolveOwnerID(m)
+	if m.Message == nil {
+		return
+	}
+	c.dispatchChannelEvent(m.ChannelID, &RXDiscord{MessageCreate: m})
+}
+
+func (c *discordConn) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if m.Message == nil {
+		return
+	}
+	c.dispatchChannelEvent(m.ChannelID, &RXDiscord{MessageUpdate: m})
+}
+
+func (c *discordConn) messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if m.Message == nil {
+		return
+	}
+	c.dispatchChannelEvent(m.ChannelID, &RXDiscord{MessageDelete: m})
+}
+
+func (c *discordConn) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	if m.MessageReaction == nil {
+		return
+	}
+	c.dispatchChannelEvent(m.ChannelID, &RXDiscord{MessageReactionAdd: m})
+}
+
+func (c *discordConn) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) {
+	if m.MessageReaction == nil {
+		return
+	}
+	c.dispatchChannelEvent(m.ChannelID, &RXDiscord{MessageReactionRemove: m})
+}
+
+func (c *discordConn) guildMemberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.Member == nil {
+		return
+	}
+	gld, err := c.dg.Guild(m.GuildID)
 	if err != nil {
 		log.Printf("got err attempting to resolve discord topic: %s", err)
 		return
 	}
-	topic := "discord:" + ownerID
-	msg := RXMessage{
-		Type: Discord,
-		Discord: &RXDiscord{
-			OwnerID:       ownerID,
-			MessageCreate: m,
-		},
+	c.dispatch(gld, &RXDiscord{GuildMemberAdd: m})
+}
+
+// dispatchChannelEvent resolves the guild of the channel the event occurred
+// in and dispatches the event to the guild owner's topic.
+func (c *discordConn) dispatchChannelEvent(channelID string, rx *RXDiscord) {
+	ch, gld, err := c.resolveGuild(channelID)
+	if err != nil {
+		log.Printf("got err attempting to resolve discord topic: %s", err)
+		return
 	}
-	select {
-	case c.d <- dispatchMessage{
-		topic: topic,
-		msg:   msg,
-	}:
-	default:
-		log.Println("discordConn.messageCreate: unable to dispatch message")
+	rx.ChannelName = ch.Name
+	c.dispatch(gld, rx)
+}
+
+// dispatch publishes the event on the topic for the owner of the guild.
+func (c *discordConn) dispatch(gld *discordgo.Guild, rx *RXDiscord) {
+	rx.OwnerID = gld.OwnerID
+	rx.GuildID = gld.ID
+	rx.Time = time.Now()
+	ok := enqueue(c.d, dispatchMessage{
+		topic: "discord:" + gld.OwnerID,
+		msg: RXMessage{
+			Type:    Discord,
+			Discord: rx,
+		},
+	})
+	if !ok {
+		log.Println("discordConn.dispatch: unable to dispatch message")
 	}
 }
 
-func (c *discordConn) resolveOwnerID(m *discordgo.MessageCreate) (string, error) {
-	ch, err := c.dg.Channel(m.ChannelID)
+// resolveGuild returns the channel with the given ID and the guild that
+// channel belongs to.
+func (c *discordConn) resolveGuild(channelID string) (*discordgo.Channel, *discordgo.Guild, error) {
+	ch, err := c.dg.Channel(channelID)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	if ch.IsPrivate {
-		return "", errors.New("Not possible to resolve private messages back to guild owner")
+		return nil, nil, errors.New("Not possible to resolve private messages back to guild owner")
 	}
 	gld, err := c.dg.Guild(ch.GuildID)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	return gld.OwnerID, nil
+	return ch, gld, nil
 }
@@ -29,3 +29,30 @@ func (m *mockTwitchUserIDFetcher) UserID(username string) (userID int, err error
 	m.UserIDInput.Username <- username
 	return <-m.UserIDOutput.UserID, <-m.UserIDOutput.Err
 }
+
+type mockTokenRefresher struct {
+	RefreshCalled chan bool
+	RefreshInput  struct {
+		TwitchUsername, Token chan string
+	}
+	RefreshOutput struct {
+		NewToken chan string
+		Err      chan error
+	}
+}
+
+func newMockTokenRefresher() *mockTokenRefresher {
+	m := &mockTokenRefresher{}
+	m.RefreshCalled = make(chan bool, 100)
+	m.RefreshInput.TwitchUsername = make(chan string, 100)
+	m.RefreshInput.Token = make(chan string, 100)
+	m.RefreshOutput.NewToken = make(chan string, 100)
+	m.RefreshOutput.Err = make(chan error, 100)
+	return m
+}
+func (m *mockTokenRefresher) Refresh(twitchUsername, token string) (newToken string, err error) {
+	m.RefreshCalled <- true
+	m.RefreshInput.TwitchUsername <- twitchUsername
+	m.RefreshInput.Token <- token
+	return <-m.RefreshOutput.NewToken, <-m.RefreshOutput.Err
+}
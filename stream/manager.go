@@ -1,30 +1,66 @@
 package stream
 
 import (
+	"context"
 	"encoding/json"
+	"expvar"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/pebbe/zmq4"
+	"github.com/jasonkeene/anubot-server/bus"
 )
 
+// dispatchTimeout is how long received messages wait for room in the
+// manager's buffer before they are dropped.
+var dispatchTimeout = time.Second
+
+// discordMaxAttempts is how many times connecting to discord is attempted
+// before giving up.
+var discordMaxAttempts = 10
+
+// metrics are published with expvar. Messages that are dropped because
+// the manager's buffer is full are counted by dropped.
+var metrics = expvar.NewMap("stream")
+
 // Manager manages numerous connections to stream soruces.
 type Manager struct {
 	pushEndpoints []string
-	push          *zmq4.Socket
+	bus           bus.Bus
+	push          bus.Pusher
 	dispatch      chan dispatchMessage
+	stop          chan struct{}
+	stopOnce      sync.Once
+	done          chan struct{}
+	// ctx is cancelled once the manager is shutting down to interrupt
+	// connections that are being retried.
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	mu          sync.Mutex
-	twitchConns map[string]conn
-	discordConn conn
+	mu sync.Mutex
+	// closed is set once the manager is shutting down to prevent new
+	// connections from being made.
+	closed      bool
+	twitchConns map[string]*twitchSupervisor
+	// discordConns are keyed by bot token so users whose guilds were joined
+	// by the same bot share a connection.
+	discordConns map[string]*discordConn
+	// discordUsers maps user IDs to the bot token they are connected with.
+	discordUsers map[string]string
 
-	twitch TwitchUserIDFetcher
+	twitch    TwitchUserIDFetcher
+	refresher TokenRefresher
 }
 
 type dispatchMessage struct {
 	topic string
 	msg   RXMessage
+	// user is the twitch user whose connection received the message.
+	user string
+	// key identifies the message so that duplicates of it can be dropped
+	// by the dispatcher. Messages without a key are never dropped.
+	key string
 }
 
 type conn interface {
@@ -37,6 +73,12 @@ type TwitchUserIDFetcher interface {
 	UserID(username string) (userID int, err error)
 }
 
+// TokenRefresher gets a new access token for a twitch user when their token
+// has been rejected.
+type TokenRefresher interface {
+	Refresh(twitchUsername, token string) (newToken string, err error)
+}
+
 // Option is used to configure a Mananger.
 type Option func(*Manager)
 
@@ -47,14 +89,43 @@ func WithPushEndpoints(endpoints []string) Option {
 	}
 }
 
+// WithBus allows you to override the default bus.
+func WithBus(b bus.Bus) Option {
+	return func(m *Manager) {
+		m.bus = b
+	}
+}
+
+// WithDispatchBuffer allows you to override how many received messages can
+// be buffered while waiting to be sent to the dispatcher.
+func WithDispatchBuffer(size int) Option {
+	return func(m *Manager) {
+		m.dispatch = make(chan dispatchMessage, size)
+	}
+}
+
+// WithTokenRefresher allows twitch connections that fail to authenticate to
+// be retried with a refreshed token.
+func WithTokenRefresher(r TokenRefresher) Option {
+	return func(m *Manager) {
+		m.refresher = r
+	}
+}
+
 // NewManager creates a new manager.
 func NewManager(twitch TwitchUserIDFetcher, opts ...Option) *Manager {
 	m := &Manager{
 		pushEndpoints: []string{"inproc://dispatch-pull"},
+		bus:           bus.Default,
 		dispatch:      make(chan dispatchMessage, 1000),
-		twitchConns:   make(map[string]conn),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		twitchConns:   make(map[string]*twitchSupervisor),
+		discordConns:  make(map[string]*discordConn),
+		discordUsers:  make(map[string]string),
 		twitch:        twitch,
 	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
 	for _, opt := range opts {
 		opt(m)
 	}
@@ -65,138 +136,419 @@ func NewManager(twitch TwitchUserIDFetcher, opts ...Option) *Manager {
 
 func (m *Manager) setupSockets() {
 	var err error
-	m.push, err = zmq4.NewSocket(zmq4.PUSH)
+	m.push, err = m.bus.Pusher(bus.Connect, m.pushEndpoints)
 	if err != nil {
-		log.Panicf("Manager.setupSockets: can not create manager push socket: %s", err)
-	}
-	for _, endpoint := range m.pushEndpoints {
-		err = m.push.Connect(endpoint)
-		if err != nil {
-			log.Panicf("Manager.setupSockets: can not connect push socket: %s", err)
-		}
+		log.Panicf("Manager.setupSockets: can not connect push socket: %s", err)
 	}
 }
 
 func (m *Manager) run() {
-	for dispatchMsg := range m.dispatch {
-		mb, err := json.Marshal(dispatchMsg.msg)
+	defer close(m.done)
+	defer func() {
+		err := m.push.Close()
 		if err != nil {
-			log.Printf("Manager.run: error with marshalling message: %s", err)
-			continue
+			log.Printf("Manager.run: got err while closing push socket: %s", err)
 		}
+	}()
 
-		_, err = m.push.SendBytes([]byte(dispatchMsg.topic), zmq4.SNDMORE)
-		if err != nil {
-			log.Printf("Manager.run: unable to send message frame 0: %s", err)
-			continue
+	for {
+		select {
+		case dispatchMsg := <-m.dispatch:
+			m.forward(m.route(dispatchMsg))
+		case <-m.stop:
+			// messages that have already been received are sent before
+			// the socket is closed so they are not lost
+			for {
+				select {
+				case dispatchMsg := <-m.dispatch:
+					m.forward(m.route(dispatchMsg))
+				default:
+					return
+				}
+			}
 		}
-		_, err = m.push.SendBytes(mb, 0)
-		if err != nil {
-			log.Printf("Manager.run: unable to send message frame 1: %s", err)
-			continue
+	}
+}
+
+// enqueue adds the message to the buffer of messages waiting to be sent to
+// the dispatcher. If the buffer stays full for the dispatch timeout the
+// message is dropped and false is returned.
+func enqueue(d chan dispatchMessage, dispatchMsg dispatchMessage) bool {
+	select {
+	case d <- dispatchMsg:
+		return true
+	default:
+	}
+	timer := time.NewTimer(dispatchTimeout)
+	defer timer.Stop()
+	select {
+	case d <- dispatchMsg:
+		return true
+	case <-timer.C:
+		metrics.Add("dropped", 1)
+		return false
+	}
+}
+
+// route makes the broadcaster of a channel the owner of the twitch lines
+// sent to it that were received by a connection that was made for that
+// channel, such as the connection of the streamer's bot. This way lines that
+// are received by both the streamer's and the bot's connections are
+// published on the same topic and the copies can be dropped by the
+// dispatcher. Lines are only routed while the broadcaster is connected.
+func (m *Manager) route(dispatchMsg dispatchMessage) dispatchMessage {
+	rx := dispatchMsg.msg.Twitch
+	if dispatchMsg.msg.Type != Twitch || rx == nil || rx.Line == nil {
+		return dispatchMsg
+	}
+
+	if rx.Channel != "" {
+		m.mu.Lock()
+		receiver := m.twitchConns[dispatchMsg.user]
+		broadcaster := m.twitchConns[strings.TrimPrefix(rx.Channel, "#")]
+		m.mu.Unlock()
+		if receiver != nil && broadcaster != nil && receiver.home == rx.Channel {
+			ownerID, ok := broadcaster.userID()
+			if ok && ownerID != rx.OwnerID {
+				routed := *rx
+				routed.OwnerID = ownerID
+				dispatchMsg.msg.Twitch = &routed
+				dispatchMsg.topic = TwitchTopic(ownerID, rx.Channel, TwitchLineKindOf(rx.Line))
+			}
 		}
 	}
+	dispatchMsg.key = twitchDedupKey(dispatchMsg.topic, rx.Line)
+	return dispatchMsg
 }
 
-// ConnectTwitch connects to twitch and streams data to the dispatcher.
+// forward sends the message to the dispatcher. Messages are sent with their
+// topic and message frames followed by a frame with their dedup key if they
+// have one.
+func (m *Manager) forward(dispatchMsg dispatchMessage) {
+	mb, err := json.Marshal(dispatchMsg.msg)
+	if err != nil {
+		log.Printf("Manager.forward: error with marshalling message: %s", err)
+		return
+	}
+
+	frames := [][]byte{[]byte(dispatchMsg.topic), mb}
+	if dispatchMsg.key != "" {
+		frames = append(frames, []byte(dispatchMsg.key))
+	}
+	err = m.push.Push(frames...)
+	if err != nil {
+		metrics.Add("forward_errors", 1)
+		log.Printf("Manager.forward: unable to send message: %s", err)
+	}
+}
+
+// ConnectTwitch connects to twitch and streams data to the dispatcher. It
+// returns once the first attempt to connect has completed. The connection is
+// supervised: if it fails or is lost it is retried with backoff until it is
+// disconnected or too many attempts fail. Lines received from twitch are
+// published on the TwitchTopic for their owner, channel and kind. Changes to
+// the state of the connection are published on the TwitchStateTopic for the
+// user. If the user is already connected the channel is joined in addition
+// to the channels they have already joined.
 func (m *Manager) ConnectTwitch(user, pass, channel string) {
 	m.mu.Lock()
-	_, ok := m.twitchConns[user]
-	m.mu.Unlock()
-	if ok {
+	if m.closed {
+		m.mu.Unlock()
+		log.Print("Manager.ConnectTwitch: manager is shut down, not connecting user:", user)
 		return
 	}
-
-	for i := 0; i < 10; i++ {
-		c, err := connectTwitch(user, pass, channel, m.dispatch, m.twitch)
-		if err == nil {
-			m.mu.Lock()
-			defer m.mu.Unlock()
-			m.twitchConns[user] = c
-			return
-		}
+	if s, ok := m.twitchConns[user]; ok {
+		m.mu.Unlock()
+		s.join(channel)
+		return
 	}
-	log.Print("unable to establish connection to twitch for user:", user)
+	var s *twitchSupervisor
+	s = newTwitchSupervisor(user, pass, channel, m.dispatch, m.twitch, m.refresher, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.twitchConns[user] == s {
+			delete(m.twitchConns, user)
+		}
+	})
+	m.twitchConns[user] = s
+	m.mu.Unlock()
+
+	s.start()
 }
 
-// ConnectDiscord connects to discord and streams data to the dispatcher.
-func (m *Manager) ConnectDiscord(token string) {
+// ConnectDiscord connects the user's Discord bot and streams data to the
+// dispatcher. Users that connect with the same bot token share a connection.
+// Failed attempts are retried with the same backoff as twitch connections
+// until the manager is shut down.
+func (m *Manager) ConnectDiscord(userID, token string) {
 	m.mu.Lock()
-	dc := m.discordConn
+	if m.closed {
+		m.mu.Unlock()
+		log.Print("Manager.ConnectDiscord: manager is shut down, not connecting user:", userID)
+		return
+	}
+	_, connected := m.discordUsers[userID]
+	_, shared := m.discordConns[token]
+	if !connected && shared {
+		m.discordUsers[userID] = token
+	}
 	m.mu.Unlock()
-	if dc != nil {
+	if connected || shared {
 		return
 	}
 
-	for i := 0; i < 10; i++ {
-		c, err := connectDiscord(token, m.dispatch)
+	var (
+		c   *discordConn
+		err error
+	)
+	for attempt := 1; ; attempt++ {
+		c, err = connectDiscord(token, m.dispatch)
 		if err == nil {
-			m.mu.Lock()
-			defer m.mu.Unlock()
-			m.discordConn = c
+			break
+		}
+		if attempt >= discordMaxAttempts {
+			log.Printf("Manager.ConnectDiscord: giving up on connecting to discord for user: %s: %s", userID, err)
+			return
+		}
+		delay := backoff(attempt)
+		log.Printf("Manager.ConnectDiscord: retrying connection in %s for user: %s: %s", delay, userID, err)
+		select {
+		case <-time.After(delay):
+		case <-m.ctx.Done():
+			log.Print("Manager.ConnectDiscord: manager is shut down, not connecting user:", userID)
 			return
 		}
 	}
-	log.Print("unable to establish connection to discord")
-}
 
-// DisconnectTwitch tears down a connection to twitch.
-func (m *Manager) DisconnectTwitch(user string) func() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.closed {
+		// the manager was shut down while connecting
+		err = c.close()
+		if err != nil {
+			log.Printf("Manager.ConnectDiscord: error occurred while closing conn: %s", err)
+		}
+		return
+	}
+	if _, ok := m.discordConns[token]; ok {
+		// another user connected with the same token in the meantime
+		err = c.close()
+		if err != nil {
+			log.Printf("Manager.ConnectDiscord: error occurred while closing duplicate conn: %s", err)
+		}
+	} else {
+		m.discordConns[token] = c
+	}
+	m.discordUsers[userID] = token
+}
+
+// DisconnectTwitch tears down a connection to twitch. It returns a function
+// that can be used to block until the connection has been closed.
+func (m *Manager) DisconnectTwitch(user string) (wait func()) {
+	m.mu.Lock()
 	log.Print("Manager.DisconnectTwitch: disconnecting for user:", user)
 
 	c, ok := m.twitchConns[user]
 	if !ok {
+		m.mu.Unlock()
 		log.Print("Manager.DisconnectTwitch: user conn does not exist for twitch user:", user)
 		return func() {}
 	}
-	err := c.close()
 	delete(m.twitchConns, user)
-	if err != nil {
+	m.mu.Unlock()
+
+	// the supervisor is closed without holding the lock as it may need
+	// the lock to remove itself if it gives up in the meantime
+	return closeConn(c, func(err error) {
 		log.Printf("Manager.DisconnectTwitch: error occurred while disconnecting user: %s error: %s", user, err)
+	})
+}
+
+// DisconnectDiscord tears down the user's connection to discord. The
+// connection is only closed once no other users share it. It returns a
+// function that can be used to block until the connection has been closed.
+func (m *Manager) DisconnectDiscord(userID string) (wait func()) {
+	m.mu.Lock()
+	log.Print("Manager.DisconnectDiscord: disconnecting for user:", userID)
+
+	token, ok := m.discordUsers[userID]
+	if !ok {
+		m.mu.Unlock()
+		log.Print("Manager.DisconnectDiscord: user conn does not exist for user:", userID)
 		return func() {}
 	}
+	delete(m.discordUsers, userID)
+	for _, t := range m.discordUsers {
+		if t == token {
+			m.mu.Unlock()
+			return func() {}
+		}
+	}
+
+	c, ok := m.discordConns[token]
+	delete(m.discordConns, token)
+	m.mu.Unlock()
+	if !ok {
+		log.Print("Manager.DisconnectDiscord: discord conn does not exist for user:", userID)
+		return func() {}
+	}
+
+	return closeConn(c, func(err error) {
+		log.Printf("Manager.DisconnectDiscord: error occurred while disconnecting user: %s error: %s", userID, err)
+	})
+}
+
+// closeConn closes the conn in its own goroutine. It returns a function that
+// blocks until the conn has been closed.
+func closeConn(c conn, onErr func(error)) (wait func()) {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		err := c.close()
+		if err != nil {
+			onErr(err)
+		}
+	}()
 	return func() {
-		// TODO: block until disconnect completed
-		time.Sleep(time.Second)
+		<-closed
 	}
 }
 
-// DisconnectDiscord tears down the connection to discord.
-func (m *Manager) DisconnectDiscord() func() {
-	log.Print("Manager.DisconnectDiscord: disconnecting")
+// Shutdown disconnects all connections to twitch and discord, sends the
+// messages that have already been received on to the dispatcher and then
+// closes the push socket. No new connections can be made once it is called.
+// If the context is done before shutdown has completed its error is
+// returned.
+func (m *Manager) Shutdown(ctx context.Context) error {
 	m.mu.Lock()
-	c := m.discordConn
-	m.discordConn = nil
+	m.closed = true
+	m.cancel()
+	twitchUsers := make([]string, 0, len(m.twitchConns))
+	for user := range m.twitchConns {
+		twitchUsers = append(twitchUsers, user)
+	}
+	discordUsers := make([]string, 0, len(m.discordUsers))
+	for userID := range m.discordUsers {
+		discordUsers = append(discordUsers, userID)
+	}
 	m.mu.Unlock()
-	err := c.close()
-	if err != nil {
-		log.Printf("Manager.DisconnectDiscord: error occurred while disconnecting: %s", err)
-		return func() {}
+
+	var waits []func()
+	for _, user := range twitchUsers {
+		waits = append(waits, m.DisconnectTwitch(user))
 	}
-	return func() {
-		// TODO: block until disconnect completed
-		time.Sleep(time.Second)
+	for _, userID := range discordUsers {
+		waits = append(waits, m.DisconnectDiscord(userID))
+	}
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for _, wait := range waits {
+			wait()
+		}
+	}()
+
+	select {
+	case <-disconnected:
+	case <-ctx.Done():
+	}
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// DiscordChannels lists the text channels in the guild using the user's
+// connection to Discord.
+func (m *Manager) DiscordChannels(userID, guildID string) ([]DiscordChannel, error) {
+	m.mu.Lock()
+	c := m.discordConns[m.discordUsers[userID]]
+	m.mu.Unlock()
+	if c == nil {
+		return nil, ErrDiscordNotConnected
+	}
+	return c.channels(guildID)
+}
+
+// IsDiscordGuildMember returns true if the Discord user is a member of the
+// guild using the user's connection to Discord.
+func (m *Manager) IsDiscordGuildMember(userID, guildID, memberID string) (bool, error) {
+	m.mu.Lock()
+	c := m.discordConns[m.discordUsers[userID]]
+	m.mu.Unlock()
+	if c == nil {
+		return false, ErrDiscordNotConnected
+	}
+	return c.isMember(guildID, memberID)
+}
+
+// PartTwitchChannel leaves a channel the user has joined. Messages from the
+// channel are no longer received. ErrTwitchNotConnected is returned if the
+// user is not connected to twitch.
+func (m *Manager) PartTwitchChannel(user, channel string) error {
+	m.mu.Lock()
+	s := m.twitchConns[user]
+	m.mu.Unlock()
+	if s == nil {
+		return ErrTwitchNotConnected
+	}
+	if !s.part(channel) {
+		log.Printf("Manager.PartTwitchChannel: channel: %s was not joined for user: %s", channel, user)
+	}
+	return nil
+}
+
+// TwitchChannels returns the channels the user has joined on twitch. It
+// returns nil if the user is not connected to twitch.
+func (m *Manager) TwitchChannels(user string) []string {
+	m.mu.Lock()
+	s := m.twitchConns[user]
+	m.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	return s.joined()
+}
+
+// TwitchQueueDepth returns how many messages are waiting to be sent to twitch
+// for the user. Messages are queued to stay within twitch's rate limits.
+func (m *Manager) TwitchQueueDepth(user string) int {
+	m.mu.Lock()
+	s := m.twitchConns[user]
+	m.mu.Unlock()
+	if s == nil {
+		return 0
+	}
+	return s.queueDepth()
+}
+
 // Send sends a message to the stream source.
 func (m *Manager) Send(ms TXMessage) {
 	var c conn
 	switch ms.Type {
 	case Twitch:
 		m.mu.Lock()
-		c = m.twitchConns[ms.Twitch.Username]
+		tc := m.twitchConns[ms.Twitch.Username]
 		m.mu.Unlock()
-		if c == nil {
+		if tc == nil {
 			log.Printf("unable to send message for twitch user: %s", ms.Twitch.Username)
 			return
 		}
+		c = tc
 	case Discord:
 		m.mu.Lock()
-		c = m.discordConn
+		dc := m.discordConns[m.discordUsers[ms.Discord.UserID]]
 		m.mu.Unlock()
+		if dc == nil {
+			log.Printf("unable to send message for discord user: %s", ms.Discord.UserID)
+			return
+		}
+		c = dc
 	default:
 		log.Printf("Manager.Send: unknown message type: %d", ms.Type)
 		return
@@ -0,0 +1,248 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/fluffle/goirc/client"
+
+	"github.com/jasonkeene/anubot-server/bus"
+)
+
+func TestShutdownSendsReceivedMessagesBeforeClosing(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+	pull, err := b.Puller(bus.Bind, []string{"inproc://test-manager-shutdown"})
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer pull.Close()
+
+	m := NewManager(
+		newMockTwitchUserIDFetcher(),
+		WithBus(b),
+		WithPushEndpoints([]string{"inproc://test-manager-shutdown"}),
+	)
+	for i := 0; i < 3; i++ {
+		m.dispatch <- dispatchMessage{
+			topic: "twitch:test-user",
+			msg: RXMessage{
+				Type: Twitch,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expect(m.Shutdown(ctx)).To.Be.Nil()
+
+	for i := 0; i < 3; i++ {
+		parts, err := pull.Pull(time.Second)
+		expect(err).To.Be.Nil().Else.FailNow()
+		expect(parts[0]).To.Equal([]byte("twitch:test-user"))
+		var ms RXMessage
+		expect(json.Unmarshal(parts[1], &ms)).To.Be.Nil()
+		expect(ms.Type).To.Equal(Twitch)
+	}
+}
+
+func TestConnectingAfterShutdownIsIgnored(t *testing.T) {
+	expect := expect.New(t)
+	m := NewManager(
+		newMockTwitchUserIDFetcher(),
+		WithPushEndpoints([]string{"inproc://test-manager-connect-after-shutdown"}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expect(m.Shutdown(ctx)).To.Be.Nil()
+
+	m.ConnectTwitch("test-user", "test-pass", "#test-chan")
+	m.ConnectDiscord("test-user-id", "test-token")
+
+	expect(len(m.twitchConns)).To.Equal(0)
+	expect(len(m.discordUsers)).To.Equal(0)
+}
+
+func TestConnectingDiscordGivesUpAfterMaxAttempts(t *testing.T) {
+	expect := expect.New(t)
+	defer patchBackoff(time.Millisecond, time.Millisecond, twitchMaxAttempts)()
+	defer patchDiscordMaxAttempts(3)()
+	attempts := make(chan struct{}, 10)
+	defer patchConnectDiscord(func(string, chan dispatchMessage) (*discordConn, error) {
+		attempts <- struct{}{}
+		return nil, errors.New("test-error")
+	})()
+	m := NewManager(
+		newMockTwitchUserIDFetcher(),
+		WithPushEndpoints([]string{"inproc://test-manager-discord-gives-up"}),
+	)
+
+	m.ConnectDiscord("test-user-id", "test-token")
+
+	expect(len(attempts)).To.Equal(3)
+	expect(len(m.discordUsers)).To.Equal(0)
+	expect(len(m.discordConns)).To.Equal(0)
+}
+
+func TestShutdownInterruptsDiscordRetries(t *testing.T) {
+	expect := expect.New(t)
+	defer patchBackoff(time.Hour, time.Hour, twitchMaxAttempts)()
+	attempts := make(chan struct{}, 10)
+	defer patchConnectDiscord(func(string, chan dispatchMessage) (*discordConn, error) {
+		attempts <- struct{}{}
+		return nil, errors.New("test-error")
+	})()
+	m := NewManager(
+		newMockTwitchUserIDFetcher(),
+		WithPushEndpoints([]string{"inproc://test-manager-discord-shutdown"}),
+	)
+
+	connected := make(chan struct{})
+	go func() {
+		defer close(connected)
+		m.ConnectDiscord("test-user-id", "test-token")
+	}()
+	<-attempts
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expect(m.Shutdown(ctx)).To.Be.Nil()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("ConnectDiscord was not interrupted by Shutdown")
+	}
+	expect(len(attempts)).To.Equal(0)
+}
+
+func TestDisconnectingDiscordWithoutAConn(t *testing.T) {
+	m := NewManager(
+		newMockTwitchUserIDFetcher(),
+		WithPushEndpoints([]string{"inproc://test-manager-disconnect-discord"}),
+	)
+	m.discordUsers["test-user-id"] = "test-token"
+
+	m.DisconnectDiscord("test-user-id")()
+}
+
+func TestRoutingTwitchLinesToTheBroadcaster(t *testing.T) {
+	m := NewManager(
+		newMockTwitchUserIDFetcher(),
+		WithPushEndpoints([]string{"inproc://test-manager-routing"}),
+	)
+	m.twitchConns["test-streamer"] = &twitchSupervisor{id: 12345}
+	m.twitchConns["test-other"] = &twitchSupervisor{id: 54321}
+	m.twitchConns["test-bot"] = &twitchSupervisor{
+		id:   67890,
+		home: "#test-streamer",
+	}
+
+	cases := map[string]struct {
+		line  *client.Line
+		topic string
+		owner int
+		key   string
+	}{
+		"message in the broadcaster's channel": {
+			line: &client.Line{
+				Cmd:  "PRIVMSG",
+				Args: []string{"#test-streamer", "test-message"},
+				Tags: map[string]string{"id": "test-id"},
+			},
+			topic: "twitch:12345:#test-streamer:chat",
+			owner: 12345,
+			key:   "twitch:12345:#test-streamer:chat:test-id",
+		},
+		"event in the broadcaster's channel": {
+			line: &client.Line{
+				Cmd:  "CLEARCHAT",
+				Args: []string{"#test-streamer", "test-chatter"},
+				Tags: map[string]string{"tmi-sent-ts": "1504483200000"},
+				Raw:  "CLEARCHAT #test-streamer :test-chatter",
+			},
+			topic: "twitch:12345:#test-streamer:event",
+			owner: 12345,
+			key:   "twitch:12345:#test-streamer:event:1504483200000:CLEARCHAT #test-streamer :test-chatter",
+		},
+		"event without a sent time": {
+			line: &client.Line{
+				Cmd:  "ROOMSTATE",
+				Args: []string{"#test-streamer"},
+				Raw:  "ROOMSTATE #test-streamer",
+			},
+			topic: "twitch:12345:#test-streamer:event",
+			owner: 12345,
+		},
+		"message in a channel whose broadcaster is not connected": {
+			line: &client.Line{
+				Cmd:  "PRIVMSG",
+				Args: []string{"#test-absent", "test-message"},
+				Tags: map[string]string{"id": "test-id"},
+			},
+			topic: "twitch:67890:#test-absent:chat",
+			owner: 67890,
+			key:   "twitch:67890:#test-absent:chat:test-id",
+		},
+		"message in another channel that was joined": {
+			line: &client.Line{
+				Cmd:  "PRIVMSG",
+				Args: []string{"#test-other", "test-message"},
+				Tags: map[string]string{"id": "test-id"},
+			},
+			topic: "twitch:67890:#test-other:chat",
+			owner: 67890,
+			key:   "twitch:67890:#test-other:chat:test-id",
+		},
+		"whisper": {
+			line: &client.Line{
+				Cmd:  "WHISPER",
+				Args: []string{"test-bot", "test-message"},
+				Tags: map[string]string{"message-id": "1"},
+			},
+			topic: "twitch:67890::whisper",
+			owner: 67890,
+		},
+	}
+
+	for name, cs := range cases {
+		t.Run(name, func(t *testing.T) {
+			expect := expect.New(t)
+			channel := LineChannel(cs.line)
+			dispatchMsg := m.route(dispatchMessage{
+				user:  "test-bot",
+				topic: TwitchTopic(67890, channel, TwitchLineKindOf(cs.line)),
+				msg: RXMessage{
+					Type: Twitch,
+					Twitch: &RXTwitch{
+						OwnerID: 67890,
+						Channel: channel,
+						Line:    cs.line,
+					},
+				},
+			})
+
+			expect(dispatchMsg.topic).To.Equal(cs.topic)
+			expect(dispatchMsg.msg.Twitch.OwnerID).To.Equal(cs.owner)
+			expect(dispatchMsg.key).To.Equal(cs.key)
+		})
+	}
+}
+
+func patchDiscordMaxAttempts(attempts int) func() {
+	orig := discordMaxAttempts
+	discordMaxAttempts = attempts
+	return func() {
+		discordMaxAttempts = orig
+	}
+}
+
+func patchConnectDiscord(f func(string, chan dispatchMessage) (*discordConn, error)) func() {
+	orig := connectDiscord
+	connectDiscord = f
+	return func() {
+		connectDiscord = orig
+	}
+}
@@ -0,0 +1,570 @@
+// Package remote lets stream managers run in their own processes. A Server
+// exposes a stream.Manager over the bus and a Client controls it from
+// another process.
+//
+// Clients push requests to the Server which publishes its replies on a
+// topic that is unique to the Client. A bus that can reach other processes,
+// such as the one in bus/zmq, is needed to run them in separate processes.
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+// remoteTimeout is how long a Client waits for a Server to reply.
+var remoteTimeout = 10 * time.Second
+
+// ErrNoReply is returned by a Client when a Server did not reply in time.
+var ErrNoReply = errors.New("remote: server did not reply in time")
+
+// Option is used to configure a Server or Client.
+type Option func(*config)
+
+type config struct {
+	bus bus.Bus
+}
+
+// WithBus allows you to override the default bus.
+func WithBus(b bus.Bus) Option {
+	return func(c *config) {
+		c.bus = b
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		bus: bus.Default,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Commands that a Client sends to a Server.
+const (
+	connectTwitchCmd     = "connect-twitch"
+	disconnectTwitchCmd  = "disconnect-twitch"
+	partTwitchChannelCmd = "part-twitch-channel"
+	twitchChannelsCmd    = "twitch-channels"
+	twitchQueueDepthCmd  = "twitch-queue-depth"
+	connectDiscordCmd    = "connect-discord"
+	disconnectDiscordCmd = "disconnect-discord"
+	discordChannelsCmd   = "discord-channels"
+	discordMemberCmd     = "discord-member"
+	sendCmd              = "send"
+)
+
+// remoteRequest is a command sent to a Server. Only the fields used by the
+// command are set.
+type remoteRequest struct {
+	Client   string            `json:"client"`
+	ID       uint64            `json:"id"`
+	Cmd      string            `json:"cmd"`
+	User     string            `json:"user"`
+	Pass     string            `json:"pass"`
+	Channel  string            `json:"channel"`
+	UserID   string            `json:"user_id"`
+	GuildID  string            `json:"guild_id"`
+	MemberID string            `json:"member_id"`
+	Token    string            `json:"token"`
+	Message  *stream.TXMessage `json:"message"`
+}
+
+// remoteResponse is the reply to a remoteRequest.
+type remoteResponse struct {
+	ID              uint64                  `json:"id"`
+	Error           string                  `json:"error"`
+	Channels        []string                `json:"channels"`
+	DiscordChannels []stream.DiscordChannel `json:"discord_channels"`
+	QueueDepth      int                     `json:"queue_depth"`
+	Member          bool                    `json:"member"`
+}
+
+// remoteErrors are the errors that are returned as themselves by a Client
+// so they can be compared against.
+var remoteErrors = []error{
+	stream.ErrTwitchNotConnected,
+	stream.ErrDiscordNotConnected,
+}
+
+// Server exposes a stream.Manager to Clients in other processes. Requests
+// are pulled as a single frame containing a JSON encoded command and the
+// replies are published on the topic of the Client that sent them.
+type Server struct {
+	m        *stream.Manager
+	pull     bus.Puller
+	pub      bus.Publisher
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewServer returns a Server that pulls requests from the endpoints and
+// publishes replies to the reply endpoints. It needs to be started.
+// Requests carry credentials so the endpoints should only be reachable by
+// trusted hosts.
+func NewServer(m *stream.Manager, endpoints, replyEndpoints []string, opts ...Option) (*Server, error) {
+	c := newConfig(opts)
+	pull, err := c.bus.Puller(bus.Bind, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := c.bus.Publisher(replyEndpoints)
+	if err != nil {
+		closeErr := pull.Close()
+		if closeErr != nil {
+			log.Printf("NewServer: got err while closing pull socket: %s", closeErr)
+		}
+		return nil, err
+	}
+	return &Server{
+		m:    m,
+		pull: pull,
+		pub:  pub,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start reads requests and replies to them until the server is shut down.
+// It needs to run in its own goroutine.
+func (s *Server) Start() {
+	defer close(s.done)
+	defer func() {
+		err := s.pull.Close()
+		if err != nil {
+			log.Printf("Server.Start: got err while closing pull socket: %s", err)
+		}
+		err = s.pub.Close()
+		if err != nil {
+			log.Printf("Server.Start: got err while closing pub socket: %s", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		// waiting for a limited time allows the server to notice it has
+		// been stopped when no requests are being received
+		parts, err := s.pull.Pull(time.Second)
+		if err != nil {
+			if err == bus.ErrTimeout {
+				continue
+			}
+			log.Printf("Server.Start: error occurred when reading from pull socket: %s", err)
+			continue
+		}
+		if len(parts) != 1 {
+			log.Printf("Server.Start: received request had invalid length: %d", len(parts))
+			continue
+		}
+
+		// without a client there is nowhere to send a reply
+		var req remoteRequest
+		err = json.Unmarshal(parts[0], &req)
+		if err != nil {
+			log.Printf("Server.Start: received invalid request: %s", err)
+			continue
+		}
+		resp := s.handle(req)
+		resp.ID = req.ID
+		rb, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("Server.Start: error with marshalling response: %s", err)
+			rb = []byte(fmt.Sprintf(`{"id":%d,"error":"unable to marshal response"}`, req.ID))
+		}
+		err = s.pub.Publish(req.Client, rb)
+		if err != nil {
+			log.Printf("Server.Start: unable to send response: %s", err)
+		}
+	}
+}
+
+// handle invokes the command on the manager. Connecting and disconnecting
+// can take a while so they are done in the background and the server
+// replies immediately.
+func (s *Server) handle(req remoteRequest) remoteResponse {
+	var resp remoteResponse
+	switch req.Cmd {
+	case connectTwitchCmd:
+		go s.m.ConnectTwitch(req.User, req.Pass, req.Channel)
+	case disconnectTwitchCmd:
+		s.m.DisconnectTwitch(req.User)
+	case partTwitchChannelCmd:
+		resp.Error = errString(s.m.PartTwitchChannel(req.User, req.Channel))
+	case twitchChannelsCmd:
+		resp.Channels = s.m.TwitchChannels(req.User)
+	case twitchQueueDepthCmd:
+		resp.QueueDepth = s.m.TwitchQueueDepth(req.User)
+	case connectDiscordCmd:
+		go s.m.ConnectDiscord(req.UserID, req.Token)
+	case disconnectDiscordCmd:
+		s.m.DisconnectDiscord(req.UserID)
+	case discordChannelsCmd:
+		channels, err := s.m.DiscordChannels(req.UserID, req.GuildID)
+		resp.DiscordChannels = channels
+		resp.Error = errString(err)
+	case discordMemberCmd:
+		member, err := s.m.IsDiscordGuildMember(req.UserID, req.GuildID, req.MemberID)
+		resp.Member = member
+		resp.Error = errString(err)
+	case sendCmd:
+		if req.Message == nil {
+			resp.Error = "message is missing"
+			break
+		}
+		s.m.Send(*req.Message)
+	default:
+		resp.Error = fmt.Sprintf("unknown command: %s", req.Cmd)
+	}
+	return resp
+}
+
+// Shutdown stops reading requests and closes the sockets. If the context
+// is done first its error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Client controls stream.Managers in other processes through their Servers.
+// It has the same methods as a Manager so it can be used in its place. When
+// connected to multiple servers each user is assigned to one of them by
+// their twitch username or Discord user ID.
+//
+// Connecting and disconnecting are done in the background by the server so
+// they return before they have completed.
+type Client struct {
+	conns []*conn
+}
+
+// conn is a connection to a single Server. Requests are made one at a
+// time and replies to earlier requests that arrive late are discarded. A
+// request that was not replied to in time may still be handled once the
+// server reads it.
+type conn struct {
+	id string
+
+	mu   sync.Mutex
+	seq  uint64
+	push bus.Pusher
+	sub  bus.Subscription
+}
+
+// NewClient returns a Client connected to the servers at the endpoints.
+// Each server has one endpoint and one reply endpoint at the same index.
+func NewClient(endpoints, replyEndpoints []string, opts ...Option) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoints were given")
+	}
+	if len(endpoints) != len(replyEndpoints) {
+		return nil, errors.New("each endpoint needs a reply endpoint")
+	}
+	id, err := newClientID()
+	if err != nil {
+		return nil, err
+	}
+	cfg := newConfig(opts)
+	c := &Client{}
+	for i, endpoint := range endpoints {
+		r, err := newConn(cfg.bus, id, endpoint, replyEndpoints[i])
+		if err != nil {
+			closeErr := c.Close()
+			if closeErr != nil {
+				log.Printf("NewClient: got err while closing connections: %s", closeErr)
+			}
+			return nil, err
+		}
+		c.conns = append(c.conns, r)
+	}
+	return c, nil
+}
+
+// newClientID returns a random ID that is used as the topic replies are
+// published on. The IDs all have the same length so one is never the
+// prefix of another.
+func newClientID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// conn returns the connection to the server the user is assigned to.
+func (c *Client) conn(key string) *conn {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.conns[h.Sum32()%uint32(len(c.conns))]
+}
+
+// ConnectTwitch asks the server to connect to twitch for the user.
+func (c *Client) ConnectTwitch(user, pass, channel string) {
+	_, err := c.conn(user).do(remoteRequest{
+		Cmd:     connectTwitchCmd,
+		User:    user,
+		Pass:    pass,
+		Channel: channel,
+	})
+	if err != nil {
+		log.Printf("Client.ConnectTwitch: unable to connect user: %s: %s", user, err)
+	}
+}
+
+// DisconnectTwitch asks the server to disconnect the user from twitch. The
+// returned function does not block as the server disconnects in the
+// background.
+func (c *Client) DisconnectTwitch(user string) (wait func()) {
+	_, err := c.conn(user).do(remoteRequest{
+		Cmd:  disconnectTwitchCmd,
+		User: user,
+	})
+	if err != nil {
+		log.Printf("Client.DisconnectTwitch: unable to disconnect user: %s: %s", user, err)
+	}
+	return func() {}
+}
+
+// PartTwitchChannel asks the server to leave a channel the user has joined.
+func (c *Client) PartTwitchChannel(user, channel string) error {
+	_, err := c.conn(user).do(remoteRequest{
+		Cmd:     partTwitchChannelCmd,
+		User:    user,
+		Channel: channel,
+	})
+	return err
+}
+
+// TwitchChannels returns the channels the user has joined on twitch. It
+// returns nil if the user is not connected or the server could not be
+// reached.
+func (c *Client) TwitchChannels(user string) []string {
+	resp, err := c.conn(user).do(remoteRequest{
+		Cmd:  twitchChannelsCmd,
+		User: user,
+	})
+	if err != nil {
+		log.Printf("Client.TwitchChannels: unable to get channels for user: %s: %s", user, err)
+		return nil
+	}
+	return resp.Channels
+}
+
+// TwitchQueueDepth returns how many messages are waiting to be sent to twitch
+// for the user.
+func (c *Client) TwitchQueueDepth(user string) int {
+	resp, err := c.conn(user).do(remoteRequest{
+		Cmd:  twitchQueueDepthCmd,
+		User: user,
+	})
+	if err != nil {
+		log.Printf("Client.TwitchQueueDepth: unable to get queue depth for user: %s: %s", user, err)
+		return 0
+	}
+	return resp.QueueDepth
+}
+
+// ConnectDiscord asks the server to connect the user's Discord bot.
+func (c *Client) ConnectDiscord(userID, token string) {
+	_, err := c.conn(userID).do(remoteRequest{
+		Cmd:    connectDiscordCmd,
+		UserID: userID,
+		Token:  token,
+	})
+	if err != nil {
+		log.Printf("Client.ConnectDiscord: unable to connect user: %s: %s", userID, err)
+	}
+}
+
+// DisconnectDiscord asks the server to disconnect the user from Discord. The
+// returned function does not block as the server disconnects in the
+// background.
+func (c *Client) DisconnectDiscord(userID string) (wait func()) {
+	_, err := c.conn(userID).do(remoteRequest{
+		Cmd:    disconnectDiscordCmd,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Client.DisconnectDiscord: unable to disconnect user: %s: %s", userID, err)
+	}
+	return func() {}
+}
+
+// DiscordChannels lists the text channels in the guild using the user's
+// connection to Discord.
+func (c *Client) DiscordChannels(userID, guildID string) ([]stream.DiscordChannel, error) {
+	resp, err := c.conn(userID).do(remoteRequest{
+		Cmd:     discordChannelsCmd,
+		UserID:  userID,
+		GuildID: guildID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.DiscordChannels, nil
+}
+
+// IsDiscordGuildMember returns true if the Discord user is a member of the
+// guild using the user's connection to Discord.
+func (c *Client) IsDiscordGuildMember(userID, guildID, memberID string) (bool, error) {
+	resp, err := c.conn(userID).do(remoteRequest{
+		Cmd:      discordMemberCmd,
+		UserID:   userID,
+		GuildID:  guildID,
+		MemberID: memberID,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Member, nil
+}
+
+// Send asks the server to send a message to the stream source.
+func (c *Client) Send(ms stream.TXMessage) {
+	var key string
+	switch ms.Type {
+	case stream.Twitch:
+		key = ms.Twitch.Username
+	case stream.Discord:
+		key = ms.Discord.UserID
+	default:
+		log.Printf("Client.Send: unknown message type: %d", ms.Type)
+		return
+	}
+	_, err := c.conn(key).do(remoteRequest{
+		Cmd:     sendCmd,
+		Message: &ms,
+	})
+	if err != nil {
+		log.Printf("Client.Send: unable to send message: %s", err)
+	}
+}
+
+// Close closes the connections to the servers.
+func (c *Client) Close() error {
+	var err error
+	for _, r := range c.conns {
+		cerr := r.close()
+		if cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func newConn(b bus.Bus, id, endpoint, replyEndpoint string) (*conn, error) {
+	sub, err := b.Subscribe([]string{replyEndpoint}, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	push, err := b.Pusher(bus.Connect, []string{endpoint})
+	if err != nil {
+		closeErr := sub.Close()
+		if closeErr != nil {
+			log.Printf("newConn: got err while closing sub socket: %s", closeErr)
+		}
+		return nil, err
+	}
+	return &conn{
+		id:   id,
+		push: push,
+		sub:  sub,
+	}, nil
+}
+
+func (r *conn) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.push.Close()
+	serr := r.sub.Close()
+	if serr != nil {
+		err = serr
+	}
+	return err
+}
+
+// do sends the request to the server and waits for its response. The
+// request is not sent if the server is unable to accept it.
+func (r *conn) do(req remoteRequest) (remoteResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	req.Client = r.id
+	req.ID = r.seq
+
+	var resp remoteResponse
+	b, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	err = r.push.TryPush(b)
+	if err != nil {
+		return resp, err
+	}
+
+	deadline := time.Now().Add(remoteTimeout)
+	for {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return resp, ErrNoReply
+		}
+		_, b, err = r.sub.Receive(timeout)
+		if err == bus.ErrTimeout {
+			return resp, ErrNoReply
+		}
+		if err != nil {
+			return resp, err
+		}
+
+		resp = remoteResponse{}
+		err = json.Unmarshal(b, &resp)
+		if err != nil {
+			return resp, err
+		}
+		if resp.ID == req.ID {
+			break
+		}
+	}
+
+	if resp.Error != "" {
+		for _, known := range remoteErrors {
+			if resp.Error == known.Error() {
+				return resp, known
+			}
+		}
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,138 @@
+package remote
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+
+	"github.com/jasonkeene/anubot-server/bus"
+	"github.com/jasonkeene/anubot-server/stream"
+)
+
+func TestClientInvokesCommandsOnRemoteManager(t *testing.T) {
+	expect := expect.New(t)
+	b := bus.NewMemory()
+	// none of the commands need to look up twitch user IDs
+	m := stream.NewManager(
+		nil,
+		stream.WithBus(b),
+		stream.WithPushEndpoints([]string{"inproc://test-remote-push"}),
+	)
+	s, err := NewServer(
+		m,
+		[]string{"inproc://test-remote"},
+		[]string{"inproc://test-remote-reply"},
+		WithBus(b),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+	go s.Start()
+	defer shutdown(t, s)
+
+	c, err := NewClient(
+		[]string{"inproc://test-remote"},
+		[]string{"inproc://test-remote-reply"},
+		WithBus(b),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer c.Close()
+
+	expect(c.TwitchChannels("test-user")).To.Be.Nil()
+	expect(c.TwitchQueueDepth("test-user")).To.Equal(0)
+	expect(c.PartTwitchChannel("test-user", "#test-chan")).To.Equal(stream.ErrTwitchNotConnected)
+	_, err = c.DiscordChannels("test-user-id", "test-guild-id")
+	expect(err).To.Equal(stream.ErrDiscordNotConnected)
+	_, err = c.IsDiscordGuildMember("test-user-id", "test-guild-id", "test-member-id")
+	expect(err).To.Equal(stream.ErrDiscordNotConnected)
+	c.Send(stream.TXMessage{
+		Type: stream.Twitch,
+		Twitch: &stream.TXTwitch{
+			Username: "test-user",
+			To:       "#test-chan",
+			Message:  "test-message",
+		},
+	})
+}
+
+func TestClientDiscardsRepliesToEarlierRequests(t *testing.T) {
+	expect := expect.New(t)
+	defer patchRemoteTimeout(100 * time.Millisecond)()
+	b := bus.NewMemory()
+
+	c, err := NewClient(
+		[]string{"inproc://test-remote"},
+		[]string{"inproc://test-remote-reply"},
+		WithBus(b),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer c.Close()
+
+	// the server is not running so the request is not replied to
+	expect(c.TwitchChannels("test-user")).To.Be.Nil()
+
+	m := stream.NewManager(
+		nil,
+		stream.WithBus(b),
+		stream.WithPushEndpoints([]string{"inproc://test-remote-push"}),
+	)
+	s, err := NewServer(
+		m,
+		[]string{"inproc://test-remote"},
+		[]string{"inproc://test-remote-reply"},
+		WithBus(b),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+	go s.Start()
+	defer shutdown(t, s)
+
+	// the server replies to the earlier request first which succeeded
+	err = c.PartTwitchChannel("test-user", "#test-chan")
+	expect(err).To.Equal(stream.ErrTwitchNotConnected)
+}
+
+func TestClientTimesOutWhenServerDoesNotReply(t *testing.T) {
+	expect := expect.New(t)
+	defer patchRemoteTimeout(100 * time.Millisecond)()
+
+	c, err := NewClient(
+		[]string{"inproc://test-remote"},
+		[]string{"inproc://test-remote-reply"},
+		WithBus(bus.NewMemory()),
+	)
+	expect(err).To.Be.Nil().Else.FailNow()
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		err = c.PartTwitchChannel("test-user", "#test-chan")
+		expect(err).To.Equal(ErrNoReply)
+	}
+}
+
+func TestNewClientRequiresAReplyEndpointForEachServer(t *testing.T) {
+	expect := expect.New(t)
+
+	_, err := NewClient(
+		[]string{"inproc://test-remote-a", "inproc://test-remote-b"},
+		[]string{"inproc://test-remote-reply-a"},
+		WithBus(bus.NewMemory()),
+	)
+	expect(err).Not.To.Be.Nil()
+}
+
+func shutdown(t *testing.T, s *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("unable to shut down server: %s", err)
+	}
+}
+
+func patchRemoteTimeout(timeout time.Duration) func() {
+	orig := remoteTimeout
+	remoteTimeout = timeout
+	return func() {
+		remoteTimeout = orig
+	}
+}
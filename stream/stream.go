@@ -1,6 +1,8 @@
 package stream
 
 import (
+	"time"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/fluffle/goirc/client"
 )
@@ -30,6 +32,9 @@ type TXTwitch struct {
 	To string
 	// Message is the content of the message.
 	Message string
+	// Priority messages, such as those typed by the streamer, are sent
+	// before any queued bot output.
+	Priority bool
 }
 
 // TXDiscordType is the type of message to send to Discord ()
@@ -44,6 +49,9 @@ const (
 
 // TXDiscord contains information to send to Discord.
 type TXDiscord struct {
+	// UserID is the ID of the user whose Discord connection is used to send
+	// the message.
+	UserID string
 	// Type is the type of message to send (Channel or Private).
 	Type TXDiscordType
 	// To is where to send the message to (channel ID or user ID).
@@ -52,6 +60,14 @@ type TXDiscord struct {
 	Message string
 }
 
+// DiscordChannel describes a text channel in a Discord guild.
+type DiscordChannel struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Topic    string `json:"topic"`
+	Position int    `json:"position"`
+}
+
 // RXMessage is the data read from a stream source.
 type RXMessage struct {
 	Type    Type       `json:"type"`
@@ -62,15 +78,38 @@ type RXMessage struct {
 // RXTwitch contains information received from Twitch.
 type RXTwitch struct {
 	// OwnerID is the Twitch user ID that was authenticated when the IRC
-	// message was received.
+	// message was received. Messages received by a connection that was
+	// made for another user's channel are owned by that user while they are
+	// connected. See TwitchTopic.
 	OwnerID int `json:"owner_id"`
+	// Channel is the channel the IRC message was sent to. It is empty for
+	// messages that were not sent to a channel such as whispers.
+	Channel string `json:"channel"`
 	// Line is the content of the IRC message.
 	Line *client.Line `json:"line"`
+	// State is set instead of Line when the message reports a change in
+	// the state of the connection to twitch. These messages are published
+	// on the TwitchStateTopic for the user.
+	State *TwitchConnState `json:"state"`
 }
 
 // RXDiscord contains information received from Discord.
 type RXDiscord struct {
+	// OwnerID is the Discord user ID of the owner of the guild the message
+	// was received in.
 	OwnerID string `json:"owner_id"`
-	// TODO: add other types
-	MessageCreate *discordgo.MessageCreate `json:"message_create"`
+	// GuildID is the ID of the guild the message was received in.
+	GuildID string `json:"guild_id"`
+	// ChannelName is the name of the channel the message was received in.
+	ChannelName string `json:"channel_name"`
+	// Time is when the event was received.
+	Time time.Time `json:"time"`
+
+	// Only one of the following events is set.
+	MessageCreate         *discordgo.MessageCreate         `json:"message_create"`
+	MessageUpdate         *discordgo.MessageUpdate         `json:"message_update"`
+	MessageDelete         *discordgo.MessageDelete         `json:"message_delete"`
+	MessageReactionAdd    *discordgo.MessageReactionAdd    `json:"message_reaction_add"`
+	MessageReactionRemove *discordgo.MessageReactionRemove `json:"message_reaction_remove"`
+	GuildMemberAdd        *discordgo.GuildMemberAdd        `json:"guild_member_add"`
 }
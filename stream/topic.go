@@ -0,0 +1,79 @@
+package stream
+
+import (
+	"strconv"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// TwitchLineKind categorizes the IRC lines received from twitch so
+// consumers can subscribe to only the lines they are interested in.
+type TwitchLineKind string
+
+const (
+	// TwitchChatLine is used for PRIVMSG and ACTION lines.
+	TwitchChatLine TwitchLineKind = "chat"
+	// TwitchWhisperLine is used for WHISPER lines.
+	TwitchWhisperLine TwitchLineKind = "whisper"
+	// TwitchEventLine is used for USERNOTICE, CLEARCHAT, CLEARMSG,
+	// ROOMSTATE and HOSTTARGET lines.
+	TwitchEventLine TwitchLineKind = "event"
+)
+
+// TwitchLineKindOf returns the kind of the IRC line.
+func TwitchLineKindOf(line *client.Line) TwitchLineKind {
+	switch line.Cmd {
+	case "PRIVMSG", "ACTION":
+		return TwitchChatLine
+	case "WHISPER":
+		return TwitchWhisperLine
+	default:
+		return TwitchEventLine
+	}
+}
+
+// TwitchTopic is the topic IRC lines received from twitch are published on.
+// It has the form twitch:<ownerID>:<channel>:<kind> where the owner is the
+// twitch user ID of the user whose connection received the line. Lines
+// received by a connection that was made for another user's channel, such
+// as the connection of their bot, are owned by that user while they are
+// connected. Whispers are not sent to a channel so their channel is empty.
+//
+// Topics are matched by prefix so TwitchOwnerTopic and TwitchChannelTopic
+// can be used to subscribe to all of an owner's lines or all of the lines
+// for one of their channels.
+func TwitchTopic(ownerID int, channel string, kind TwitchLineKind) string {
+	return TwitchChannelTopic(ownerID, channel) + string(kind)
+}
+
+// TwitchOwnerTopic is the prefix of the topics of all IRC lines owned by the
+// twitch user.
+func TwitchOwnerTopic(ownerID int) string {
+	return "twitch:" + strconv.Itoa(ownerID) + ":"
+}
+
+// TwitchChannelTopic is the prefix of the topics of all IRC lines sent to
+// the channel that are owned by the twitch user.
+func TwitchChannelTopic(ownerID int, channel string) string {
+	return TwitchOwnerTopic(ownerID) + channel + ":"
+}
+
+// twitchDedupKey identifies the line so that copies of it received by
+// multiple connections can be dropped by the dispatcher. Lines are
+// identified by their id tag when they have one and otherwise by when twitch
+// sent them along with their raw content. Lines without either, such as
+// ROOMSTATE and HOSTTARGET, can be repeated with the same content so they
+// have no key. Whispers are only received by one connection so they have no
+// key either.
+func twitchDedupKey(topic string, line *client.Line) string {
+	if TwitchLineKindOf(line) == TwitchWhisperLine {
+		return ""
+	}
+	if id := line.Tags["id"]; id != "" {
+		return topic + ":" + id
+	}
+	if sent := line.Tags["tmi-sent-ts"]; sent != "" {
+		return topic + ":" + sent + ":" + line.Raw
+	}
+	return ""
+}
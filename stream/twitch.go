@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fluffle/goirc/client"
@@ -21,11 +22,24 @@ var (
 	twitchHost         = defaultTwitchHost
 	twitchPort         = defaultTwitchPort
 	insecureSkipVerify = false
-	flood              = false
+	// flood disables goirc's own rate limiting, messages are limited by
+	// the sendQueue instead.
+	flood = true
+	// twitchCloseTimeout is how long to wait for twitch to acknowledge a
+	// QUIT before the connection is closed from our end.
+	twitchCloseTimeout = 5 * time.Second
 
 	capString string
 )
 
+// ErrTwitchAuthFailed is returned when twitch rejects the password for a
+// user. This typically means the oauth token has expired.
+var ErrTwitchAuthFailed = errors.New("twitch login authentication failed")
+
+// ErrTwitchNotConnected occurs when twitch is used for a user that does not
+// have a connection to twitch.
+var ErrTwitchNotConnected = errors.New("twitch is not connected for user")
+
 func init() {
 	caps := []string{
 		"twitch.tv/tags",
@@ -40,26 +54,81 @@ type twitchConn struct {
 	c  *client.Conn
 	u  string
 	id int
+	q  *sendQueue
+
+	// disconnected is closed once the connection to twitch is lost.
+	disconnected     chan struct{}
+	disconnectedOnce sync.Once
+
+	mu sync.Mutex
+	// reconnect is set when twitch asks for the connection to be
+	// reestablished.
+	reconnect bool
 }
 
 func (c *twitchConn) send(m TXMessage) {
-	c.c.Privmsg(m.Twitch.To, m.Twitch.Message)
+	c.q.enqueue(m.Twitch)
+}
+
+func (c *twitchConn) join(channel string) {
+	log.Printf("twitchConn.join: joining channel: %s on twitch for user: %s", channel, c.u)
+	c.c.Join(channel)
+}
+
+func (c *twitchConn) part(channel string) {
+	log.Printf("twitchConn.part: parting channel: %s on twitch for user: %s", channel, c.u)
+	c.c.Part(channel)
 }
 
 func (c *twitchConn) close() error {
-	disconnected := make(chan struct{})
-	c.c.HandleFunc("DISCONNECTED", func(conn *client.Conn, line *client.Line) {
-		close(disconnected)
-	})
+	select {
+	case <-c.disconnected:
+		log.Printf("twitchConn.close: already disconnected from twitch for user: %s", c.u)
+		return nil
+	default:
+	}
 	log.Printf("twitchConn.close: disconnecting from twitch for user: %s", c.u)
 	c.c.Quit()
 	log.Printf("twitchConn.close: waiting for disconnect event from twitch for user: %s", c.u)
-	<-disconnected
+	select {
+	case <-c.disconnected:
+		log.Printf("twitchConn.close: disconnected from twitch for user: %s", c.u)
+		return nil
+	case <-time.After(twitchCloseTimeout):
+	}
+
+	log.Printf("twitchConn.close: twitch did not disconnect in time, closing connection for user: %s", c.u)
+	err := c.c.Close()
+	if err != nil {
+		log.Printf("twitchConn.close: error occurred while closing connection for user: %s: %s", c.u, err)
+	}
+	select {
+	case <-c.disconnected:
+	case <-time.After(twitchCloseTimeout):
+		return errors.New("did not receive DISCONNECTED event")
+	}
 	log.Printf("twitchConn.close: disconnected from twitch for user: %s", c.u)
 	return nil
 }
 
-func connectTwitch(u, p, c string, d chan dispatchMessage, twitch TwitchUserIDFetcher) (*twitchConn, error) {
+// reconnectRequested returns true if the connection was closed because
+// twitch sent a RECONNECT command.
+func (c *twitchConn) reconnectRequested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnect
+}
+
+// connectTwitch connects to twitch and joins the channels. Messages sent
+// through the connection are queued on q and written out while the
+// connection is established.
+func connectTwitch(
+	u, p string,
+	channels []string,
+	q *sendQueue,
+	d chan dispatchMessage,
+	twitch TwitchUserIDFetcher,
+) (*twitchConn, error) {
 	userID, err := twitch.UserID(u)
 	if err != nil {
 		return nil, err
@@ -77,19 +146,56 @@ func connectTwitch(u, p, c string, d chan dispatchMessage, twitch TwitchUserIDFe
 	}
 	cfg.Server = net.JoinHostPort(twitchHost, strconv.Itoa(twitchPort))
 	tc := &twitchConn{
-		d:  d,
-		c:  client.Client(cfg),
-		u:  u,
-		id: userID,
+		d:            d,
+		c:            client.Client(cfg),
+		u:            u,
+		id:           userID,
+		q:            q,
+		disconnected: make(chan struct{}),
 	}
+	tc.c.HandleFunc("DISCONNECTED", func(conn *client.Conn, line *client.Line) {
+		tc.disconnectedOnce.Do(func() {
+			close(tc.disconnected)
+		})
+	})
+	tc.c.HandleFunc("RECONNECT", func(conn *client.Conn, line *client.Line) {
+		log.Printf("connectTwitch: twitch requested a reconnect for user: %s", u)
+		tc.mu.Lock()
+		tc.reconnect = true
+		tc.mu.Unlock()
+		// closing from within a handler would deadlock waiting on the
+		// goroutine that is dispatching this handler
+		go tc.c.Close()
+	})
 
 	connected := make(chan struct{})
 	tc.c.HandleFunc("CONNECTED", func(conn *client.Conn, line *client.Line) {
 		close(connected)
 	})
+	authFailed := make(chan struct{})
+	var authFailedOnce sync.Once
+	tc.c.HandleFunc("NOTICE", func(conn *client.Conn, line *client.Line) {
+		if isAuthFailure(line) {
+			authFailedOnce.Do(func() {
+				close(authFailed)
+			})
+		}
+	})
+	tc.c.HandleFunc("USERSTATE", func(conn *client.Conn, line *client.Line) {
+		if len(line.Args) == 0 {
+			return
+		}
+		tags := ParseTwitchTags(line.Tags)
+		tc.q.setMod(line.Args[0], tags.Mod || tags.HasBadge("moderator") || tags.HasBadge("broadcaster"))
+	})
 	tc.c.HandleFunc("PRIVMSG", tc.dispatchMessage)
 	tc.c.HandleFunc("ACTION", tc.dispatchMessage)
 	tc.c.HandleFunc("WHISPER", tc.dispatchMessage)
+	tc.c.HandleFunc("USERNOTICE", tc.dispatchMessage)
+	tc.c.HandleFunc("CLEARCHAT", tc.dispatchMessage)
+	tc.c.HandleFunc("CLEARMSG", tc.dispatchMessage)
+	tc.c.HandleFunc("ROOMSTATE", tc.dispatchMessage)
+	tc.c.HandleFunc("HOSTTARGET", tc.dispatchMessage)
 
 	log.Printf("connectTwitch: connecting to twitch for user: %s", u)
 	if err := tc.c.Connect(); err != nil {
@@ -99,35 +205,63 @@ func connectTwitch(u, p, c string, d chan dispatchMessage, twitch TwitchUserIDFe
 	log.Printf("connectTwitch: connection to twitch established for user: %s", u)
 	select {
 	case <-connected:
+	case <-authFailed:
+		log.Printf("connectTwitch: login authentication failed for user: %s", u)
+		tc.c.Close()
+		return nil, ErrTwitchAuthFailed
 	case <-time.After(3 * time.Second):
 		log.Printf("connectTwitch: did not receive CONNECTED event")
+		tc.c.Close()
 		return nil, errors.New("did not receive CONNECTED event")
 	}
 	log.Printf("connectTwitch: received connection event from twitch for user: %s", u)
-	tc.c.Join(c)
-	log.Printf("connectTwitch: joined channel: %s on twitch for user: %s", c, u)
+	for _, c := range channels {
+		tc.c.Join(c)
+		log.Printf("connectTwitch: joined channel: %s on twitch for user: %s", c, u)
+	}
 
 	tc.c.Raw("CAP REQ :" + capString)
 	log.Printf("connectTwitch: requested capabilities on twitch for user: %s", u)
 
+	go tc.q.run(tc.c.Privmsg, tc.disconnected)
+
 	return tc, nil
 }
 
+// isAuthFailure returns true if the line is a notice from twitch rejecting
+// the password provided when connecting.
+func isAuthFailure(line *client.Line) bool {
+	text := line.Text()
+	return strings.Contains(text, "Login authentication failed") ||
+		strings.Contains(text, "Improperly formatted auth")
+}
+
 func (c *twitchConn) dispatchMessage(conn *client.Conn, line *client.Line) {
-	topic := "twitch:" + c.u
+	channel := LineChannel(line)
+	topic := TwitchTopic(c.id, channel, TwitchLineKindOf(line))
 	msg := RXMessage{
 		Type: Twitch,
 		Twitch: &RXTwitch{
 			OwnerID: c.id,
+			Channel: channel,
 			Line:    line,
 		},
 	}
-	select {
-	case c.d <- dispatchMessage{
+	ok := enqueue(c.d, dispatchMessage{
 		topic: topic,
 		msg:   msg,
-	}:
-	default:
+		user:  c.u,
+	})
+	if !ok {
 		log.Println("twitchConn.dispatchMessage: unable to dispatch message")
 	}
 }
+
+// LineChannel returns the channel the line was sent to or an empty string if
+// it was not sent to a channel.
+func LineChannel(line *client.Line) string {
+	if line == nil || len(line.Args) == 0 || !strings.HasPrefix(line.Args[0], "#") {
+		return ""
+	}
+	return line.Args[0]
+}
@@ -0,0 +1,230 @@
+package stream
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// TwitchEventKind identifies what a TwitchEvent represents.
+type TwitchEventKind string
+
+const (
+	// TwitchEventSub is sent when a user subscribes to the channel for the
+	// first time.
+	TwitchEventSub TwitchEventKind = "sub"
+	// TwitchEventResub is sent when a user shares that they resubscribed.
+	TwitchEventResub TwitchEventKind = "resub"
+	// TwitchEventSubGift is sent when a user gifts a subscription to another
+	// user.
+	TwitchEventSubGift TwitchEventKind = "subgift"
+	// TwitchEventMysterySubGift is sent when a user gifts subscriptions to
+	// random users in the channel.
+	TwitchEventMysterySubGift TwitchEventKind = "submysterygift"
+	// TwitchEventRaid is sent when another channel raids the channel.
+	TwitchEventRaid TwitchEventKind = "raid"
+	// TwitchEventUserNotice is sent for any other kind of USERNOTICE.
+	TwitchEventUserNotice TwitchEventKind = "usernotice"
+	// TwitchEventTimeout is sent when a user is temporarily banned.
+	TwitchEventTimeout TwitchEventKind = "timeout"
+	// TwitchEventBan is sent when a user is permanently banned.
+	TwitchEventBan TwitchEventKind = "ban"
+	// TwitchEventClearChat is sent when all messages in the channel are
+	// cleared.
+	TwitchEventClearChat TwitchEventKind = "clearchat"
+	// TwitchEventDeleteMessage is sent when a single message is deleted.
+	TwitchEventDeleteMessage TwitchEventKind = "delete"
+	// TwitchEventRoomState is sent when joining a channel or when its chat
+	// settings change.
+	TwitchEventRoomState TwitchEventKind = "roomstate"
+	// TwitchEventHost is sent when the channel starts hosting another
+	// channel.
+	TwitchEventHost TwitchEventKind = "host"
+	// TwitchEventUnhost is sent when the channel stops hosting.
+	TwitchEventUnhost TwitchEventKind = "unhost"
+)
+
+// TwitchEvent is a typed representation of IRC commands Twitch sends that
+// are not chat messages. Only the fields relevant to the Kind are set.
+type TwitchEvent struct {
+	Kind    TwitchEventKind `json:"kind"`
+	Channel string          `json:"channel"`
+	Time    time.Time       `json:"time"`
+
+	// User is the login of the user the event is about: the subscriber,
+	// gifter, raider or the user that was banned or had a message deleted.
+	User        string `json:"user"`
+	DisplayName string `json:"display_name"`
+	// Message is the message the user shared with their sub or the content
+	// of the deleted message.
+	Message string `json:"message"`
+	// SystemMessage is the text Twitch provides to describe the event.
+	SystemMessage string `json:"system_message"`
+
+	// SubTier is 1, 2 or 3 for subscription events. Prime subs are tier 1.
+	SubTier      int    `json:"sub_tier"`
+	Prime        bool   `json:"prime"`
+	Months       int    `json:"months"`
+	StreakMonths int    `json:"streak_months"`
+	Recipient    string `json:"recipient"`
+	GiftCount    int    `json:"gift_count"`
+
+	// Viewers is the size of a raid or host.
+	Viewers int `json:"viewers"`
+	// Target is the channel being hosted.
+	Target string `json:"target"`
+
+	// BanDuration is the length of a timeout in seconds.
+	BanDuration int `json:"ban_duration"`
+	// TargetMessageID is the ID of the deleted message.
+	TargetMessageID string `json:"target_message_id"`
+
+	// RoomState has the chat settings for the channel. Twitch only sends
+	// the settings that changed so unset settings are nil.
+	RoomState *TwitchRoomState `json:"room_state"`
+}
+
+// TwitchRoomState describes the chat settings of a channel.
+type TwitchRoomState struct {
+	EmoteOnly *bool `json:"emote_only"`
+	// FollowersOnly is the number of minutes a user must follow before
+	// chatting. It is -1 when followers only mode is off.
+	FollowersOnly *int  `json:"followers_only"`
+	R9K           *bool `json:"r9k"`
+	// Slow is the number of seconds users must wait between messages.
+	Slow     *int  `json:"slow"`
+	SubsOnly *bool `json:"subs_only"`
+}
+
+// ParseTwitchEvent converts an IRC line received from Twitch into a
+// TwitchEvent. It returns false if the line is not a USERNOTICE, CLEARCHAT,
+// CLEARMSG, ROOMSTATE or HOSTTARGET.
+func ParseTwitchEvent(line *client.Line) (*TwitchEvent, bool) {
+	if line == nil || len(line.Args) == 0 {
+		return nil, false
+	}
+	e := &TwitchEvent{
+		Channel: line.Args[0],
+		Time:    line.Time,
+	}
+	tags := line.Tags
+
+	switch line.Cmd {
+	case "USERNOTICE":
+		e.Kind = TwitchEventKind(tags["msg-id"])
+		e.User = tags["login"]
+		e.DisplayName = tags["display-name"]
+		e.Message = arg(line, 1)
+		e.SystemMessage = tags["system-msg"]
+		switch e.Kind {
+		case TwitchEventSub, TwitchEventResub, TwitchEventSubGift, TwitchEventMysterySubGift:
+			e.SubTier, e.Prime = subTier(tags["msg-param-sub-plan"])
+			e.Months = tagInt(tags, "msg-param-cumulative-months")
+			if e.Months == 0 {
+				e.Months = tagInt(tags, "msg-param-months")
+			}
+			e.StreakMonths = tagInt(tags, "msg-param-streak-months")
+			e.Recipient = tags["msg-param-recipient-user-name"]
+			e.GiftCount = tagInt(tags, "msg-param-mass-gift-count")
+		case TwitchEventRaid:
+			e.Viewers = tagInt(tags, "msg-param-viewerCount")
+		default:
+			e.Kind = TwitchEventUserNotice
+		}
+	case "CLEARCHAT":
+		e.User = arg(line, 1)
+		switch {
+		case e.User == "":
+			e.Kind = TwitchEventClearChat
+		case tags["ban-duration"] != "":
+			e.Kind = TwitchEventTimeout
+			e.BanDuration = tagInt(tags, "ban-duration")
+		default:
+			e.Kind = TwitchEventBan
+		}
+	case "CLEARMSG":
+		e.Kind = TwitchEventDeleteMessage
+		e.User = tags["login"]
+		e.Message = arg(line, 1)
+		e.TargetMessageID = tags["target-msg-id"]
+	case "ROOMSTATE":
+		e.Kind = TwitchEventRoomState
+		e.RoomState = &TwitchRoomState{
+			EmoteOnly:     tagBoolPtr(tags, "emote-only"),
+			FollowersOnly: tagIntPtr(tags, "followers-only"),
+			R9K:           tagBoolPtr(tags, "r9k"),
+			Slow:          tagIntPtr(tags, "slow"),
+			SubsOnly:      tagBoolPtr(tags, "subs-only"),
+		}
+	case "HOSTTARGET":
+		// the trailing argument is "<target> [<viewers>]" where a target
+		// of "-" means hosting has stopped
+		fields := strings.Fields(arg(line, 1))
+		if len(fields) == 0 {
+			return nil, false
+		}
+		e.Kind = TwitchEventHost
+		e.Target = fields[0]
+		if e.Target == "-" {
+			e.Kind = TwitchEventUnhost
+			e.Target = ""
+		}
+		if len(fields) > 1 {
+			e.Viewers, _ = strconv.Atoi(fields[1])
+		}
+	default:
+		return nil, false
+	}
+	return e, true
+}
+
+func arg(line *client.Line, i int) string {
+	if len(line.Args) <= i {
+		return ""
+	}
+	return line.Args[i]
+}
+
+// subTier converts a sub plan into its tier and whether it was a Prime sub.
+func subTier(plan string) (int, bool) {
+	switch plan {
+	case "Prime":
+		return 1, true
+	case "1000":
+		return 1, false
+	case "2000":
+		return 2, false
+	case "3000":
+		return 3, false
+	default:
+		return 0, false
+	}
+}
+
+func tagInt(tags map[string]string, key string) int {
+	i, _ := strconv.Atoi(tags[key])
+	return i
+}
+
+func tagIntPtr(tags map[string]string, key string) *int {
+	v, ok := tags[key]
+	if !ok {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return nil
+	}
+	return &i
+}
+
+func tagBoolPtr(tags map[string]string, key string) *bool {
+	i := tagIntPtr(tags, key)
+	if i == nil {
+		return nil
+	}
+	b := *i != 0
+	return &b
+}
@@ -0,0 +1,163 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/fluffle/goirc/client"
+)
+
+func TestParsingTwitchEvents(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	boolPtr := func(b bool) *bool { return &b }
+
+	cases := map[string]struct {
+		raw      string
+		expected TwitchEvent
+	}{
+		"resub": {
+			raw: `@badges=subscriber/6;display-name=Test_User;login=test_user;msg-id=resub;msg-param-cumulative-months=6;msg-param-streak-months=2;msg-param-sub-plan=2000;system-msg=Test_User\ssubscribed\sat\sTier\s2. :tmi.twitch.tv USERNOTICE #test-chan :great stream`,
+			expected: TwitchEvent{
+				Kind:          TwitchEventResub,
+				Channel:       "#test-chan",
+				User:          "test_user",
+				DisplayName:   "Test_User",
+				Message:       "great stream",
+				SystemMessage: "Test_User subscribed at Tier 2.",
+				SubTier:       2,
+				Months:        6,
+				StreakMonths:  2,
+			},
+		},
+		"prime sub": {
+			raw: `@login=test_user;msg-id=sub;msg-param-sub-plan=Prime :tmi.twitch.tv USERNOTICE #test-chan`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventSub,
+				Channel: "#test-chan",
+				User:    "test_user",
+				SubTier: 1,
+				Prime:   true,
+			},
+		},
+		"gift sub": {
+			raw: `@login=test_gifter;msg-id=subgift;msg-param-months=1;msg-param-recipient-user-name=test_recipient;msg-param-sub-plan=1000 :tmi.twitch.tv USERNOTICE #test-chan`,
+			expected: TwitchEvent{
+				Kind:      TwitchEventSubGift,
+				Channel:   "#test-chan",
+				User:      "test_gifter",
+				SubTier:   1,
+				Months:    1,
+				Recipient: "test_recipient",
+			},
+		},
+		"mystery gift": {
+			raw: `@login=test_gifter;msg-id=submysterygift;msg-param-mass-gift-count=5;msg-param-sub-plan=3000 :tmi.twitch.tv USERNOTICE #test-chan`,
+			expected: TwitchEvent{
+				Kind:      TwitchEventMysterySubGift,
+				Channel:   "#test-chan",
+				User:      "test_gifter",
+				SubTier:   3,
+				GiftCount: 5,
+			},
+		},
+		"raid": {
+			raw: `@login=test_raider;msg-id=raid;msg-param-viewerCount=42 :tmi.twitch.tv USERNOTICE #test-chan`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventRaid,
+				Channel: "#test-chan",
+				User:    "test_raider",
+				Viewers: 42,
+			},
+		},
+		"other usernotice": {
+			raw: `@login=test_user;msg-id=ritual :tmi.twitch.tv USERNOTICE #test-chan :HeyGuys`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventUserNotice,
+				Channel: "#test-chan",
+				User:    "test_user",
+				Message: "HeyGuys",
+			},
+		},
+		"timeout": {
+			raw: `@ban-duration=600 :tmi.twitch.tv CLEARCHAT #test-chan :test_user`,
+			expected: TwitchEvent{
+				Kind:        TwitchEventTimeout,
+				Channel:     "#test-chan",
+				User:        "test_user",
+				BanDuration: 600,
+			},
+		},
+		"ban": {
+			raw: `:tmi.twitch.tv CLEARCHAT #test-chan :test_user`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventBan,
+				Channel: "#test-chan",
+				User:    "test_user",
+			},
+		},
+		"clear chat": {
+			raw: `:tmi.twitch.tv CLEARCHAT #test-chan`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventClearChat,
+				Channel: "#test-chan",
+			},
+		},
+		"delete message": {
+			raw: `@login=test_user;target-msg-id=test-msg-id :tmi.twitch.tv CLEARMSG #test-chan :bad words`,
+			expected: TwitchEvent{
+				Kind:            TwitchEventDeleteMessage,
+				Channel:         "#test-chan",
+				User:            "test_user",
+				Message:         "bad words",
+				TargetMessageID: "test-msg-id",
+			},
+		},
+		"room state": {
+			raw: `@emote-only=0;followers-only=-1;slow=30 :tmi.twitch.tv ROOMSTATE #test-chan`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventRoomState,
+				Channel: "#test-chan",
+				RoomState: &TwitchRoomState{
+					EmoteOnly:     boolPtr(false),
+					FollowersOnly: intPtr(-1),
+					Slow:          intPtr(30),
+				},
+			},
+		},
+		"host": {
+			raw: `:tmi.twitch.tv HOSTTARGET #test-chan :other_chan 12`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventHost,
+				Channel: "#test-chan",
+				Target:  "other_chan",
+				Viewers: 12,
+			},
+		},
+		"unhost": {
+			raw: `:tmi.twitch.tv HOSTTARGET #test-chan :- 0`,
+			expected: TwitchEvent{
+				Kind:    TwitchEventUnhost,
+				Channel: "#test-chan",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			expect := expect.New(t)
+
+			line := client.ParseLine(c.raw)
+			e, ok := ParseTwitchEvent(line)
+			expect(ok).To.Be.True().Else.FailNow()
+			c.expected.Time = line.Time
+			expect(*e).To.Equal(c.expected)
+		})
+	}
+}
+
+func TestThatChatMessagesAreNotTwitchEvents(t *testing.T) {
+	expect := expect.New(t)
+
+	_, ok := ParseTwitchEvent(client.ParseLine(":test-user PRIVMSG #test-chan :test-message"))
+	expect(ok).To.Be.False()
+}
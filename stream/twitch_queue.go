@@ -0,0 +1,219 @@
+package stream
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on how many messages twitch allows to be sent. Exceeding them gets
+// the account muted.
+var (
+	twitchChatLimit        = 20
+	twitchModChatLimit     = 100
+	twitchChatWindow       = 30 * time.Second
+	twitchWhisperPerSecond = 3
+	twitchWhisperPerMinute = 100
+	// twitchMaxQueue is how many messages of each priority can be queued
+	// before the oldest are dropped.
+	twitchMaxQueue = 50
+)
+
+// tokenBucket allows n events per duration. Tokens are refilled
+// continuously and the bucket starts full.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	// rate is how many tokens are added per second.
+	rate float64
+	last time.Time
+}
+
+func newTokenBucket(n int, per time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(n),
+		tokens:   float64(n),
+		rate:     float64(n) / per.Seconds(),
+		last:     now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long until a token is available. It returns zero if one
+// is available now.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+// sendQueue queues messages sent to twitch so they are written out without
+// exceeding twitch's limits. Priority messages are always sent before
+// other messages. A user's queue outlives their connections so the limits
+// are not reset when reconnecting.
+type sendQueue struct {
+	user string
+
+	mu       sync.Mutex
+	priority []*TXTwitch
+	normal   []*TXTwitch
+	// mods are the channels the user is a moderator or broadcaster of.
+	mods          map[string]bool
+	chat          *tokenBucket
+	modChat       *tokenBucket
+	whisperSecond *tokenBucket
+	whisperMinute *tokenBucket
+
+	wake chan struct{}
+}
+
+func newSendQueue(user string) *sendQueue {
+	now := time.Now()
+	return &sendQueue{
+		user: user,
+		mods: map[string]bool{
+			"#" + user: true,
+		},
+		chat:          newTokenBucket(twitchChatLimit, twitchChatWindow, now),
+		modChat:       newTokenBucket(twitchModChatLimit, twitchChatWindow, now),
+		whisperSecond: newTokenBucket(twitchWhisperPerSecond, time.Second, now),
+		whisperMinute: newTokenBucket(twitchWhisperPerMinute, time.Minute, now),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// enqueue adds the message to the queue. Messages identical to one that is
+// already queued are coalesced into it. If the queue is full the oldest
+// message is dropped.
+func (q *sendQueue) enqueue(m *TXTwitch) {
+	q.mu.Lock()
+	queue := &q.normal
+	if m.Priority {
+		queue = &q.priority
+	}
+	for _, queued := range *queue {
+		if queued.To == m.To && queued.Message == m.Message {
+			q.mu.Unlock()
+			log.Printf("sendQueue.enqueue: coalesced duplicate message for user: %s", q.user)
+			return
+		}
+	}
+	if len(*queue) >= twitchMaxQueue {
+		log.Printf("sendQueue.enqueue: queue is full, dropping oldest message for user: %s", q.user)
+		*queue = (*queue)[1:]
+	}
+	*queue = append(*queue, m)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// setMod records whether the user is a moderator of the channel.
+func (q *sendQueue) setMod(channel string, mod bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.mods[channel] = mod || channel == "#"+q.user
+}
+
+// depth returns how many messages are waiting to be sent.
+func (q *sendQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.priority) + len(q.normal)
+}
+
+// run writes out queued messages as the limits allow until done is closed.
+// Messages that have not been written when done is closed remain queued.
+func (q *sendQueue) run(write func(to, msg string), done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		m, wait := q.next(time.Now())
+		if m != nil {
+			write(m.To, m.Message)
+			continue
+		}
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-timer:
+		case <-q.wake:
+		case <-done:
+			return
+		}
+	}
+}
+
+// next removes and returns the next message that can be sent now. Messages
+// that are held back by a limit do not block messages that are subject to
+// other limits, such as whispers. If no message can be sent it returns how
+// long until one can, or zero if the queue is empty.
+func (q *sendQueue) next(now time.Time) (*TXTwitch, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var minWait time.Duration
+	for _, queue := range []*[]*TXTwitch{&q.priority, &q.normal} {
+		for i, m := range *queue {
+			buckets := q.buckets(m)
+			var wait time.Duration
+			for _, b := range buckets {
+				if w := b.wait(now); w > wait {
+					wait = w
+				}
+			}
+			if wait == 0 {
+				for _, b := range buckets {
+					b.take(now)
+				}
+				*queue = append((*queue)[:i:i], (*queue)[i+1:]...)
+				return m, 0
+			}
+			if minWait == 0 || wait < minWait {
+				minWait = wait
+			}
+		}
+	}
+	return nil, minWait
+}
+
+// buckets returns the limits that apply to the message. Messages to
+// channels the user does not moderate count against both chat limits as
+// the higher limit applies to all messages the user sends.
+func (q *sendQueue) buckets(m *TXTwitch) []*tokenBucket {
+	switch {
+	case strings.HasPrefix(m.Message, "/w "):
+		return []*tokenBucket{q.whisperSecond, q.whisperMinute}
+	case q.mods[m.To]:
+		return []*tokenBucket{q.modChat}
+	default:
+		return []*tokenBucket{q.chat, q.modChat}
+	}
+}
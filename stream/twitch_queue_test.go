@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+)
+
+func TestTokenBucketRefillsOverTime(t *testing.T) {
+	expect := expect.New(t)
+	now := time.Now()
+	b := newTokenBucket(2, 10*time.Second, now)
+
+	expect(b.wait(now)).To.Equal(time.Duration(0))
+	b.take(now)
+	b.take(now)
+	expect(b.wait(now)).To.Equal(5 * time.Second)
+	expect(b.wait(now.Add(5 * time.Second))).To.Equal(time.Duration(0))
+}
+
+func TestSendQueuePrioritizesMessages(t *testing.T) {
+	expect := expect.New(t)
+	q := newSendQueue("test-bot")
+
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "bot-message"})
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "streamer-message", Priority: true})
+	expect(q.depth()).To.Equal(2)
+
+	m, _ := q.next(time.Now())
+	expect(m.Message).To.Equal("streamer-message")
+	m, _ = q.next(time.Now())
+	expect(m.Message).To.Equal("bot-message")
+	m, wait := q.next(time.Now())
+	expect(m).To.Be.Nil()
+	expect(wait).To.Equal(time.Duration(0))
+}
+
+func TestSendQueueCoalescesAndDropsWhenBackedUp(t *testing.T) {
+	expect := expect.New(t)
+	defer patchQueueLimits(20, 100, 2)()
+	q := newSendQueue("test-bot")
+
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "first"})
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "first"})
+	expect(q.depth()).To.Equal(1)
+
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "second"})
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "third"})
+	expect(q.depth()).To.Equal(2)
+
+	m, _ := q.next(time.Now())
+	expect(m.Message).To.Equal("second")
+}
+
+func TestSendQueueHonorsChatLimits(t *testing.T) {
+	expect := expect.New(t)
+	defer patchQueueLimits(1, 2, 50)()
+	q := newSendQueue("test-bot")
+	now := time.Now()
+
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "first"})
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "second"})
+
+	m, _ := q.next(now)
+	expect(m.Message).To.Equal("first")
+	m, wait := q.next(now)
+	expect(m).To.Be.Nil()
+	expect(wait > 0).To.Be.True()
+
+	q.setMod("#test-chan", true)
+	m, _ = q.next(now)
+	expect(m.Message).To.Equal("second")
+}
+
+func TestSendQueueLimitsWhispersSeparately(t *testing.T) {
+	expect := expect.New(t)
+	defer patchQueueLimits(1, 1, 50)()
+	q := newSendQueue("test-bot")
+	now := time.Now()
+
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "chat"})
+	m, _ := q.next(now)
+	expect(m.Message).To.Equal("chat")
+
+	q.enqueue(&TXTwitch{To: "#test-chan", Message: "more chat"})
+	q.enqueue(&TXTwitch{To: "#jtv", Message: "/w test-user hi"})
+	m, _ = q.next(now)
+	expect(m.Message).To.Equal("/w test-user hi")
+}
+
+func TestTheBroadcasterIsAlwaysAModerator(t *testing.T) {
+	expect := expect.New(t)
+	q := newSendQueue("test-streamer")
+
+	q.setMod("#test-streamer", false)
+
+	expect(q.mods["#test-streamer"]).To.Be.True()
+}
+
+func patchQueueLimits(chat, modChat, maxQueue int) func() {
+	oChat, oModChat, oMaxQueue := twitchChatLimit, twitchModChatLimit, twitchMaxQueue
+	twitchChatLimit, twitchModChatLimit, twitchMaxQueue = chat, modChat, maxQueue
+	return func() {
+		twitchChatLimit, twitchModChatLimit, twitchMaxQueue = oChat, oModChat, oMaxQueue
+	}
+}
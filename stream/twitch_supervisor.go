@@ -0,0 +1,391 @@
+package stream
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errTwitchDisconnected is reported when an established connection to twitch
+// is lost.
+var errTwitchDisconnected = errors.New("disconnected from twitch")
+
+// oauthPrefix is prepended to access tokens to use them as irc passwords.
+const oauthPrefix = "oauth:"
+
+var (
+	twitchMinBackoff  = time.Second
+	twitchMaxBackoff  = 2 * time.Minute
+	twitchMaxAttempts = 10
+)
+
+// TwitchState is the state of a connection to twitch.
+type TwitchState string
+
+const (
+	// TwitchConnecting is reported when a connection attempt starts.
+	TwitchConnecting TwitchState = "connecting"
+	// TwitchConnected is reported when the connection has been established
+	// and the channel has been joined.
+	TwitchConnected TwitchState = "connected"
+	// TwitchBackoff is reported when the connection was lost or could not be
+	// established and another attempt will be made after a delay.
+	TwitchBackoff TwitchState = "backoff"
+	// TwitchFailed is reported when no more attempts will be made to
+	// connect.
+	TwitchFailed TwitchState = "failed"
+)
+
+// TwitchConnState describes a change in the state of a connection to
+// twitch.
+type TwitchConnState struct {
+	Username string      `json:"username"`
+	State    TwitchState `json:"state"`
+	// Attempt is the number of consecutive attempts that have failed.
+	Attempt int `json:"attempt"`
+	// RetryAt is when the next attempt will be made while in backoff.
+	RetryAt time.Time `json:"retry_at"`
+	// Error describes why the connection was lost or failed.
+	Error string `json:"error"`
+}
+
+// TwitchStateTopic is the topic changes to the state of the user's
+// connection to twitch are published on.
+func TwitchStateTopic(username string) string {
+	return "twitch-state:" + username
+}
+
+// twitchSupervisor keeps a connection to twitch established for a user. It
+// reconnects with exponential backoff when the connection is lost and
+// publishes changes to the state of the connection. The channels the user
+// has joined are rejoined when reconnecting.
+type twitchSupervisor struct {
+	user string
+	pass string
+	// home is the channel the connection was made for.
+	home      string
+	d         chan dispatchMessage
+	twitch    TwitchUserIDFetcher
+	refresher TokenRefresher
+	// failed is called when the supervisor gives up on connecting.
+	failed func()
+	// q is written out through the current connection so twitch's limits
+	// carry over from one connection to the next.
+	q *sendQueue
+
+	mu       sync.Mutex
+	c        *twitchConn
+	channels []string
+	// id is the twitch user ID of the user. It is set once connected.
+	id      int
+	stopped bool
+
+	firstAttempt     chan struct{}
+	firstAttemptOnce sync.Once
+	stop             chan struct{}
+	done             chan struct{}
+}
+
+func newTwitchSupervisor(
+	user string,
+	pass string,
+	channel string,
+	d chan dispatchMessage,
+	twitch TwitchUserIDFetcher,
+	refresher TokenRefresher,
+	failed func(),
+) *twitchSupervisor {
+	return &twitchSupervisor{
+		user:         user,
+		pass:         pass,
+		home:         channel,
+		channels:     []string{channel},
+		d:            d,
+		twitch:       twitch,
+		refresher:    refresher,
+		failed:       failed,
+		q:            newSendQueue(user),
+		firstAttempt: make(chan struct{}),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// start spawns the goroutine that maintains the connection. It blocks until
+// the first attempt to connect has completed.
+func (s *twitchSupervisor) start() {
+	go s.run()
+	<-s.firstAttempt
+}
+
+func (s *twitchSupervisor) run() {
+	defer close(s.done)
+
+	var (
+		attempt   int
+		refreshed bool
+	)
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.publish(TwitchConnState{
+			State:   TwitchConnecting,
+			Attempt: attempt,
+		})
+		channels := s.joined()
+		c, err := connectTwitch(s.user, s.pass, channels, s.q, s.d, s.twitch)
+		if err == nil {
+			s.mu.Lock()
+			if s.stopped {
+				s.mu.Unlock()
+				s.attempted()
+				s.closeConn(c)
+				return
+			}
+			s.c = c
+			s.id = c.id
+			current := append([]string(nil), s.channels...)
+			s.mu.Unlock()
+			s.attempted()
+
+			// channels may have been joined or parted while connecting
+			for _, channel := range difference(current, channels) {
+				c.join(channel)
+			}
+			for _, channel := range difference(channels, current) {
+				c.part(channel)
+			}
+
+			attempt, refreshed = 0, false
+			s.publish(TwitchConnState{
+				State: TwitchConnected,
+			})
+
+			select {
+			case <-c.disconnected:
+			case <-s.stop:
+				return
+			}
+			s.mu.Lock()
+			stopped := s.stopped
+			s.c = nil
+			s.mu.Unlock()
+			if stopped {
+				return
+			}
+
+			if c.reconnectRequested() {
+				log.Printf("twitchSupervisor.run: reconnecting at the request of twitch for user: %s", s.user)
+				continue
+			}
+			err = errTwitchDisconnected
+		} else {
+			s.attempted()
+			attempt++
+		}
+
+		if err == ErrTwitchAuthFailed {
+			if s.refresher == nil || refreshed {
+				s.fail(attempt, err)
+				return
+			}
+			// the password is the access token with the prefix twitch
+			// requires for irc while the refresher deals in access tokens
+			token, rerr := s.refresher.Refresh(s.user, strings.TrimPrefix(s.pass, oauthPrefix))
+			if rerr != nil {
+				log.Printf("twitchSupervisor.run: unable to refresh token for user: %s: %s", s.user, rerr)
+				s.fail(attempt, err)
+				return
+			}
+			s.pass = oauthPrefix + token
+			refreshed = true
+			continue
+		}
+		if attempt >= twitchMaxAttempts {
+			s.fail(attempt, err)
+			return
+		}
+
+		delay := backoff(attempt)
+		log.Printf("twitchSupervisor.run: retrying connection in %s for user: %s: %s", delay, s.user, err)
+		s.publish(TwitchConnState{
+			State:   TwitchBackoff,
+			Attempt: attempt,
+			RetryAt: time.Now().Add(delay),
+			Error:   err.Error(),
+		})
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// attempted unblocks start once the first attempt to connect has completed.
+func (s *twitchSupervisor) attempted() {
+	s.firstAttemptOnce.Do(func() {
+		close(s.firstAttempt)
+	})
+}
+
+func (s *twitchSupervisor) fail(attempt int, err error) {
+	log.Printf("twitchSupervisor.run: giving up on connecting to twitch for user: %s: %s", s.user, err)
+	s.publish(TwitchConnState{
+		State:   TwitchFailed,
+		Attempt: attempt,
+		Error:   err.Error(),
+	})
+	if s.failed != nil {
+		s.failed()
+	}
+}
+
+func (s *twitchSupervisor) publish(state TwitchConnState) {
+	state.Username = s.user
+	select {
+	case s.d <- dispatchMessage{
+		topic: TwitchStateTopic(s.user),
+		msg: RXMessage{
+			Type: Twitch,
+			Twitch: &RXTwitch{
+				State: &state,
+			},
+		},
+	}:
+	default:
+		log.Println("twitchSupervisor.publish: unable to dispatch state")
+	}
+}
+
+// send queues the message. It is written out once connected if the user
+// is reconnecting.
+func (s *twitchSupervisor) send(m TXMessage) {
+	s.q.enqueue(m.Twitch)
+}
+
+// join adds the channel to the channels the user has joined. If connected
+// the channel is joined immediately, otherwise it is joined once connected.
+func (s *twitchSupervisor) join(channel string) {
+	s.mu.Lock()
+	for _, ch := range s.channels {
+		if ch == channel {
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.channels = append(s.channels, channel)
+	c := s.c
+	s.mu.Unlock()
+	if c != nil {
+		c.join(channel)
+	}
+}
+
+// part removes the channel from the channels the user has joined. It
+// returns false if the channel had not been joined.
+func (s *twitchSupervisor) part(channel string) bool {
+	s.mu.Lock()
+	remaining := difference(s.channels, []string{channel})
+	if len(remaining) == len(s.channels) {
+		s.mu.Unlock()
+		return false
+	}
+	s.channels = remaining
+	c := s.c
+	s.mu.Unlock()
+	if c != nil {
+		c.part(channel)
+	}
+	return true
+}
+
+// joined returns the channels the user has joined.
+func (s *twitchSupervisor) joined() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.channels...)
+}
+
+// userID returns the twitch user ID of the user. It returns false if the
+// user has not connected yet.
+func (s *twitchSupervisor) userID() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id, s.id != 0
+}
+
+// queueDepth returns how many messages are waiting to be sent.
+func (s *twitchSupervisor) queueDepth() int {
+	return s.q.depth()
+}
+
+// close stops reconnecting and closes the current connection.
+func (s *twitchSupervisor) close() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	c := s.c
+	s.c = nil
+	close(s.stop)
+	s.mu.Unlock()
+
+	if c != nil {
+		s.closeConn(c)
+	}
+	<-s.done
+	return nil
+}
+
+func (s *twitchSupervisor) closeConn(c *twitchConn) {
+	err := c.close()
+	if err != nil {
+		log.Printf("twitchSupervisor.closeConn: error occurred while disconnecting user: %s error: %s", s.user, err)
+	}
+}
+
+// difference returns the channels in a that are not in b.
+func difference(a, b []string) []string {
+	var diff []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, x)
+		}
+	}
+	return diff
+}
+
+// backoff returns how long to wait before the given attempt. The delay
+// doubles with each attempt up to twitchMaxBackoff and is jittered so that
+// many users reconnecting at once are spread out.
+func backoff(attempt int) time.Duration {
+	d := twitchMinBackoff
+	for i := 1; i < attempt && d < twitchMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > twitchMaxBackoff {
+		d = twitchMaxBackoff
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
@@ -0,0 +1,312 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+)
+
+func TestSupervisorReconnectsWhenTheConnectionIsLost(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	defer patchBackoff(time.Millisecond, 10*time.Millisecond, 10)()
+	d := make(chan dispatchMessage, 100)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	s := newTwitchSupervisor("test-user", "test-pass", "#test-chan", d, twitch, nil, nil)
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		s.start()
+	}()
+
+	serverConn, _ := acceptConn(server)
+	<-started
+	serverConn.close()
+
+	_, cleanup := acceptConn(server)
+	expect(readStates(t, d, 5)).To.Equal([]TwitchState{
+		TwitchConnecting,
+		TwitchConnected,
+		TwitchBackoff,
+		TwitchConnecting,
+		TwitchConnected,
+	})
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.close()
+	}()
+	cleanup()
+	<-closed
+}
+
+func TestSupervisorReconnectsImmediatelyWhenTwitchRequestsIt(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	defer patchBackoff(time.Hour, time.Hour, 10)()
+	d := make(chan dispatchMessage, 100)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	s := newTwitchSupervisor("test-user", "test-pass", "#test-chan", d, twitch, nil, nil)
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		s.start()
+	}()
+
+	serverConn, _ := acceptConn(server)
+	<-started
+	serverConn.send(":tmi.twitch.tv RECONNECT")
+
+	_, cleanup := acceptConn(server)
+	serverConn.close()
+	expect(readStates(t, d, 4)).To.Equal([]TwitchState{
+		TwitchConnecting,
+		TwitchConnected,
+		TwitchConnecting,
+		TwitchConnected,
+	})
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.close()
+	}()
+	cleanup()
+	<-closed
+}
+
+func TestSupervisorKeepsSendLimitsWhenReconnecting(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	defer patchBackoff(time.Millisecond, 10*time.Millisecond, 10)()
+	defer patchQueueLimits(1, 1, 50)()
+	d := make(chan dispatchMessage, 100)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	s := newTwitchSupervisor("test-user", "test-pass", "#test-chan", d, twitch, nil, nil)
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		s.start()
+	}()
+
+	serverConn, _ := acceptConn(server)
+	<-started
+	s.send(TXMessage{
+		Type: Twitch,
+		Twitch: &TXTwitch{
+			To:      "#test-chan",
+			Message: "first",
+		},
+	})
+	expect(serverConn.receive("PRIVMSG")).To.Equal("PRIVMSG #test-chan :first")
+	serverConn.close()
+
+	_, cleanup := acceptConn(server)
+	readStates(t, d, 5)
+	s.send(TXMessage{
+		Type: Twitch,
+		Twitch: &TXTwitch{
+			To:      "#test-chan",
+			Message: "second",
+		},
+	})
+
+	// the chat limit allows one message per window so the second message
+	// waits even though it is sent on a new connection
+	time.Sleep(50 * time.Millisecond)
+	expect(s.queueDepth()).To.Equal(1)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.close()
+	}()
+	cleanup()
+	<-closed
+}
+
+func TestSupervisorRejoinsChannelsWhenReconnecting(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	defer patchBackoff(time.Millisecond, 10*time.Millisecond, 10)()
+	d := make(chan dispatchMessage, 100)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	s := newTwitchSupervisor("test-user", "test-pass", "#test-chan", d, twitch, nil, nil)
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		s.start()
+	}()
+
+	serverConn, _ := acceptConn(server)
+	<-started
+	serverConn.receive("CAP")
+
+	s.join("#other-chan")
+	s.join("#other-chan")
+	expect(serverConn.receive("JOIN")).To.Equal("JOIN #other-chan")
+	expect(s.part("#test-chan")).To.Be.True()
+	expect(serverConn.receive("PART")).To.Equal("PART #test-chan")
+	expect(s.part("#test-chan")).To.Be.False()
+	expect(s.joined()).To.Equal([]string{"#other-chan"})
+	serverConn.close()
+
+	serverConn = server.accept()
+	serverConn.receive("PASS")
+	serverConn.receive("NICK")
+	serverConn.receive("USER")
+	serverConn.send(":127.0.0.1 001 test-user :GLHF!")
+	join := serverConn.receive("JOIN")
+	expect(strings.HasPrefix(join, "JOIN #other-chan")).To.Be.True()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.close()
+	}()
+	serverConn.receive("QUIT")
+	serverConn.close()
+	<-closed
+}
+
+func TestSupervisorReconnectsWithARefreshedToken(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	defer patchBackoff(time.Hour, time.Hour, 10)()
+	d := make(chan dispatchMessage, 100)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+	refresher := newMockTokenRefresher()
+	refresher.RefreshOutput.NewToken <- "new-token"
+	refresher.RefreshOutput.Err <- nil
+
+	s := newTwitchSupervisor("test-user", "oauth:old-token", "#test-chan", d, twitch, refresher, nil)
+	go s.start()
+
+	serverConn := server.accept()
+	expect(serverConn.receive("PASS")).To.Equal("PASS oauth:old-token")
+	serverConn.receive("NICK")
+	serverConn.receive("USER")
+	serverConn.send(":tmi.twitch.tv NOTICE * :Login authentication failed")
+
+	expect(<-refresher.RefreshInput.TwitchUsername).To.Equal("test-user")
+	expect(<-refresher.RefreshInput.Token).To.Equal("old-token")
+	serverConn.close()
+
+	serverConn = server.accept()
+	expect(serverConn.receive("PASS")).To.Equal("PASS oauth:new-token")
+	serverConn.receive("NICK")
+	serverConn.receive("USER")
+	serverConn.send(":127.0.0.1 001 test-user :GLHF!")
+	serverConn.receive("JOIN")
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.close()
+	}()
+	serverConn.receive("QUIT")
+	serverConn.close()
+	<-closed
+}
+
+func TestSupervisorGivesUpAfterTooManyAttempts(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer patchTwitch(server.port())()
+	defer patchBackoff(time.Millisecond, time.Millisecond, 2)()
+	server.close()
+	d := make(chan dispatchMessage, 100)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	failed := make(chan struct{})
+	s := newTwitchSupervisor("test-user", "test-pass", "#test-chan", d, twitch, nil, func() {
+		close(failed)
+	})
+	s.start()
+
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not give up")
+	}
+	expect(readStates(t, d, 4)).To.Equal([]TwitchState{
+		TwitchConnecting,
+		TwitchBackoff,
+		TwitchConnecting,
+		TwitchFailed,
+	})
+	expect(s.close()).To.Be.Nil()
+}
+
+func TestBackoffGrowsUpToTheMaximum(t *testing.T) {
+	expect := expect.New(t)
+	defer patchBackoff(time.Second, 8*time.Second, 10)()
+
+	for attempt, max := range []time.Duration{
+		time.Second,
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		8 * time.Second,
+	} {
+		d := backoff(attempt)
+		expect(d >= max/2).To.Be.True()
+		expect(d < max).To.Be.True()
+	}
+}
+
+func patchBackoff(min, max time.Duration, attempts int) func() {
+	oMin, oMax, oAttempts := twitchMinBackoff, twitchMaxBackoff, twitchMaxAttempts
+	twitchMinBackoff, twitchMaxBackoff, twitchMaxAttempts = min, max, attempts
+	return func() {
+		twitchMinBackoff, twitchMaxBackoff, twitchMaxAttempts = oMin, oMax, oAttempts
+	}
+}
+
+// readStates reads n connection states that were dispatched, skipping any
+// other messages.
+func readStates(t *testing.T, d chan dispatchMessage, n int) []TwitchState {
+	var states []TwitchState
+	for len(states) < n {
+		select {
+		case dm := <-d:
+			if dm.topic != TwitchStateTopic("test-user") {
+				continue
+			}
+			states = append(states, dm.msg.Twitch.State.State)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only read states: %v", states)
+		}
+	}
+	return states
+}
@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TwitchTags is a typed representation of the IRCv3 tags Twitch attaches to
+// chat messages.
+type TwitchTags struct {
+	// ID is the ID of the message. It is used to delete or reply to it.
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	// Color is the hex color of the chatter's name, it is empty if they
+	// have not set one.
+	Color  string        `json:"color"`
+	Badges []TwitchBadge `json:"badges"`
+	Emotes []TwitchEmote `json:"emotes"`
+	// Bits is the amount of bits cheered in the message.
+	Bits       int  `json:"bits"`
+	Mod        bool `json:"mod"`
+	Subscriber bool `json:"subscriber"`
+	// FirstMessage is true if this is the first message the chatter has
+	// sent in the channel.
+	FirstMessage bool `json:"first_message"`
+	// ReplyParent is set when the message is a reply to another message.
+	ReplyParent *TwitchReplyParent `json:"reply_parent"`
+	// SentAt is when Twitch received the message.
+	SentAt time.Time `json:"sent_at"`
+}
+
+// TwitchBadge is a badge displayed next to the chatter's name.
+type TwitchBadge struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// TwitchEmote is the position of an emote in a message. Start and end are
+// inclusive character indexes.
+type TwitchEmote struct {
+	ID    string `json:"id"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// TwitchReplyParent describes the message that was replied to.
+type TwitchReplyParent struct {
+	MessageID   string `json:"message_id"`
+	UserID      string `json:"user_id"`
+	UserLogin   string `json:"user_login"`
+	DisplayName string `json:"display_name"`
+	Body        string `json:"body"`
+}
+
+// ParseTwitchTags converts the raw tags of an IRC line into TwitchTags.
+// Tags that are missing or malformed are left as their zero value.
+func ParseTwitchTags(tags map[string]string) TwitchTags {
+	t := TwitchTags{
+		ID:           tags["id"],
+		UserID:       tags["user-id"],
+		DisplayName:  tags["display-name"],
+		Color:        tags["color"],
+		Badges:       parseBadges(tags["badges"]),
+		Emotes:       parseEmotes(tags["emotes"]),
+		Bits:         tagInt(tags, "bits"),
+		Mod:          tags["mod"] == "1",
+		Subscriber:   tags["subscriber"] == "1",
+		FirstMessage: tags["first-msg"] == "1",
+	}
+	if id := tags["reply-parent-msg-id"]; id != "" {
+		t.ReplyParent = &TwitchReplyParent{
+			MessageID:   id,
+			UserID:      tags["reply-parent-user-id"],
+			UserLogin:   tags["reply-parent-user-login"],
+			DisplayName: tags["reply-parent-display-name"],
+			Body:        tags["reply-parent-msg-body"],
+		}
+	}
+	if ms, err := strconv.ParseInt(tags["tmi-sent-ts"], 10, 64); err == nil {
+		t.SentAt = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	}
+	return t
+}
+
+// HasBadge returns true if the chatter has a badge with the given name.
+func (t TwitchTags) HasBadge(name string) bool {
+	for _, b := range t.Badges {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBadges parses the badges tag. The tag has the form
+// name/version,name/version.
+func parseBadges(tag string) []TwitchBadge {
+	var badges []TwitchBadge
+	for _, badge := range strings.Split(tag, ",") {
+		parts := strings.SplitN(badge, "/", 2)
+		if parts[0] == "" {
+			continue
+		}
+		b := TwitchBadge{Name: parts[0]}
+		if len(parts) == 2 {
+			b.Version = parts[1]
+		}
+		badges = append(badges, b)
+	}
+	return badges
+}
+
+// parseEmotes parses the emotes tag. The tag has the form
+// id:start-end,start-end/id:start-end.
+func parseEmotes(tag string) []TwitchEmote {
+	var emotes []TwitchEmote
+	for _, emote := range strings.Split(tag, "/") {
+		parts := strings.SplitN(emote, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, pos := range strings.Split(parts[1], ",") {
+			bounds := strings.SplitN(pos, "-", 2)
+			if len(bounds) != 2 {
+				continue
+			}
+			start, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				continue
+			}
+			end, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				continue
+			}
+			emotes = append(emotes, TwitchEmote{
+				ID:    parts[0],
+				Start: start,
+				End:   end,
+			})
+		}
+	}
+	return emotes
+}
@@ -0,0 +1,112 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a8m/expect"
+	"github.com/fluffle/goirc/client"
+)
+
+func TestParsingTwitchTags(t *testing.T) {
+	cases := map[string]struct {
+		raw      string
+		expected TwitchTags
+	}{
+		"plain message": {
+			raw: `@badge-info=;badges=;color=;display-name=test_user;emotes=;first-msg=0;id=b34ccfc7-4977-403a-8a94-33c6bac34fb8;mod=0;subscriber=0;tmi-sent-ts=1507246572675;user-id=1337 :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :hello`,
+			expected: TwitchTags{
+				ID:          "b34ccfc7-4977-403a-8a94-33c6bac34fb8",
+				UserID:      "1337",
+				DisplayName: "test_user",
+				SentAt:      time.Unix(1507246572, 675000000).UTC(),
+			},
+		},
+		"badges with versions": {
+			raw: `@badges=broadcaster/1,subscriber/12,glhf-pledge/1;color=#0D4200;display-name=Test_User;mod=0;subscriber=1 :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test_user :hello`,
+			expected: TwitchTags{
+				DisplayName: "Test_User",
+				Color:       "#0D4200",
+				Badges: []TwitchBadge{
+					{Name: "broadcaster", Version: "1"},
+					{Name: "subscriber", Version: "12"},
+					{Name: "glhf-pledge", Version: "1"},
+				},
+				Subscriber: true,
+			},
+		},
+		"moderator": {
+			raw: `@badges=moderator/1;mod=1 :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :hello`,
+			expected: TwitchTags{
+				Badges: []TwitchBadge{
+					{Name: "moderator", Version: "1"},
+				},
+				Mod: true,
+			},
+		},
+		"emotes": {
+			raw: `@emotes=25:0-4,12-16/1902:6-10 :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :Kappa Keepo Kappa`,
+			expected: TwitchTags{
+				Emotes: []TwitchEmote{
+					{ID: "25", Start: 0, End: 4},
+					{ID: "25", Start: 12, End: 16},
+					{ID: "1902", Start: 6, End: 10},
+				},
+			},
+		},
+		"malformed emotes": {
+			raw:      `@emotes=25:0-x,2/1902 :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :Kappa`,
+			expected: TwitchTags{},
+		},
+		"bits": {
+			raw: `@bits=100;id=test-msg-id :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :cheer100`,
+			expected: TwitchTags{
+				ID:   "test-msg-id",
+				Bits: 100,
+			},
+		},
+		"first message": {
+			raw: `@first-msg=1 :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :hi all`,
+			expected: TwitchTags{
+				FirstMessage: true,
+			},
+		},
+		"reply": {
+			raw: `@reply-parent-display-name=Other_User;reply-parent-msg-body=how\sare\syou?;reply-parent-msg-id=test-parent-id;reply-parent-user-id=4242;reply-parent-user-login=other_user :test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :@Other_User good`,
+			expected: TwitchTags{
+				ReplyParent: &TwitchReplyParent{
+					MessageID:   "test-parent-id",
+					UserID:      "4242",
+					UserLogin:   "other_user",
+					DisplayName: "Other_User",
+					Body:        "how are you?",
+				},
+			},
+		},
+		"no tags": {
+			raw:      `:test_user!test_user@test_user.tmi.twitch.tv PRIVMSG #test-chan :hello`,
+			expected: TwitchTags{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			expect := expect.New(t)
+
+			line := client.ParseLine(c.raw)
+			expect(ParseTwitchTags(line.Tags)).To.Equal(c.expected)
+		})
+	}
+}
+
+func TestTwitchTagsHasBadge(t *testing.T) {
+	expect := expect.New(t)
+
+	tags := ParseTwitchTags(map[string]string{
+		"badges": "vip/1,subscriber/3",
+	})
+
+	expect(tags.HasBadge("vip")).To.Be.True()
+	expect(tags.HasBadge("subscriber")).To.Be.True()
+	expect(tags.HasBadge("moderator")).To.Be.False()
+}
@@ -3,6 +3,7 @@ package stream
 import (
 	"log"
 	"testing"
+	"time"
 
 	"github.com/a8m/expect"
 )
@@ -20,7 +21,7 @@ func TestConnectingOverTLS(t *testing.T) {
 	clientDone := make(chan struct{})
 	go func() {
 		defer close(clientDone)
-		clientConn, err := connectTwitch("test-user", "test-pass", "#test-chan", d, twitch)
+		clientConn, err := connectTwitch("test-user", "test-pass", []string{"#test-chan"}, newSendQueue("test-user"), d, twitch)
 		defer func() {
 			err := clientConn.close()
 			if err != nil {
@@ -69,7 +70,7 @@ func TestDispatchingMessages(t *testing.T) {
 	go func() {
 		defer close(clientDone)
 		// racey
-		clientConn, err := connectTwitch("test-user", "test-pass", "#test-chan", d, twitch)
+		clientConn, err := connectTwitch("test-user", "test-pass", []string{"#test-chan"}, newSendQueue("test-user"), d, twitch)
 		if err != nil {
 			log.Panic("unable to connect to twitch")
 		}
@@ -87,9 +88,10 @@ func TestDispatchingMessages(t *testing.T) {
 	dispatchMsg := <-d
 	topic := dispatchMsg.topic
 	msg := dispatchMsg.msg
-	expect(topic).To.Equal("twitch:test-user")
+	expect(topic).To.Equal("twitch:12345:#test-chan:chat")
 	expect(msg.Type).To.Equal(Twitch)
 	expect(msg.Twitch.OwnerID).To.Equal(12345)
+	expect(msg.Twitch.Channel).To.Equal("#test-chan")
 	expect(msg.Twitch.Line.Raw).To.Equal("PRIVMSG #test-chan :test-message")
 
 	cleanup()
@@ -110,7 +112,7 @@ func TestSendingMessages(t *testing.T) {
 	clientDone := make(chan struct{})
 	go func() {
 		defer close(clientDone)
-		clientConn, err := connectTwitch("test-user", "test-pass", "#test-chan", d, twitch)
+		clientConn, err := connectTwitch("test-user", "test-pass", []string{"#test-chan"}, newSendQueue("test-user"), d, twitch)
 		defer func() {
 			err := clientConn.close()
 			if err != nil {
@@ -149,10 +151,81 @@ func TestConnectingToUnresponsiveServer(t *testing.T) {
 	close(twitch.UserIDOutput.Err)
 	server.close()
 
-	_, err := connectTwitch("test-user", "test-pass", "#test-chan", d, twitch)
+	_, err := connectTwitch("test-user", "test-pass", []string{"#test-chan"}, newSendQueue("test-user"), d, twitch)
 	expect(err).Not.To.Be.Nil()
 }
 
+func TestConnectingWithAnExpiredToken(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	d := make(chan dispatchMessage)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	errs := make(chan error)
+	go func() {
+		_, err := connectTwitch("test-user", "test-pass", []string{"#test-chan"}, newSendQueue("test-user"), d, twitch)
+		errs <- err
+	}()
+
+	serverConn := server.accept()
+	serverConn.receive("PASS")
+	serverConn.receive("NICK")
+	serverConn.receive("USER")
+	serverConn.send(":tmi.twitch.tv NOTICE * :Login authentication failed")
+
+	expect(<-errs).To.Equal(ErrTwitchAuthFailed)
+	serverConn.close()
+}
+
+func TestClosingWhenTwitchDoesNotAcknowledgeQuit(t *testing.T) {
+	expect := expect.New(t)
+	server := newFakeIRCServer(t)
+	defer server.close()
+	defer patchTwitch(server.port())()
+	defer patchCloseTimeout(10 * time.Millisecond)()
+	d := make(chan dispatchMessage)
+	twitch := newMockTwitchUserIDFetcher()
+	close(twitch.UserIDOutput.UserID)
+	close(twitch.UserIDOutput.Err)
+
+	conns := make(chan *twitchConn)
+	go func() {
+		c, err := connectTwitch("test-user", "test-pass", []string{"#test-chan"}, newSendQueue("test-user"), d, twitch)
+		if err != nil {
+			log.Panic("unable to connect to twitch")
+		}
+		conns <- c
+	}()
+	serverConn, _ := acceptConn(server)
+	defer serverConn.close()
+	c := <-conns
+
+	errs := make(chan error)
+	go func() {
+		errs <- c.close()
+	}()
+	serverConn.receive("QUIT")
+
+	select {
+	case err := <-errs:
+		expect(err).To.Be.Nil()
+	case <-time.After(time.Second):
+		t.Fatal("close did not return when twitch ignored the QUIT")
+	}
+}
+
+func patchCloseTimeout(d time.Duration) func() {
+	orig := twitchCloseTimeout
+	twitchCloseTimeout = d
+	return func() {
+		twitchCloseTimeout = orig
+	}
+}
+
 func patchTwitch(port int) func() {
 	oHost, oPort := twitchHost, twitchPort
 	oSkip, oFlood := insecureSkipVerify, flood
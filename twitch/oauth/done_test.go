@@ -0,0 +1,37 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+func TestThatCallbacksForBadNoncesAreDiscarded(t *testing.T) {
+	expect := expect.New(t)
+
+	h := NewDoneHandler("", "", "", &fakeNonceStore{}, nil)
+	var called bool
+	h.RegisterCompletionCallback("expired-nonce", func() {
+		called = true
+	})
+
+	req := httptest.NewRequest("GET", "/v1/twitch_oauth/done?state=expired-nonce&code=test-code", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expect(rec.Code).To.Equal(http.StatusBadRequest)
+	expect(called).To.Be.False()
+}
+
+type fakeNonceStore struct{}
+
+func (fakeNonceStore) OauthNonceExists(nonce string) (bool, error) {
+	return false, nil
+}
+
+func (fakeNonceStore) FinishOauthNonce(nonce, twitchUsername string, twitchUserID int, od store.OauthData) error {
+	return store.ErrUnknownNonce
+}
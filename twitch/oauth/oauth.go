@@ -4,38 +4,15 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
-	"strings"
-	"sync"
 	"time"
 
+	"github.com/jasonkeene/anubot-server/internal/oauthflow"
 	"github.com/jasonkeene/anubot-server/store"
 	"github.com/jasonkeene/anubot-server/twitch"
 )
 
-const oauthResponse = `
-<!doctype html>
-<html class="no-js" lang="">
-<head>
-<style type="text/css">
-body {
-	text-align: center;
-	line-height: 100vh;
-	font-family: Arial, Helvetica, sans-serif;
-	font-size: 14px;
-}
-</style>
-</head>
-<body>
-Done authenticating with Twitch.
-</body>
-</html>
-`
-
 // NonceStore is used to to store and operate on oauth nonces.
 type NonceStore interface {
 	OauthNonceExists(nonce string) (exists bool, err error)
@@ -75,13 +52,24 @@ func parseOauthData(data []byte) (store.OauthData, error) {
 
 // DoneHandler is where the redirect URI hits to finsih the Oauth flow.
 type DoneHandler struct {
-	twitchOauthClientID     string
-	twitchOauthClientSecret string
-	twitchOauthRedirectURI  string
-	ns                      NonceStore
-	twitch                  *twitch.API
-	mu                      sync.Mutex
-	callbacks               map[string]func()
+	*oauthflow.DoneHandler
+}
+
+// doneConfig is what a DoneHandler is created with.
+type doneConfig struct {
+	callbackTTL time.Duration
+}
+
+// DoneHandlerOption is used to configure a DoneHandler.
+type DoneHandlerOption func(*doneConfig)
+
+// WithCallbackTTL allows you to override how long completion callbacks are
+// kept for oauth flows that never complete. This should match the nonce TTL
+// of the store.
+func WithCallbackTTL(ttl time.Duration) DoneHandlerOption {
+	return func(c *doneConfig) {
+		c.callbackTTL = ttl
+	}
 }
 
 // NewDoneHandler creates a new handler to finish the oauth flow.
@@ -91,125 +79,66 @@ func NewDoneHandler(
 	twitchOauthRedirectURI string,
 	ns NonceStore,
 	twitch *twitch.API,
+	opts ...DoneHandlerOption,
 ) *DoneHandler {
+	cfg := doneConfig{
+		callbackTTL: store.DefaultNonceTTL,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	return &DoneHandler{
-		twitchOauthClientID:     twitchOauthClientID,
-		twitchOauthClientSecret: twitchOauthClientSecret,
-		twitchOauthRedirectURI:  twitchOauthRedirectURI,
-		ns:        ns,
-		twitch:    twitch,
-		callbacks: make(map[string]func()),
+		DoneHandler: oauthflow.NewDoneHandler(
+			"twitch",
+			tokenURL,
+			"Done authenticating with Twitch.",
+			flow{
+				twitchOauthClientID:     twitchOauthClientID,
+				twitchOauthClientSecret: twitchOauthClientSecret,
+				twitchOauthRedirectURI:  twitchOauthRedirectURI,
+				ns:                      ns,
+				twitch:                  twitch,
+			},
+			cfg.callbackTTL,
+		),
 	}
 }
 
-// RegisterCompletionCallback allows you to register a callback that is
-// invoked when the oauth flow is complete.
-func (h *DoneHandler) RegisterCompletionCallback(nonce string, f func()) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.callbacks[nonce] = f
+// flow exchanges codes for twitch access tokens and stores them for the
+// twitch user they belong to.
+type flow struct {
+	twitchOauthClientID     string
+	twitchOauthClientSecret string
+	twitchOauthRedirectURI  string
+	ns                      NonceStore
+	twitch                  *twitch.API
 }
 
-// ServeHTTP handles the response from Twitch after authentication has
-// happened.
-func (h *DoneHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	values := r.URL.Query()
-
-	// validate nonce
-	nonce := values.Get("state")
-	ok, err := h.ns.OauthNonceExists(nonce)
-	if !ok || err != nil {
-		log.Print("bad nonce")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// validate code
-	code := values.Get("code")
-	if code == "" {
-		log.Print("code not set in oauth response")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// create request to send to twitch
-	req, err := http.NewRequest("POST", tokenURL, h.createPayload(nonce, code))
-	if err != nil {
-		log.Printf("unable to create request for posting to twitch oauth for token: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// make request to twitch
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("error in response from post to twitch oauth for token: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// validate response code
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("got %d response code from post to twitch oauth for token", resp.StatusCode)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// read response body
-	defer func() {
-		err := resp.Body.Close()
-		if err != nil {
-			log.Printf("DoneHandler got err in closing resp body: %s", err)
-		}
-	}()
-	d, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("unable to read response body from post to twitch oauth for token: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// parse out the oauth data
-	od, err := parseOauthData(d)
-	if err != nil {
-		log.Printf("unable to parse response from post to twitch oauth for token: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// send oauth data to the store
-	user, err := h.twitch.User(od.AccessToken)
-	if err != nil {
-		log.Printf("unable to get user data from access token: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	err = h.ns.FinishOauthNonce(nonce, user.Name, user.ID, od)
-	if err != nil {
-		log.Printf("unable finish oauth: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// call any callbacks
-	cb, ok := h.callbacks[nonce]
-	if ok {
-		cb()
-	}
-
-	// write out response
-	fmt.Fprintf(w, oauthResponse)
+func (f flow) NonceExists(nonce string) (bool, error) {
+	return f.ns.OauthNonceExists(nonce)
 }
 
-func (h *DoneHandler) createPayload(nonce, code string) io.Reader {
+func (f flow) Payload(nonce, code string) url.Values {
 	payload := url.Values{}
-	payload.Set("client_id", h.twitchOauthClientID)
-	payload.Set("client_secret", h.twitchOauthClientSecret)
-	payload.Set("redirect_uri", h.twitchOauthRedirectURI)
+	payload.Set("client_id", f.twitchOauthClientID)
+	payload.Set("client_secret", f.twitchOauthClientSecret)
+	payload.Set("redirect_uri", f.twitchOauthRedirectURI)
 	payload.Set("grant_type", "authorization_code")
 	payload.Set("code", code)
 	payload.Set("state", nonce)
-	return strings.NewReader(payload.Encode())
+	return payload
+}
+
+func (f flow) Finish(nonce string, token []byte) error {
+	od, err := parseOauthData(token)
+	if err != nil {
+		return err
+	}
+	user, err := f.twitch.User(od.AccessToken)
+	if err != nil {
+		return fmt.Errorf("unable to get user data from access token: %s", err)
+	}
+	return f.ns.FinishOauthNonce(nonce, user.Name, user.ID, od)
 }
 
 // GenerateNonce generates a random nonce to be used in the oauth flow.
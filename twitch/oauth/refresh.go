@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jasonkeene/anubot-server/store"
+)
+
+// TokenStore is used to read and persist oauth data for twitch users.
+type TokenStore interface {
+	TwitchOauthData(twitchUsername string) (od store.OauthData, err error)
+	UpdateTwitchOauthData(twitchUsername string, od store.OauthData) (err error)
+}
+
+// Refresher exchanges refresh tokens for new access tokens when they have
+// expired.
+type Refresher struct {
+	twitchOauthClientID     string
+	twitchOauthClientSecret string
+	tokenURL                string
+	store                   TokenStore
+
+	// mu serializes refreshes so concurrent auth failures for the same user
+	// only result in a single exchange
+	mu sync.Mutex
+}
+
+// RefresherOption is used to configure a Refresher.
+type RefresherOption func(*Refresher)
+
+// WithTokenURL allows you to override the default token endpoint.
+func WithTokenURL(u string) RefresherOption {
+	return func(r *Refresher) {
+		r.tokenURL = u
+	}
+}
+
+// NewRefresher creates a new Refresher.
+func NewRefresher(
+	twitchOauthClientID,
+	twitchOauthClientSecret string,
+	store TokenStore,
+	opts ...RefresherOption,
+) *Refresher {
+	r := &Refresher{
+		twitchOauthClientID:     twitchOauthClientID,
+		twitchOauthClientSecret: twitchOauthClientSecret,
+		tokenURL:                tokenURL,
+		store:                   store,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Refresh gets a new access token for the twitch user. The token is the
+// access token that failed. If it has already been replaced the current
+// access token is returned without doing another exchange.
+func (r *Refresher) Refresh(twitchUsername, token string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	od, err := r.store.TwitchOauthData(twitchUsername)
+	if err != nil {
+		return "", err
+	}
+	if od.AccessToken != "" && od.AccessToken != token {
+		return od.AccessToken, nil
+	}
+	if od.RefreshToken == "" {
+		return "", errors.New("no refresh token for twitch user: " + twitchUsername)
+	}
+
+	newOD, err := r.exchange(od.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	// twitch may not rotate the refresh token
+	if newOD.RefreshToken == "" {
+		newOD.RefreshToken = od.RefreshToken
+	}
+	if newOD.Scope == nil {
+		newOD.Scope = od.Scope
+	}
+
+	err = r.store.UpdateTwitchOauthData(twitchUsername, newOD)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("refreshed oauth token for twitch user: %s", twitchUsername)
+	return newOD.AccessToken, nil
+}
+
+func (r *Refresher) exchange(refreshToken string) (store.OauthData, error) {
+	payload := url.Values{}
+	payload.Set("client_id", r.twitchOauthClientID)
+	payload.Set("client_secret", r.twitchOauthClientSecret)
+	payload.Set("grant_type", "refresh_token")
+	payload.Set("refresh_token", refreshToken)
+
+	req, err := http.NewRequest("POST", r.tokenURL, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return store.OauthData{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return store.OauthData{}, err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("Refresher got err in closing resp body: %s", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return store.OauthData{}, fmt.Errorf("got %d response code from post to twitch oauth for refresh", resp.StatusCode)
+	}
+
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return store.OauthData{}, err
+	}
+	od, err := parseOauthData(d)
+	if err != nil {
+		return store.OauthData{}, err
+	}
+	if od.AccessToken == "" {
+		return store.OauthData{}, errors.New("empty access token in refresh response from twitch")
+	}
+	return od, nil
+}
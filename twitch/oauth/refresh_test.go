@@ -0,0 +1,120 @@
+package oauth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/store"
+	"github.com/jasonkeene/anubot-server/twitch/oauth"
+)
+
+func TestRefreshingAnAccessToken(t *testing.T) {
+	expect := expect.New(t)
+
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		expect(err).To.Be.Nil()
+		form = map[string]string{
+			"client_id":     r.PostForm.Get("client_id"),
+			"client_secret": r.PostForm.Get("client_secret"),
+			"grant_type":    r.PostForm.Get("grant_type"),
+			"refresh_token": r.PostForm.Get("refresh_token"),
+		}
+		_, _ = w.Write([]byte(`{
+			"access_token": "new-access-token",
+			"refresh_token": "new-refresh-token",
+			"scope": ["chat_login"]
+		}`))
+	}))
+	defer server.Close()
+	spyStore := &SpyTokenStore{
+		od: store.OauthData{
+			AccessToken:  "old-access-token",
+			RefreshToken: "old-refresh-token",
+		},
+	}
+	r := oauth.NewRefresher(
+		"test-client-id",
+		"test-client-secret",
+		spyStore,
+		oauth.WithTokenURL(server.URL),
+	)
+
+	token, err := r.Refresh("test-user", "old-access-token")
+
+	expect(err).To.Be.Nil()
+	expect(token).To.Equal("new-access-token")
+	expect(form).To.Equal(map[string]string{
+		"client_id":     "test-client-id",
+		"client_secret": "test-client-secret",
+		"grant_type":    "refresh_token",
+		"refresh_token": "old-refresh-token",
+	})
+	expect(spyStore.updateCalledWithUsername).To.Equal("test-user")
+	expect(spyStore.updateCalledWithOD).To.Equal(store.OauthData{
+		AccessToken:  "new-access-token",
+		RefreshToken: "new-refresh-token",
+		Scope:        []string{"chat_login"},
+	})
+}
+
+func TestRefreshingWhenTheTokenWasAlreadyRefreshed(t *testing.T) {
+	expect := expect.New(t)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	spyStore := &SpyTokenStore{
+		od: store.OauthData{
+			AccessToken:  "new-access-token",
+			RefreshToken: "new-refresh-token",
+		},
+	}
+	r := oauth.NewRefresher("", "", spyStore, oauth.WithTokenURL(server.URL))
+
+	token, err := r.Refresh("test-user", "old-access-token")
+
+	expect(err).To.Be.Nil()
+	expect(token).To.Equal("new-access-token")
+	expect(requests).To.Equal(0)
+	expect(spyStore.updateCalledWithUsername).To.Equal("")
+}
+
+func TestRefreshingWhenTheTokenEndpointFails(t *testing.T) {
+	expect := expect.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	spyStore := &SpyTokenStore{
+		od: store.OauthData{
+			AccessToken:  "old-access-token",
+			RefreshToken: "old-refresh-token",
+		},
+	}
+	r := oauth.NewRefresher("", "", spyStore, oauth.WithTokenURL(server.URL))
+
+	_, err := r.Refresh("test-user", "old-access-token")
+
+	expect(err).Not.To.Be.Nil()
+	expect(spyStore.updateCalledWithUsername).To.Equal("")
+}
+
+func TestRefreshingWhenTheStoreFails(t *testing.T) {
+	expect := expect.New(t)
+
+	r := oauth.NewRefresher("", "", &SpyTokenStore{
+		err: errors.New("test-error"),
+	})
+
+	_, err := r.Refresh("test-user", "old-access-token")
+
+	expect(err).Not.To.Be.Nil()
+}
@@ -0,0 +1,21 @@
+package oauth_test
+
+import "github.com/jasonkeene/anubot-server/store"
+
+type SpyTokenStore struct {
+	od  store.OauthData
+	err error
+
+	updateCalledWithUsername string
+	updateCalledWithOD       store.OauthData
+}
+
+func (s *SpyTokenStore) TwitchOauthData(twitchUsername string) (store.OauthData, error) {
+	return s.od, s.err
+}
+
+func (s *SpyTokenStore) UpdateTwitchOauthData(twitchUsername string, od store.OauthData) error {
+	s.updateCalledWithUsername = twitchUsername
+	s.updateCalledWithOD = od
+	return nil
+}
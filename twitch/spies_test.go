@@ -0,0 +1,14 @@
+package twitch_test
+
+type SpyTokenRefresher struct {
+	calledWithUsername string
+	calledWithToken    string
+	token              string
+	err                error
+}
+
+func (s *SpyTokenRefresher) Refresh(twitchUsername, token string) (string, error) {
+	s.calledWithUsername = twitchUsername
+	s.calledWithToken = token
+	return s.token, s.err
+}
@@ -21,23 +21,48 @@ var httpClient = &http.Client{
 	Timeout: time.Second * 5,
 }
 
+// ErrUnauthorized is returned when Twitch rejects the oauth token.
+var ErrUnauthorized = errors.New("Bad status code 401")
+
+// TokenRefresher gets a new access token for a twitch user when their token
+// has been rejected.
+type TokenRefresher interface {
+	Refresh(twitchUsername, token string) (newToken string, err error)
+}
+
 // API makes requests to Twitch's API.
 type API struct {
-	url      string
-	clientID string
-	mu       sync.Mutex
-	games    []Game
+	url       string
+	clientID  string
+	refresher TokenRefresher
+	mu        sync.Mutex
+	games     []Game
+}
+
+// Option is used to configure an API.
+type Option func(*API)
+
+// WithTokenRefresher allows requests that are rejected due to an expired
+// token to be retried with a refreshed token.
+func WithTokenRefresher(r TokenRefresher) Option {
+	return func(t *API) {
+		t.refresher = r
+	}
 }
 
 // New creates a new API.
-func New(url, clientID string) *API {
+func New(url, clientID string, opts ...Option) *API {
 	if url == "" {
 		url = twitchAPIURL
 	}
-	return &API{
+	t := &API{
 		url:      url,
 		clientID: clientID,
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 // UserData represents data associated with a Twitch user.
@@ -69,6 +94,9 @@ func (t *API) User(token string) (UserData, error) {
 		return UserData{}, err
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return UserData{}, ErrUnauthorized
+	}
 	if resp.StatusCode != http.StatusOK {
 		return UserData{}, fmt.Errorf("Bad status code %d", resp.StatusCode)
 	}
@@ -181,8 +209,21 @@ func (t *API) StreamInfo(channel string) (string, string, error) {
 	return data.Status, data.Game, nil
 }
 
-// UpdateDescription updates the status and game for the given channel.
+// UpdateDescription updates the status and game for the given channel. If
+// the token has expired it is refreshed and the update is retried.
 func (t *API) UpdateDescription(status, game, channel, token string) error {
+	err := t.updateDescription(status, game, channel, token)
+	if err != ErrUnauthorized || t.refresher == nil {
+		return err
+	}
+	token, err = t.refresher.Refresh(channel, token)
+	if err != nil {
+		return err
+	}
+	return t.updateDescription(status, game, channel, token)
+}
+
+func (t *API) updateDescription(status, game, channel, token string) error {
 	u := t.url + "/channels/" + channel
 
 	data, err := json.Marshal(map[string]map[string]string{
@@ -209,7 +250,16 @@ func (t *API) UpdateDescription(status, game, channel, token string) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("got error in closing response body: %s", err)
+		}
+	}()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("Bad status code %d", resp.StatusCode)
 	}
@@ -0,0 +1,50 @@
+package twitch_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a8m/expect"
+	"github.com/jasonkeene/anubot-server/twitch"
+)
+
+func TestUpdatingDescriptionRefreshesExpiredTokens(t *testing.T) {
+	expect := expect.New(t)
+
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "OAuth new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	spyRefresher := &SpyTokenRefresher{
+		token: "new-token",
+	}
+	api := twitch.New(server.URL, "test-client-id", twitch.WithTokenRefresher(spyRefresher))
+
+	err := api.UpdateDescription("test-status", "test-game", "test-channel", "old-token")
+
+	expect(err).To.Be.Nil()
+	expect(auths).To.Equal([]string{"OAuth old-token", "OAuth new-token"})
+	expect(spyRefresher.calledWithUsername).To.Equal("test-channel")
+	expect(spyRefresher.calledWithToken).To.Equal("old-token")
+}
+
+func TestUpdatingDescriptionWithoutARefresher(t *testing.T) {
+	expect := expect.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	api := twitch.New(server.URL, "test-client-id")
+
+	err := api.UpdateDescription("test-status", "test-game", "test-channel", "old-token")
+
+	expect(err).To.Equal(twitch.ErrUnauthorized)
+}